@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"regelwerk/rules"
+)
+
+// An outgoing command for a device, or a publish to an arbitrary topic
+type action struct {
+	dev     *device // nil if publishing to topic
+	topic   string  // full MQTT topic, or what send writes to, e.g. knx/1/2/3
+	payload any     // sent as-is if a string, otherwise encoded as JSON
+	source  string  // what caused it, see arbiter
+
+	// for commands outside of MQTT, like KNX: sends it instead of publishing
+	send func(ctx context.Context) error
+}
+
+// Returns the device ID or topic the action is for
+func (a *action) Target() string {
+	if a.dev != nil {
+		return a.dev.id
+	}
+	return a.topic
+}
+
+func (a *action) String() string {
+	return fmt.Sprintf("%s %v", a.Target(), a.payload)
+}
+
+// Performs an action, or returns an error explaining why it couldn't
+type actionFunc func(ctx context.Context, a *action) error
+
+// Wraps an actionFunc to inspect, modify or block actions before they are sent.
+// Middleware is called in the order it was registered with Use.
+type middleware func(next actionFunc) actionFunc
+
+// Registers a middleware for all actions.
+// Should be called before any events are processed.
+func (r *regelwerk) Use(m middleware) {
+	r.middleware = append(r.middleware, m)
+}
+
+// Runs the action through the middleware chain before publishing it
+func (r *regelwerk) Do(ctx context.Context, a *action) {
+	f := r.publishAction
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		f = r.middleware[i](f)
+	}
+
+	if err := f(ctx, a); err != nil {
+		log.Printf("action %s not sent: %v", a, err)
+	}
+}
+
+// Final step of the chain, sends out the payload
+func (r *regelwerk) publishAction(ctx context.Context, a *action) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	} else if a.send != nil {
+		return a.send(ctx)
+	}
+
+	payload := a.payload
+	if a.dev != nil {
+		payload = a.dev.rawCommand(payload)
+	}
+
+	var js []byte
+	if s, ok := payload.(string); ok {
+		js = []byte(s)
+	} else {
+		var err error
+		if js, err = json.Marshal(payload); err != nil {
+			return fmt.Errorf("error encoding to JSON: %v", err)
+		}
+	}
+
+	topic := a.topic
+	if a.dev != nil && a.dev.commandTopic != "" {
+		topic = a.dev.commandTopic
+	} else if a.dev != nil {
+		// by its current name, in case it was renamed
+		topic = MQTT_TOPIC_PREFIX + r.registry.Name(a.dev.topic) + "/set"
+	}
+
+	if r.queue != nil {
+		r.queue.Enqueue(ctx, topic, js)
+		return nil
+	}
+
+	tok := r.client.Publish(topic, 0, false, js)
+	r.checkPublish(ctx, a.String(), tok)
+	return nil
+}
+
+// built-in middleware
+
+// Logs actions in debug mode
+func logActions(next actionFunc) actionFunc {
+	return func(ctx context.Context, a *action) error {
+		debugf(LOG_ACTIONS, "sending %s payload: %v", a.Target(), a.payload)
+		return next(ctx, a)
+	}
+}
+
+// configured actions
+
+// An action as specified in the config file, e.g.
+// {"Type": "activate_scene", "Scene": "evening"}
+type actionSpec struct {
+	Type  string
+	Scene string     `json:",omitempty"`
+	If    string     `json:",omitempty"` // condition expression, see rules.Expr
+	cond  rules.Expr // If, parsed when loading the config
+
+	// when run by buttons, repeats within this are ignored
+	Debounce textDuration `json:",omitempty"`
+
+	// for publish, like a scene step
+	Device  string `json:",omitempty"`
+	Topic   string `json:",omitempty"`
+	Payload any    `json:",omitempty"`
+
+	// for cover, with the cover as Device: OPEN, CLOSE or STOP, or a Position.
+	// for alarm: arm or disarm. for emergency and alert: ack
+	Command  string   `json:",omitempty"`
+	Position *float64 `json:",omitempty"`
+
+	// for snooze, with the Device: how long to snooze its automations for,
+	// zero to resume them
+	For textDuration `json:",omitempty"`
+
+	// for alert, the key of the alert to ack, all of them if not set
+	Alert string `json:",omitempty"`
+
+	// for knx, with the Payload as value: a group address like 1/2/3
+	GroupAddress string `json:",omitempty"`
+
+	// for modbus, with the Payload as value: the device and unit, and the
+	// coil or holding register to write
+	Address  string  `json:",omitempty"`
+	Unit     byte    `json:",omitempty"`
+	Coil     *uint16 `json:",omitempty"`
+	Register *uint16 `json:",omitempty"`
+
+	// for counter, with the Counter's name: increment (by Amount, default 1)
+	// or reset as the Command
+	Counter string   `json:",omitempty"`
+	Amount  *float64 `json:",omitempty"`
+
+	// for wol: the MAC to wake, optionally the interface to send the magic
+	// packet from, and how many times to send it
+	MAC       string `json:",omitempty"`
+	Interface string `json:",omitempty"`
+	Repeat    int    `json:",omitempty"`
+}
+
+// Performs a configured action type.
+// May be called from any group's worker, so shared state needs its own locking.
+type actionTypeFunc func(ctx context.Context, r *regelwerk, spec *actionSpec) error
+
+var actionTypes = map[string]actionTypeFunc{}
+
+// Checks the fields of a configured action type when loading the config,
+// for the types that need it
+var actionChecks = map[string]func(spec *actionSpec) error{}
+
+// Performs the configured action
+func (r *regelwerk) Run(ctx context.Context, spec *actionSpec) error {
+	fn := actionTypes[spec.Type]
+	if fn == nil {
+		return fmt.Errorf("unknown action type %q", spec.Type)
+	} else if !r.checkCondition(ctx, spec.cond) {
+		log.Printf("condition %q not met, skipping %s", spec.If, spec.Type)
+		return nil
+	}
+	return fn(ctx, r, spec)
+}
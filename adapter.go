@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// conventions of non-Zigbee devices, for the topics and payloads of devices
+const (
+	// Tasmota, with its device topic as Topic, by default the device's name
+	ADAPTER_TASMOTA = "tasmota"
+
+	// ESPHome, with "<node>/<component>/<id>" as Topic, e.g.
+	// "garage/switch/relay"
+	ADAPTER_ESPHOME = "esphome"
+
+	// Shelly relays, with the device ID (Gen1, e.g. "shelly1-B929CC") or
+	// topic prefix (Gen2) as Topic, by default the device's name
+	ADAPTER_SHELLY      = "shelly"
+	ADAPTER_SHELLY_GEN2 = "shelly_gen2"
+)
+
+// Where a device's states and commands go, and what the payloads look like
+type topics struct {
+	state, command string
+	payload        string // see PAYLOAD_JSON
+
+	// where the device is asked for its state, and with what
+	query, queryPayload string
+
+	// the device's on/off values, if not "ON" and "OFF"
+	valueOn, valueOff any
+
+	// builds the command for a state, if not sent as a bare value
+	encode func(state any) any
+}
+
+// Derives the topics of a device from its settings, for devices using an
+// Adapter, or those with their own Topic
+func deviceTopics(name string, dc deviceConfig) (topics, error) {
+	switch dc.Adapter {
+	case "":
+		return topics{state: dc.Topic, command: dc.CommandTopic, payload: dc.Payload}, nil
+
+	case ADAPTER_TASMOTA:
+		// stat/<topic>/POWER is "ON" or "OFF", and sent in reply to the
+		// command, or an empty one as a query
+		topic := topicOr(dc.Topic, name)
+		power := "POWER"
+		if dc.Relay > 0 {
+			power += strconv.Itoa(dc.Relay)
+		}
+		cmnd := "cmnd/" + topic + "/" + power
+		return topics{state: "stat/" + topic + "/" + power, command: cmnd, query: cmnd, payload: PAYLOAD_RAW}, nil
+
+	case ADAPTER_ESPHOME:
+		// states are retained, so there's no need to query them
+		if strings.Count(dc.Topic, "/") != 2 {
+			return topics{}, fmt.Errorf("esphome device %q needs a Topic like <node>/<component>/<id>", name)
+		}
+		t := topics{state: dc.Topic + "/state", command: dc.Topic + "/command", payload: PAYLOAD_RAW}
+		if strings.Split(dc.Topic, "/")[1] == "sensor" {
+			t.payload = PAYLOAD_NUMBER
+		}
+		return t, nil
+
+	case ADAPTER_SHELLY:
+		// shellies/<id>/relay/<n> is "on" or "off"
+		relay := "shellies/" + topicOr(dc.Topic, name) + "/relay/" + strconv.Itoa(dc.Relay)
+		return topics{
+			state: relay, command: relay + "/command", payload: PAYLOAD_RAW,
+			query: "shellies/" + topicOr(dc.Topic, name) + "/command", queryPayload: "update",
+			valueOn: "on", valueOff: "off",
+		}, nil
+
+	case ADAPTER_SHELLY_GEN2:
+		// the switch's status is JSON with its state as output, and it's
+		// switched with an RPC call
+		prefix, id := topicOr(dc.Topic, name), dc.Relay
+		return topics{
+			state:   fmt.Sprintf("%s/status/switch:%d", prefix, id),
+			command: prefix + "/rpc", payload: PAYLOAD_JSON_PATH + "output",
+			query: prefix + "/command", queryPayload: "status_update",
+			valueOn: true, valueOff: false,
+			encode: func(state any) any {
+				return map[string]any{
+					"id": 1, "src": "regelwerk", "method": "Switch.Set",
+					"params": map[string]any{"id": id, "on": state},
+				}
+			},
+		}, nil
+	}
+	return topics{}, fmt.Errorf("unknown Adapter %q for %q", dc.Adapter, name)
+}
+
+func topicOr(topic, name string) string {
+	if topic == "" {
+		return name
+	}
+	return topic
+}
@@ -0,0 +1,63 @@
+package main
+
+import "testing"
+
+func TestTasmotaDevice(t *testing.T) {
+	cfg := testConfig()
+	cfg.Devices = map[string]deviceConfig{
+		"light": {Adapter: ADAPTER_TASMOTA, Topic: "sonoff", Relay: 2},
+		"door":  {Adapter: ADAPTER_ESPHOME, Topic: "garage/binary_sensor/door"},
+	}
+	r, mc := newTestRegelwerk(t, cfg)
+	light := r.deviceByTopic("light")
+
+	light.RequestState(mc, r.clock.Now())
+	if got := mc.Payloads("cmnd/sonoff/POWER2"); len(got) != 1 || got[0] != "" {
+		t.Errorf("wanted state query, got %v", got)
+	}
+	r.processMessage(r.ctx, light, &virtualMessage{topic: "stat/sonoff/POWER2", payload: []byte("ON")})
+	if light.state != "ON" {
+		t.Errorf("wrong state %#v", light.state)
+	}
+
+	r.Do(r.ctx, light.NewState("OFF"))
+	if got := mc.WaitFor(t, "cmnd/sonoff/POWER2", 2); got[1] != "OFF" {
+		t.Errorf("wrong command %s", got[1])
+	}
+
+	if d := r.stateTopics["garage/binary_sensor/door/state"]; d != r.deviceByTopic("door") {
+		t.Errorf("esphome state topic not set up")
+	}
+	if _, err := deviceTopics("x", deviceConfig{Adapter: ADAPTER_ESPHOME, Topic: "garage"}); err == nil {
+		t.Errorf("expected error for incomplete esphome topic")
+	}
+}
+
+func TestShellyDevice(t *testing.T) {
+	for _, tt := range []struct {
+		adapter, state, report, command string
+	}{
+		{ADAPTER_SHELLY, "shellies/shelly1-B929CC/relay/0", "on", `off`},
+		{ADAPTER_SHELLY_GEN2, "shelly1-B929CC/status/switch:0", `{"id":0,"output":true}`,
+			`{"id":1,"method":"Switch.Set","params":{"id":0,"on":false},"src":"regelwerk"}`},
+	} {
+		cfg := testConfig()
+		cfg.Devices = map[string]deviceConfig{"light": {Adapter: tt.adapter, Topic: "shelly1-B929CC"}}
+		r, mc := newTestRegelwerk(t, cfg)
+		light := r.stateTopics[tt.state]
+		if light == nil {
+			t.Errorf("%s: no device at %s", tt.adapter, tt.state)
+			continue
+		}
+
+		r.processMessage(r.ctx, light, &virtualMessage{topic: tt.state, payload: []byte(tt.report)})
+		if light.state != "ON" {
+			t.Errorf("%s: wrong state %#v", tt.adapter, light.state)
+		}
+
+		r.Do(r.ctx, light.NewState("OFF"))
+		if got := mc.WaitFor(t, light.commandTopic, 1); got[0] != tt.command {
+			t.Errorf("%s: wrong command %s", tt.adapter, got[0])
+		}
+	}
+}
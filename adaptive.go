@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Lengthens the motion off-delay in busy rooms: each time motion re-triggers
+// a session, the delay is multiplied by Factor, up to Max. It goes back to
+// the base delay after ResetAfter without re-triggers.
+type adaptiveDelayConfig struct {
+	Factor     float64
+	Max        textDuration
+	ResetAfter textDuration
+}
+
+type adaptiveDelay struct {
+	cfg adaptiveDelayConfig
+
+	mu    sync.Mutex
+	level int       // number of re-triggers
+	last  time.Time // of the last re-trigger
+}
+
+func (c *adaptiveDelayConfig) validate(base time.Duration) error {
+	if c == nil {
+		return nil
+	}
+	if c.Factor == 0 {
+		c.Factor = 2
+	}
+	if c.ResetAfter == 0 {
+		c.ResetAfter = textDuration(30 * time.Minute)
+	}
+
+	if c.Factor < 1 {
+		return errors.New("AdaptiveOffDelay Factor must be at least 1")
+	} else if time.Duration(c.Max) < base {
+		return errors.New("AdaptiveOffDelay Max must not be less than MotionOffDelay")
+	}
+	return nil
+}
+
+func newAdaptiveDelay(cfg *adaptiveDelayConfig) *adaptiveDelay {
+	if cfg == nil {
+		return nil
+	}
+	return &adaptiveDelay{cfg: *cfg}
+}
+
+// Notes that motion re-triggered a session
+func (a *adaptiveDelay) Retrigger(now time.Time) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.reset(now)
+	a.level++
+	a.last = now
+}
+
+// Returns the off-delay to use instead of the base one
+func (a *adaptiveDelay) Delay(base time.Duration, now time.Time) time.Duration {
+	if a == nil {
+		return base
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.reset(now)
+	d := float64(base)
+	for i := 0; i < a.level; i++ {
+		d *= a.cfg.Factor
+		if d >= float64(a.cfg.Max) {
+			return time.Duration(a.cfg.Max)
+		}
+	}
+	return time.Duration(d)
+}
+
+// Goes back to the base delay after a quiet period.
+// Must be called with the lock held.
+func (a *adaptiveDelay) reset(now time.Time) {
+	if a.level > 0 && now.Sub(a.last) >= time.Duration(a.cfg.ResetAfter) {
+		a.level = 0
+	}
+}
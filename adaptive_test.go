@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdaptiveDelay(t *testing.T) {
+	a := newAdaptiveDelay(&adaptiveDelayConfig{
+		Factor:     2,
+		Max:        textDuration(5 * time.Minute),
+		ResetAfter: textDuration(30 * time.Minute),
+	})
+	base := time.Minute
+	now := time.Date(2022, 6, 1, 21, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		retriggers int
+		after      time.Duration
+		want       time.Duration
+	}{
+		{0, 0, time.Minute},
+		{1, 0, 2 * time.Minute},
+		{1, 0, 4 * time.Minute},
+		{1, 0, 5 * time.Minute}, // capped
+		{0, 29 * time.Minute, 5 * time.Minute},
+		{0, time.Minute, time.Minute}, // quiet for 30m
+	}
+	for i, tt := range tests {
+		now = now.Add(tt.after)
+		for n := 0; n < tt.retriggers; n++ {
+			a.Retrigger(now)
+		}
+		if got := a.Delay(base, now); got != tt.want {
+			t.Errorf("%d: wanted delay %s, got %s", i, tt.want, got)
+		}
+	}
+
+	var none *adaptiveDelay
+	none.Retrigger(now)
+	if got := none.Delay(base, now); got != base {
+		t.Errorf("wanted base delay without config, got %s", got)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"regelwerk/timers"
+)
+
+// alarm states, as published to regelwerk/alarm
+const (
+	ALARM_DISARMED  = "disarmed"
+	ALARM_ARMING    = "arming"  // exit delay, to leave the house
+	ALARM_ARMED     = "armed"   // sensors trigger the alarm
+	ALARM_PENDING   = "pending" // entry delay, to disarm
+	ALARM_TRIGGERED = "triggered"
+)
+
+// Armed and disarmed by the alarm/set command, or by "alarm" actions with
+// Command arm or disarm, e.g. from buttons, SunTriggers or the Calendar.
+// While armed, sensors opening or detecting motion run the Action, e.g. a
+// scene with sirens and lights.
+type alarmConfig struct {
+	Sensors    []string     // contact & motion sensors, default all configured
+	ExitDelay  textDuration // after arming, before sensors are watched
+	EntryDelay textDuration // after a sensor trips, to disarm in time
+	Action     string       // run when the alarm goes off
+	OnDisarm   string       // run when disarmed after going off, e.g. to silence sirens
+}
+
+type alarm struct {
+	cfg     alarmConfig
+	sensors map[string]bool
+
+	mu    sync.Mutex
+	state string
+}
+
+func validateAlarm(a *alarmConfig, actions map[string]*actionSpec) error {
+	if a == nil {
+		return nil
+	} else if act := actions[a.Action]; act == nil || act.Type == "alarm" {
+		return fmt.Errorf("Alarm needs a known Action, not %q", a.Action)
+	} else if act := actions[a.OnDisarm]; a.OnDisarm != "" && (act == nil || act.Type == "alarm") {
+		return fmt.Errorf("Alarm OnDisarm refers to unknown action %q", a.OnDisarm)
+	}
+	return nil
+}
+
+func (r *regelwerk) addAlarm(cfg *alarmConfig) {
+	if cfg == nil {
+		return
+	}
+
+	al := &alarm{cfg: *cfg, sensors: make(map[string]bool), state: ALARM_DISARMED}
+	for _, topic := range cfg.Sensors {
+		al.sensors[topic] = true
+		if r.devices[topic] == nil {
+			r.AddDevice(&device{id: topic, topic: topic})
+		}
+	}
+	if len(al.sensors) == 0 {
+		for topic, d := range r.devices {
+			if d.id == "contact" || d.id == "motion" {
+				al.sensors[topic] = true
+			}
+		}
+	}
+	r.alarm = al
+
+	r.timers.Register("alarm", r.handleAlarmTimer)
+	r.HandleCommand("alarm/set", func(ctx context.Context, payload []byte) error {
+		return r.SetAlarm(ctx, string(payload))
+	})
+}
+
+// Arms or disarms the alarm, with "arm" or "disarm"
+func (r *regelwerk) SetAlarm(ctx context.Context, command string) error {
+	al := r.alarm
+	if al == nil {
+		return fmt.Errorf("alarm is not configured")
+	}
+
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	switch strings.ToLower(strings.TrimSpace(command)) {
+	case "arm":
+		if al.state != ALARM_DISARMED {
+			return nil
+		}
+		r.setAlarmState(ctx, ALARM_ARMING)
+		if al.cfg.ExitDelay > 0 {
+			r.timers.Add("alarm", "alarm", nil)
+			r.timers.Start("alarm", time.Duration(al.cfg.ExitDelay))
+		} else {
+			r.setAlarmState(ctx, ALARM_ARMED)
+		}
+
+	case "disarm":
+		if al.state == ALARM_DISARMED {
+			return nil
+		}
+		wasTriggered := al.state == ALARM_TRIGGERED
+		r.timers.Destroy("alarm")
+		r.setAlarmState(ctx, ALARM_DISARMED)
+		r.resolveAlert("alarm")
+		if wasTriggered && al.cfg.OnDisarm != "" {
+			r.runAlarmAction(ctx, al.cfg.OnDisarm)
+		}
+
+	default:
+		return fmt.Errorf("expected arm or disarm, got %q", command)
+	}
+	return nil
+}
+
+// Must be called with the alarm lock held
+func (r *regelwerk) setAlarmState(ctx context.Context, state string) {
+	al := r.alarm
+	al.state = state
+	log.Printf("alarm %s", state)
+	r.journal.Add("alarm", state, nil)
+	r.client.Publish(CONTROL_TOPIC_PREFIX+"alarm", 0, true, state)
+
+	if state == ALARM_TRIGGERED {
+		r.raiseAlert("alarm", "alarm triggered")
+		r.runAlarmAction(ctx, al.cfg.Action)
+	}
+}
+
+func (r *regelwerk) runAlarmAction(ctx context.Context, name string) {
+	if err := r.Run(ctx, r.actions[name]); err != nil {
+		log.Printf("alarm action %q failed: %v", name, err)
+	}
+}
+
+// Whether a sensor's payload means the door opened or there's motion
+func sensorTripped(payload map[string]any) bool {
+	if contact, ok := payload["contact"].(bool); ok && !contact {
+		return true
+	}
+	for _, attr := range []string{"occupancy", "presence"} {
+		if on, ok := payload[attr].(bool); ok && on {
+			return true
+		}
+	}
+	return false
+}
+
+// Starts the entry delay, or sets off the alarm, when an armed sensor trips
+func (r *regelwerk) observeAlarm(ctx context.Context, d *device, payload map[string]any) {
+	al := r.alarm
+	if al == nil || !al.sensors[d.topic] || !sensorTripped(payload) {
+		return
+	}
+
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	if al.state != ALARM_ARMED {
+		return
+	}
+
+	log.Printf("alarm tripped by %q", d.topic)
+	if al.cfg.EntryDelay > 0 {
+		r.setAlarmState(ctx, ALARM_PENDING)
+		r.timers.Add("alarm", "alarm", nil)
+		r.timers.Start("alarm", time.Duration(al.cfg.EntryDelay))
+	} else {
+		r.setAlarmState(ctx, ALARM_TRIGGERED)
+	}
+}
+
+// Ends the exit or entry delay
+func (r *regelwerk) handleAlarmTimer(ctx context.Context, tm *timers.Timer, expired bool) {
+	al := r.alarm
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	switch al.state {
+	case ALARM_ARMING:
+		r.setAlarmState(ctx, ALARM_ARMED)
+	case ALARM_PENDING:
+		r.setAlarmState(ctx, ALARM_TRIGGERED)
+	}
+}
+
+// Returns the alarm's state
+func (r *regelwerk) AlarmState() string {
+	al := r.alarm
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	return al.state
+}
+
+func init() {
+	actionTypes["alarm"] = func(ctx context.Context, r *regelwerk, spec *actionSpec) error {
+		return r.SetAlarm(ctx, spec.Command)
+	}
+}
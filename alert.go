@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"regelwerk/timers"
+)
+
+// Logs a problem that needs attention, and publishes it for notifiers
+func (r *regelwerk) alert(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	log.Printf("ALERT: %s", msg)
+	r.client.Publish(CONTROL_TOPIC_PREFIX+"alert", 0, false, msg)
+}
+
+// Makes alerts about ongoing problems, like a door left open, a stale device
+// or the alarm going off, stay until acknowledged, with
+// regelwerk/alerts/ack, an "alert" action with Command ack, or a POST to
+// /alerts. Until then they are published again after each of the Escalate
+// intervals, repeating the last one. Alerts also end once the problem is
+// gone, e.g. the door was closed.
+type alertsConfig struct {
+	Escalate  []textDuration // default 5m, 15m, 1h
+	OpenAfter textDuration   // alert when contact sensors stay open this long
+}
+
+// An alert that wasn't acknowledged yet, as published to regelwerk/alerts
+type activeAlert struct {
+	Key     string
+	Message string
+	Raised  time.Time
+	Repeats int
+}
+
+type alerts struct {
+	cfg alertsConfig
+
+	mu     sync.Mutex
+	active map[string]*activeAlert
+}
+
+func validateAlerts(a *alertsConfig) error {
+	if a == nil {
+		return nil
+	} else if a.OpenAfter < 0 {
+		return fmt.Errorf("Alerts OpenAfter cannot be negative")
+	}
+	for _, d := range a.Escalate {
+		if d <= 0 {
+			return fmt.Errorf("Alerts Escalate intervals need to be positive")
+		}
+	}
+	return nil
+}
+
+func (r *regelwerk) addAlerts(cfg *alertsConfig) {
+	if cfg == nil {
+		return
+	}
+
+	al := &alerts{cfg: *cfg, active: make(map[string]*activeAlert)}
+	if len(al.cfg.Escalate) == 0 {
+		al.cfg.Escalate = []textDuration{
+			textDuration(5 * time.Minute),
+			textDuration(15 * time.Minute),
+			textDuration(time.Hour),
+		}
+	}
+	r.alerts = al
+
+	r.timers.Register("alert", r.lockedDevice(r.handleAlertTimer))
+	r.timers.Register("alert_open", r.lockedDevice(r.handleOpenTimer))
+	r.HandleCommand("alerts/ack", func(ctx context.Context, payload []byte) error {
+		return r.AckAlert(string(payload))
+	})
+}
+
+// Raises an alert that stays until acknowledged or resolved. Raising it again
+// while active doesn't repeat it. Without Alerts, it's a plain alert.
+func (r *regelwerk) raiseAlert(key, format string, args ...any) {
+	al := r.alerts
+	if al == nil {
+		r.alert(format, args...)
+		return
+	}
+
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	if al.active[key] != nil {
+		return
+	}
+	a := &activeAlert{Key: key, Message: fmt.Sprintf(format, args...), Raised: r.clock.Now()}
+	al.active[key] = a
+	r.alert("%s", a.Message)
+	r.publishAlerts()
+
+	tname := "alert/" + key
+	r.timers.Add(tname, "alert", map[string]string{"alert": key})
+	r.timers.Start(tname, time.Duration(al.cfg.Escalate[0]))
+}
+
+// Ends the alert as the problem is gone, if it wasn't acknowledged before
+func (r *regelwerk) resolveAlert(key string) {
+	al := r.alerts
+	if al == nil {
+		return
+	}
+
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	if al.active[key] != nil {
+		log.Printf("alert %q resolved", key)
+		r.endAlert(key)
+	}
+}
+
+// Acknowledges the alert, or all of them if key is empty or "all"
+func (r *regelwerk) AckAlert(key string) error {
+	al := r.alerts
+	if al == nil {
+		return fmt.Errorf("alerts are not configured")
+	}
+
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	key = strings.TrimSpace(key)
+	if key == "" || key == "all" {
+		log.Printf("all alerts acknowledged")
+		for k := range al.active {
+			r.endAlert(k)
+		}
+		return nil
+	} else if al.active[key] == nil {
+		return fmt.Errorf("no active alert %q", key)
+	}
+	log.Printf("alert %q acknowledged", key)
+	r.endAlert(key)
+	return nil
+}
+
+// Must be called with the alerts lock held
+func (r *regelwerk) endAlert(key string) {
+	delete(r.alerts.active, key)
+	r.timers.Destroy("alert/" + key)
+	r.publishAlerts()
+}
+
+// Publishes the alert again, waiting longer each time
+func (r *regelwerk) handleAlertTimer(ctx context.Context, tm *timers.Timer, expired bool) {
+	al := r.alerts
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	key := tm.Meta("alert")
+	a := al.active[key]
+	if a == nil {
+		return
+	}
+
+	a.Repeats++
+	r.alert("%s (unacknowledged for %s)", a.Message, r.clock.Now().Sub(a.Raised).Round(time.Minute))
+	r.publishAlerts()
+
+	i := a.Repeats
+	if i >= len(al.cfg.Escalate) {
+		i = len(al.cfg.Escalate) - 1
+	}
+	// the fired timer is removed after this returns, so replace it
+	tname := "alert/" + key
+	r.timers.Destroy(tname)
+	r.timers.Add(tname, "alert", map[string]string{"alert": key})
+	r.timers.Start(tname, time.Duration(al.cfg.Escalate[i]))
+}
+
+// Must be called with the alerts lock held
+func (r *regelwerk) publishAlerts() {
+	js, err := json.Marshal(r.alerts.list())
+	if err != nil {
+		log.Printf("error encoding alerts to JSON: %v", err)
+		return
+	}
+	r.client.Publish(CONTROL_TOPIC_PREFIX+"alerts", 0, true, js)
+}
+
+// Must be called with the alerts lock held
+func (al *alerts) list() []*activeAlert {
+	list := make([]*activeAlert, 0, len(al.active))
+	for _, key := range sortedKeys(al.active) {
+		a := *al.active[key]
+		list = append(list, &a)
+	}
+	return list
+}
+
+// Returns the alerts that weren't acknowledged yet
+func (r *regelwerk) AlertsReport() []*activeAlert {
+	al := r.alerts
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	return al.list()
+}
+
+// Times how long contact sensors stay open
+func (r *regelwerk) observeAlerts(d *device, payload map[string]any) {
+	al := r.alerts
+	if al == nil || al.cfg.OpenAfter == 0 || d.id != "contact" {
+		return
+	}
+	contact, ok := payload["contact"].(bool)
+	if !ok {
+		return
+	}
+
+	tname := "alert_open/" + d.topic
+	if contact {
+		r.timers.Destroy(tname)
+		r.resolveAlert("open/" + d.topic)
+	} else if r.timers.Add(tname, "alert_open", map[string]string{"topic": d.topic}) != nil {
+		r.timers.Start(tname, time.Duration(al.cfg.OpenAfter))
+	}
+}
+
+func (r *regelwerk) handleOpenTimer(ctx context.Context, tm *timers.Timer, expired bool) {
+	topic := tm.Meta("topic")
+	r.raiseAlert("open/"+topic, "%q has been open for %s", topic,
+		time.Duration(r.alerts.cfg.OpenAfter))
+}
+
+func init() {
+	actionTypes["alert"] = func(ctx context.Context, r *regelwerk, spec *actionSpec) error {
+		if cmd := strings.ToLower(strings.TrimSpace(spec.Command)); cmd != "ack" {
+			return fmt.Errorf("expected ack, got %q", spec.Command)
+		}
+		return r.AckAlert(spec.Alert)
+	}
+}
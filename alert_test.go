@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"regelwerk/timers"
+)
+
+func TestAlertEscalation(t *testing.T) {
+	cfg := testConfig()
+	cfg.Alerts = &alertsConfig{OpenAfter: textDuration(10 * time.Minute)}
+	r, mc := newTestRegelwerk(t, cfg)
+	fc := timers.NewFakeClock(time.Date(2022, 6, 1, 10, 0, 0, 0, time.Local))
+	r.clock, r.timers.Clock = fc, fc
+
+	// closed in time
+	receive(r, "door", map[string]any{"contact": false})
+	fc.Advance(5 * time.Minute)
+	receive(r, "door", map[string]any{"contact": true})
+	fc.Advance(10 * time.Minute)
+	if got := mc.Payloads(CONTROL_TOPIC_PREFIX + "alert"); len(got) != 0 {
+		t.Fatalf("wanted no alerts, got %v", got)
+	}
+
+	receive(r, "door", map[string]any{"contact": false})
+	fc.Advance(10 * time.Minute)
+	mc.WaitFor(t, CONTROL_TOPIC_PREFIX+"alert", 1)
+
+	// repeated after 5m, 15m, and then every hour
+	for i, wait := range []time.Duration{5 * time.Minute, 15 * time.Minute, time.Hour, time.Hour} {
+		fc.Advance(wait - time.Second)
+		if got := mc.Payloads(CONTROL_TOPIC_PREFIX + "alert"); len(got) != i+1 {
+			t.Fatalf("repeat %d came early: %v", i+1, got)
+		}
+		fc.Advance(time.Second)
+		mc.WaitFor(t, CONTROL_TOPIC_PREFIX+"alert", i+2)
+	}
+	if got := r.AlertsReport(); len(got) != 1 || got[0].Key != "open/door" || got[0].Repeats != 4 {
+		t.Errorf("unexpected active alerts %+v", got)
+	}
+
+	if err := r.AckAlert("open/door"); err != nil {
+		t.Fatal(err)
+	}
+	fc.Advance(2 * time.Hour)
+	if got := mc.Payloads(CONTROL_TOPIC_PREFIX + "alert"); len(got) != 5 {
+		t.Errorf("repeated after ack: %v", got)
+	} else if len(r.AlertsReport()) != 0 {
+		t.Errorf("alert still active after ack")
+	}
+}
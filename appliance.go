@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"regelwerk/mqttio"
+	"regelwerk/timers"
+)
+
+// states of appliances
+const (
+	APPLIANCE_RUNNING  = "running"
+	APPLIANCE_FINISHED = "finished"
+)
+
+// Detects the cycles of an appliance like a washing machine from its plug's
+// power: it has started once the power stayed above StartAbove for MinRun,
+// and finished once it stayed below StopBelow for StopAfter, which needs to
+// be longer than any pauses within a cycle, e.g. while soaking.
+//
+// The appliance shows up as a device with the state running or finished,
+// and OnStart and OnFinish run with the appliance and the cycle's duration
+// in seconds as payload.
+type applianceConfig struct {
+	Device    string // the plug
+	PowerAttr string // default power
+
+	StartAbove float64      // W, default 10
+	MinRun     textDuration // default 1m
+	StopBelow  float64      // W, default 3
+	StopAfter  textDuration // default 5m
+
+	OnStart, OnFinish string // actions
+}
+
+type appliance struct {
+	name string
+	cfg  applianceConfig
+	dev  *device
+
+	power        float64
+	running      bool
+	startedAt    time.Time
+	since        time.Time // of power crossing the threshold for the next state
+	timerRunning bool
+}
+
+type appliances struct {
+	mu   sync.Mutex
+	list []*appliance
+}
+
+func validateAppliances(cfg map[string]applianceConfig, actions map[string]*actionSpec) error {
+	for name, ac := range cfg {
+		if ac.Device == "" || ac.Device == name {
+			return fmt.Errorf("appliance %q needs a Device other than itself", name)
+		} else if ac.StartAbove < 0 || ac.StopBelow < 0 || ac.MinRun < 0 || ac.StopAfter < 0 {
+			return fmt.Errorf("appliance %q thresholds cannot be negative", name)
+		} else if ac.StartAbove > 0 && ac.StopBelow > ac.StartAbove {
+			return fmt.Errorf("appliance %q needs StopBelow <= StartAbove", name)
+		}
+		for _, action := range []string{ac.OnStart, ac.OnFinish} {
+			if action != "" && actions[action] == nil {
+				return fmt.Errorf("appliance %q refers to unknown action %q", name, action)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *regelwerk) addAppliances(cfg map[string]applianceConfig) {
+	if len(cfg) == 0 {
+		return
+	}
+
+	r.appliances = &appliances{}
+	for _, name := range sortedKeys(cfg) {
+		ac := cfg[name]
+		if ac.PowerAttr == "" {
+			ac.PowerAttr = "power"
+		}
+		if ac.StartAbove == 0 {
+			ac.StartAbove = 10
+		}
+		if ac.MinRun == 0 {
+			ac.MinRun = textDuration(time.Minute)
+		}
+		if ac.StopBelow == 0 {
+			ac.StopBelow = 3
+		}
+		if ac.StopAfter == 0 {
+			ac.StopAfter = textDuration(5 * time.Minute)
+		}
+
+		if r.devices[ac.Device] == nil {
+			r.AddDevice(&device{id: ac.Device, topic: ac.Device})
+		}
+		r.appliances.list = append(r.appliances.list, &appliance{
+			name: name,
+			cfg:  ac,
+			dev:  r.addVirtualDevice(name),
+		})
+	}
+	r.timers.Register("appliance", r.handleApplianceTimer)
+}
+
+// Follows the power of the appliances' plugs
+func (r *regelwerk) observeAppliances(ctx context.Context, d *device, payload map[string]any) {
+	as := r.appliances
+	if as == nil {
+		return
+	}
+
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	for _, a := range as.list {
+		if a.cfg.Device != d.topic {
+			continue
+		}
+		v, _ := mqttio.LookupPath(payload, a.cfg.PowerAttr)
+		if watts, ok := v.(float64); ok {
+			a.power = watts
+			r.updateAppliance(ctx, a, r.clock.Now())
+		}
+	}
+}
+
+// Moves the appliance on to the next state once the power has been past its
+// threshold for long enough, with a timer for when that will be if the plug
+// doesn't report again before.
+// Must be called with the appliances lock held.
+func (r *regelwerk) updateAppliance(ctx context.Context, a *appliance, now time.Time) {
+	crossed := a.power > a.cfg.StartAbove
+	hold := time.Duration(a.cfg.MinRun)
+	if a.running {
+		crossed = a.power < a.cfg.StopBelow
+		hold = time.Duration(a.cfg.StopAfter)
+	}
+
+	tname := "appliance/" + a.name
+	if !crossed {
+		a.since = time.Time{}
+		if a.timerRunning {
+			r.timers.Destroy(tname)
+			a.timerRunning = false
+		}
+		return
+	} else if a.since.IsZero() {
+		a.since = now
+	}
+
+	if left := hold - now.Sub(a.since); left > 0 {
+		if !a.timerRunning {
+			r.timers.Add(tname, "appliance", map[string]string{"appliance": a.name})
+			r.timers.Start(tname, left)
+			a.timerRunning = true
+		}
+		return
+	}
+
+	r.timers.Destroy(tname)
+	a.timerRunning = false
+	a.since = time.Time{}
+	a.running = !a.running
+
+	payload := map[string]any{"appliance": a.name}
+	action := a.cfg.OnStart
+	if a.running {
+		a.startedAt = now
+		log.Printf("appliance %q started", a.name)
+		putVirtualState(a.dev, APPLIANCE_RUNNING)
+	} else {
+		duration := now.Sub(a.startedAt)
+		payload["duration"] = duration.Seconds()
+		action = a.cfg.OnFinish
+		log.Printf("appliance %q finished after %s", a.name, duration.Round(time.Second))
+		putVirtualState(a.dev, APPLIANCE_FINISHED)
+	}
+
+	if action != "" {
+		if err := r.Run(withTrigger(ctx, payload), r.actions[action]); err != nil {
+			log.Printf("action %q failed: %v", action, err)
+		}
+	}
+}
+
+func (r *regelwerk) handleApplianceTimer(ctx context.Context, tm *timers.Timer, expired bool) {
+	as := r.appliances
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	for _, a := range as.list {
+		if a.name == tm.Meta("appliance") {
+			a.timerRunning = false
+			r.updateAppliance(ctx, a, r.clock.Now())
+		}
+	}
+}
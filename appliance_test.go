@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"regelwerk/timers"
+)
+
+func TestApplianceCycle(t *testing.T) {
+	cfg := testConfig()
+	cfg.Actions = map[string]*actionSpec{
+		"started":  {Type: "publish", Topic: "home/washer", Payload: "started"},
+		"finished": {Type: "publish", Topic: "home/washer", Payload: "{{ .Payload.duration }}"},
+	}
+	cfg.Appliances = map[string]applianceConfig{
+		"washer": {Device: "washer_plug", OnStart: "started", OnFinish: "finished"},
+	}
+	r, mc := newTestRegelwerk(t, cfg)
+	fc := timers.NewFakeClock(time.Date(2022, 6, 1, 10, 0, 0, 0, time.Local))
+	r.clock, r.timers.Clock = fc, fc
+	washer := r.appliances.list[0]
+
+	// a short spike isn't a cycle
+	receive(r, "washer_plug", map[string]any{"power": 1500.0})
+	fc.Advance(30 * time.Second)
+	receive(r, "washer_plug", map[string]any{"power": 0.0})
+	fc.Advance(time.Minute)
+	if washer.running {
+		t.Fatalf("spike started a cycle")
+	}
+
+	// started by the timer, without another report
+	receive(r, "washer_plug", map[string]any{"power": 1500.0})
+	fc.Advance(time.Minute)
+	if got := mc.WaitFor(t, "home/washer", 1); got[0] != "started" {
+		t.Errorf("wanted OnStart, got %v", got)
+	}
+
+	// a pause in the cycle doesn't finish it
+	receive(r, "washer_plug", map[string]any{"power": 1.0})
+	fc.Advance(2 * time.Minute)
+	receive(r, "washer_plug", map[string]any{"power": 200.0})
+	fc.Advance(40 * time.Minute)
+	receive(r, "washer_plug", map[string]any{"power": 0.5})
+	fc.Advance(5 * time.Minute)
+
+	if got := mc.WaitFor(t, "home/washer", 2); got[1] != "2820" {
+		t.Errorf("wanted OnFinish with the duration, got %v", got)
+	}
+	if washer.running || len(r.timers.List()) != 0 {
+		t.Errorf("cycle not finished cleanly: %v", r.timers.List())
+	}
+}
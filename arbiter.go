@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// sources of actions, for arbitration between rules
+const (
+	SOURCE_MANUAL     = "manual" // switch presses & control commands
+	SOURCE_BUTTON     = "button" // configured actions run by remotes
+	SOURCE_SCENE      = "scene"
+	SOURCE_SUN        = "sun" // covers closed against the sun
+	SOURCE_THERMOSTAT = "thermostat"
+	SOURCE_FAN        = "fan" // humidity-controlled fans
+	SOURCE_VACATION   = "vacation"
+	SOURCE_SESSION    = "session"    // contact & motion rules
+	SOURCE_AUTOMATION = "automation" // configured actions run by schedules & events
+)
+
+// policies for devices targeted by several sources
+const (
+	POLICY_LAST     = "last"     // last writer wins, the default
+	POLICY_FIRST    = "first"    // others are blocked during the cooldown
+	POLICY_PRIORITY = "priority" // lower priorities are blocked during the cooldown
+)
+
+var defaultPriorities = map[string]int{
+	SOURCE_MANUAL:     100,
+	SOURCE_BUTTON:     50,
+	SOURCE_SCENE:      40,
+	SOURCE_SUN:        30,
+	SOURCE_THERMOSTAT: 30,
+	SOURCE_FAN:        30,
+	SOURCE_AUTOMATION: 30,
+	SOURCE_VACATION:   20,
+	SOURCE_SESSION:    10,
+}
+
+type sourceKey struct{}
+
+// Sets the source for the configured actions run with the context
+func withSource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, sourceKey{}, source)
+}
+
+// Returns the source set for the context, or SOURCE_AUTOMATION
+func sourceOf(ctx context.Context) string {
+	if source, ok := ctx.Value(sourceKey{}).(string); ok {
+		return source
+	}
+	return SOURCE_AUTOMATION
+}
+
+// Resolves conflicts between sources setting the same device.
+// Each device can have a policy, and a cooldown after each write during which
+// it applies.
+type arbiter struct {
+	priorities map[string]int
+
+	mu   sync.Mutex
+	last map[*device]lastWrite
+}
+
+type lastWrite struct {
+	source string
+	at     time.Time
+}
+
+func newArbiter(priorities map[string]int) *arbiter {
+	ab := &arbiter{
+		priorities: make(map[string]int),
+		last:       make(map[*device]lastWrite),
+	}
+	for src, p := range defaultPriorities {
+		ab.priorities[src] = p
+	}
+	for src, p := range priorities {
+		ab.priorities[src] = p
+	}
+	return ab
+}
+
+func validatePolicy(topic, policy string) error {
+	switch policy {
+	case "", POLICY_LAST, POLICY_FIRST, POLICY_PRIORITY:
+		return nil
+	}
+	return fmt.Errorf("unknown policy %q for %q", policy, topic)
+}
+
+// Records a write to the device that didn't go through the action chain,
+// like someone pressing the switch
+func (ab *arbiter) Claim(d *device, source string, now time.Time) {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+	ab.last[d] = lastWrite{source, now}
+}
+
+// Checks if the source may write to the device, recording the write if so
+func (ab *arbiter) allow(d *device, source string, now time.Time) error {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	prev, found := ab.last[d]
+	if found && prev.source != source && now.Sub(prev.at) < d.cooldown {
+		switch d.policy {
+		case POLICY_FIRST:
+			return fmt.Errorf("%s set it %s ago", prev.source, now.Sub(prev.at).Round(time.Second))
+		case POLICY_PRIORITY:
+			if ab.priorities[source] < ab.priorities[prev.source] {
+				return fmt.Errorf("%s has priority over %s", prev.source, source)
+			}
+		}
+	}
+
+	ab.last[d] = lastWrite{source, now}
+	return nil
+}
+
+// Blocks actions losing out under their device's policy
+func (r *regelwerk) arbitrateActions(next actionFunc) actionFunc {
+	ab := r.arbiter
+	return func(ctx context.Context, a *action) error {
+		if a.dev == nil || a.dev.policy == "" || a.dev.policy == POLICY_LAST || isEmergency(ctx) {
+			return next(ctx, a)
+		}
+
+		if err := ab.allow(a.dev, a.source, r.clock.Now()); err != nil {
+			return err
+		}
+		return next(ctx, a)
+	}
+}
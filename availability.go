@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+)
+
+// how often to look for stale devices, at most
+const STALE_CHECK_INTERVAL = time.Minute
+
+// Periodically flags devices that haven't reported within their staleAfter
+// interval. Stale devices raise an alert, and are left out of rule
+// conditions until they report again.
+func (r *regelwerk) runStaleCheck(ctx context.Context) {
+	for r.idle.Sleep(ctx, STALE_CHECK_INTERVAL) {
+		for _, d := range r.trackedDevices() {
+			d.group.mu.Lock()
+			if !d.stale && r.clock.Now().Sub(d.lastUpdated) > d.staleAfter {
+				d.stale = true
+				r.states.Delete(d)
+				r.raiseAlert("stale/"+d.topic, "device %q has not reported for %s",
+					d.topic, r.clock.Now().Sub(d.lastUpdated).Round(time.Minute))
+			}
+			d.group.mu.Unlock()
+		}
+	}
+}
+
+// Returns the devices that have a staleAfter interval
+func (r *regelwerk) trackedDevices() []*device {
+	r.devicesMu.RLock()
+	defer r.devicesMu.RUnlock()
+
+	var list []*device
+	for _, d := range r.devices {
+		if d.staleAfter > 0 && !d.isTemplate() && !d.virtual {
+			list = append(list, d)
+		}
+	}
+	return list
+}
+
+// Records that the device reported in.
+// Must be called with the group lock held.
+func (r *regelwerk) deviceSeen(d *device) {
+	d.lastUpdated = r.clock.Now()
+	if d.stale {
+		d.stale = false
+		r.states.Set(d)
+		log.Printf("device %q is reporting again", d.topic)
+		r.resolveAlert("stale/" + d.topic)
+	}
+}
+
+// Lists the topics of stale devices
+func (r *regelwerk) StaleDevices() []string {
+	var stale []string
+	for _, d := range r.trackedDevices() {
+		d.group.mu.Lock()
+		if d.stale {
+			stale = append(stale, d.topic)
+		}
+		d.group.mu.Unlock()
+	}
+
+	sort.Strings(stale)
+	return stale
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// Reports devices whose battery level drops below the threshold
+type batteryConfig struct {
+	Low   float64 // percent
+	Alert bool    // also raise an alert when a device becomes low
+}
+
+type batteries struct {
+	cfg batteryConfig
+
+	mu     sync.Mutex
+	levels map[string]float64 // by topic
+	low    map[string]bool
+}
+
+// Battery levels of all devices that report one, and those that are low.
+// Published to regelwerk/battery whenever the set of low devices changes.
+type batteryReport struct {
+	Levels map[string]float64
+	Low    []string
+}
+
+// Records the battery level, if the device reported one
+func (r *regelwerk) observeBattery(d *device, payload map[string]any) {
+	b := r.batteries
+	if b == nil {
+		return
+	}
+
+	level, ok := payload["battery"].(float64)
+	if !ok {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.levels[d.topic] = level
+	if low := level < b.cfg.Low; low != b.low[d.topic] {
+		if low {
+			b.low[d.topic] = true
+			if b.cfg.Alert {
+				r.alert("battery of %q is low: %.0f%%", d.topic, level)
+			}
+		} else {
+			delete(b.low, d.topic)
+		}
+		r.publishJSON("battery", b.report())
+	}
+}
+
+// Must be called with the lock held
+func (b *batteries) report() batteryReport {
+	rep := batteryReport{Levels: make(map[string]float64, len(b.levels))}
+	for topic, level := range b.levels {
+		rep.Levels[topic] = level
+	}
+	for topic := range b.low {
+		rep.Low = append(rep.Low, topic)
+	}
+	sort.Strings(rep.Low)
+	return rep
+}
+
+func (r *regelwerk) BatteryReport() batteryReport {
+	b := r.batteries
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.report()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"testing"
+)
+
+// a typical sensor report, with the state attribute nested for the dotted
+// path
+var benchPayload = []byte(`{"battery":97,"voltage":3005,"linkquality":120,"temperature":21.5,` +
+	`"humidity":48.2,"contact":true,"update":{"state":"idle","installed_version":1}}`)
+
+// An instance with many devices, as on a busy broker
+func newBenchRegelwerk(b *testing.B) *regelwerk {
+	cfg := testConfig()
+	cfg.Devices = make(map[string]deviceConfig)
+	cfg.Sensors = nil
+	for i := 0; i < 200; i++ {
+		cfg.Sensors = append(cfg.Sensors, fmt.Sprintf("sensor_%d", i))
+	}
+
+	r, _ := newTestRegelwerk(b, cfg)
+	return r
+}
+
+func BenchmarkDecodePayload(b *testing.B) {
+	for _, attr := range []string{"contact", "update.state"} {
+		b.Run(attr, func(b *testing.B) {
+			d := &device{topic: "sensor", stateAttr: attr}
+			msg := &virtualMessage{topic: MQTT_TOPIC_PREFIX + "sensor", payload: benchPayload}
+
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := d.DecodePayload(msg); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// From the MQTT client's callback through the inbox to the rules
+func BenchmarkHandleMqtt(b *testing.B) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(out) })
+
+	r := newBenchRegelwerk(b)
+	msg := &virtualMessage{topic: MQTT_TOPIC_PREFIX + "sensor_100", payload: benchPayload}
+	g := r.deviceByTopic("sensor_100").group
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.handleMqtt(nil, msg)
+		ev, _ := g.inbox.Get(r.ctx)
+		r.processMessage(r.ctx, ev.dev, ev.msg)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Disables rules that fire more than MaxPerMinute times, e.g. because of a
+// flapping sensor or rules triggering each other, until CoolDown has passed
+type breakerConfig struct {
+	MaxPerMinute int
+	CoolDown     textDuration // default 15m
+}
+
+type breaker struct {
+	cfg breakerConfig
+
+	mu      sync.Mutex
+	fires   map[string][]time.Time // within the last minute, by rule
+	tripped map[string]time.Time   // until when rules are disabled
+}
+
+func newBreaker(cfg *breakerConfig) *breaker {
+	if cfg == nil {
+		return nil
+	}
+	b := &breaker{
+		cfg:     *cfg,
+		fires:   make(map[string][]time.Time),
+		tripped: make(map[string]time.Time),
+	}
+	if b.cfg.CoolDown <= 0 {
+		b.cfg.CoolDown = textDuration(15 * time.Minute)
+	}
+	return b
+}
+
+// Records that the rule fired. Returns whether it may run, and whether it
+// just tripped the breaker.
+func (b *breaker) Fire(rule string, now time.Time) (ok, tripped bool) {
+	if b == nil {
+		return true, false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if until, found := b.tripped[rule]; found {
+		if now.Before(until) {
+			return false, false
+		}
+		delete(b.tripped, rule)
+		log.Printf("rule %q re-enabled after cool-down", rule)
+	}
+
+	fires := b.fires[rule]
+	cutoff := now.Add(-time.Minute)
+	for len(fires) > 0 && !fires[0].After(cutoff) {
+		fires = fires[1:]
+	}
+	fires = append(fires, now)
+
+	if len(fires) > b.cfg.MaxPerMinute {
+		b.tripped[rule] = now.Add(time.Duration(b.cfg.CoolDown))
+		delete(b.fires, rule)
+		return false, true
+	}
+	b.fires[rule] = fires
+	return true, false
+}
+
+// Returns the rules disabled by the breaker, and until when
+func (b *breaker) Tripped(now time.Time) map[string]time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	tripped := make(map[string]time.Time)
+	for rule, until := range b.tripped {
+		if now.Before(until) {
+			tripped[rule] = until
+		}
+	}
+	return tripped
+}
+
+// Whether the rule is enabled and hasn't tripped the circuit breaker,
+// counting it as fired
+func (r *regelwerk) ruleFires(rule string) bool {
+	if !r.rules.Enabled(rule) {
+		return false
+	}
+
+	ok, tripped := r.breaker.Fire(rule, r.clock.Now())
+	if tripped {
+		r.alert("rule %q fired more than %d times in a minute, disabled for %s",
+			rule, r.breaker.cfg.MaxPerMinute, time.Duration(r.breaker.cfg.CoolDown))
+	}
+	return ok
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"regelwerk/mqttio"
+)
+
+// Tracks whether zigbee2mqtt itself is up, from its retained bridge/state
+type bridge struct {
+	mu      sync.Mutex
+	online  bool
+	offline bool // seen going offline, so a resync is needed when it returns
+}
+
+// Handles messages on the bridge/ topics, if bridge monitoring is enabled
+func (r *regelwerk) handleBridge(topic string, msg mqtt.Message) {
+	switch topic {
+	case "bridge/state":
+		// older versions send a plain string, newer ones JSON
+		state := string(msg.Payload())
+		var js struct{ State string }
+		if json.Unmarshal(msg.Payload(), &js) == nil {
+			state = js.State
+		}
+		r.setBridgeState(state == "online")
+
+	case "bridge/event":
+		payload, err := mqttio.DecodePayload(msg)
+		if err != nil {
+			log.Printf("error parsing bridge event: %v", err)
+			return
+		}
+		r.journal.Add("bridge", topic, payload)
+
+		if mqttio.GetMapValue(payload, "type") == "device_leave" {
+			data, _ := payload["data"].(map[string]any)
+			if name := mqttio.GetMapValue(data, "friendly_name"); r.deviceByTopic(name) != nil {
+				r.raiseAlert("left/"+name, "device %q left the network", name)
+			}
+		}
+	}
+}
+
+func (r *regelwerk) setBridgeState(online bool) {
+	b := r.bridge
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if online == b.online {
+		return
+	}
+	b.online = online
+
+	if !online {
+		b.offline = true
+		r.raiseAlert("bridge", "zigbee2mqtt is offline, pausing automation")
+	} else if b.offline {
+		b.offline = false
+		r.resolveAlert("bridge")
+		log.Printf("zigbee2mqtt is back online, resyncing device states")
+		go r.RequestStates()
+	} else {
+		log.Printf("zigbee2mqtt is online")
+	}
+}
+
+// Whether zigbee2mqtt is up, or not known to be down.
+// Always true if bridge monitoring isn't enabled.
+func (r *regelwerk) BridgeOnline() bool {
+	b := r.bridge
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.online || !b.offline
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"time"
+)
+
+// Brightness and color temperature for dimmable lights, depending on the
+// time of night: Max at dusk, fading to Min at midnight, until dawn.
+type brightnessConfig struct {
+	Max, Min  int
+	ColorTemp [2]int // mireds at dusk & midnight, e.g. [250, 450]
+}
+
+// Returns how far into the night it is, from 0 at dusk (or during the day)
+// to 1 at midnight and after, until dawn
+func nightFraction(t, dawn, dusk time.Time) float64 {
+	if t.Before(dawn) {
+		return 1
+	} else if t.Before(dusk) {
+		return 0
+	}
+
+	y, m, d := t.Date()
+	midnight := time.Date(y, m, d+1, 0, 0, 0, 0, t.Location())
+	return float64(t.Sub(dusk)) / float64(midnight.Sub(dusk))
+}
+
+// Adds brightness and color temperature for the current time to the payload
+func (r *regelwerk) addBrightness(payload map[string]any, t time.Time) {
+	bc := r.brightness
+	if bc == nil {
+		return
+	}
+
+	f := nightFraction(t, r.sun.Sunrise(t), r.sun.Sunset(t))
+	payload["brightness"] = bc.Max - int(f*float64(bc.Max-bc.Min)+0.5)
+	if bc.ColorTemp != [2]int{} {
+		payload["color_temp"] = bc.ColorTemp[0] + int(f*float64(bc.ColorTemp[1]-bc.ColorTemp[0])+0.5)
+	}
+}
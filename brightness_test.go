@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNightFraction(t *testing.T) {
+	at := func(hh, mm int) time.Time {
+		return time.Date(2022, 1, 1, hh, mm, 0, 0, time.Local)
+	}
+	dawn, dusk := at(7, 0), at(19, 0)
+
+	tests := []struct {
+		t time.Time
+		f float64
+	}{
+		{at(3, 0), 1},
+		{at(12, 0), 0},
+		{at(19, 0), 0},
+		{at(21, 30), 0.5},
+		{at(23, 59), 299. / 300.},
+	}
+	for _, tt := range tests {
+		if f := nightFraction(tt.t, dawn, dusk); f != tt.f {
+			t.Errorf("%s: wanted %v got %v", tt.t.Format("15:04"), tt.f, f)
+		}
+	}
+}
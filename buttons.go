@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"regelwerk/mqttio"
+)
+
+// Names of actions run together, e.g. to flash the lights and send a
+// notification when the doorbell rings. A single name or a list in the config.
+type actionList []string
+
+func (l *actionList) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		*l = actionList{name}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(l))
+}
+
+// Remembers when actions last ran, to ignore repeated triggers within their
+// Debounce, e.g. someone leaning on the doorbell
+type debouncer struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func newDebouncer() *debouncer {
+	return &debouncer{last: make(map[string]time.Time)}
+}
+
+// Whether the key may run at the time, recording it if so
+func (db *debouncer) Allow(key string, window time.Duration, now time.Time) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if last, found := db.last[key]; found && now.Sub(last) < window {
+		return false
+	}
+	db.last[key] = now
+	return true
+}
+
+// Runs the named actions mapped to the button action reported by the device.
+// The device's state isn't tracked, so this works for any transient action
+// event, like doorbells or vibration sensors.
+// Called with the group lock held.
+func (r *regelwerk) handleButton(ctx context.Context, d *device, payload map[string]any) {
+	mapping := r.buttons[d.topic]
+	if mapping == nil && d.pattern != "" {
+		mapping = r.buttons[d.pattern]
+	}
+	if mapping == nil {
+		return
+	}
+
+	action := mqttio.GetMapValue(payload, "action")
+	names, found := mapping[action]
+	if !found || !r.ruleFires(RULE_BUTTONS) {
+		return
+	}
+
+	now := r.clock.Now()
+	for _, name := range names {
+		spec := r.actions[name]
+		if !r.debounce.Allow(name, time.Duration(spec.Debounce), now) {
+			debugf(LOG_ACTIONS, "button %q %s: %q debounced", d.topic, action, name)
+			continue
+		}
+
+		log.Printf("button %q %s: running %q", d.topic, action, name)
+		if err := r.Run(withSource(withTrigger(ctx, payload), SOURCE_BUTTON), spec); err != nil {
+			log.Printf("action %q failed: %v", name, err)
+		}
+	}
+}
+
+// Checks that buttons refer to existing actions
+func validateButtons(buttons map[string]map[string]actionList, actions map[string]*actionSpec) error {
+	for name, a := range actions {
+		if actionTypes[a.Type] == nil {
+			return fmt.Errorf("action %q has unknown type %q", name, a.Type)
+		} else if err := validatePayload(a.Payload); err != nil {
+			return fmt.Errorf("action %q: %v", name, err)
+		} else if err := validateCondition(a.If); err != nil {
+			return fmt.Errorf("action %q condition: %v", name, err)
+		}
+
+		if check := actionChecks[a.Type]; check != nil {
+			if err := check(a); err != nil {
+				return fmt.Errorf("action %q: %v", name, err)
+			}
+		}
+	}
+
+	for topic, mapping := range buttons {
+		for action, names := range mapping {
+			for _, name := range names {
+				if actions[name] == nil {
+					return fmt.Errorf("button %q %s refers to unknown action %q", topic, action, name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func init() {
+	actionTypes["publish"] = func(ctx context.Context, r *regelwerk, spec *actionSpec) error {
+		st := sceneStep{Device: spec.Device, Topic: spec.Topic, Payload: spec.Payload}
+		a, err := st.action(ctx, r)
+		if err != nil {
+			return err
+		}
+		a.source = sourceOf(ctx)
+		r.Do(ctx, a)
+		return nil
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"regelwerk/timers"
+)
+
+// Events from an iCal feed, e.g. public holidays or a shared family calendar.
+// Recurring events (RRULE) are not expanded, only their first occurrence
+// counts.
+type calendarConfig struct {
+	URL     string       // http(s) URL or local .ics file
+	Refresh textDuration // how often to reload it
+
+	// named actions to run when events with the summary start
+	OnStart map[string]string
+}
+
+type calendarEvent struct {
+	Summary    string
+	Start, End time.Time
+	AllDay     bool
+}
+
+type calendar struct {
+	cfg calendarConfig
+
+	mu     sync.Mutex
+	events []calendarEvent
+}
+
+func validateCalendar(cfg *calendarConfig, actions map[string]*actionSpec) error {
+	if cfg == nil {
+		return nil
+	} else if cfg.URL == "" {
+		return fmt.Errorf("Calendar needs a URL")
+	} else if cfg.Refresh <= 0 {
+		cfg.Refresh = textDuration(6 * time.Hour)
+	}
+
+	for summary, name := range cfg.OnStart {
+		if actions[name] == nil {
+			return fmt.Errorf("calendar event %q refers to unknown action %q", summary, name)
+		}
+	}
+	return nil
+}
+
+// Whether there's an all-day event on the day, like a holiday
+func (c *calendar) AllDayEvent(day time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	y, m, d := day.Date()
+	noon := time.Date(y, m, d, 12, 0, 0, 0, day.Location())
+	for _, ev := range c.events {
+		if ev.AllDay && !noon.Before(ev.Start) && noon.Before(ev.End) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the summary of a timed event going on at t, if any
+func (c *calendar) CurrentEvent(t time.Time) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, ev := range c.events {
+		if !ev.AllDay && !t.Before(ev.Start) && t.Before(ev.End) {
+			return ev.Summary
+		}
+	}
+	return ""
+}
+
+// Reloads the calendar every Refresh until the context is done
+func (r *regelwerk) runCalendar(ctx context.Context) {
+	c := r.calendar
+	for {
+		if err := r.loadCalendar(ctx); err != nil {
+			log.Printf("unable to load calendar: %v", err)
+		}
+
+		if !r.idle.Sleep(ctx, time.Duration(c.cfg.Refresh)) {
+			return
+		}
+	}
+}
+
+func (r *regelwerk) loadCalendar(ctx context.Context) error {
+	c := r.calendar
+
+	var rd io.ReadCloser
+	if strings.HasPrefix(c.cfg.URL, "http://") || strings.HasPrefix(c.cfg.URL, "https://") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.cfg.URL, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		} else if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return fmt.Errorf("server returned %s", resp.Status)
+		}
+		rd = resp.Body
+	} else {
+		f, err := os.Open(c.cfg.URL)
+		if err != nil {
+			return err
+		}
+		rd = f
+	}
+	defer rd.Close()
+
+	events, err := parseICal(rd)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.events = events
+	c.mu.Unlock()
+
+	log.Printf("loaded %d calendar events", len(events))
+	r.scheduleCalendarActions(events)
+	return nil
+}
+
+// Adds timers for the configured actions of events starting before the next
+// refresh
+func (r *regelwerk) scheduleCalendarActions(events []calendarEvent) {
+	c := r.calendar
+	now := r.clock.Now()
+	until := now.Add(time.Duration(c.cfg.Refresh))
+
+	for _, ev := range events {
+		action, found := c.cfg.OnStart[ev.Summary]
+		if !found || ev.Start.Before(now) || ev.Start.After(until) {
+			continue
+		}
+
+		name := fmt.Sprintf("calendar/%s/%d", ev.Summary, ev.Start.Unix())
+		if r.timers.Add(name, "calendar", map[string]string{"action": action}) != nil {
+			r.timers.Start(name, ev.Start.Sub(now))
+		}
+	}
+}
+
+func (r *regelwerk) handleCalendarTimer(ctx context.Context, tm *timers.Timer, expired bool) {
+	name := tm.Meta("action")
+	log.Printf("calendar event %q started: running %q", tm.Name(), name)
+	if spec := r.actions[name]; spec == nil {
+		log.Printf("unknown action %q", name)
+	} else if err := r.Run(ctx, spec); err != nil {
+		log.Printf("action %q failed: %v", name, err)
+	}
+}
+
+// Parses the VEVENTs of an iCal file
+func parseICal(rd io.Reader) ([]calendarEvent, error) {
+	// unfold continuation lines first
+	var lines []string
+	sc := bufio.NewScanner(rd)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+		} else {
+			lines = append(lines, line)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	var events []calendarEvent
+	var ev *calendarEvent
+	for _, line := range lines {
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		name, params, _ := strings.Cut(name, ";")
+
+		switch {
+		case line == "BEGIN:VEVENT":
+			ev = &calendarEvent{}
+		case line == "END:VEVENT" && ev != nil:
+			if ev.End.IsZero() {
+				ev.End = ev.Start
+				if ev.AllDay {
+					ev.End = ev.Start.AddDate(0, 0, 1)
+				}
+			}
+			if !ev.Start.IsZero() {
+				events = append(events, *ev)
+			}
+			ev = nil
+		case ev == nil:
+		case name == "SUMMARY":
+			ev.Summary = strings.NewReplacer(`\,`, ",", `\;`, ";", `\n`, " ", `\\`, `\`).Replace(value)
+		case name == "DTSTART" || name == "DTEND":
+			t, allDay, err := parseICalTime(params, value)
+			if err != nil {
+				return nil, fmt.Errorf("bad %s %q: %v", name, value, err)
+			}
+			if name == "DTSTART" {
+				ev.Start, ev.AllDay = t, allDay
+			} else {
+				ev.End = t
+			}
+		}
+	}
+	return events, nil
+}
+
+// Parses a date or date-time value, in UTC, the TZID param's zone or local
+// time
+func parseICalTime(params, value string) (t time.Time, allDay bool, err error) {
+	loc := time.Local
+	for _, p := range strings.Split(params, ";") {
+		if strings.HasPrefix(p, "TZID=") {
+			if l, err := time.LoadLocation(p[len("TZID="):]); err == nil {
+				loc = l
+			}
+		}
+	}
+
+	switch {
+	case len(value) == 8:
+		t, err = time.ParseInLocation("20060102", value, time.Local)
+		return t, true, err
+	case strings.HasSuffix(value, "Z"):
+		t, err = time.Parse("20060102T150405Z", value)
+	default:
+		t, err = time.ParseInLocation("20060102T150405", value, loc)
+	}
+	return t, false, err
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testICal = `BEGIN:VCALENDAR
+BEGIN:VEVENT
+DTSTART;VALUE=DATE:20221225
+DTEND;VALUE=DATE:20221226
+SUMMARY:Christmas Day
+END:VEVENT
+BEGIN:VEVENT
+DTSTART:20221227T063000Z
+DTEND:20221227T070000Z
+SUMMARY:Wake up\, early
+ bird
+END:VEVENT
+END:VCALENDAR
+`
+
+func TestParseICal(t *testing.T) {
+	events, err := parseICal(strings.NewReader(strings.ReplaceAll(testICal, "\n", "\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(events) != 2 {
+		t.Fatalf("wanted 2 events, got %d", len(events))
+	}
+
+	c := &calendar{events: events}
+	if !c.AllDayEvent(time.Date(2022, 12, 25, 8, 0, 0, 0, time.Local)) {
+		t.Errorf("expected holiday on 25th")
+	} else if c.AllDayEvent(time.Date(2022, 12, 26, 8, 0, 0, 0, time.Local)) {
+		t.Errorf("expected no holiday on 26th")
+	}
+
+	if ev := c.CurrentEvent(time.Date(2022, 12, 27, 6, 45, 0, 0, time.UTC)); ev != "Wake up, earlybird" {
+		t.Errorf("wrong current event %q", ev)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// how long the client waits for the broker, and for replies
+const CLIENT_TIMEOUT = 5 * time.Second
+
+const CLIENT_USAGE = `commands for a running instance:
+  status                     print the status report
+  discover [json|interactive]
+                             list zigbee2mqtt's devices with what they
+                             expose, or print config for them, asking for
+                             their roles if interactive
+  cancel-session <name>      end the contact or motion session
+  set <device> <state>       set a device's state, e.g. set switch ON
+  scene <name>               activate a scene
+  vacation <on|off>          turn vacation mode on or off
+  alarm <arm|disarm>         arm or disarm the alarm
+  sim <device> <attr=value>  change a device simulated with -simulate,
+                             e.g. sim 0x00158d00037aa30d contact=false
+`
+
+// Runs a command against the running instance, through its control topics
+func runClient(cfg *config, args []string) error {
+	var topic, payload string
+	switch {
+	case args[0] == "status" && len(args) == 1:
+		return clientStatus(cfg)
+	case args[0] == "discover" && len(args) <= 2:
+		mode := ""
+		if len(args) == 2 {
+			mode = args[1]
+		}
+		return discover(cfg, mode, os.Stdin, os.Stdout)
+	case args[0] == "cancel-session" && len(args) == 2:
+		topic, payload = "session/cancel", args[1]
+	case args[0] == "set" && len(args) == 3:
+		js, _ := json.Marshal(deviceSetCommand{Device: args[1], State: parseStateArg(args[2])})
+		topic, payload = "device/set", string(js)
+	case args[0] == "scene" && len(args) == 2:
+		topic, payload = "scene/activate", args[1]
+	case args[0] == "vacation" && len(args) == 2:
+		topic, payload = "vacation/set", strings.ToUpper(args[1])
+	case args[0] == "alarm" && len(args) == 2:
+		topic, payload = "alarm/set", args[1]
+	case args[0] == "sim" && len(args) >= 3:
+		cmd := simulateCommand{Device: args[1], State: make(map[string]any)}
+		for _, arg := range args[2:] {
+			attr, value, found := strings.Cut(arg, "=")
+			if !found {
+				return fmt.Errorf("expected attr=value, got %q", arg)
+			}
+			cmd.State[attr] = parseStateArg(value)
+		}
+		js, _ := json.Marshal(cmd)
+		topic, payload = "simulate/set", string(js)
+	default:
+		return fmt.Errorf("unknown command %q\n%s", strings.Join(args, " "), CLIENT_USAGE)
+	}
+
+	c, err := connectClient(cfg)
+	if err != nil {
+		return err
+	}
+	defer c.Disconnect(250)
+
+	tok := c.Publish(CONTROL_TOPIC_PREFIX+topic, 1, false, payload)
+	if !tok.WaitTimeout(CLIENT_TIMEOUT) {
+		return fmt.Errorf("timed out sending command")
+	}
+	return tok.Error()
+}
+
+// Requests the status report and prints it
+func clientStatus(cfg *config) error {
+	c, err := connectClient(cfg)
+	if err != nil {
+		return err
+	}
+	defer c.Disconnect(250)
+
+	reply := make(chan []byte, 1)
+	tok := c.Subscribe(CONTROL_TOPIC_PREFIX+"status", 0, func(_ mqtt.Client, msg mqtt.Message) {
+		select {
+		case reply <- msg.Payload():
+		default:
+		}
+	})
+	if !tok.WaitTimeout(CLIENT_TIMEOUT) {
+		return fmt.Errorf("timed out subscribing")
+	} else if tok.Error() != nil {
+		return tok.Error()
+	}
+	c.Publish(CONTROL_TOPIC_PREFIX+"status/get", 0, false, "")
+
+	select {
+	case js := <-reply:
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, js, "", "  "); err != nil {
+			return err
+		}
+		fmt.Println(buf.String())
+		return nil
+	case <-time.After(CLIENT_TIMEOUT):
+		return fmt.Errorf("no reply, is regelwerk running?")
+	}
+}
+
+func connectClient(cfg *config) (mqtt.Client, error) {
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.Server).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetClientID(fmt.Sprintf("regelwerk-cli-%d", os.Getpid()))
+
+	c := mqtt.NewClient(opts)
+	tok := c.Connect()
+	if !tok.WaitTimeout(CLIENT_TIMEOUT) {
+		return nil, fmt.Errorf("timed out connecting to %s", cfg.Server)
+	} else if tok.Error() != nil {
+		return nil, tok.Error()
+	}
+	return c, nil
+}
+
+// Takes numbers and booleans as such, anything else as a string
+func parseStateArg(s string) any {
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err == nil {
+		switch v.(type) {
+		case float64, bool:
+			return v
+		}
+	}
+	return s
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"regelwerk/rules"
+)
+
+// Checks that the condition can be parsed, if set
+func validateCondition(cond string) error {
+	_, err := rules.ParseCondition(cond)
+	return err
+}
+
+// Builds the environment for conditions:
+// payload and topic of the triggering event, devices.<id or topic>.state
+// and .on, sun.isDark and sun.isLateNight, sun.azimuth and sun.elevation in degrees
+// (null without a Location), now.hour, now.minute and now.weekday
+// (0 is Sunday), home, and calendar, weather, stats, counters, energy and
+// modbus.<name> if configured
+func (r *regelwerk) exprEnv(ctx context.Context) map[string]any {
+	devices := make(map[string]any)
+	for k, state := range r.states.Snapshot() {
+		devices[k] = map[string]any{
+			"state": state,
+			"on":    state == "ON" || state == true,
+		}
+	}
+
+	now := r.clock.Now()
+	sun := map[string]any{
+		"isDark":      r.NowIsDusk(nil),
+		"isLateNight": now.Before(r.sun.Sunrise(now)),
+	}
+	if r.sun.known() {
+		sun["azimuth"], sun["elevation"] = r.sun.Position(now)
+	}
+
+	payload, _ := ctx.Value(triggerKey{}).(map[string]any)
+	topic, _ := ctx.Value(topicKey{}).(string)
+	return map[string]any{
+		"payload": payload,
+		"topic":   topic,
+		"devices": devices,
+		"sun":     sun,
+		"now": map[string]any{
+			"hour":    float64(now.Hour()),
+			"minute":  float64(now.Minute()),
+			"weekday": float64(now.Weekday()),
+		},
+		"home":     r.SomeoneHome(),
+		"calendar": r.calendarEnv(now),
+		"weather":  r.weatherEnv(),
+		"stats":    r.statsEnv(now),
+		"counters": r.countersEnv(now),
+		"energy":   r.energyEnv(now),
+		"modbus":   r.modbusEnv(),
+	}
+}
+
+// modbus.<name> with the last value read of each input: a bool for coils,
+// a number for registers, or null if not known
+func (r *regelwerk) modbusEnv() map[string]any {
+	if r.modbus == nil {
+		return nil
+	}
+	return r.modbus.Values()
+}
+
+// weather.cloudCover in percent and weather.rain in mm for the next hour,
+// or null if not known
+func (r *regelwerk) weatherEnv() map[string]any {
+	if r.weather == nil {
+		return nil
+	}
+
+	rep := r.weather.Report()
+	if rep.Updated.IsZero() {
+		return nil
+	}
+	return map[string]any{"cloudCover": rep.CloudCover, "rain": rep.Rain}
+}
+
+// calendar.holiday if there's an all-day event today, calendar.workday if
+// it's a weekday without one, and calendar.event with the summary of a
+// current event, if any
+func (r *regelwerk) calendarEnv(now time.Time) map[string]any {
+	if r.calendar == nil {
+		return nil
+	}
+
+	holiday := r.calendar.AllDayEvent(now)
+	weekend := now.Weekday() == time.Saturday || now.Weekday() == time.Sunday
+	return map[string]any{
+		"holiday": holiday,
+		"workday": !holiday && !weekend,
+		"event":   r.calendar.CurrentEvent(now),
+	}
+}
+
+// Evaluates the parsed condition. A nil condition is always true.
+func (r *regelwerk) checkCondition(ctx context.Context, cond rules.Expr) bool {
+	if cond == nil {
+		return true
+	}
+	return rules.Truthy(cond.Eval(r.exprEnv(ctx)))
+}
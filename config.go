@@ -0,0 +1,425 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	// matches whole line comments in config file
+	CONFIG_COMMENTS_RE = regexp.MustCompile(`(?m)^\s*//.*$`)
+
+	// for MQTT server URI validation
+	SERVER_URL_RE = regexp.MustCompile(`^[a-z]+://.*:[0-9]{1,5}$`)
+)
+
+// Program config, directly filled by json.Unmarshal
+type config struct {
+	// schema version, see CONFIG_VERSION
+	Version int
+
+	// MQTT server & credentials
+	Server, Username, Password string
+
+	Location location // [lat, long] or a place name
+	SunAngle int
+
+	// durations by name, which other durations can refer to
+	Durations map[string]textDuration
+
+	// settings per session, since Version 2. they replace the session
+	// settings below, which are filled from them
+	Sessions map[string]sessionConfig
+
+	OffDelay       textDuration
+	MotionOffDelay textDuration
+	MotionExpiry   textDuration
+	ContactExpiry  textDuration // none by default
+	Sensor, Switch string
+	MotionSensor   string
+
+	// what happens when a session expires, by session name, see sessionKind
+	SessionExpiry map[string][]string
+
+	// for dimmable lights, set brightness according to time of night
+	Brightness *brightnessConfig
+
+	// extra condition for starting sessions, see rules.Expr
+	SessionIf string
+
+	// devices with states computed from conditions, by name
+	Virtual map[string]string
+
+	// messages to republish under other topics, optionally transformed
+	Republish []republishConfig
+
+	// queue commands, retrying those not acknowledged by the broker
+	SendQueue *sendQueueConfig
+
+	// action to run when a command couldn't be published
+	OnPublishFailure string
+
+	// zigbee2mqtt groups by topic, with their members' states tracked
+	Z2MGroups map[string]z2mGroupConfig
+
+	// pause automation while zigbee2mqtt is offline, and resync afterwards
+	WatchBridge bool
+
+	// subscribe to the devices' topics only, instead of all of zigbee2mqtt
+	SubscribeDevices bool
+
+	// after (re)subscribing, messages within this window and retained ones
+	// only update states without firing rules
+	SyncWindow textDuration
+
+	// alert about devices with a state that haven't reported for this long
+	StaleAfter textDuration
+
+	// optional low battery report
+	Battery *batteryConfig
+
+	// optional warnings about weak zigbee links
+	LinkQuality *linkQualityConfig
+
+	// optional export of device attributes to InfluxDB
+	Export *exportConfig
+
+	// optional OpenTelemetry tracing of message handling
+	Tracing *tracingConfig
+
+	// more sensors for the same session; any of them can start the session,
+	// but it only ends once all of them are clear
+	Sensors, MotionSensors []string
+
+	// size of the event queues per group, and topics that bypass them
+	QueueSize      int
+	CriticalTopics []string
+
+	// power-save when there are no events for a while
+	IdleAfter  textDuration
+	IdleFactor int
+
+	// per-device settings, keyed by topic
+	Devices map[string]deviceConfig
+
+	// per-group settings, by group name
+	Groups map[string]groupConfig
+
+	// number of recent events & actions to remember
+	JournalSize int
+
+	// debug or info per log category, e.g. {"timers": "debug"}
+	LogLevels map[string]string
+
+	// address for the /healthz and /status HTTP endpoints, if set. without a
+	// host, e.g. ":8086", it's only served on localhost
+	HTTPAddr string
+
+	// if set, POSTs to the HTTP endpoints need "Authorization: Bearer <token>"
+	HTTPToken string
+
+	// /healthz fails when no message was received for this long, if set
+	HealthStaleAfter textDuration
+
+	// path of the unix socket for local control, if set
+	ControlSocket string
+
+	// how long to wait for devices to confirm commands, and how many times
+	// to resend them
+	ConfirmTimeout textDuration
+	ConfirmRetries int
+
+	// priorities of action sources (manual, button, scene, sun, thermostat,
+	// fan, vacation, session), for devices with the priority policy
+	Priorities map[string]int
+
+	// named lists of publishes, activated together
+	Scenes map[string][]sceneStep
+
+	// optional presence detection
+	Presence *presenceConfig
+
+	// optional weather conditions, needs Location
+	Weather *weatherConfig
+
+	// optional device for whether this machine is in use, from logind
+	Logind *logindConfig
+
+	// optional device for whether phones or the like are on the network
+	NetworkPresence *netPresenceConfig
+
+	// optional KNX IP gateway, for knx actions
+	KNX *knxConfig
+
+	// Modbus TCP coils & registers to poll for conditions, by name
+	Modbus map[string]modbusInput
+
+	// optional calendar for conditions & scheduled actions
+	Calendar *calendarConfig
+
+	// sensors are ignored for this long after the lights are turned off by hand
+	ManualOffGrace textDuration
+
+	// action to run shortly before a session turns off the lights
+	OffWarning *offWarningConfig
+
+	// lengthens MotionOffDelay while motion keeps re-triggering
+	AdaptiveOffDelay *adaptiveDelayConfig
+
+	// when motion doesn't turn on the lights, or only dims them
+	QuietHours *quietHoursConfig
+
+	// actions to run at dawn or dusk
+	SunTriggers []sunTriggerConfig
+
+	// covers & blinds, optionally closed while the sun shines in, needs Location
+	Covers map[string]coverConfig
+
+	// radiator valves, by topic
+	Thermostats map[string]thermostatConfig
+
+	// longest chain of rules triggered by each other's commands, default 5
+	LoopLimit int
+
+	// disables rules firing too often, e.g. for a flapping sensor
+	CircuitBreaker *breakerConfig
+
+	// optional alarm, armed by command or action
+	Alarm *alarmConfig
+
+	// humidity-controlled fans, by topic
+	Fans map[string]fanConfig
+
+	// rolling statistics of numeric attributes, by name, for conditions and
+	// the Export
+	Stats map[string]statConfig
+
+	// counters by name, incremented by counter actions or device events
+	Counters map[string]counterConfig
+
+	// daily consumption of plugs reporting power, by name
+	Energy map[string]energyConfig
+
+	// cycles of appliances detected from their plug's power, by name
+	Appliances map[string]applianceConfig
+
+	// optional acknowledgement & escalation of alerts
+	Alerts *alertsConfig
+
+	// optional water leak & smoke sensors, whose actions run until acknowledged
+	Emergency *emergencyConfig
+
+	// optional presence simulation
+	Vacation *vacationConfig
+
+	// what switch button actions do, keyed by z2m action
+	Overrides map[string]overrideConfig
+
+	// named actions, and the button actions of remotes that run them
+	Actions map[string]*actionSpec
+	Buttons map[string]map[string]actionList // topic -> z2m action -> action names
+}
+
+// Settings of a group of devices, e.g. for an outbuilding elsewhere
+type groupConfig struct {
+	Location location // overrides the global Location
+	SunAngle int      // overrides the global SunAngle
+}
+
+type deviceConfig struct {
+	Group       string       // e.g. room, for concurrent processing
+	StateAttr   string       // overrides the state attribute, can be a dotted path
+	StateKind   string       // bool, string or number, otherwise the first state's
+	Debounce    textDuration // settle time before state changes fire
+	MinInterval textDuration // between publishes to the device
+	MinToggle   textDuration // between state changes, others are dropped
+
+	// format of the payloads, see PAYLOAD_JSON, and full MQTT topics for
+	// devices outside of zigbee2mqtt, or those derived by an Adapter
+	Payload             string
+	Topic, CommandTopic string
+	Adapter             string
+	Relay               int // for tasmota & shelly, of devices with several
+
+	ChangeThreshold float64 // numeric states must change by at least this much
+
+	Dedup textDuration // drop identical payloads repeated within this window
+
+	StaleAfter textDuration // overrides the global StaleAfter
+
+	// when several sources set the device, see arbiter
+	Policy   string
+	Cooldown textDuration
+
+	// multi-press & long-press detection for buttons, see gestureConfig
+	Gestures *gestureConfig
+}
+
+type textDuration time.Duration
+
+func (d *textDuration) UnmarshalText(b []byte) error {
+	// tolerate spaces
+	t := strings.ReplaceAll(string(b), " ", "")
+	if t == "" {
+		return nil
+	}
+
+	dur, err := parseNamedDuration(t)
+	if err != nil {
+		return err
+	} else if dur.Seconds() < 0 {
+		return fmt.Errorf("duration cannot be negative")
+	}
+
+	*d = textDuration(dur)
+	return nil
+}
+
+func parseConfig(fname string, cfg *config) error {
+	cfgStr, err := readConfig(fname)
+	if err != nil {
+		return err
+	}
+
+	if namedDurations, err = loadNamedDurations(cfgStr); err != nil {
+		return err
+	}
+	defer func() { namedDurations = nil }()
+
+	// older schema versions are converted first
+	raw, err := migrateConfig(cfgStr)
+	if err != nil {
+		return err
+	}
+	if cfgStr, err = json.Marshal(raw); err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(cfgStr, cfg); err != nil {
+		return err
+	}
+	return cfg.applySessions()
+}
+
+// Parses the config file over the defaults, and checks it for errors
+func loadConfig(fname string) (*config, error) {
+	cfg := config{
+		// default values
+		SunAngle: 96,
+
+		OffDelay:       textDuration(15 * time.Second),
+		MotionOffDelay: textDuration(100 * time.Second),
+		MotionExpiry:   textDuration(5 * time.Minute),
+
+		QueueSize:   64,
+		JournalSize: 100,
+
+		ConfirmTimeout: textDuration(5 * time.Second),
+		ConfirmRetries: 2,
+
+		Overrides: map[string]overrideConfig{
+			"single_right": {Mode: OVERRIDE_DISCARD},
+		},
+
+		IdleFactor: 10,
+	}
+	if err := parseConfig(fname, &cfg); err != nil {
+		return nil, fmt.Errorf("unable to parse config: %v", err)
+	}
+
+	if cfg.Server == "" {
+		return nil, errors.New("MQTT server not specified")
+	} else if !SERVER_URL_RE.MatchString(cfg.Server) {
+		return nil, errors.New("invalid MQTT server: needs to be in URL format with port")
+	} else if cfg.QueueSize <= 0 {
+		return nil, errors.New("QueueSize must be positive")
+	} else if b := cfg.Brightness; b != nil && (b.Min < 0 || b.Max > 254 || b.Min > b.Max) {
+		return nil, errors.New("Brightness needs 0 <= Min <= Max <= 254")
+	} else if cfg.Export != nil && (cfg.Export.URL == "" || len(cfg.Export.Attrs) == 0) {
+		return nil, errors.New("Export needs a URL and Attrs")
+	} else if cfg.Tracing != nil && cfg.Tracing.URL == "" {
+		return nil, errors.New("Tracing needs a URL")
+	} else if cfg.KNX != nil && cfg.KNX.Gateway == "" {
+		return nil, errors.New("KNX needs a Gateway")
+	} else if err := validateModbus(cfg.Modbus); err != nil {
+		return nil, err
+	} else if err := validateNetPresence(cfg.NetworkPresence); err != nil {
+		return nil, err
+	} else if err := validateCondition(cfg.SessionIf); err != nil {
+		return nil, fmt.Errorf("bad SessionIf: %v", err)
+	} else if err := cfg.Location.Validate(); err != nil {
+		return nil, fmt.Errorf("bad Location: %v", err)
+	} else if err := validateGroups(cfg.Groups, cfg.Devices); err != nil {
+		return nil, err
+	} else if err := validateLogLevels(cfg.LogLevels); err != nil {
+		return nil, err
+	} else if cfg.JournalSize < 0 {
+		return nil, errors.New("JournalSize cannot be negative")
+	} else if cfg.IdleFactor < 1 {
+		return nil, errors.New("IdleFactor must be at least 1")
+	} else if err := validateScenes(cfg.Scenes); err != nil {
+		return nil, err
+	} else if cfg.Presence != nil && cfg.Presence.AwayAfter <= 0 {
+		return nil, errors.New("Presence needs AwayAfter")
+	} else if cfg.Vacation != nil && cfg.Vacation.MaxOn < cfg.Vacation.MinOn {
+		return nil, errors.New("Vacation MaxOn must not be less than MinOn")
+	} else if err := validateOverrides(cfg.Overrides); err != nil {
+		return nil, err
+	} else if err := validateButtons(cfg.Buttons, cfg.Actions); err != nil {
+		return nil, err
+	} else if err := validateCalendar(cfg.Calendar, cfg.Actions); err != nil {
+		return nil, err
+	} else if err := validateSunTriggers(cfg.SunTriggers, cfg.Actions); err != nil {
+		return nil, err
+	} else if cfg.SendQueue != nil && cfg.SendQueue.Retries < 0 {
+		return nil, errors.New("SendQueue Retries cannot be negative")
+	} else if cfg.OnPublishFailure != "" && cfg.Actions[cfg.OnPublishFailure] == nil {
+		return nil, fmt.Errorf("OnPublishFailure refers to unknown action %q", cfg.OnPublishFailure)
+	} else if err := cfg.QuietHours.validate(); err != nil {
+		return nil, err
+	} else if w := cfg.OffWarning; w != nil && (w.Before <= 0 || cfg.Actions[w.Action] == nil) {
+		return nil, errors.New("OffWarning needs Before and a known Action")
+	} else if err := cfg.AdaptiveOffDelay.validate(time.Duration(cfg.MotionOffDelay)); err != nil {
+		return nil, err
+	} else if cfg.Weather != nil && !cfg.Location.set {
+		return nil, errors.New("Weather needs Location")
+	} else if err := validateCovers(cfg.Covers); err != nil {
+		return nil, err
+	} else if len(cfg.Covers) > 0 && !cfg.Location.set {
+		return nil, errors.New("Covers need Location")
+	} else if err := validateThermostats(cfg.Thermostats); err != nil {
+		return nil, err
+	} else if err := validateFans(cfg.Fans); err != nil {
+		return nil, err
+	} else if err := validateStats(cfg.Stats); err != nil {
+		return nil, err
+	} else if err := validateCounters(cfg.Counters); err != nil {
+		return nil, err
+	} else if err := validateEnergy(cfg.Energy, cfg.Actions); err != nil {
+		return nil, err
+	} else if err := validateAppliances(cfg.Appliances, cfg.Actions); err != nil {
+		return nil, err
+	} else if err := validateAlarm(cfg.Alarm, cfg.Actions); err != nil {
+		return nil, err
+	} else if err := validateEmergency(cfg.Emergency, cfg.Actions); err != nil {
+		return nil, err
+	} else if err := validateAlerts(cfg.Alerts); err != nil {
+		return nil, err
+	} else if err := validateSessionExpiry(cfg.SessionExpiry); err != nil {
+		return nil, err
+	} else if cfg.LoopLimit < 0 {
+		return nil, errors.New("LoopLimit cannot be negative")
+	} else if b := cfg.CircuitBreaker; b != nil && b.MaxPerMinute <= 0 {
+		return nil, errors.New("CircuitBreaker needs MaxPerMinute")
+	}
+
+	if cfg.Weather != nil && cfg.Weather.Refresh <= 0 {
+		cfg.Weather.Refresh = textDuration(30 * time.Minute)
+	}
+
+	return &cfg, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"regelwerk/mqttio"
+	"regelwerk/timers"
+)
+
+// Tracks commands until the device reports the new state.
+// Unconfirmed commands are resent, up to a number of retries, after which an
+// alert is raised.
+type confirmer struct {
+	timeout time.Duration
+	retries int
+
+	mu      sync.Mutex
+	pending map[*device]*pendingCommand
+}
+
+type pendingCommand struct {
+	a        *action
+	want     any
+	attempts int
+	t        timers.ClockTimer
+}
+
+func newConfirmer(timeout time.Duration, retries int) *confirmer {
+	return &confirmer{
+		timeout: timeout,
+		retries: retries,
+		pending: make(map[*device]*pendingCommand),
+	}
+}
+
+// Returns a middleware that tracks actions setting a device's state
+func (r *regelwerk) confirmActions(c *confirmer) middleware {
+	return func(next actionFunc) actionFunc {
+		return func(ctx context.Context, a *action) error {
+			if a.dev == nil || a.dev.stateAttr == "" || c.timeout == 0 {
+				return next(ctx, a)
+			}
+
+			m, _ := a.payload.(map[string]any)
+			want, ok := mqttio.LookupPath(m, a.dev.stateAttr)
+			if !ok {
+				return next(ctx, a)
+			}
+			want = a.dev.normalizeState(want)
+
+			c.mu.Lock()
+			if old := c.pending[a.dev]; old != nil {
+				old.t.Stop()
+			}
+			pc := &pendingCommand{a: a, want: want}
+			pc.t = r.clock.AfterFunc(c.timeout, func() { c.expire(ctx, a.dev, pc, next, r) })
+			c.pending[a.dev] = pc
+			c.mu.Unlock()
+
+			return next(ctx, a)
+		}
+	}
+}
+
+func (c *confirmer) expire(ctx context.Context, d *device, pc *pendingCommand, send actionFunc, r *regelwerk) {
+	c.mu.Lock()
+	if c.pending[d] != pc || ctx.Err() != nil {
+		c.mu.Unlock()
+		return
+	}
+
+	pc.attempts++
+	if pc.attempts > c.retries {
+		delete(c.pending, d)
+		c.mu.Unlock()
+
+		r.alert("dev %q did not confirm %s after %d attempts", d.id, pc.a, pc.attempts)
+		return
+	}
+
+	pc.t.Reset(c.timeout)
+	c.mu.Unlock()
+
+	log.Printf("dev %q did not confirm %s, resending", d.id, pc.a)
+	if err := send(ctx, pc.a); err != nil {
+		log.Printf("action %s not sent: %v", pc.a, err)
+	}
+}
+
+// Checks the device's reported state against the pending command, if any.
+// Called with the group lock held.
+func (c *confirmer) Observe(d *device) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pc := c.pending[d]
+	if pc != nil && d.state == pc.want {
+		pc.t.Stop()
+		delete(c.pending, d)
+
+		debugf(LOG_ACTIONS, "dev %q confirmed %s", d.id, pc.a)
+	}
+}
+
+// Returns the state the device is expected to be in, which is the state of
+// a pending command, or the last reported state
+func (c *confirmer) Expected(d *device) any {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if pc := c.pending[d]; pc != nil {
+		return pc.want
+	}
+	return d.state
+}
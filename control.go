@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Commands to regelwerk itself are published under this prefix, e.g.
+// "regelwerk/scene/activate"
+const CONTROL_TOPIC_PREFIX = "regelwerk/"
+
+// Handles a control command.
+// Commands may run concurrently with device events, so they must do their own
+// locking.
+type commandFunc func(ctx context.Context, payload []byte) error
+
+// Registers a handler for the control topic, relative to the prefix
+func (r *regelwerk) HandleCommand(topic string, fn commandFunc) {
+	r.commands[topic] = fn
+}
+
+func (r *regelwerk) handleControl(_ mqtt.Client, msg mqtt.Message) {
+	topic := strings.TrimPrefix(msg.Topic(), CONTROL_TOPIC_PREFIX)
+
+	fn := r.commands[topic]
+	if fn == nil {
+		return
+	}
+
+	log.Printf("received command %q: %s", topic, msg.Payload())
+
+	if err := fn(r.ctx, msg.Payload()); err != nil {
+		log.Printf("command %q failed: %v", topic, err)
+	}
+}
+
+// Payload of device/set
+type deviceSetCommand struct {
+	Device string // ID or topic
+	State  any
+}
+
+// Registers the built-in commands
+func (r *regelwerk) registerCommands() {
+	r.HandleCommand("scene/activate", func(ctx context.Context, payload []byte) error {
+		return r.ActivateScene(ctx, strings.TrimSpace(string(payload)))
+	})
+
+	// like a discard override, ends the session but leaves the lights as is
+	r.HandleCommand("session/cancel", func(ctx context.Context, payload []byte) error {
+		name := strings.TrimSpace(string(payload))
+		if r.sessionKind(name) == nil {
+			return fmt.Errorf("unknown session %q", name)
+		} else if !r.discardSession(name, "cancelled") {
+			return fmt.Errorf("no %s session", name)
+		}
+		log.Printf("cancelled %s session", name)
+		return nil
+	})
+
+	r.HandleCommand("device/set", func(ctx context.Context, payload []byte) error {
+		var cmd deviceSetCommand
+		if err := json.Unmarshal(payload, &cmd); err != nil {
+			return err
+		}
+
+		d := r.LookupDevice(cmd.Device)
+		if d == nil {
+			d = r.deviceByTopic(cmd.Device)
+		}
+		if d == nil || d.stateAttr == "" {
+			return fmt.Errorf("unknown device %q", cmd.Device)
+		}
+
+		a := d.NewState(cmd.State)
+		a.source = SOURCE_MANUAL
+		r.Do(ctx, a)
+		return nil
+	})
+}
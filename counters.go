@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"regelwerk/mqttio"
+	"regelwerk/rules"
+	"regelwerk/timers"
+)
+
+// when counters start over
+const (
+	COUNTER_DAILY   = "daily" // the default
+	COUNTER_WEEKLY  = "weekly"
+	COUNTER_MONTHLY = "monthly"
+	COUNTER_NEVER   = "never"
+)
+
+// A counter, e.g. of the times the front door opened today. It's incremented
+// by counter actions, or on events of its Device: by one each time Attr
+// changes to Value, or by Attr itself if there's no Value, to add up numbers
+// like rain or energy.
+type counterConfig struct {
+	Device string
+	Attr   string // can be a dotted path
+	Value  any
+	Reset  string
+}
+
+type counterState struct {
+	Value    float64
+	Previous float64   // at the end of the last period
+	Period   time.Time // start of the current one
+
+	last any // last value of Attr, to count changes
+}
+
+// Counters, persisted next to the -state file so they survive restarts
+type counters struct {
+	cfg  map[string]counterConfig
+	file string // empty if not persisted
+
+	mu     sync.Mutex
+	states map[string]*counterState
+}
+
+func validateCounters(cfg map[string]counterConfig) error {
+	for name, cc := range cfg {
+		if cc.Device != "" && cc.Attr == "" {
+			return fmt.Errorf("counter %q needs an Attr with its Device", name)
+		}
+		switch cc.Reset {
+		case "", COUNTER_DAILY, COUNTER_WEEKLY, COUNTER_MONTHLY, COUNTER_NEVER:
+		default:
+			return fmt.Errorf("counter %q has unknown Reset %q", name, cc.Reset)
+		}
+	}
+	return nil
+}
+
+func (r *regelwerk) addCounters(cfg map[string]counterConfig, stateFile string) {
+	if len(cfg) == 0 {
+		return
+	}
+
+	cs := &counters{cfg: cfg, states: make(map[string]*counterState)}
+	if stateFile != "" {
+		cs.file = filepath.Join(filepath.Dir(stateFile), "counters.json")
+	}
+	for name, cc := range cfg {
+		cs.states[name] = &counterState{}
+		if cc.Device != "" && r.devices[cc.Device] == nil {
+			r.AddDevice(&device{id: cc.Device, topic: cc.Device})
+		}
+	}
+	r.counters = cs
+}
+
+// Returns when the period containing t started, or the zero time for
+// counters that are never reset
+func periodStart(t time.Time, reset string) time.Time {
+	y, m, d := t.Date()
+	switch reset {
+	case COUNTER_NEVER:
+		return time.Time{}
+	case COUNTER_WEEKLY:
+		// weeks start on Monday
+		return time.Date(y, m, d-(int(t.Weekday())+6)%7, 0, 0, 0, 0, t.Location())
+	case COUNTER_MONTHLY:
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// Starts a new period for counters whose period is over.
+// Must be called with mu held.
+func (cs *counters) rollOver(now time.Time) {
+	for name, st := range cs.states {
+		start := periodStart(now, cs.cfg[name].Reset)
+		if st.Period.Equal(start) {
+			continue
+		} else if !st.Period.IsZero() {
+			st.Previous, st.Value = st.Value, 0
+		}
+		st.Period = start
+	}
+}
+
+// Adds to the counter, or resets it
+func (cs *counters) Add(name string, amount float64, reset bool, now time.Time) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	st := cs.states[name]
+	if st == nil {
+		return fmt.Errorf("unknown counter %q", name)
+	}
+
+	cs.rollOver(now)
+	if reset {
+		st.Value = 0
+	} else {
+		st.Value += amount
+	}
+	debugf(LOG_RULES, "counter %q is now %v", name, st.Value)
+	return cs.save()
+}
+
+// Counts the device's event towards its counters
+func (r *regelwerk) observeCounters(d *device, payload map[string]any) {
+	cs := r.counters
+	if cs == nil {
+		return
+	}
+
+	now := r.clock.Now()
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	counted := false
+	for name, cc := range cs.cfg {
+		if cc.Device != d.topic {
+			continue
+		}
+		v, found := mqttio.LookupPath(payload, cc.Attr)
+		if !found {
+			continue
+		}
+
+		st := cs.states[name]
+		if cc.Value == nil {
+			if f, ok := v.(float64); ok {
+				cs.rollOver(now)
+				st.Value += f
+				counted = true
+			}
+		} else if rules.Equal(v, cc.Value) && !rules.Equal(st.last, v) {
+			cs.rollOver(now)
+			st.Value++
+			counted = true
+		}
+		st.last = v
+	}
+
+	if counted {
+		if err := cs.save(); err != nil {
+			log.Printf("unable to save counters: %v", err)
+		}
+	}
+}
+
+// Returns the counters with their value and the previous period's
+func (cs *counters) Report(now time.Time) map[string]any {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cs.rollOver(now)
+	report := make(map[string]any, len(cs.states))
+	for name, st := range cs.states {
+		report[name] = map[string]any{"value": st.Value, "previous": st.Previous}
+	}
+	return report
+}
+
+// counters.<name>.value and .previous, for the last period
+func (r *regelwerk) countersEnv(now time.Time) map[string]any {
+	if r.counters == nil {
+		return nil
+	}
+	return r.counters.Report(now)
+}
+
+// Publishes the counters every midnight, once the day's periods are over
+func (r *regelwerk) runCounters(ctx context.Context) {
+	for {
+		now := r.clock.Now()
+		y, m, d := now.Date()
+		if !r.sleepUntil(ctx, time.Date(y, m, d+1, 0, 0, 0, 0, now.Location())) {
+			return
+		}
+
+		report := r.counters.Report(r.clock.Now())
+		r.publishJSON("counters", report)
+
+		r.counters.mu.Lock()
+		if err := r.counters.save(); err != nil {
+			log.Printf("unable to save counters: %v", err)
+		}
+		r.counters.mu.Unlock()
+	}
+}
+
+// Must be called with mu held
+func (cs *counters) save() error {
+	if cs.file == "" {
+		return nil
+	}
+
+	js, _ := json.Marshal(cs.states)
+	return timers.WriteFileAtomic(cs.file, js)
+}
+
+// Loads the counters, if they were saved. Counters no longer configured are
+// dropped.
+func (cs *counters) Restore() error {
+	if cs == nil || cs.file == "" {
+		return nil
+	}
+
+	js, err := os.ReadFile(cs.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var saved map[string]*counterState
+	if err := json.Unmarshal(js, &saved); err != nil {
+		return err
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	for name, st := range saved {
+		if cs.states[name] != nil {
+			cs.states[name] = st
+		}
+	}
+	return nil
+}
+
+func init() {
+	actionTypes["counter"] = func(ctx context.Context, r *regelwerk, spec *actionSpec) error {
+		if r.counters == nil {
+			return fmt.Errorf("unknown counter %q", spec.Counter)
+		}
+
+		amount := 1.0
+		if spec.Amount != nil {
+			amount = *spec.Amount
+		}
+		switch spec.Command {
+		case "", "increment":
+			return r.counters.Add(spec.Counter, amount, false, r.clock.Now())
+		case "reset":
+			return r.counters.Add(spec.Counter, 0, true, r.clock.Now())
+		}
+		return fmt.Errorf("unknown counter command %q", spec.Command)
+	}
+}
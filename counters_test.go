@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"regelwerk/rules"
+)
+
+func TestPeriodStart(t *testing.T) {
+	wed := time.Date(2022, 6, 1, 21, 30, 0, 0, time.UTC)
+	tests := map[string]time.Time{
+		COUNTER_DAILY:   time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC),
+		COUNTER_WEEKLY:  time.Date(2022, 5, 30, 0, 0, 0, 0, time.UTC),
+		COUNTER_MONTHLY: time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC),
+		COUNTER_NEVER:   {},
+	}
+	for reset, want := range tests {
+		if got := periodStart(wed, reset); !got.Equal(want) {
+			t.Errorf("%s: wanted %v, got %v", reset, want, got)
+		}
+	}
+
+	sun := time.Date(2022, 6, 5, 12, 0, 0, 0, time.UTC)
+	if got := periodStart(sun, COUNTER_WEEKLY); got.Day() != 30 {
+		t.Errorf("week of a Sunday starts %v", got)
+	}
+}
+
+func TestCounters(t *testing.T) {
+	cfg := testConfig()
+	cfg.Counters = map[string]counterConfig{
+		"door_opened": {Device: "door", Attr: "contact", Value: false},
+		"presses":     {},
+	}
+	r, _ := newTestRegelwerk(t, cfg)
+
+	// repeated reports of the same state count once
+	receive(r, "door", map[string]any{"contact": false})
+	receive(r, "door", map[string]any{"contact": false})
+	receive(r, "door", map[string]any{"contact": true})
+	receive(r, "door", map[string]any{"contact": false})
+
+	if err := r.Run(r.ctx, &actionSpec{Type: "counter", Counter: "presses"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Run(r.ctx, &actionSpec{Type: "counter", Counter: "nope"}); err == nil {
+		t.Errorf("unknown counter incremented")
+	}
+
+	e, _ := rules.Parse(`counters.door_opened.value == 2 && counters.presses.value == 1`)
+	if !rules.Truthy(e.Eval(r.exprEnv(r.ctx))) {
+		t.Errorf("wrong counts %v", r.counters.Report(time.Now()))
+	}
+
+	// the next day starts over
+	report := r.counters.Report(time.Now().Add(25 * time.Hour))
+	if door := report["door_opened"].(map[string]any); door["value"] != 0.0 || door["previous"] != 2.0 {
+		t.Errorf("wrong roll over %v", door)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// commands understood by covers
+const (
+	COVER_OPEN  = "OPEN"
+	COVER_CLOSE = "CLOSE"
+	COVER_STOP  = "STOP"
+)
+
+// how often covers are checked against the sun's position
+const COVER_CHECK_INTERVAL = 5 * time.Minute
+
+// A cover or blind, tracked by its position (0 closed, 100 open).
+// If Azimuth is set, it's closed to Position while the sun shines into the
+// window, and opened again once the sun has moved on.
+type coverConfig struct {
+	// the sun's azimuth range that hits the window, in degrees clockwise from
+	// north, e.g. [135, 225] for a window facing south. Can wrap around north.
+	Azimuth *[2]float64
+
+	MinElevation float64 // the sun must be at least this high, in degrees
+	Position     float64 // to close to while shaded
+}
+
+// Sun-driven state of the covers
+type covers struct {
+	cfg map[string]coverConfig
+
+	mu     sync.Mutex
+	shaded map[string]bool
+}
+
+// A cover's state, as reported in the status
+type coverInfo struct {
+	Position any
+	Shaded   bool
+}
+
+func validateCovers(covers map[string]coverConfig) error {
+	for topic, c := range covers {
+		if c.Position < 0 || c.Position > 100 {
+			return fmt.Errorf("cover %q: Position must be between 0 and 100", topic)
+		} else if c.MinElevation < 0 || c.MinElevation >= 90 {
+			return fmt.Errorf("cover %q: MinElevation must be between 0 and 90", topic)
+		} else if a := c.Azimuth; a != nil && (a[0] < 0 || a[0] >= 360 || a[1] < 0 || a[1] >= 360) {
+			return fmt.Errorf("cover %q: Azimuth must be between 0 and 360", topic)
+		}
+	}
+	return nil
+}
+
+func (r *regelwerk) addCovers(cfg map[string]coverConfig) {
+	if len(cfg) == 0 {
+		return
+	}
+
+	for topic := range cfg {
+		r.AddDevice(&device{
+			id:             topic,
+			topic:          topic,
+			stateAttr:      "position",
+			stateAttrFixed: true,
+		})
+	}
+	r.covers = &covers{cfg: cfg, shaded: make(map[string]bool)}
+}
+
+// Returns the payload commanding a cover to open, close or stop
+func coverPayload(command string) (map[string]any, error) {
+	switch command {
+	case COVER_OPEN, COVER_CLOSE, COVER_STOP:
+		return map[string]any{"state": command}, nil
+	}
+	return nil, fmt.Errorf("unknown cover command %q", command)
+}
+
+func init() {
+	actionTypes["cover"] = func(ctx context.Context, r *regelwerk, spec *actionSpec) error {
+		if r.covers == nil {
+			return fmt.Errorf("unknown cover %q", spec.Device)
+		} else if _, found := r.covers.cfg[spec.Device]; !found {
+			return fmt.Errorf("unknown cover %q", spec.Device)
+		}
+		d := r.devices[spec.Device]
+
+		var payload map[string]any
+		if spec.Position != nil {
+			payload = map[string]any{"position": *spec.Position}
+		} else {
+			var err error
+			if payload, err = coverPayload(spec.Command); err != nil {
+				return err
+			}
+		}
+
+		r.Do(ctx, &action{dev: d, payload: payload, source: sourceOf(ctx)})
+		return nil
+	}
+}
+
+// Whether the azimuth falls in the range, which may wrap around north
+func inAzimuth(az float64, rng [2]float64) bool {
+	if rng[0] <= rng[1] {
+		return az >= rng[0] && az <= rng[1]
+	}
+	return az >= rng[0] || az <= rng[1]
+}
+
+// Periodically closes and opens covers as the sun moves
+func (r *regelwerk) runCovers(ctx context.Context) {
+	r.updateCovers(ctx, r.clock.Now())
+	for r.idle.Sleep(ctx, COVER_CHECK_INTERVAL) {
+		r.updateCovers(ctx, r.clock.Now())
+	}
+}
+
+// Moves covers whose window started or stopped getting sun
+func (r *regelwerk) updateCovers(ctx context.Context, now time.Time) {
+	cv := r.covers
+	if !r.ruleFires(RULE_COVERS) {
+		return
+	}
+
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+
+	for topic, c := range cv.cfg {
+		d := r.devices[topic]
+		s := r.sunFor(d)
+		if c.Azimuth == nil || !s.known() {
+			continue
+		}
+
+		az, el := s.Position(now)
+		shaded := el >= c.MinElevation && inAzimuth(az, *c.Azimuth)
+		if shaded == cv.shaded[topic] {
+			continue
+		}
+		cv.shaded[topic] = shaded
+
+		var payload map[string]any
+		if shaded {
+			log.Printf("sun on cover %q (azimuth %.0f°, elevation %.0f°), closing to %v",
+				topic, az, el, c.Position)
+			payload = map[string]any{"position": c.Position}
+		} else {
+			log.Printf("sun has left cover %q, opening", topic)
+			payload = map[string]any{"state": COVER_OPEN}
+		}
+		r.Do(ctx, &action{dev: d, payload: payload, source: SOURCE_SUN})
+	}
+}
+
+// Reports the position of each cover and whether it's shading
+func (r *regelwerk) CoverReport() map[string]coverInfo {
+	cv := r.covers
+	cv.mu.Lock()
+	shaded := make(map[string]bool, len(cv.cfg))
+	for topic := range cv.cfg {
+		shaded[topic] = cv.shaded[topic]
+	}
+	cv.mu.Unlock()
+
+	report := make(map[string]coverInfo, len(shaded))
+	for topic := range shaded {
+		d := r.devices[topic]
+		d.group.mu.Lock()
+		report[topic] = coverInfo{Position: d.state, Shaded: shaded[topic]}
+		d.group.mu.Unlock()
+	}
+	return report
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// Whether to output debug messages. Starts out as set by -debug, and can be
+// toggled at runtime with SIGUSR1 or the debug/set command.
+var debugLogging atomic.Bool
+
+// categories of debug messages
+const (
+	LOG_MQTT    = "mqtt"    // received messages
+	LOG_DEVICES = "devices" // state changes
+	LOG_RULES   = "rules"
+	LOG_ACTIONS = "actions" // sent & confirmed commands
+	LOG_TIMERS  = "timers"
+)
+
+// levels per category, from the config: debug messages of a category at
+// "debug" are always output, at "info" never, and otherwise only when debug
+// logging is on. Only set during startup.
+var logLevels map[string]string
+
+func validateLogLevels(levels map[string]string) error {
+	for cat, level := range levels {
+		switch cat {
+		case LOG_MQTT, LOG_DEVICES, LOG_RULES, LOG_ACTIONS, LOG_TIMERS:
+		default:
+			return fmt.Errorf("unknown log category %q", cat)
+		}
+
+		if level != "debug" && level != "info" {
+			return fmt.Errorf("log level for %q must be debug or info", cat)
+		}
+	}
+	return nil
+}
+
+// Whether debug messages of the category should be output
+func debugEnabled(category string) bool {
+	switch logLevels[category] {
+	case "debug":
+		return true
+	case "info":
+		return false
+	}
+	return debugLogging.Load()
+}
+
+// Logs a debug message of the category, if enabled
+func debugf(category, format string, args ...any) {
+	if debugEnabled(category) {
+		log.Printf(format, args...)
+	}
+}
+
+func setDebug(on bool) {
+	debugLogging.Store(on)
+	log.Printf("debug logging %s", onOff(on))
+}
+
+// Toggles debug logging on SIGUSR1, until the context is done
+func handleDebugSignal(ctx context.Context) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1)
+	defer signal.Stop(ch)
+
+	for {
+		select {
+		case <-ch:
+			setDebug(!debugLogging.Load())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *regelwerk) registerDebugCommands() {
+	// ON or OFF, or empty to toggle
+	r.HandleCommand("debug/set", func(ctx context.Context, payload []byte) error {
+		if strings.TrimSpace(string(payload)) == "" {
+			setDebug(!debugLogging.Load())
+			return nil
+		}
+
+		on, err := parseOnOff(payload)
+		if err != nil {
+			return err
+		}
+		setDebug(on)
+		return nil
+	})
+}
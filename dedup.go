@@ -0,0 +1,28 @@
+package main
+
+import "time"
+
+const (
+	FNV_OFFSET64 = 14695981039346656037
+	FNV_PRIME64  = 1099511628211
+)
+
+// Whether the payload repeats the device's previous one within its dedup
+// window, as zigbee2mqtt sometimes sends identical messages twice.
+// Called with the group lock held.
+func (d *device) isDuplicate(payload []byte, now time.Time) bool {
+	if d.dedup <= 0 {
+		return false
+	}
+
+	// FNV-1a, inline as hash/fnv allocates
+	sum := uint64(FNV_OFFSET64)
+	for _, c := range payload {
+		sum ^= uint64(c)
+		sum *= FNV_PRIME64
+	}
+
+	dup := sum == d.lastPayloadHash && now.Sub(d.lastPayloadAt) < d.dedup
+	d.lastPayloadHash, d.lastPayloadAt = sum, now
+	return dup
+}
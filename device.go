@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"regelwerk/mqttio"
+	"regelwerk/timers"
+)
+
+// how long to wait for replies to the initial state queries
+const STATE_SYNC_TIMEOUT = 5 * time.Second
+
+type device struct {
+	id          string // internal device ID
+	topic       string // MQTT topic
+	pattern     string // topic pattern this device was matched by, if any
+	group       *group
+	stateAttr   string   // state attribute
+	statePath   []string // stateAttr split, see lookupState
+	statePathOf string   // the stateAttr it was split from
+	state       any      // current state
+	stateKind   string   // of the state, see typedState
+	kindWarned  string   // kind of the last mismatched state warned about
+	lastUpdated time.Time
+	critical    bool // safety-critical, gets priority processing
+	virtual     bool // state is computed, see virtualDevice
+
+	// arbitration between sources setting the device
+	policy   string
+	cooldown time.Duration
+
+	// no reports for staleAfter makes the device stale, see runStaleCheck
+	staleAfter time.Duration
+	stale      bool
+
+	// replies before this deadline only sync the state, without firing rules
+	syncUntil time.Time
+
+	// debouncing of state changes
+	debounce       time.Duration
+	debounceT      timers.ClockTimer
+	settledState   any
+	pendingPayload map[string]any
+	pendingCtx     context.Context // of the latest change, to fire with
+
+	minInterval time.Duration // between publishes
+	minToggle   time.Duration // between state changes sent, see protectRelays
+
+	changeThreshold float64 // minimum difference for numeric state changes
+
+	// set from the config, otherwise they may be derived from the exposes
+	// and reports
+	stateAttrFixed, stateKindFixed bool
+
+	// the device's on/off values, if not "ON" and "OFF"
+	valueOn, valueOff any
+
+	// identical payloads within dedup are dropped, see isDuplicate
+	dedup           time.Duration
+	lastPayloadHash uint64
+	lastPayloadAt   time.Time
+
+	gesture *gestureState // if detecting gestures
+
+	// for payloads other than JSON objects, and devices outside of
+	// zigbee2mqtt, see setupRawDevice
+	parser                   payloadParser
+	stateTopic, commandTopic string
+	queryTopic, queryPayload string // where the state is asked for
+	encodeCommand            func(state any) any
+}
+
+func (d *device) DecodePayload(msg mqtt.Message) (payload map[string]any, changed bool, err error) {
+	if d.parser != nil {
+		payload, err = d.parser(msg.Payload(), d.stateAttr)
+	} else {
+		payload, err = mqttio.DecodePayload(msg)
+	}
+	if err != nil {
+		return payload, false, fmt.Errorf("unable to parse MQTT payload: %v", err)
+	}
+
+	changed = false
+
+	if d.stateAttr != "" {
+		attr, ok := d.lookupState(payload)
+		if !ok {
+			return payload, false, fmt.Errorf("state attr %q not found for %q", d.stateAttr, d.topic)
+		}
+		if attr, ok = d.typedState(d.normalizeState(attr)); !ok {
+			return payload, false, nil
+		}
+
+		// ignore small fluctuations of numeric values, comparing against the
+		// last accepted value so that slow drifts still get through
+		if f, ok := attr.(float64); ok && d.changeThreshold > 0 {
+			if prev, ok := d.state.(float64); ok && math.Abs(f-prev) < d.changeThreshold {
+				return payload, false, nil
+			}
+		}
+
+		// check and toggle state
+		if attr != d.state {
+			d.state = attr
+			changed = true
+		}
+	}
+
+	return payload, changed, nil
+}
+
+// Looks up the state attribute in the payload, splitting its path only once
+// for each attribute the device has
+func (d *device) lookupState(payload map[string]any) (any, bool) {
+	if v, ok := payload[d.stateAttr]; ok {
+		return v, true
+	}
+
+	// the attribute may have been derived from the exposes since
+	if d.statePathOf != d.stateAttr {
+		d.statePath, d.statePathOf = mqttio.SplitPath(d.stateAttr), d.stateAttr
+	}
+	return mqttio.LookupElems(payload, d.statePath)
+}
+
+// Creates an action that sets the device to the new state
+func (d *device) NewState(newState any) *action {
+	return &action{dev: d, payload: mqttio.NestPath(d.stateAttr, d.deviceState(newState))}
+}
+
+// Asks the device to report its current state.
+// The reply is expected within STATE_SYNC_TIMEOUT, and will be used to
+// initialize the state instead of being treated as a change.
+func (d *device) RequestState(c mqttio.Publisher, now time.Time) {
+	if d.queryTopic != "" {
+		d.syncUntil = now.Add(STATE_SYNC_TIMEOUT)
+		c.Publish(d.queryTopic, 0, false, d.queryPayload)
+		return
+	} else if d.stateAttr == "" || d.virtual || d.stateTopic != "" {
+		return
+	}
+
+	// only the top-level attribute can be requested
+	attr := mqttio.SplitPath(d.stateAttr)[0]
+	js, _ := json.Marshal(map[string]any{attr: ""})
+	d.syncUntil = now.Add(STATE_SYNC_TIMEOUT)
+	c.Publish(MQTT_TOPIC_PREFIX+d.topic+"/get", 0, false, js)
+}
+
+// Takes on the state of the first message after RequestState as the initial
+// one, instead of a change. Returns whether it did.
+func (d *device) takeInitialState(now time.Time) bool {
+	if d.syncUntil.IsZero() || !now.Before(d.syncUntil) {
+		return false
+	}
+	d.syncUntil = time.Time{}
+	d.settledState = d.state
+	log.Printf("dev %q initial state %q is %#v", d.id, d.stateAttr, d.state)
+	return true
+}
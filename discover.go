@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Roles a discovered device can have in the config
+const (
+	ROLE_CONTACT = "contact" // contact session sensor
+	ROLE_MOTION  = "motion"  // motion session sensor
+	ROLE_SWITCH  = "switch"  // the lights
+	ROLE_NONE    = "none"
+)
+
+// Guesses a device's role from what it exposes
+func suggestRole(zd *z2mDevice) string {
+	if zd.Definition == nil {
+		return ROLE_NONE
+	}
+
+	exposes := zd.Definition.Exposes
+	if _, on, _, ok := detectState(exposes, ""); ok && on != nil {
+		return ROLE_SWITCH
+	} else if hasProperty(exposes, "contact") {
+		return ROLE_CONTACT
+	} else if hasProperty(exposes, "occupancy") || hasProperty(exposes, "presence") {
+		return ROLE_MOTION
+	}
+	return ROLE_NONE
+}
+
+// Whether a binary property is exposed, possibly as a feature
+func hasProperty(exposes []expose, prop string) bool {
+	for _, e := range exposes {
+		if (e.Type == "binary" && e.Property == prop) || hasProperty(e.Features, prop) {
+			return true
+		}
+	}
+	return false
+}
+
+// Lists the properties a device exposes, including those of features
+func exposedProperties(exposes []expose) []string {
+	var props []string
+	for _, e := range exposes {
+		if e.Property != "" {
+			props = append(props, e.Property)
+		}
+		props = append(props, exposedProperties(e.Features)...)
+	}
+	return props
+}
+
+// Lists the devices zigbee2mqtt knows with what they expose, or with mode
+// "json" prints config stanzas for them, or with "interactive" asks for each
+// device's role before printing them
+func discover(cfg *config, mode string, in io.Reader, out io.Writer) error {
+	devices, err := fetchBridgeDevices(cfg)
+	if err != nil {
+		return err
+	}
+
+	// the coordinator has no definition
+	var found []*z2mDevice
+	for _, zd := range devices {
+		if zd.Definition != nil {
+			found = append(found, zd)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].FriendlyName < found[j].FriendlyName })
+
+	roles := make(map[string]string, len(found))
+	for _, zd := range found {
+		roles[zd.FriendlyName] = suggestRole(zd)
+	}
+
+	switch mode {
+	case "":
+		for _, zd := range found {
+			fmt.Fprintf(out, "%-24s %-8s %s %s\n    %s\n", zd.FriendlyName, roles[zd.FriendlyName],
+				zd.Definition.Vendor, zd.Definition.Model,
+				strings.Join(exposedProperties(zd.Definition.Exposes), ", "))
+		}
+		return nil
+
+	case "interactive":
+		sc := bufio.NewScanner(in)
+		for _, zd := range found {
+			for {
+				fmt.Fprintf(out, "%s (%s %s: %s)\n  role [contact/motion/switch/none, default %s]: ",
+					zd.FriendlyName, zd.Definition.Vendor, zd.Definition.Model,
+					strings.Join(exposedProperties(zd.Definition.Exposes), ", "), roles[zd.FriendlyName])
+				if !sc.Scan() {
+					return sc.Err()
+				}
+
+				role := strings.TrimSpace(sc.Text())
+				if role == "" {
+					break
+				} else if role == ROLE_CONTACT || role == ROLE_MOTION || role == ROLE_SWITCH || role == ROLE_NONE {
+					roles[zd.FriendlyName] = role
+					break
+				}
+				fmt.Fprintf(out, "unknown role %q\n", role)
+			}
+		}
+		fallthrough
+
+	case "json":
+		stanza, err := discoveredConfig(found, roles)
+		if err != nil {
+			return err
+		}
+		js, _ := json.MarshalIndent(stanza, "", "\t")
+		fmt.Fprintf(out, "%s\n", js)
+		return nil
+	}
+	return fmt.Errorf("unknown discover mode %q", mode)
+}
+
+// Builds the config for the devices' roles, which can be merged in with
+// Include
+func discoveredConfig(devices []*z2mDevice, roles map[string]string) (map[string]any, error) {
+	stanza := map[string]any{"Version": CONFIG_VERSION}
+	sessions := make(map[string]map[string][]string)
+
+	for _, zd := range devices {
+		name := zd.FriendlyName
+		switch roles[name] {
+		case ROLE_CONTACT, ROLE_MOTION:
+			if sessions[roles[name]] == nil {
+				sessions[roles[name]] = make(map[string][]string)
+			}
+			s := sessions[roles[name]]
+			s["Sensors"] = append(s["Sensors"], name)
+		case ROLE_SWITCH:
+			if sw, found := stanza["Switch"]; found {
+				return nil, fmt.Errorf("only one switch can be used, got %s and %s", sw, name)
+			}
+			stanza["Switch"] = name
+		}
+	}
+
+	if len(sessions) > 0 {
+		stanza["Sessions"] = sessions
+	}
+	return stanza, nil
+}
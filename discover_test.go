@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiscoveredConfig(t *testing.T) {
+	var devices []*z2mDevice
+	json.Unmarshal([]byte(`[
+		{"friendly_name": "Coordinator"},
+		{"friendly_name": "hall_pir", "definition": {"exposes": [{"type": "binary", "property": "occupancy"}]}},
+		{"friendly_name": "stairs_pir", "definition": {"exposes": [{"type": "binary", "property": "presence"}]}},
+		{"friendly_name": "plug", "definition": {"exposes": [{"type": "switch", "features": [
+			{"type": "binary", "name": "state", "property": "state", "value_on": "ON", "value_off": "OFF"}]}]}}
+	]`), &devices)
+
+	roles := make(map[string]string)
+	for _, zd := range devices {
+		roles[zd.FriendlyName] = suggestRole(zd)
+	}
+	if roles["stairs_pir"] != ROLE_MOTION || roles["plug"] != ROLE_SWITCH || roles["Coordinator"] != ROLE_NONE {
+		t.Errorf("wrong roles %v", roles)
+	}
+
+	stanza, err := discoveredConfig(devices, roles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	js, _ := json.Marshal(stanza)
+	fname := filepath.Join(t.TempDir(), "discovered.conf")
+	os.WriteFile(fname, js, 0o644)
+
+	var cfg config
+	if err := parseConfig(fname, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MotionSensor != "hall_pir" || len(cfg.MotionSensors) != 1 || cfg.Switch != "plug" {
+		t.Errorf("wrong config %s", js)
+	}
+
+	roles["hall_pir"] = ROLE_SWITCH
+	if _, err := discoveredConfig(devices, roles); err == nil {
+		t.Errorf("expected error for two switches")
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Durations named in the config's Durations, e.g. {"short": "30s"}, which
+// other durations can refer to by name, so related timeouts are tuned in one
+// place. Only set while the config is being parsed.
+var namedDurations map[string]time.Duration
+
+// Parses a duration like "1m30s", or the name of one, optionally negated
+func parseNamedDuration(s string) (time.Duration, error) {
+	if d, found := namedDurations[strings.TrimPrefix(s, "-")]; found {
+		if strings.HasPrefix(s, "-") {
+			return -d, nil
+		}
+		return d, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// Reads the config's named durations, ahead of the rest of the config
+func loadNamedDurations(cfgStr []byte) (map[string]time.Duration, error) {
+	var named struct {
+		Durations map[string]textDuration
+	}
+	if err := json.Unmarshal(cfgStr, &named); err != nil {
+		return nil, err
+	}
+
+	durations := make(map[string]time.Duration, len(named.Durations))
+	for name, d := range named.Durations {
+		if _, err := time.ParseDuration(name); err == nil || name == "" {
+			return nil, fmt.Errorf("%q is not a valid duration name", name)
+		}
+		durations[name] = time.Duration(d)
+	}
+	return durations, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNamedDurations(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "regelwerk.conf")
+	os.WriteFile(fname, []byte(`{
+		// tuned together
+		"Durations": { "short": "30s", "long": "10m" },
+		"OffDelay": "short",
+		"MotionExpiry": "long",
+		"SunTriggers": [{ "Type": "at_dusk", "Offset": "-short", "Action": "x" }]
+	}`), 0o644)
+
+	var cfg config
+	if err := parseConfig(fname, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.OffDelay != textDuration(30*time.Second) || cfg.MotionExpiry != textDuration(10*time.Minute) {
+		t.Errorf("wrong durations %v, %v", cfg.OffDelay, cfg.MotionExpiry)
+	}
+	if off := cfg.SunTriggers[0].Offset; off != offsetDuration(-30*time.Second) {
+		t.Errorf("wrong offset %v", off)
+	}
+
+	os.WriteFile(fname, []byte(`{"OffDelay": "medium"}`), 0o644)
+	if err := parseConfig(fname, &cfg); err == nil {
+		t.Errorf("expected error for unknown duration name")
+	}
+}
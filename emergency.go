@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"regelwerk/timers"
+)
+
+// emergency states, as published to regelwerk/emergency
+const (
+	EMERGENCY_CLEAR        = "clear"
+	EMERGENCY_ACTIVE       = "active" // actions are repeated until acknowledged
+	EMERGENCY_ACKNOWLEDGED = "acknowledged"
+)
+
+// Safety sensors like water leak and smoke detectors. When one of them
+// detects, all Actions (sirens, valves, notifications) run at once, without
+// their conditions and past any arbitration, and run again every RetryEvery
+// until acknowledged with regelwerk/emergency/ack or an "emergency" action
+// with Command ack.
+type emergencyConfig struct {
+	Sensors    []string     // topics, which also get the priority lane
+	Attrs      []string     // default water_leak, smoke, gas and carbon_monoxide
+	Actions    []string     // run on each detection, and each retry
+	RetryEvery textDuration // default 1m
+}
+
+type emergency struct {
+	cfg     emergencyConfig
+	sensors map[string]bool
+
+	mu       sync.Mutex
+	state    string
+	detected map[string]bool // sensors currently detecting
+	cause    string          // sensor & attribute that set it off
+}
+
+type emergencyKey struct{}
+
+// Marks actions as run for an emergency, so nothing holds them back
+func withEmergency(ctx context.Context) context.Context {
+	return context.WithValue(ctx, emergencyKey{}, true)
+}
+
+func isEmergency(ctx context.Context) bool {
+	v, _ := ctx.Value(emergencyKey{}).(bool)
+	return v
+}
+
+func validateEmergency(e *emergencyConfig, actions map[string]*actionSpec) error {
+	if e == nil {
+		return nil
+	} else if len(e.Sensors) == 0 || len(e.Actions) == 0 {
+		return fmt.Errorf("Emergency needs Sensors and Actions")
+	} else if e.RetryEvery < 0 {
+		return fmt.Errorf("Emergency RetryEvery cannot be negative")
+	}
+	for _, name := range e.Actions {
+		if act := actions[name]; act == nil || act.Type == "emergency" {
+			return fmt.Errorf("Emergency refers to unknown action %q", name)
+		}
+	}
+	return nil
+}
+
+func (r *regelwerk) addEmergency(cfg *emergencyConfig) {
+	if cfg == nil {
+		return
+	}
+
+	em := &emergency{
+		cfg:      *cfg,
+		sensors:  make(map[string]bool),
+		state:    EMERGENCY_CLEAR,
+		detected: make(map[string]bool),
+	}
+	if len(em.cfg.Attrs) == 0 {
+		em.cfg.Attrs = []string{"water_leak", "smoke", "gas", "carbon_monoxide"}
+	}
+	if em.cfg.RetryEvery == 0 {
+		em.cfg.RetryEvery = textDuration(time.Minute)
+	}
+
+	for _, topic := range cfg.Sensors {
+		em.sensors[topic] = true
+		if d := r.devices[topic]; d != nil {
+			d.critical = true
+		} else {
+			r.AddDevice(&device{id: topic, topic: topic, critical: true})
+		}
+	}
+	r.emergency = em
+
+	r.timers.Register("emergency", r.lockedDevice(r.handleEmergencyTimer))
+	r.HandleCommand("emergency/ack", func(ctx context.Context, payload []byte) error {
+		return r.AckEmergency()
+	})
+}
+
+// Returns the first of the attributes the sensor reports as detecting
+func detectedHazard(payload map[string]any, attrs []string) string {
+	for _, attr := range attrs {
+		if on, ok := payload[attr].(bool); ok && on {
+			return attr
+		}
+	}
+	return ""
+}
+
+// Sets off the emergency when a sensor starts detecting. Sensors that keep
+// reporting a detection only set it off again once they were clear.
+func (r *regelwerk) observeEmergency(ctx context.Context, d *device, payload map[string]any) {
+	em := r.emergency
+	if em == nil || !em.sensors[d.topic] {
+		return
+	}
+
+	hazard := detectedHazard(payload, em.cfg.Attrs)
+
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	wasDetected := em.detected[d.topic]
+	em.detected[d.topic] = hazard != ""
+	if hazard == "" {
+		if em.state == EMERGENCY_ACKNOWLEDGED && !em.anyDetected() {
+			r.setEmergencyState(EMERGENCY_CLEAR)
+		}
+		return
+	} else if wasDetected {
+		return
+	}
+
+	em.cause = d.topic + " " + hazard
+	r.setEmergencyState(EMERGENCY_ACTIVE)
+	r.alert("emergency: %s", em.cause)
+	r.runEmergencyActions(ctx, payload)
+
+	r.timers.Destroy("emergency")
+	r.timers.Add("emergency", "emergency", map[string]string{"topic": d.topic})
+	r.timers.Start("emergency", time.Duration(em.cfg.RetryEvery))
+}
+
+// Runs all actions, skipping their conditions.
+// Must be called with the emergency lock held.
+func (r *regelwerk) runEmergencyActions(ctx context.Context, payload map[string]any) {
+	ctx = withEmergency(withTrigger(ctx, payload))
+	for _, name := range r.emergency.cfg.Actions {
+		spec := r.actions[name]
+		if fn := actionTypes[spec.Type]; fn == nil {
+			log.Printf("emergency action %q has unknown type %q", name, spec.Type)
+		} else if err := fn(ctx, r, spec); err != nil {
+			log.Printf("emergency action %q failed: %v", name, err)
+		}
+	}
+}
+
+// Must be called with the emergency lock held
+func (r *regelwerk) setEmergencyState(state string) {
+	em := r.emergency
+	em.state = state
+	log.Printf("emergency %s", state)
+	r.journal.Add("emergency", state, map[string]any{"cause": em.cause})
+	r.client.Publish(CONTROL_TOPIC_PREFIX+"emergency", 0, true, state)
+}
+
+// Repeats the actions until acknowledged
+func (r *regelwerk) handleEmergencyTimer(ctx context.Context, tm *timers.Timer, expired bool) {
+	em := r.emergency
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	if em.state != EMERGENCY_ACTIVE {
+		return
+	}
+
+	r.alert("emergency: %s, still not acknowledged", em.cause)
+	r.runEmergencyActions(ctx, nil)
+
+	// the fired timer is removed after this returns, so replace it
+	r.timers.Destroy("emergency")
+	r.timers.Add("emergency", "emergency", map[string]string{"topic": tm.Meta("topic")})
+	r.timers.Start("emergency", time.Duration(em.cfg.RetryEvery))
+}
+
+// Stops repeating the actions. The emergency is clear once all sensors are,
+// and sensors still detecting only set it off again after being clear.
+func (r *regelwerk) AckEmergency() error {
+	em := r.emergency
+	if em == nil {
+		return fmt.Errorf("emergency is not configured")
+	}
+
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	if em.state != EMERGENCY_ACTIVE {
+		return nil
+	}
+	r.timers.Destroy("emergency")
+	r.setEmergencyState(EMERGENCY_ACKNOWLEDGED)
+	if !em.anyDetected() {
+		r.setEmergencyState(EMERGENCY_CLEAR)
+	}
+	return nil
+}
+
+// Must be called with mu held
+func (em *emergency) anyDetected() bool {
+	for _, on := range em.detected {
+		if on {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the emergency's state, and what set it off
+func (r *regelwerk) EmergencyReport() map[string]any {
+	em := r.emergency
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	var detecting []string
+	for _, topic := range sortedKeys(em.detected) {
+		if em.detected[topic] {
+			detecting = append(detecting, topic)
+		}
+	}
+	return map[string]any{"state": em.state, "cause": em.cause, "detecting": detecting}
+}
+
+func init() {
+	actionTypes["emergency"] = func(ctx context.Context, r *regelwerk, spec *actionSpec) error {
+		if cmd := strings.ToLower(strings.TrimSpace(spec.Command)); cmd != "ack" {
+			return fmt.Errorf("expected ack, got %q", spec.Command)
+		}
+		return r.AckEmergency()
+	}
+}
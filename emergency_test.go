@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"regelwerk/timers"
+)
+
+func TestEmergencyRetriesUntilAck(t *testing.T) {
+	cfg := testConfig()
+	cfg.Actions = map[string]*actionSpec{
+		// only at night, but an emergency doesn't wait for that
+		"siren": {Type: "publish", Topic: "home/siren", Payload: "ON", If: "false"},
+	}
+	cfg.Emergency = &emergencyConfig{Sensors: []string{"kitchen_leak"}, Actions: []string{"siren"}}
+	r, mc := newTestRegelwerk(t, cfg)
+	fc := timers.NewFakeClock(time.Date(2022, 6, 1, 10, 0, 0, 0, time.Local))
+	r.clock, r.timers.Clock = fc, fc
+
+	if !r.devices["kitchen_leak"].critical {
+		t.Errorf("sensor doesn't get the priority lane")
+	}
+
+	receive(r, "kitchen_leak", map[string]any{"water_leak": true})
+	mc.WaitFor(t, "home/siren", 1)
+
+	// still leaking isn't a new emergency, but it's repeated until acked
+	receive(r, "kitchen_leak", map[string]any{"water_leak": true})
+	fc.Advance(time.Minute)
+	mc.WaitFor(t, "home/siren", 2)
+	fc.Advance(time.Minute)
+	mc.WaitFor(t, "home/siren", 3)
+
+	if err := r.AckEmergency(); err != nil {
+		t.Fatal(err)
+	}
+	fc.Advance(5 * time.Minute)
+	if got := mc.Payloads("home/siren"); len(got) != 3 {
+		t.Errorf("actions repeated after ack: %v", got)
+	} else if st := r.EmergencyReport()["state"]; st != EMERGENCY_ACKNOWLEDGED {
+		t.Errorf("wanted acknowledged while leaking, got %v", st)
+	}
+
+	receive(r, "kitchen_leak", map[string]any{"water_leak": false})
+	if st := r.EmergencyReport()["state"]; st != EMERGENCY_CLEAR {
+		t.Errorf("wanted clear once dry, got %v", st)
+	}
+}
+
+// A message the broker replays on subscribing
+type retainedMessage struct{ virtualMessage }
+
+func (*retainedMessage) Retained() bool { return true }
+
+func TestEmergencyFromRetained(t *testing.T) {
+	cfg := testConfig()
+	cfg.SyncWindow = textDuration(time.Minute)
+	cfg.Actions = map[string]*actionSpec{
+		"siren": {Type: "publish", Topic: "home/siren", Payload: "ON"},
+	}
+	cfg.Emergency = &emergencyConfig{Sensors: []string{"kitchen_leak"}, Actions: []string{"siren"}}
+	r, mc := newTestRegelwerk(t, cfg)
+
+	// started leaking while we were down
+	msg := &retainedMessage{virtualMessage{topic: MQTT_TOPIC_PREFIX + "kitchen_leak", payload: []byte(`{"water_leak": true}`)}}
+	r.processMessage(r.ctx, r.deviceByTopic("kitchen_leak"), msg)
+	mc.WaitFor(t, "home/siren", 1)
+	if st := r.EmergencyReport()["state"]; st != EMERGENCY_ACTIVE {
+		t.Errorf("wanted active from a retained message, got %v", st)
+	}
+}
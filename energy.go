@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"regelwerk/mqttio"
+)
+
+// power readings further apart than this aren't integrated, as the plug was
+// probably offline in between
+const ENERGY_MAX_GAP = time.Hour
+
+// Consumption of a plug reporting power in W, and optionally an energy meter
+// in kWh, which is preferred over integrating the power. OnIdle runs when the
+// power drops to IdleBelow, e.g. for an alert when the washer is done.
+type energyConfig struct {
+	Device     string
+	PowerAttr  string // default power
+	EnergyAttr string // default energy
+
+	IdleBelow float64 // W
+	OnIdle    string  // action
+}
+
+type energyMeter struct {
+	cfg energyConfig
+
+	power            float64 // W
+	today, yesterday float64 // kWh
+	day              time.Time
+	powerAt          time.Time // of the last power reading
+	meter            float64   // last energy reading
+	meterKnown       bool
+	running          bool // above IdleBelow
+}
+
+type energy struct {
+	mu     sync.Mutex
+	meters map[string]*energyMeter
+}
+
+func validateEnergy(cfg map[string]energyConfig, actions map[string]*actionSpec) error {
+	for name, ec := range cfg {
+		if ec.Device == "" {
+			return fmt.Errorf("energy meter %q needs a Device", name)
+		} else if ec.OnIdle != "" && actions[ec.OnIdle] == nil {
+			return fmt.Errorf("energy meter %q OnIdle refers to unknown action %q", name, ec.OnIdle)
+		}
+	}
+	return nil
+}
+
+func (r *regelwerk) addEnergy(cfg map[string]energyConfig) {
+	if len(cfg) == 0 {
+		return
+	}
+
+	r.energy = &energy{meters: make(map[string]*energyMeter)}
+	for name, ec := range cfg {
+		if ec.PowerAttr == "" {
+			ec.PowerAttr = "power"
+		}
+		if ec.EnergyAttr == "" {
+			ec.EnergyAttr = "energy"
+		}
+		r.energy.meters[name] = &energyMeter{cfg: ec}
+
+		if r.devices[ec.Device] == nil {
+			r.AddDevice(&device{id: ec.Device, topic: ec.Device})
+		}
+	}
+}
+
+// Starts a new day if it's past midnight. Must be called with mu held.
+func (m *energyMeter) rollOver(now time.Time) {
+	day := periodStart(now, COUNTER_DAILY)
+	if m.day.Equal(day) {
+		return
+	} else if !m.day.IsZero() {
+		// only a reading from the day before is yesterday's
+		m.yesterday = 0
+		if m.day.Equal(day.AddDate(0, 0, -1)) {
+			m.yesterday = m.today
+		}
+	}
+	m.day, m.today = day, 0
+}
+
+// Adds a reading to the day's consumption. Returns whether the power
+// dropped to IdleBelow. Must be called with mu held.
+func (m *energyMeter) add(payload map[string]any, now time.Time) bool {
+	m.rollOver(now)
+
+	if v, ok := mqttio.LookupPath(payload, m.cfg.EnergyAttr); ok {
+		if kwh, ok := v.(float64); ok {
+			// a meter going backwards was reset, e.g. after a power cut
+			if m.meterKnown && kwh >= m.meter {
+				m.today += kwh - m.meter
+			}
+			m.meter, m.meterKnown = kwh, true
+		}
+	}
+
+	v, _ := mqttio.LookupPath(payload, m.cfg.PowerAttr)
+	watts, ok := v.(float64)
+	if !ok {
+		return false
+	}
+
+	// integrate the power until the next reading, without a meter
+	if !m.meterKnown && !m.powerAt.IsZero() {
+		if dt := now.Sub(m.powerAt); dt > 0 && dt <= ENERGY_MAX_GAP {
+			m.today += m.power * dt.Hours() / 1000
+		}
+	}
+	m.power, m.powerAt = watts, now
+
+	wasRunning := m.running
+	m.running = watts > m.cfg.IdleBelow
+	return wasRunning && !m.running
+}
+
+// Adds the device's readings to its meters, running OnIdle for those whose
+// power dropped
+func (r *regelwerk) observeEnergy(ctx context.Context, d *device, payload map[string]any) {
+	en := r.energy
+	if en == nil {
+		return
+	}
+
+	now := r.clock.Now()
+	var idle []string
+
+	en.mu.Lock()
+	for name, m := range en.meters {
+		if m.cfg.Device == d.topic && m.add(payload, now) {
+			log.Printf("energy meter %q went idle", name)
+			if m.cfg.OnIdle != "" {
+				idle = append(idle, m.cfg.OnIdle)
+			}
+		}
+	}
+	en.mu.Unlock()
+
+	for _, action := range idle {
+		if err := r.Run(withTrigger(ctx, payload), r.actions[action]); err != nil {
+			log.Printf("action %q failed: %v", action, err)
+		}
+	}
+}
+
+// Returns each meter's power, and consumption today and yesterday
+func (en *energy) Report(now time.Time) map[string]any {
+	en.mu.Lock()
+	defer en.mu.Unlock()
+
+	report := make(map[string]any, len(en.meters))
+	for name, m := range en.meters {
+		m.rollOver(now)
+		report[name] = map[string]any{
+			"power":     m.power,
+			"today":     m.today,
+			"yesterday": m.yesterday,
+		}
+	}
+	return report
+}
+
+// energy.<name>.power in W, and .today and .yesterday in kWh
+func (r *regelwerk) energyEnv(now time.Time) map[string]any {
+	if r.energy == nil {
+		return nil
+	}
+	return r.energy.Report(now)
+}
+
+// Publishes the daily consumption to regelwerk/energy every midnight
+func (r *regelwerk) runEnergy(ctx context.Context) {
+	for {
+		now := r.clock.Now()
+		y, m, d := now.Date()
+		if !r.sleepUntil(ctx, time.Date(y, m, d+1, 0, 0, 0, 0, now.Location())) {
+			return
+		}
+		r.publishJSON("energy", r.energy.Report(r.clock.Now()))
+	}
+}
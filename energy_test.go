@@ -0,0 +1,58 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"regelwerk/rules"
+)
+
+func TestEnergyMeter(t *testing.T) {
+	m := &energyMeter{cfg: energyConfig{PowerAttr: "power", EnergyAttr: "energy", IdleBelow: 2}}
+	start := time.Date(2022, 6, 1, 22, 0, 0, 0, time.Local)
+
+	// 1kW for an hour, then idle
+	m.add(map[string]any{"power": 1000.0}, start)
+	if idle := m.add(map[string]any{"power": 0.0}, start.Add(time.Hour)); !idle {
+		t.Errorf("power drop not detected")
+	}
+	if math.Abs(m.today-1) > 1e-9 {
+		t.Errorf("wanted 1kWh from power, got %v", m.today)
+	}
+
+	// the meter is preferred once known, and a reset isn't counted
+	m.add(map[string]any{"energy": 10.0, "power": 500.0}, start.Add(90*time.Minute))
+	m.add(map[string]any{"energy": 10.5, "power": 500.0}, start.Add(100*time.Minute))
+	m.add(map[string]any{"energy": 0.2, "power": 500.0}, start.Add(110*time.Minute))
+	if math.Abs(m.today-1.5) > 1e-9 {
+		t.Errorf("wanted 1.5kWh with the meter, got %v", m.today)
+	}
+
+	m.add(map[string]any{"energy": 0.4}, start.Add(3*time.Hour))
+	if math.Abs(m.yesterday-1.5) > 1e-9 || math.Abs(m.today-0.2) > 1e-9 {
+		t.Errorf("wrong roll over: yesterday %v, today %v", m.yesterday, m.today)
+	}
+}
+
+func TestEnergyOnIdle(t *testing.T) {
+	cfg := testConfig()
+	cfg.Actions = map[string]*actionSpec{
+		"done": {Type: "publish", Topic: "home/washer", Payload: "done"},
+	}
+	cfg.Energy = map[string]energyConfig{
+		"washer": {Device: "washer_plug", IdleBelow: 2, OnIdle: "done"},
+	}
+	r, mc := newTestRegelwerk(t, cfg)
+
+	receive(r, "washer_plug", map[string]any{"power": 350.0})
+	receive(r, "washer_plug", map[string]any{"power": 0.5})
+	if got := mc.WaitFor(t, "home/washer", 1); got[0] != "done" {
+		t.Errorf("wanted OnIdle action, got %v", got)
+	}
+
+	e, _ := rules.Parse(`energy.washer.power < 1`)
+	if !rules.Truthy(e.Eval(r.exprEnv(r.ctx))) {
+		t.Errorf("power not in conditions: %v", r.energy.Report(time.Now()))
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"regelwerk/mqttio"
+)
+
+// readings held while the database is unreachable, older ones are dropped
+const EXPORT_BUFFER_SIZE = 10000
+
+// Writes selected device attributes to InfluxDB (or anything else that
+// accepts its line protocol, like VictoriaMetrics)
+type exportConfig struct {
+	URL      string // write endpoint, e.g. http://localhost:8086/write?db=home
+	Token    string // for InfluxDB 2.x
+	Attrs    []string
+	Interval textDuration // between writes
+}
+
+type exporter struct {
+	cfg    exportConfig
+	client http.Client
+
+	mu    sync.Mutex
+	lines []string
+}
+
+func newExporter(cfg exportConfig) *exporter {
+	if cfg.Interval == 0 {
+		cfg.Interval = textDuration(10 * time.Second)
+	}
+	return &exporter{cfg: cfg, client: http.Client{Timeout: 10 * time.Second}}
+}
+
+// Queues the configured attributes of the payload for the next write
+func (e *exporter) Add(d *device, payload map[string]any) {
+	if e == nil {
+		return
+	}
+
+	fields := make(map[string]any)
+	for _, attr := range e.cfg.Attrs {
+		if v, ok := mqttio.LookupPath(payload, attr); ok {
+			fields[attr] = v
+		}
+	}
+	if len(fields) == 0 {
+		return
+	}
+
+	line := formatLine("zigbee", map[string]string{"device": d.topic}, fields, time.Now())
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.lines) >= EXPORT_BUFFER_SIZE {
+		e.lines = e.lines[1:]
+	}
+	e.lines = append(e.lines, line)
+}
+
+// Queues the summary of a rolling stat, see statConfig
+func (e *exporter) AddStat(name string, sum windowSummary, t time.Time) {
+	if e == nil {
+		return
+	}
+
+	line := formatLine("stats", map[string]string{"stat": name}, map[string]any{
+		"min":    sum.Min,
+		"max":    sum.Max,
+		"mean":   sum.Mean,
+		"median": sum.Median,
+		"count":  float64(sum.Count),
+	}, t)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.lines) >= EXPORT_BUFFER_SIZE {
+		e.lines = e.lines[1:]
+	}
+	e.lines = append(e.lines, line)
+}
+
+// Writes out queued readings every Interval until the context is done
+func (e *exporter) Run(ctx context.Context, idle *idleDetector) {
+	for idle.Sleep(ctx, time.Duration(e.cfg.Interval)) {
+		e.mu.Lock()
+		lines := e.lines
+		e.lines = nil
+		e.mu.Unlock()
+
+		if len(lines) == 0 {
+			continue
+		}
+
+		if err := e.write(ctx, lines); err != nil {
+			log.Printf("export failed, will retry: %v", err)
+
+			// put them back in front of newer readings
+			e.mu.Lock()
+			e.lines = append(lines, e.lines...)
+			if n := len(e.lines) - EXPORT_BUFFER_SIZE; n > 0 {
+				e.lines = e.lines[n:]
+			}
+			e.mu.Unlock()
+		}
+	}
+}
+
+func (e *exporter) write(ctx context.Context, lines []string) error {
+	body := strings.Join(lines, "\n") + "\n"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.cfg.URL, bytes.NewBufferString(body))
+	if err != nil {
+		return err
+	}
+	if e.cfg.Token != "" {
+		req.Header.Set("Authorization", "Token "+e.cfg.Token)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("server returned %s", resp.Status)
+	}
+	return nil
+}
+
+var (
+	lineKeyEscaper    = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	lineStringEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+)
+
+// Formats a point in InfluxDB line protocol.
+// Values that aren't numbers, booleans or strings are skipped.
+func formatLine(measurement string, tags map[string]string, fields map[string]any, t time.Time) string {
+	var b strings.Builder
+	b.WriteString(lineKeyEscaper.Replace(measurement))
+
+	for _, k := range sortedKeys(tags) {
+		fmt.Fprintf(&b, ",%s=%s", lineKeyEscaper.Replace(k), lineKeyEscaper.Replace(tags[k]))
+	}
+
+	sep := " "
+	for _, k := range sortedKeys(fields) {
+		var v string
+		switch f := fields[k].(type) {
+		case float64:
+			v = strconv.FormatFloat(f, 'f', -1, 64)
+		case bool:
+			v = strconv.FormatBool(f)
+		case string:
+			v = `"` + lineStringEscaper.Replace(f) + `"`
+		default:
+			continue
+		}
+		fmt.Fprintf(&b, "%s%s=%s", sep, lineKeyEscaper.Replace(k), v)
+		sep = ","
+	}
+
+	fmt.Fprintf(&b, " %d", t.UnixNano())
+	return b.String()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
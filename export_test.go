@@ -0,0 +1,17 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatLine(t *testing.T) {
+	ts := time.Unix(1650000000, 0)
+	got := formatLine("zigbee", map[string]string{"device": "living room"},
+		map[string]any{"temperature": 21.5, "state": `"ON"`, "contact": true, "x": nil}, ts)
+
+	want := `zigbee,device=living\ room contact=true,state="\"ON\"",temperature=21.5 1650000000000000000`
+	if got != want {
+		t.Errorf("wanted\n%s\ngot\n%s", want, got)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import "log"
+
+// binary sensor properties that can stand in for one another, e.g. for
+// mmWave sensors reporting presence instead of occupancy
+var sensorProperties = map[string]bool{"occupancy": true, "presence": true, "contact": true}
+
+// Finds the state attribute and on/off values of a device from what it
+// exposes: the state of its only switch or light, or a sensor's binary
+// property if the configured one isn't exposed. Returns ok false if there's
+// nothing to change or the exposes are ambiguous.
+func detectState(exposes []expose, current string) (attr string, on, off any, ok bool) {
+	var switches, sensors []expose
+	exposed := false
+
+	var walk func(exposes []expose, parent string)
+	walk = func(exposes []expose, parent string) {
+		for _, e := range exposes {
+			if e.Property == current {
+				exposed = true
+			}
+			if e.Type == "binary" {
+				if e.Name == "state" && (parent == "switch" || parent == "light") {
+					switches = append(switches, e)
+				} else if sensorProperties[e.Property] {
+					sensors = append(sensors, e)
+				}
+			}
+			walk(e.Features, e.Type)
+		}
+	}
+	walk(exposes, "")
+
+	if len(switches) == 1 {
+		e := switches[0]
+		return e.Property, e.ValueOn, e.ValueOff, true
+	} else if !exposed && len(switches) == 0 && len(sensors) == 1 {
+		return sensors[0].Property, nil, nil, true
+	}
+	return "", nil, nil, false
+}
+
+// Derives the device's state attribute and on/off values from its exposes,
+// unless a StateAttr was configured
+func (r *regelwerk) applyExposes(d *device, zd *z2mDevice) {
+	if zd.Definition == nil || d.virtual {
+		return
+	}
+
+	d.group.mu.Lock()
+	defer d.group.mu.Unlock()
+
+	if d.stateAttrFixed || d.stateAttr == "" {
+		return
+	}
+
+	attr, on, off, ok := detectState(zd.Definition.Exposes, d.stateAttr)
+	if !ok || (attr == d.stateAttr && on == d.valueOn && off == d.valueOff) {
+		return
+	}
+
+	log.Printf("dev %q exposes its state as %q (%v/%v)", d.id, attr, on, off)
+	d.stateAttr, d.valueOn, d.valueOff = attr, on, off
+	if !d.stateKindFixed {
+		d.stateKind = "" // taken from the next report
+	}
+}
+
+// Maps the device's own on/off values to "ON" and "OFF", as used by rules
+func (d *device) normalizeState(v any) any {
+	if d.valueOn == nil {
+		return v
+	} else if v == d.valueOn {
+		return "ON"
+	} else if v == d.valueOff {
+		return "OFF"
+	}
+	return v
+}
+
+// Maps "ON" and "OFF" to the device's own on/off values
+func (d *device) deviceState(v any) any {
+	if d.valueOn == nil {
+		return v
+	} else if v == "ON" {
+		return d.valueOn
+	} else if v == "OFF" {
+		return d.valueOff
+	}
+	return v
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDetectState(t *testing.T) {
+	tests := []struct {
+		name     string
+		exposes  string
+		current  string
+		attr     string
+		on, off  any
+		detected bool
+	}{
+		{"single switch", `[{"type": "switch", "features": [
+			{"type": "binary", "name": "state", "property": "state", "value_on": "ON", "value_off": "OFF"}]}]`,
+			"state_right", "state", "ON", "OFF", true},
+		{"light with own values", `[{"type": "light", "features": [
+			{"type": "binary", "name": "state", "property": "state", "value_on": "on", "value_off": "off"},
+			{"type": "numeric", "name": "brightness", "property": "brightness"}]}]`,
+			"state", "state", "on", "off", true},
+		{"two gangs", `[
+			{"type": "switch", "features": [{"type": "binary", "name": "state", "property": "state_left"}]},
+			{"type": "switch", "features": [{"type": "binary", "name": "state", "property": "state_right"}]}]`,
+			"state_right", "", nil, nil, false},
+		{"presence sensor", `[{"type": "binary", "name": "presence", "property": "presence"},
+			{"type": "numeric", "name": "illuminance", "property": "illuminance"}]`,
+			"occupancy", "presence", nil, nil, true},
+		{"already right", `[{"type": "binary", "name": "contact", "property": "contact"}]`,
+			"contact", "", nil, nil, false},
+	}
+
+	for _, tt := range tests {
+		var exposes []expose
+		if err := json.Unmarshal([]byte(tt.exposes), &exposes); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		attr, on, off, ok := detectState(exposes, tt.current)
+		if ok != tt.detected || attr != tt.attr || on != tt.on || off != tt.off {
+			t.Errorf("%s: got %q %v/%v %v, wanted %q %v/%v %v", tt.name,
+				attr, on, off, ok, tt.attr, tt.on, tt.off, tt.detected)
+		}
+	}
+}
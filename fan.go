@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"regelwerk/timers"
+)
+
+// Turns on a bathroom fan when the humidity rises Rise percentage points above
+// its rolling average, e.g. when someone showers, and off again once it's
+// back within Recover of where it was, after running at least MinRun.
+type fanConfig struct {
+	Sensor   string       // humidity sensor
+	Rise     float64      // above the baseline that turns the fan on
+	Recover  float64      // above the baseline that turns it off, default Rise/2
+	Baseline textDuration // window of the rolling average, default 1h
+	MinRun   textDuration
+}
+
+type fans struct {
+	mu   sync.Mutex
+	list []*fan
+}
+
+type fan struct {
+	topic string
+	cfg   fanConfig
+
+	baseline *rollingWindow // humidity while the fan is off
+	humidity float64        // latest reading
+
+	on         bool
+	onBaseline float64 // the baseline when turned on
+	onSince    time.Time
+}
+
+func validateFans(fans map[string]fanConfig) error {
+	for topic, fc := range fans {
+		if fc.Sensor == "" || fc.Rise <= 0 {
+			return fmt.Errorf("fan %q needs a Sensor and Rise", topic)
+		} else if fc.Recover < 0 || fc.Recover > fc.Rise {
+			return fmt.Errorf("fan %q: Recover must be between 0 and Rise", topic)
+		}
+	}
+	return nil
+}
+
+func (r *regelwerk) addFans(cfg map[string]fanConfig) {
+	if len(cfg) == 0 {
+		return
+	}
+
+	r.fans = &fans{}
+	for topic, fc := range cfg {
+		if fc.Recover == 0 {
+			fc.Recover = fc.Rise / 2
+		}
+		if fc.Baseline <= 0 {
+			fc.Baseline = textDuration(time.Hour)
+		}
+		r.fans.list = append(r.fans.list, &fan{
+			topic:    topic,
+			cfg:      fc,
+			baseline: newRollingWindow(time.Duration(fc.Baseline)),
+		})
+
+		if r.devices[topic] == nil {
+			r.AddDevice(&device{id: topic, topic: topic, stateAttr: "state", state: "OFF"})
+		}
+		if r.devices[fc.Sensor] == nil {
+			r.AddDevice(&device{id: fc.Sensor, topic: fc.Sensor, stateAttr: "humidity"})
+		}
+	}
+	r.timers.Register("fan", r.handleFanTimer)
+}
+
+// Follows the humidity of the fans' sensors
+func (r *regelwerk) observeFans(ctx context.Context, d *device, payload map[string]any) {
+	fs := r.fans
+	if fs == nil {
+		return
+	}
+
+	humidity, ok := payload["humidity"].(float64)
+	if !ok {
+		return
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	for _, f := range fs.list {
+		if f.cfg.Sensor == d.topic {
+			f.humidity = humidity
+			r.updateFan(ctx, f, r.clock.Now(), true)
+		}
+	}
+}
+
+// Turns the fan on or off for the latest humidity, adding it to the baseline
+// if it's a new reading and the fan stays off.
+// Must be called with the fans lock held.
+func (r *regelwerk) updateFan(ctx context.Context, f *fan, now time.Time, reading bool) {
+	if !f.on {
+		baseline, ok := f.baseline.Mean()
+		if ok && f.humidity >= baseline+f.cfg.Rise {
+			log.Printf("humidity %.0f%% is above %.0f%%, turning on fan %q", f.humidity, baseline, f.topic)
+			f.on, f.onBaseline, f.onSince = true, baseline, now
+			r.switchFan(ctx, f)
+
+			r.timers.Destroy("fan/" + f.topic)
+			r.timers.Add("fan/"+f.topic, "fan", map[string]string{"fan": f.topic})
+			r.timers.Start("fan/"+f.topic, time.Duration(f.cfg.MinRun))
+		} else if reading {
+			f.baseline.Add(now, f.humidity)
+		}
+		return
+	}
+
+	if f.humidity <= f.onBaseline+f.cfg.Recover && now.Sub(f.onSince) >= time.Duration(f.cfg.MinRun) {
+		log.Printf("humidity back to %.0f%%, turning off fan %q", f.humidity, f.topic)
+		f.on = false
+		r.switchFan(ctx, f)
+	}
+}
+
+func (r *regelwerk) switchFan(ctx context.Context, f *fan) {
+	a := r.devices[f.topic].NewState(onOff(f.on))
+	a.source = SOURCE_FAN
+	r.Do(ctx, a)
+}
+
+// Turns the fan off at the end of its minimum run time, if the humidity has
+// already recovered
+func (r *regelwerk) handleFanTimer(ctx context.Context, tm *timers.Timer, expired bool) {
+	fs := r.fans
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	for _, f := range fs.list {
+		if f.topic == tm.Meta("fan") {
+			r.updateFan(ctx, f, r.clock.Now(), false)
+		}
+	}
+}
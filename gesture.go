@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"regelwerk/mqttio"
+	"regelwerk/timers"
+)
+
+// Synthesizes gestures from the raw actions of buttons that only report
+// single presses and holds: presses in quick succession become "double",
+// "triple" or "press_<n>", and a hold until release becomes "long", with the
+// hold_time in seconds. The gestures run Buttons actions and are available to
+// conditions as payload.action.
+type gestureConfig struct {
+	Press   string       // raw action of a press, default "single"
+	Hold    string       // default "hold"
+	Release string       // default "release"
+	Window  textDuration // max time between presses, default 400ms
+}
+
+// Multi-press detection of a device, protected by its group lock
+type gestureState struct {
+	cfg gestureConfig
+
+	presses   int
+	pressT    timers.ClockTimer
+	holdStart time.Time
+}
+
+func newGestureState(cfg gestureConfig) *gestureState {
+	if cfg.Press == "" {
+		cfg.Press = "single"
+	}
+	if cfg.Hold == "" {
+		cfg.Hold = "hold"
+	}
+	if cfg.Release == "" {
+		cfg.Release = "release"
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = textDuration(400 * time.Millisecond)
+	}
+	return &gestureState{cfg: cfg}
+}
+
+// Returns the name of the gesture of n presses
+func pressGesture(n int) string {
+	switch n {
+	case 1:
+		return "single"
+	case 2:
+		return "double"
+	case 3:
+		return "triple"
+	}
+	return fmt.Sprintf("press_%d", n)
+}
+
+// Takes the device's raw press, hold and release actions, returning whether
+// the action was consumed; the resulting gesture is handled once complete.
+// Must be called with the group lock held.
+func (r *regelwerk) detectGesture(ctx context.Context, d *device, payload map[string]any) bool {
+	g := d.gesture
+	if g == nil {
+		return false
+	}
+
+	switch mqttio.GetMapValue(payload, "action") {
+	case g.cfg.Press:
+		g.presses++
+		if g.pressT != nil {
+			g.pressT.Reset(time.Duration(g.cfg.Window))
+			return true
+		}
+
+		g.pressT = r.clock.AfterFunc(time.Duration(g.cfg.Window), func() {
+			d.group.mu.Lock()
+			defer d.group.mu.Unlock()
+
+			n := g.presses
+			g.presses, g.pressT = 0, nil
+			if ctx.Err() == nil {
+				r.fireGesture(ctx, d, payload, pressGesture(n), nil)
+			}
+		})
+
+	case g.cfg.Hold:
+		// repeated while held by some devices
+		if g.holdStart.IsZero() {
+			g.holdStart = r.clock.Now()
+		}
+
+	case g.cfg.Release:
+		if g.holdStart.IsZero() {
+			return true
+		}
+		held := r.clock.Now().Sub(g.holdStart)
+		g.holdStart = time.Time{}
+		r.fireGesture(ctx, d, payload, "long", map[string]any{"hold_time": held.Seconds()})
+
+	default:
+		return false
+	}
+	return true
+}
+
+// Handles the gesture like a button action of the device
+func (r *regelwerk) fireGesture(ctx context.Context, d *device, raw map[string]any, gesture string, extra map[string]any) {
+	payload := make(map[string]any, len(raw)+len(extra))
+	for k, v := range raw {
+		payload[k] = v
+	}
+	for k, v := range extra {
+		payload[k] = v
+	}
+	payload["action"] = gesture
+
+	log.Printf("button %q gesture %s", d.topic, gesture)
+	r.journal.Add("gesture", d.topic, payload)
+	r.handleButton(ctx, d, payload)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"regelwerk/timers"
+)
+
+// name of the group for devices that don't specify one
+const DEFAULT_GROUP = "default"
+
+// Devices are organized in groups, e.g. rooms. Each group has its own queue,
+// worker and lock, so a slow or stuck rule in one group can't hold up
+// devices in the others. Devices used together by a rule need to be in the
+// same group.
+type group struct {
+	name  string
+	mu    sync.Mutex // held while handling events of the group's devices
+	inbox *inbox
+	sun   *sun // if the group has its own location or angle
+
+	busySince atomic.Int64 // when the current event started processing, if any
+}
+
+// Returns the named group, creating it if needed.
+// Only to be used during setup.
+func (r *regelwerk) group(name string) *group {
+	if name == "" {
+		name = DEFAULT_GROUP
+	}
+
+	g := r.groups[name]
+	if g == nil {
+		g = &group{name: name, inbox: newInbox(r.queueSize)}
+		r.groups[name] = g
+	}
+	return g
+}
+
+// Starts a worker for each group, which run until the context is done
+func (r *regelwerk) startWorkers(ctx context.Context) {
+	for _, g := range r.groups {
+		go g.run(ctx, r)
+	}
+}
+
+// Processes queued messages until the context is done
+func (g *group) run(ctx context.Context, r *regelwerk) {
+	for {
+		ev, ok := g.inbox.Get(ctx)
+		if !ok {
+			return
+		}
+		g.busySince.Store(time.Now().UnixNano())
+
+		// from receiving, including the wait in the inbox
+		ctx, sp := r.tracer.Start(ctx, "message", ev.received)
+		sp.SetAttr("topic", ev.msg.Topic())
+		sp.SetAttr("group", g.name)
+		r.processMessage(ctx, ev.dev, ev.msg)
+		sp.End()
+
+		g.busySince.Store(0)
+	}
+}
+
+// Wraps a timer callback to run with the lock held for the group of the
+// device in the timer's "topic" metadata, or the default group without one.
+// Only to be used during setup.
+func (r *regelwerk) lockedDevice(fn timers.Func) timers.Func {
+	def := r.group(DEFAULT_GROUP)
+	return func(ctx context.Context, tm *timers.Timer, expired bool) {
+		g := def
+		if d := r.deviceByTopic(tm.Meta("topic")); d != nil {
+			g = d.group
+		}
+
+		ctx, sp := r.tracer.Start(ctx, "timer", time.Now())
+		sp.SetAttr("timer", tm.Name())
+		defer sp.End()
+
+		_, lock := r.tracer.Start(ctx, "lock", time.Now())
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		lock.End()
+
+		fn(ctx, tm, expired)
+	}
+}
+
+// Checks that the sensors and the switch of the sessions are in one group,
+// as the session rule handles them together
+func (r *regelwerk) checkSessionGroup() error {
+	sw := r.LookupDevice("switch")
+	for _, d := range r.devices {
+		if (d.id == "contact" || d.id == "motion") && d.group != sw.group {
+			return fmt.Errorf("sensor %q is in group %q, but switch %q is in %q",
+				d.topic, d.group.name, sw.topic, sw.group.name)
+		}
+	}
+	return nil
+}
+
+// Checks that the configured groups are used by devices
+func validateGroups(groups map[string]groupConfig, devices map[string]deviceConfig) error {
+	for name, gc := range groups {
+		used := false
+		for _, dc := range devices {
+			used = used || dc.Group == name
+		}
+		if !used {
+			return fmt.Errorf("group %q has no devices", name)
+		} else if err := gc.Location.Validate(); err != nil {
+			return fmt.Errorf("bad Location for group %q: %v", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// a group's worker handling a single event for longer than this is stuck
+const WORKER_STUCK_AFTER = time.Minute
+
+type healthReport struct {
+	Healthy     bool
+	Connected   bool
+	LastMessage time.Time `json:",omitempty"`
+	Stale       bool      `json:",omitempty"` // no messages for too long
+	StuckGroups []string  `json:",omitempty"`
+}
+
+// Checks that we are connected to the broker, messages are coming in and
+// the workers are not stuck
+func (r *regelwerk) Health() healthReport {
+	h := healthReport{Connected: r.client.IsConnectionOpen()}
+
+	since := r.started
+	if ts := r.lastMessage.Load(); ts != 0 {
+		h.LastMessage = time.Unix(0, ts)
+		since = h.LastMessage
+	}
+	h.Stale = r.staleAfter > 0 && r.clock.Now().Sub(since) > r.staleAfter
+
+	for _, g := range r.groups {
+		if ts := g.busySince.Load(); ts != 0 && time.Since(time.Unix(0, ts)) > WORKER_STUCK_AFTER {
+			h.StuckGroups = append(h.StuckGroups, g.name)
+		}
+	}
+
+	h.Healthy = h.Connected && !h.Stale && len(h.StuckGroups) == 0
+	return h
+}
+
+// Serves /healthz for liveness probes and /status for the status report,
+// along with the endpoints changing rules, snoozes and alerts
+func (r *regelwerk) serveHTTP(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
+		h := r.Health()
+		if !h.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		writeJSON(w, h)
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, r.Status())
+	})
+
+	// POST e.g. {"motion": false} to disable rules
+	mux.HandleFunc("/rules", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPost {
+			var states map[string]bool
+			if err := json.NewDecoder(req.Body).Decode(&states); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			} else if err := r.rules.Set(states); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		writeJSON(w, r.rules.List())
+	})
+
+	// POST e.g. {"Device": "hall_light", "For": "2h"} to snooze automations
+	mux.HandleFunc("/snooze", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPost {
+			var cmd snoozeCommand
+			if err := json.NewDecoder(req.Body).Decode(&cmd); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			} else if err := r.Snooze(cmd.Device, time.Duration(cmd.For)); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		writeJSON(w, r.SnoozeReport())
+	})
+
+	// POST the key of an alert to acknowledge it, or nothing for all
+	mux.HandleFunc("/alerts", func(w http.ResponseWriter, req *http.Request) {
+		if r.alerts == nil {
+			http.NotFound(w, req)
+			return
+		} else if req.Method == http.MethodPost {
+			key, _ := io.ReadAll(req.Body)
+			if err := r.AckAlert(string(key)); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		writeJSON(w, r.AlertsReport())
+	})
+
+	srv := &http.Server{Addr: localAddr(addr), Handler: r.authorizePosts(mux)}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	log.Printf("serving health & status on %s", srv.Addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("HTTP server failed: %v", err)
+	}
+}
+
+// Returns the address to listen on, on localhost if it has no host
+func localAddr(addr string) string {
+	if host, port, err := net.SplitHostPort(addr); err == nil && host == "" {
+		return net.JoinHostPort("127.0.0.1", port)
+	}
+	return addr
+}
+
+// Rejects POSTs without the HTTPToken, if one is configured
+func (r *regelwerk) authorizePosts(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && r.httpToken != "" {
+			want := "Bearer " + r.httpToken
+			if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte(want)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("error writing response: %v", err)
+	}
+}
+
+// Queries the health endpoint of a running instance.
+// Returns nil if it's healthy.
+func healthcheck(addr string) error {
+	c := http.Client{Timeout: 5 * time.Second}
+	resp, err := c.Get("http://" + addr + "/healthz")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unhealthy: %s", body)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthStale(t *testing.T) {
+	cfg := testConfig()
+	cfg.HealthStaleAfter = textDuration(time.Minute)
+	r, _ := newTestRegelwerk(t, cfg)
+
+	if h := r.Health(); !h.Healthy {
+		t.Errorf("wanted healthy right after start, got %+v", h)
+	}
+
+	r.lastMessage.Store(time.Now().Add(-2 * time.Minute).UnixNano())
+	if h := r.Health(); h.Healthy || !h.Stale {
+		t.Errorf("wanted stale without recent messages, got %+v", h)
+	}
+}
+
+func TestAuthorizePosts(t *testing.T) {
+	cfg := testConfig()
+	cfg.HTTPToken = "secret"
+	r, _ := newTestRegelwerk(t, cfg)
+	h := r.authorizePosts(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+
+	tests := []struct {
+		method, auth string
+		code         int
+	}{
+		{http.MethodGet, "", http.StatusOK},
+		{http.MethodPost, "", http.StatusUnauthorized},
+		{http.MethodPost, "Bearer wrong", http.StatusUnauthorized},
+		{http.MethodPost, "Bearer secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/rules", nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Errorf("%s %q: wanted %d, got %d", tt.method, tt.auth, tt.code, w.Code)
+		}
+	}
+
+	if addr := localAddr(":8086"); addr != "127.0.0.1:8086" {
+		t.Errorf("wanted localhost without a host, got %q", addr)
+	} else if addr := localAddr("0.0.0.0:8086"); addr != "0.0.0.0:8086" {
+		t.Errorf("wanted an explicit host kept, got %q", addr)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Detects long periods without device events, e.g. when nobody is home.
+// While idle, background work should be done less often to save power; see
+// Interval and Sleep.
+type idleDetector struct {
+	after  time.Duration // inactivity before going idle, 0 to disable
+	factor int           // how much to stretch intervals by when idle
+
+	mu   sync.Mutex
+	t    *time.Timer
+	idle bool
+	wake chan struct{} // closed when activity resumes
+}
+
+func newIdleDetector(after time.Duration, factor int) *idleDetector {
+	d := &idleDetector{after: after, factor: factor, wake: make(chan struct{})}
+	if after > 0 {
+		d.t = time.AfterFunc(after, d.enterIdle)
+	}
+	return d
+}
+
+func (d *idleDetector) enterIdle() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.idle {
+		d.idle = true
+		log.Printf("no events for %s, entering power-save mode", d.after)
+	}
+}
+
+// Records activity, waking up from idle if needed
+func (d *idleDetector) Activity() {
+	if d.t == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.idle {
+		d.idle = false
+		close(d.wake)
+		d.wake = make(chan struct{})
+		log.Printf("activity resumed, leaving power-save mode")
+	}
+	d.t.Reset(d.after)
+}
+
+func (d *idleDetector) Idle() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.idle
+}
+
+// Returns the polling interval to use, stretched if idle
+func (d *idleDetector) Interval(base time.Duration) time.Duration {
+	if d.Idle() {
+		return base * time.Duration(d.factor)
+	}
+	return base
+}
+
+// Sleeps for the polling interval, but returns early if activity resumes
+// while idle. Returns false if the context is done.
+func (d *idleDetector) Sleep(ctx context.Context, base time.Duration) bool {
+	d.mu.Lock()
+	idle, wake := d.idle, d.wake
+	d.mu.Unlock()
+
+	if idle {
+		base *= time.Duration(d.factor)
+	} else {
+		wake = nil
+	}
+
+	t := time.NewTimer(base)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+	case <-wake:
+	case <-ctx.Done():
+		return false
+	}
+	return true
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// An incoming message for a registered device, waiting to be processed
+type inboxEvent struct {
+	dev      *device
+	msg      mqtt.Message
+	received time.Time
+}
+
+// Bounded queues between the MQTT client and the rule handlers.
+// Messages from critical devices (leak, smoke, locks) go into a separate
+// lane that is always drained first, so a flood of chatty sensors can't
+// delay them.
+type inbox struct {
+	normal   chan inboxEvent
+	critical chan inboxEvent
+}
+
+func newInbox(size int) *inbox {
+	return &inbox{
+		normal:   make(chan inboxEvent, size),
+		critical: make(chan inboxEvent, size),
+	}
+}
+
+// Queues the message for processing, without blocking the MQTT client.
+// Normal messages are dropped when the queue is full. Critical ones that
+// don't fit in their lane take the place of the oldest normal message.
+func (b *inbox) Put(dev *device, msg mqtt.Message) {
+	ev := inboxEvent{dev, msg, time.Now()}
+	if dev.critical {
+		select {
+		case b.critical <- ev:
+			return
+		default:
+		}
+
+		select {
+		case old := <-b.normal:
+			log.Printf("inbox full, dropping msg for %q to queue %q", old.dev.topic, dev.topic)
+		default:
+		}
+	}
+
+	select {
+	case b.normal <- ev:
+	default:
+		log.Printf("inbox full, dropping msg for %q", dev.topic)
+	}
+}
+
+// Waits for the next message, preferring the critical lane.
+// Returns false if the context is done.
+func (b *inbox) Get(ctx context.Context) (inboxEvent, bool) {
+	select {
+	case ev := <-b.critical:
+		return ev, true
+	default:
+	}
+
+	select {
+	case ev := <-b.critical:
+		return ev, true
+	case ev := <-b.normal:
+		return ev, true
+	case <-ctx.Done():
+		return inboxEvent{}, false
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestInboxCriticalOverflow(t *testing.T) {
+	b := newInbox(1)
+	chatty := &device{topic: "plug"}
+	leak := &device{topic: "kitchen_leak", critical: true}
+
+	b.Put(chatty, &virtualMessage{topic: "plug"})
+	b.Put(leak, &virtualMessage{topic: "leak 1"})
+
+	// doesn't block, but replaces the normal message
+	b.Put(leak, &virtualMessage{topic: "leak 2"})
+
+	for _, want := range []string{"leak 1", "leak 2"} {
+		if ev, _ := b.Get(context.Background()); ev.msg.Topic() != want {
+			t.Errorf("wanted %q, got %q", want, ev.msg.Topic())
+		}
+	}
+	if len(b.normal) != 0 || len(b.critical) != 0 {
+		t.Errorf("inbox not empty")
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Merges config files, e.g. one per room, which are listed in the config's
+// Include. Named entries like Devices or Actions can be spread over files,
+// but each defined only once; lists are concatenated, and settings can only
+// be given in one file unless the values agree.
+type configMerger struct {
+	merged  map[string]any
+	defined map[string]string // key, or key & entry name -> defining file
+	read    map[string]bool   // files already merged
+}
+
+// Reads the config file, without comments, merged with the files it includes
+func readConfig(fname string) ([]byte, error) {
+	m := &configMerger{
+		merged:  make(map[string]any),
+		defined: make(map[string]string),
+		read:    make(map[string]bool),
+	}
+	if err := m.include(fname); err != nil {
+		return nil, err
+	}
+	return json.Marshal(m.merged)
+}
+
+// Reads a single file, without comments
+func readConfigFile(fname string) (map[string]any, error) {
+	cfgStr, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	// remove line comments, json.Unmarshal can't parse them
+	cfgStr = CONFIG_COMMENTS_RE.ReplaceAllLiteral(cfgStr, []byte{})
+
+	var raw map[string]any
+	dec := json.NewDecoder(bytes.NewReader(cfgStr))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("%s: %v", fname, err)
+	}
+	return raw, nil
+}
+
+// Merges the file, then the ones it includes, relative to its directory
+func (m *configMerger) include(fname string) error {
+	if abs, err := filepath.Abs(fname); err == nil {
+		if m.read[abs] {
+			return fmt.Errorf("%s is included more than once", fname)
+		}
+		m.read[abs] = true
+	}
+
+	raw, err := readConfigFile(fname)
+	if err != nil {
+		return err
+	}
+
+	var includes []string
+	if v, found := takeKey(raw, "Include"); found {
+		list, _ := v.([]any)
+		for _, p := range list {
+			if s, ok := p.(string); ok {
+				includes = append(includes, s)
+			} else {
+				return fmt.Errorf("%s: bad Include %v", fname, v)
+			}
+		}
+		if list == nil {
+			return fmt.Errorf("%s: Include needs a list of files", fname)
+		}
+	}
+
+	if err := m.add(fname, raw); err != nil {
+		return err
+	}
+
+	for _, pattern := range includes {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(fname), pattern)
+		}
+
+		// patterns may match nothing, but plain files need to exist
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("%s: bad Include %q: %v", fname, pattern, err)
+		} else if len(files) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			files = []string{pattern}
+		}
+
+		for _, f := range files {
+			if err := m.include(f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Merges the top-level keys of a file's config
+func (m *configMerger) add(fname string, raw map[string]any) error {
+	for k, v := range raw {
+		// keys are case-insensitive, like for json.Unmarshal
+		key := k
+		for existing := range m.merged {
+			if strings.EqualFold(existing, k) {
+				key = existing
+			}
+		}
+
+		old, found := m.merged[key]
+		if !found {
+			m.merged[key] = v
+			m.defined[key] = fname
+			if entries, ok := v.(map[string]any); ok {
+				for name := range entries {
+					m.defined[key+"/"+name] = fname
+				}
+			}
+			continue
+		}
+
+		switch v := v.(type) {
+		case map[string]any:
+			entries, ok := old.(map[string]any)
+			if !ok {
+				return fmt.Errorf("%s: %s conflicts with %s", fname, key, m.defined[key])
+			}
+			for name, entry := range v {
+				if _, dup := entries[name]; dup {
+					return fmt.Errorf("%s: %s %q already defined in %s", fname, key, name, m.defined[key+"/"+name])
+				}
+				entries[name] = entry
+				m.defined[key+"/"+name] = fname
+			}
+
+		case []any:
+			list, ok := old.([]any)
+			if !ok {
+				return fmt.Errorf("%s: %s conflicts with %s", fname, key, m.defined[key])
+			}
+			m.merged[key] = append(list, v...)
+
+		default:
+			if old != v {
+				return fmt.Errorf("%s: %s already set in %s", fname, key, m.defined[key])
+			}
+		}
+	}
+	return nil
+}
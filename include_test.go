@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInclude(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "rooms"), 0o755)
+	fname := filepath.Join(dir, "regelwerk.conf")
+	os.WriteFile(fname, []byte(`{
+		"Include": ["rooms/*.conf"],
+		"Switch": "light",
+		"Actions": { "night": { "Type": "activate_scene", "Scene": "night" } },
+		"CriticalTopics": ["door"]
+	}`), 0o644)
+	os.WriteFile(filepath.Join(dir, "rooms", "kitchen.conf"), []byte(`{
+		// the kitchen
+		"switch": "light",
+		"Actions": { "kitchen_on": { "Type": "publish", "Device": "kitchen" } },
+		"CriticalTopics": ["kitchen"]
+	}`), 0o644)
+
+	var cfg config
+	if err := parseConfig(fname, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Actions) != 2 || len(cfg.CriticalTopics) != 2 {
+		t.Errorf("not merged: %+v, %v", cfg.Actions, cfg.CriticalTopics)
+	}
+
+	os.WriteFile(filepath.Join(dir, "rooms", "hall.conf"), []byte(`{
+		"Actions": { "night": { "Type": "publish", "Device": "hall" } }
+	}`), 0o644)
+	err := parseConfig(fname, &cfg)
+	if err == nil || !strings.Contains(err.Error(), `Actions "night" already defined in `+fname) {
+		t.Errorf("wanted conflict reported, got %v", err)
+	}
+
+	os.WriteFile(filepath.Join(dir, "rooms", "hall.conf"), []byte(`{"Switch": "hall_light"}`), 0o644)
+	if err := parseConfig(fname, &cfg); err == nil {
+		t.Errorf("expected error for setting given twice")
+	}
+}
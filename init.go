@@ -0,0 +1,142 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// The commented example config, written by -init
+//
+//go:embed regelwerk.conf
+var exampleConfig string
+
+// Placeholders of the example config that -init fills in
+const (
+	EXAMPLE_SERVER        = `"Server": "tcp://localhost:1883"`
+	EXAMPLE_USERNAME      = `"Username": "regelwerk"`
+	EXAMPLE_PASSWORD      = `"Password": "my_s3cr3t_PasSw0rd"`
+	EXAMPLE_SENSOR        = `"0x00158d00037aa30d"`
+	EXAMPLE_SWITCH        = `"0x54efda1d5823873d"`
+	EXAMPLE_MOTIONSENSORS = `"MotionSensors": []`
+)
+
+// Writes the example config to fname, for new installs. If server is set,
+// the devices zigbee2mqtt knows there are listed in it, and the first contact
+// sensor and switch, and all motion sensors are filled in.
+func initConfig(fname, server string) error {
+	if _, err := os.Stat(fname); err == nil {
+		return fmt.Errorf("%s already exists", fname)
+	}
+
+	cfgStr := exampleConfig
+	if server != "" {
+		devices, err := fetchBridgeDevices(&config{Server: server})
+		if err != nil {
+			return fmt.Errorf("unable to discover devices: %v", err)
+		}
+		cfgStr = fillExampleConfig(server, devices)
+	}
+
+	// it holds the broker's password
+	return os.WriteFile(fname, []byte(cfgStr), 0o600)
+}
+
+// Fills the example config in with the server, and devices from bridge/devices
+func fillExampleConfig(server string, devices []*z2mDevice) string {
+	cfgStr := exampleConfig
+	quote := func(s string) string {
+		js, _ := json.Marshal(s)
+		return string(js)
+	}
+
+	// credentials in the URL go into their own settings
+	if u, err := url.Parse(server); err == nil && u.User != nil {
+		cfgStr = strings.Replace(cfgStr, EXAMPLE_USERNAME, `"Username": `+quote(u.User.Username()), 1)
+		if pw, set := u.User.Password(); set {
+			cfgStr = strings.Replace(cfgStr, EXAMPLE_PASSWORD, `"Password": `+quote(pw), 1)
+		}
+		u.User = nil
+		server = u.String()
+	}
+	cfgStr = strings.Replace(cfgStr, EXAMPLE_SERVER, `"Server": `+quote(server), 1)
+
+	var list strings.Builder
+	var sensor, sw string
+	var motion []string
+	for _, zd := range devices {
+		if zd.Definition == nil {
+			continue // the coordinator
+		}
+		fmt.Fprintf(&list, "\t//   %-24s %s %s\n", zd.FriendlyName, zd.Definition.Vendor, zd.Definition.Model)
+
+		switch suggestRole(zd) {
+		case ROLE_SWITCH:
+			if sw == "" {
+				sw = zd.FriendlyName
+			}
+		case ROLE_CONTACT:
+			if sensor == "" {
+				sensor = zd.FriendlyName
+			}
+		case ROLE_MOTION:
+			motion = append(motion, quote(zd.FriendlyName))
+		}
+	}
+
+	if sensor != "" {
+		cfgStr = strings.ReplaceAll(cfgStr, EXAMPLE_SENSOR, quote(sensor))
+	}
+	if sw != "" {
+		cfgStr = strings.ReplaceAll(cfgStr, EXAMPLE_SWITCH, quote(sw))
+	}
+	if len(motion) > 0 {
+		cfgStr = strings.Replace(cfgStr, EXAMPLE_MOTIONSENSORS,
+			`"MotionSensors": [`+strings.Join(motion, ", ")+`]`, 1)
+	}
+
+	if list.Len() > 0 {
+		cfgStr = strings.Replace(cfgStr, "{\n",
+			"{\n\t// devices found on "+server+":\n"+list.String()+"\n", 1)
+	}
+	return cfgStr
+}
+
+// Gets zigbee2mqtt's retained device list from the broker
+func fetchBridgeDevices(cfg *config) ([]*z2mDevice, error) {
+	c, err := connectClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Disconnect(250)
+
+	reply := make(chan []byte, 1)
+	tok := c.Subscribe(MQTT_TOPIC_PREFIX+"bridge/devices", 0, func(_ mqtt.Client, msg mqtt.Message) {
+		select {
+		case reply <- msg.Payload():
+		default:
+		}
+	})
+	if !tok.WaitTimeout(CLIENT_TIMEOUT) {
+		return nil, fmt.Errorf("timed out subscribing")
+	} else if tok.Error() != nil {
+		return nil, tok.Error()
+	}
+
+	select {
+	case js := <-reply:
+		var devices []*z2mDevice
+		if err := json.Unmarshal(js, &devices); err != nil {
+			return nil, err
+		}
+		return devices, nil
+	case <-time.After(CLIENT_TIMEOUT):
+		return nil, fmt.Errorf("no device list, is zigbee2mqtt running?")
+	}
+}
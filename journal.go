@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A recorded device event or action
+type journalEntry struct {
+	Time    time.Time
+	Kind    string // "event" or "action"
+	Target  string // device topic or ID
+	Payload any
+}
+
+// Ring buffer of the most recent events and actions, to find out what led up
+// to something happening without needing debug logs
+type journal struct {
+	mu      sync.Mutex
+	entries []journalEntry
+	next    int // where the next entry goes
+	full    bool
+}
+
+func newJournal(size int) *journal {
+	return &journal{entries: make([]journalEntry, size)}
+}
+
+func (j *journal) Add(kind, target string, payload any) {
+	if len(j.entries) == 0 {
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.entries[j.next] = journalEntry{time.Now(), kind, target, payload}
+	j.next = (j.next + 1) % len(j.entries)
+	if j.next == 0 {
+		j.full = true
+	}
+}
+
+// Returns the entries, oldest first
+func (j *journal) Entries() []journalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if !j.full {
+		return append([]journalEntry(nil), j.entries[:j.next]...)
+	}
+	return append(append([]journalEntry(nil), j.entries[j.next:]...), j.entries[:j.next]...)
+}
+
+// Returns a middleware that records actions in the journal
+func (j *journal) recordActions(next actionFunc) actionFunc {
+	return func(ctx context.Context, a *action) error {
+		j.Add("action", a.Target(), a.payload)
+		return next(ctx, a)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestJournalWraps(t *testing.T) {
+	j := newJournal(3)
+	for i := 0; i < 5; i++ {
+		j.Add("event", "dev", i)
+	}
+
+	entries := j.Entries()
+	if len(entries) != 3 {
+		t.Fatalf("wanted 3 entries, got %d", len(entries))
+	}
+	for i, e := range entries {
+		if e.Payload != i+2 {
+			t.Errorf("entry %d: wanted %d got %v", i, i+2, e.Payload)
+		}
+	}
+}
+
+func TestJournalPartial(t *testing.T) {
+	j := newJournal(3)
+	j.Add("event", "dev", 0)
+
+	if entries := j.Entries(); len(entries) != 1 || entries[0].Payload != 0 {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+}
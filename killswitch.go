@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"regelwerk/timers"
+)
+
+// The master switch for all automation, e.g. for maintenance or parties.
+// While off, device states are still tracked, but only manual actions and
+// emergencies are sent. Set with regelwerk/automation/set, which can be
+// retained, and persisted next to the -state file.
+type killSwitch struct {
+	mu   sync.Mutex
+	off  bool
+	file string // empty if not persisted
+}
+
+func newKillSwitch(stateFile string) *killSwitch {
+	ks := &killSwitch{}
+	if stateFile != "" {
+		ks.file = filepath.Join(filepath.Dir(stateFile), "automation.json")
+	}
+	return ks
+}
+
+func (ks *killSwitch) Enabled() bool {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	return !ks.off
+}
+
+// Turns automation on or off. Returns whether it changed.
+func (ks *killSwitch) Set(on bool) (bool, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if ks.off == !on {
+		return false, nil
+	}
+	ks.off = !on
+	if on {
+		log.Printf("automation enabled")
+	} else {
+		log.Printf("automation disabled")
+	}
+
+	if ks.file == "" {
+		return true, nil
+	}
+	js, _ := json.Marshal(on)
+	return true, timers.WriteFileAtomic(ks.file, js)
+}
+
+// Loads the switch's state, if it was saved
+func (ks *killSwitch) Restore() error {
+	if ks.file == "" {
+		return nil
+	}
+
+	js, err := os.ReadFile(ks.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var on bool
+	if err := json.Unmarshal(js, &on); err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.off = !on
+	if ks.off {
+		log.Printf("automation is disabled")
+	}
+	return nil
+}
+
+func automationState(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+func parseAutomationState(s string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "on", "true", "enable":
+		return true, nil
+	case "off", "false", "disable":
+		return false, nil
+	}
+	return false, fmt.Errorf("expected on or off, got %q", s)
+}
+
+// Publishes the switch's state to regelwerk/automation
+func (r *regelwerk) publishAutomation() {
+	r.client.Publish(CONTROL_TOPIC_PREFIX+"automation", 0, true, automationState(r.killSwitch.Enabled()))
+}
+
+// Turns all automation on or off
+func (r *regelwerk) SetAutomation(on bool) error {
+	changed, err := r.killSwitch.Set(on)
+	if changed {
+		r.journal.Add("automation", automationState(on), nil)
+		r.publishAutomation()
+	}
+	return err
+}
+
+// Blocks all but manual actions and emergencies while automation is off
+func (r *regelwerk) killSwitchActions(next actionFunc) actionFunc {
+	return func(ctx context.Context, a *action) error {
+		if a.source != SOURCE_MANUAL && !isEmergency(ctx) && !r.killSwitch.Enabled() {
+			return errors.New("automation is disabled")
+		}
+		return next(ctx, a)
+	}
+}
+
+func (r *regelwerk) registerKillSwitch() {
+	r.AddStatus("automation", func() any { return r.killSwitch.Enabled() })
+
+	r.HandleCommand("automation/set", func(ctx context.Context, payload []byte) error {
+		on, err := parseAutomationState(string(payload))
+		if err != nil {
+			return err
+		}
+		return r.SetAutomation(on)
+	})
+}
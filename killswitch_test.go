@@ -0,0 +1,108 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"regelwerk/timers"
+)
+
+func TestKillSwitch(t *testing.T) {
+	r, mc := newTestRegelwerk(t, testConfig())
+	fc := timers.NewFakeClock(time.Date(2022, 6, 1, 21, 0, 0, 0, time.Local))
+	r.clock, r.timers.Clock = fc, fc
+
+	if err := r.commands["automation/set"](r.ctx, []byte("off")); err != nil {
+		t.Fatal(err)
+	} else if got := mc.Payloads(CONTROL_TOPIC_PREFIX + "automation"); len(got) != 1 || got[0] != "off" {
+		t.Errorf("wanted state published, got %v", got)
+	}
+
+	// sessions don't start, scenes are dropped, manual commands go through
+	receive(r, "door", map[string]any{"contact": false})
+	if st := r.sessions.State("contact"); st != SESSION_IDLE {
+		t.Errorf("session started while disabled: %s", st)
+	}
+	if err := r.ActivateScene(r.ctx, "night"); err != nil {
+		t.Fatal(err)
+	}
+	a := r.devices["light"].NewState("ON")
+	a.source = SOURCE_MANUAL
+	r.Do(r.ctx, a)
+	if got := mc.WaitFor(t, "zigbee2mqtt/light/set", 1); len(got) != 1 || got[0] != `{"state_right":"ON"}` {
+		t.Errorf("wanted only the manual command, got %v", got)
+	}
+
+	// states are still tracked
+	if closed, ok := r.devices["door"].state.(bool); !ok || closed {
+		t.Errorf("door state not tracked while disabled")
+	}
+
+	if err := r.SetAutomation(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ActivateScene(r.ctx, "night"); err != nil {
+		t.Fatal(err)
+	}
+	mc.WaitFor(t, "zigbee2mqtt/light/set", 2)
+}
+
+func TestKillSwitchModbus(t *testing.T) {
+	written := make(chan []byte, 1)
+	addr := fakeModbusDevice(t, func(fn byte, data []byte) []byte {
+		pdu := append([]byte{fn}, data...)
+		written <- pdu
+		return pdu
+	})
+
+	coil := uint16(3)
+	cfg := testConfig()
+	cfg.Actions = map[string]*actionSpec{
+		"pump": {Type: "modbus", Address: addr, Unit: 1, Coil: &coil, Payload: true},
+	}
+	r, _ := newTestRegelwerk(t, cfg)
+
+	if err := r.SetAutomation(false); err != nil {
+		t.Fatal(err)
+	} else if err := r.Run(r.ctx, r.actions["pump"]); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range r.journal.Entries() {
+		if e.Target == "modbus/"+addr {
+			t.Fatalf("modbus sent while disabled: %+v", e)
+		}
+	}
+
+	if err := r.SetAutomation(true); err != nil {
+		t.Fatal(err)
+	} else if err := r.Run(r.ctx, r.actions["pump"]); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-written:
+	case <-time.After(time.Second):
+		t.Fatalf("modbus not written once enabled")
+	}
+
+	entries := r.journal.Entries()
+	if last := entries[len(entries)-1]; last.Kind != "action" || last.Target != "modbus/"+addr {
+		t.Errorf("write not journaled: %+v", last)
+	}
+}
+
+func TestKillSwitchRestore(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "timers.json")
+
+	ks := newKillSwitch(stateFile)
+	if _, err := ks.Set(false); err != nil {
+		t.Fatal(err)
+	}
+
+	ks = newKillSwitch(stateFile)
+	if err := ks.Restore(); err != nil {
+		t.Fatal(err)
+	} else if ks.Enabled() {
+		t.Errorf("wanted automation to stay disabled")
+	}
+}
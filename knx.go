@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KNXnet/IP defaults & service types
+const (
+	KNX_PORT    = "3671"
+	KNX_TIMEOUT = 3 * time.Second
+
+	KNX_CONNECT_REQUEST    = 0x0205
+	KNX_CONNECT_RESPONSE   = 0x0206
+	KNX_DISCONNECT_REQUEST = 0x0209
+	KNX_TUNNELING_REQUEST  = 0x0420
+	KNX_TUNNELING_ACK      = 0x0421
+)
+
+// KNX IP interface that knx actions write group values through
+type knxConfig struct {
+	Gateway string // host, or host:port
+}
+
+func (kc *knxConfig) gateway() string {
+	if _, _, err := net.SplitHostPort(kc.Gateway); err != nil {
+		return net.JoinHostPort(kc.Gateway, KNX_PORT)
+	}
+	return kc.Gateway
+}
+
+// Parses a 3-level group address like "1/2/3"
+func parseGroupAddress(s string) (uint16, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("bad group address %q, expected main/middle/sub", s)
+	}
+
+	var ga uint16
+	for i, max := range []int{31, 7, 255} {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil || n < 0 || n > max {
+			return 0, fmt.Errorf("bad group address %q", s)
+		}
+		ga = ga<<[]int{5, 3, 8}[i] | uint16(n)
+	}
+	return ga, nil
+}
+
+// Encodes a value as the APDU of a GroupValueWrite: booleans as DPT 1 within
+// the APCI, and numbers 0-255 as a byte, like DPT 5
+func knxWriteAPDU(v any) ([]byte, error) {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return []byte{0x00, 0x81}, nil
+		}
+		return []byte{0x00, 0x80}, nil
+	case float64:
+		if v == float64(byte(v)) {
+			return []byte{0x00, 0x80, byte(v)}, nil
+		}
+	}
+	return nil, fmt.Errorf("can only write booleans and numbers 0-255, not %#v", v)
+}
+
+func knxFrame(service uint16, body []byte) []byte {
+	frame := []byte{0x06, 0x10, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(frame[2:], service)
+	binary.BigEndian.PutUint16(frame[4:], uint16(6+len(body)))
+	return append(frame, body...)
+}
+
+// Reads frames until one of the service type, returning its body
+func knxRead(conn net.Conn, service uint16) ([]byte, error) {
+	buf := make([]byte, 512)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		if n >= 6 && buf[0] == 0x06 && binary.BigEndian.Uint16(buf[2:]) == service {
+			return append([]byte(nil), buf[6:n]...), nil
+		}
+	}
+}
+
+// Writes a group value through a tunnelling connection, opened for each
+// write as they are rare
+func knxWrite(ctx context.Context, gateway string, ga uint16, v any) error {
+	apdu, err := knxWriteAPDU(v)
+	if err != nil {
+		return err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", gateway)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(KNX_TIMEOUT)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	conn.SetDeadline(deadline)
+
+	// the gateway replies to where requests come from, which works with NAT
+	hpai := []byte{0x08, 0x01, 0, 0, 0, 0, 0, 0}
+	cri := []byte{0x04, 0x04, 0x02, 0x00} // tunnel on the link layer
+
+	body := append(append(append([]byte{}, hpai...), hpai...), cri...)
+	if _, err := conn.Write(knxFrame(KNX_CONNECT_REQUEST, body)); err != nil {
+		return err
+	}
+	resp, err := knxRead(conn, KNX_CONNECT_RESPONSE)
+	if err != nil {
+		return fmt.Errorf("no connection to %s: %v", gateway, err)
+	} else if len(resp) < 2 || resp[1] != 0 {
+		return fmt.Errorf("connection refused by %s", gateway)
+	}
+	channel := resp[0]
+	defer conn.Write(knxFrame(KNX_DISCONNECT_REQUEST, append([]byte{channel, 0}, hpai...)))
+
+	// cEMI L_Data.req from the interface's own address
+	cemi := []byte{0x11, 0x00, 0xbc, 0xe0, 0x00, 0x00, byte(ga >> 8), byte(ga), byte(len(apdu) - 1)}
+	cemi = append(cemi, apdu...)
+	if _, err := conn.Write(knxFrame(KNX_TUNNELING_REQUEST, append([]byte{0x04, channel, 0, 0}, cemi...))); err != nil {
+		return err
+	}
+	if ack, err := knxRead(conn, KNX_TUNNELING_ACK); err != nil {
+		return fmt.Errorf("write not acknowledged: %v", err)
+	} else if len(ack) < 4 || ack[3] != 0 {
+		return fmt.Errorf("write rejected by %s", gateway)
+	}
+
+	// the L_Data.con, telling if it made it onto the bus
+	con, err := knxRead(conn, KNX_TUNNELING_REQUEST)
+	if err != nil {
+		return fmt.Errorf("write not confirmed: %v", err)
+	} else if len(con) < 6 {
+		return fmt.Errorf("bad confirmation from %s", gateway)
+	}
+	conn.Write(knxFrame(KNX_TUNNELING_ACK, []byte{0x04, channel, con[2], 0}))
+
+	if ctrl := 6 + int(con[5]); ctrl < len(con) && con[ctrl]&0x01 != 0 {
+		return fmt.Errorf("write to %s failed on the bus", gateway)
+	}
+	return nil
+}
+
+func init() {
+	actionChecks["knx"] = func(spec *actionSpec) error {
+		_, err := parseGroupAddress(spec.GroupAddress)
+		return err
+	}
+	actionTypes["knx"] = func(ctx context.Context, r *regelwerk, spec *actionSpec) error {
+		if r.knx == nil {
+			return fmt.Errorf("KNX is not configured")
+		}
+		ga, err := parseGroupAddress(spec.GroupAddress)
+		if err != nil {
+			return err
+		}
+
+		// through the middleware like publishes, for the kill switch & journal
+		r.Do(ctx, &action{
+			topic:   "knx/" + spec.GroupAddress,
+			payload: spec.Payload,
+			source:  sourceOf(ctx),
+			send: func(ctx context.Context) error {
+				// run asynchronously, so the gateway doesn't hold up the rules
+				go func() {
+					if err := knxWrite(ctx, r.knx.gateway(), ga, spec.Payload); err != nil {
+						log.Printf("knx write to %s failed: %v", spec.GroupAddress, err)
+					} else {
+						debugf(LOG_ACTIONS, "knx wrote %v to %s", spec.Payload, spec.GroupAddress)
+					}
+				}()
+				return nil
+			},
+		})
+		return nil
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseGroupAddress(t *testing.T) {
+	if ga, err := parseGroupAddress("1/2/3"); err != nil || ga != 0x0a03 {
+		t.Errorf("wanted 0a03, got %04x %v", ga, err)
+	}
+	if ga, err := parseGroupAddress("31/7/255"); err != nil || ga != 0xffff {
+		t.Errorf("wanted ffff, got %04x %v", ga, err)
+	}
+
+	for _, bad := range []string{"", "1/2", "1/8/0", "32/0/0", "1/2/x"} {
+		if _, err := parseGroupAddress(bad); err == nil {
+			t.Errorf("bad group address %q accepted", bad)
+		}
+	}
+}
+
+func TestKnxWriteAPDU(t *testing.T) {
+	for _, tc := range []struct {
+		v    any
+		apdu []byte
+	}{
+		{true, []byte{0x00, 0x81}},
+		{false, []byte{0x00, 0x80}},
+		{200.0, []byte{0x00, 0x80, 200}},
+	} {
+		if apdu, err := knxWriteAPDU(tc.v); err != nil || !bytes.Equal(apdu, tc.apdu) {
+			t.Errorf("%v: wanted %x, got %x %v", tc.v, tc.apdu, apdu, err)
+		}
+	}
+
+	for _, bad := range []any{256.0, 1.5, "ON"} {
+		if _, err := knxWriteAPDU(bad); err == nil {
+			t.Errorf("%#v encoded", bad)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log"
+	"math"
+	"sort"
+	"sync"
+)
+
+// number of readings before a device can be considered weak, so a single
+// bad reading doesn't count
+const LQI_MIN_SAMPLES = 5
+
+// weight of the newest reading in the moving average
+const LQI_SMOOTHING = 0.2
+
+// Warns about devices whose link quality is consistently below the threshold
+type linkQualityConfig struct {
+	Weak  float64 // LQI, 0-255
+	Alert bool    // also raise an alert when a link becomes weak
+}
+
+type linkQuality struct {
+	cfg linkQualityConfig
+
+	mu    sync.Mutex
+	links map[string]*link // by topic
+}
+
+type link struct {
+	Average float64 // exponential moving average of linkquality
+	Samples int
+	Weak    bool
+}
+
+// Records the link quality, if the device reported one
+func (r *regelwerk) observeLinkQuality(d *device, payload map[string]any) {
+	lq := r.linkQuality
+	if lq == nil {
+		return
+	}
+
+	v, ok := payload["linkquality"].(float64)
+	if !ok {
+		return
+	}
+
+	lq.mu.Lock()
+	defer lq.mu.Unlock()
+
+	l := lq.links[d.topic]
+	if l == nil {
+		l = &link{Average: v}
+		lq.links[d.topic] = l
+	}
+	l.Average += LQI_SMOOTHING * (v - l.Average)
+	l.Samples++
+
+	weak := l.Samples >= LQI_MIN_SAMPLES && l.Average < lq.cfg.Weak
+	if weak == l.Weak {
+		return
+	}
+	l.Weak = weak
+
+	if !weak {
+		log.Printf("link of %q recovered, linkquality averaging %.0f", d.topic, l.Average)
+	} else if lq.cfg.Alert {
+		r.alert("weak link to %q, linkquality averaging %.0f", d.topic, l.Average)
+	} else {
+		log.Printf("weak link to %q, linkquality averaging %.0f", d.topic, l.Average)
+	}
+}
+
+// Returns the averaged link quality per device, and the weak ones
+func (r *regelwerk) LinkQualityReport() map[string]any {
+	lq := r.linkQuality
+	lq.mu.Lock()
+	defer lq.mu.Unlock()
+
+	avg := make(map[string]float64, len(lq.links))
+	weak := []string{}
+	for topic, l := range lq.links {
+		avg[topic] = math.Round(l.Average)
+		if l.Weak {
+			weak = append(weak, topic)
+		}
+	}
+	sort.Strings(weak)
+	return map[string]any{"Average": avg, "Weak": weak}
+}
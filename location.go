@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"regelwerk/timers"
+)
+
+const NOMINATIM_URL = "https://nominatim.openstreetmap.org/search?format=json&limit=1&q="
+
+// Location in the config, either as [lat, long] or a place name like
+// "Fresno, CA", which is geocoded at startup
+type location struct {
+	Lat, Lng float64
+	Place    string
+	set      bool
+}
+
+func (l *location) UnmarshalJSON(b []byte) error {
+	if bytes.Equal(b, []byte("null")) {
+		return nil
+	}
+
+	var place string
+	if err := json.Unmarshal(b, &place); err == nil {
+		if place == "" {
+			return errors.New("empty place name")
+		}
+		*l = location{Place: place, set: true}
+		return nil
+	}
+
+	var coords [2]float64
+	if err := json.Unmarshal(b, &coords); err != nil {
+		return errors.New("expected [lat, long] or a place name")
+	}
+	*l = location{Lat: coords[0], Lng: coords[1], set: true}
+	return nil
+}
+
+// Checks the coordinates, if any. (0, 0) is rejected as it's more likely an
+// unfilled template than a spot in the Atlantic.
+func (l location) Validate() error {
+	if !l.set || l.Place != "" {
+		return nil
+	}
+
+	if l.Lat < -90 || l.Lat > 90 {
+		return fmt.Errorf("latitude %v out of range, needs to be within ±90", l.Lat)
+	} else if l.Lng < -180 || l.Lng > 180 {
+		return fmt.Errorf("longitude %v out of range, needs to be within ±180", l.Lng)
+	} else if l.Lat == 0 && l.Lng == 0 {
+		return errors.New("[0, 0] is not a real location, leave it out if unknown")
+	}
+	return nil
+}
+
+// Returns the coordinates, geocoding the place name if needed. Results are
+// cached in cacheFile, if set, so that the geocoder is only asked once.
+func (l location) Resolve(ctx context.Context, cacheFile string) (lat, lng float64, err error) {
+	if l.Place == "" {
+		return l.Lat, l.Lng, nil
+	}
+
+	cache := make(map[string][2]float64)
+	if cacheFile != "" {
+		if js, err := os.ReadFile(cacheFile); err == nil {
+			json.Unmarshal(js, &cache)
+		}
+	}
+	if c, ok := cache[l.Place]; ok {
+		return c[0], c[1], nil
+	}
+
+	lat, lng, err = geocode(ctx, l.Place)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to geocode %q: %v", l.Place, err)
+	}
+	log.Printf("location %q is at %.4f, %.4f", l.Place, lat, lng)
+
+	if cacheFile != "" {
+		cache[l.Place] = [2]float64{lat, lng}
+		js, _ := json.MarshalIndent(cache, "", "\t")
+		if err := timers.WriteFileAtomic(cacheFile, js); err != nil {
+			log.Printf("unable to cache location: %v", err)
+		}
+	}
+	return lat, lng, nil
+}
+
+// Looks up the place with OpenStreetMap's Nominatim
+func geocode(ctx context.Context, place string) (lat, lng float64, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, NOMINATIM_URL+url.QueryEscape(place), nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	// required by their usage policy
+	req.Header.Set("User-Agent", "regelwerk github.com/geekman/regelwerk")
+
+	c := http.Client{Timeout: 30 * time.Second}
+	resp, err := c.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, 0, fmt.Errorf("server returned %s", resp.Status)
+	}
+
+	var results []struct {
+		Lat, Lon string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return 0, 0, err
+	} else if len(results) == 0 {
+		return 0, 0, errors.New("no such place")
+	}
+
+	if lat, err = strconv.ParseFloat(results[0].Lat, 64); err != nil {
+		return 0, 0, err
+	}
+	if lng, err = strconv.ParseFloat(results[0].Lon, 64); err != nil {
+		return 0, 0, err
+	}
+	if err := (location{Lat: lat, Lng: lng, set: true}).Validate(); err != nil {
+		return 0, 0, err
+	}
+	return lat, lng, nil
+}
+
+// Returns the file geocoded locations are cached in, next to the -state file
+func geocodeCacheFile(stateFile string) string {
+	if stateFile == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(stateFile), "geocode.json")
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestLocation(t *testing.T) {
+	tests := []struct {
+		js    string
+		want  location
+		valid bool
+	}{
+		{`[36.7782, -119.4179]`, location{Lat: 36.7782, Lng: -119.4179, set: true}, true},
+		{`"Fresno, CA"`, location{Place: "Fresno, CA", set: true}, true},
+		{`null`, location{}, true},
+		{`[0, 0]`, location{set: true}, false},
+		{`[0, 32.5]`, location{Lng: 32.5, set: true}, true},
+		{`[91, 0]`, location{Lat: 91, set: true}, false},
+		{`[45, -181]`, location{Lat: 45, Lng: -181, set: true}, false},
+	}
+
+	for _, tt := range tests {
+		var l location
+		if err := json.Unmarshal([]byte(tt.js), &l); err != nil {
+			t.Errorf("%s: %v", tt.js, err)
+			continue
+		}
+		if l != tt.want {
+			t.Errorf("%s: got %+v, wanted %+v", tt.js, l, tt.want)
+		}
+		if err := l.Validate(); (err == nil) != tt.valid {
+			t.Errorf("%s: valid is %v, wanted %v (%v)", tt.js, err == nil, tt.valid, err)
+		}
+	}
+
+	var l location
+	if err := json.Unmarshal([]byte(`{"lat": 1}`), &l); err == nil {
+		t.Errorf("object accepted as location")
+	}
+}
@@ -1,88 +1,125 @@
 package main
 
 import (
+	"context"
 	"log"
+	"time"
+
+	"regelwerk/mqttio"
 )
 
-func (r *regelwerk) setSwitchState(state string) {
-	r.LookupDevice("switch").SendNewState(r.client, state)
+func (r *regelwerk) setSwitchState(ctx context.Context, state string) {
+	a := r.LookupDevice("switch").NewState(state)
+	a.source = SOURCE_SESSION
+	if m, ok := a.payload.(map[string]any); ok && state == "ON" {
+		now := r.clock.Now()
+		r.addBrightness(m, now)
+
+		// motion during quiet hours only gets the night-light
+		if r.sessions.State("motion") == SESSION_ACTIVE && r.quietHours.Active(now) {
+			m["brightness"] = r.quietHours.Brightness
+		}
+	}
+	r.Do(ctx, a)
+}
+
+// Whether the switch is on, or has been told to turn on
+func (r *regelwerk) switchIsOn() bool {
+	return r.confirm.Expected(r.LookupDevice("switch")) == "ON"
 }
 
-func (r *regelwerk) handleDeviceEvent(d *device, payload map[string]any) {
+func (r *regelwerk) handleDeviceEvent(ctx context.Context, d *device, payload map[string]any) {
 	switch d.id {
 	case "switch":
-		action := getMapValue(payload, "action")
+		action := mqttio.GetMapValue(payload, "action")
 
-		if action == "single_right" {
-			if *debugMode {
-				log.Printf("switch actuated: %v", action)
-			}
+		if action != "" {
+			debugf(LOG_RULES, "switch actuated: %v", action)
+			r.arbiter.Claim(d, SOURCE_MANUAL, r.clock.Now())
 
-			if r.DestroyTimer("contact") || r.DestroyTimer("motion") {
-				log.Printf("manual override - discarding current session")
+			r.handleOverride(action)
+			if mqttio.GetMapValue(payload, d.stateAttr) == "OFF" {
+				r.startManualOffGrace()
 			}
 		}
 	}
 }
 
-func (r *regelwerk) handleDeviceChangedEvent(d *device, payload map[string]any) {
-	switch d.id {
-	case "contact":
-		if d.state != true { // door opened
-			// either stop the timer, or we add a timer if we should turn on
-			if r.StopTimer("contact") != nil {
-				log.Printf("paused session for triggered sensor")
-			} else if t2 := r.StopTimer("motion"); t2 != nil ||
-				(r.LookupDevice("switch").state != "ON" && r.NowIsDusk()) {
-
-				if t2 != nil {
-					log.Printf("converting motion->contact session")
-					r.DestroyTimer("motion")
-				} else {
-					log.Printf("starting session for triggered sensor")
-				}
+// Whether a new session for the sensor should turn on the lights
+func (r *regelwerk) shouldStartSession(ctx context.Context, d *device) bool {
+	return !r.switchIsOn() && r.NowIsDusk(d) && r.SomeoneHome() &&
+		r.checkCondition(ctx, r.sessionIf)
+}
 
-				r.AddTimer("contact")
+func (r *regelwerk) handleDeviceChangedEvent(ctx context.Context, d *device, payload map[string]any) {
+	k := r.sessionKind(d.id)
+	if k == nil || !r.AutomationActive() || !r.ruleFires(k.name) {
+		return
+	}
 
-				// send turn on
-				go r.setSwitchState("ON")
-			}
-		} else {
-			// door closed, start countdown timer if any
-			if r.StartTimer("contact", r.offDelay) {
-				log.Printf("starting delayed turn-off after %s", r.offDelay)
+	now := r.clock.Now()
+	if d.state == k.on {
+		// either pause the countdown, or start a session if we should turn on
+		if r.pauseSession(k.name) {
+			log.Printf("paused session for triggered sensor")
+			if k.retriggered != nil {
+				k.retriggered(now)
 			}
+		} else if from := r.takeOverSession(k); from != "" {
+			log.Printf("converting %s->%s session", from, k.name)
+			r.startSession(ctx, k.name, d, k.expiry)
+		} else if k.suppressed != nil && k.suppressed(now) {
+			debugf(LOG_RULES, "ignoring %s of %q", k.name, d.topic)
+		} else if r.shouldStartSession(withTrigger(ctx, payload), d) {
+			log.Printf("starting session for triggered sensor %q", d.topic)
+			r.startSession(ctx, k.name, d, k.expiry)
 		}
-
-	case "motion":
-		if d.state == true { // motion detected
-			if r.StopTimer("motion") != nil {
-				log.Printf("paused session for triggered sensor")
-			} else if r.LookupDevice("switch").state != "ON" && r.NowIsDusk() {
-				log.Printf("starting session for triggered sensor")
-				r.AddTimerWithExpiry("motion", r.motionExpiry)
-
-				go r.setSwitchState("ON")
-			}
-		} else {
-			// no more motion, start countdown timer if any
-			if r.StartTimer("motion", r.motionOffDelay) {
-				log.Printf("starting delayed turn-off after %s", r.motionOffDelay)
-			}
+	} else if d.state == k.off {
+		// all sensors clear, start countdown timer if any
+		delay := k.offDelay(now)
+		if r.allClear(k.name, k.off) && r.countdownSession(k.name, delay) {
+			log.Printf("starting delayed turn-off after %s", delay)
 		}
 	}
 }
 
-func (r *regelwerk) handleTimer(name string, expired bool) {
-	switch name {
-	case "contact", "motion":
-		// turn off lights after timeout/expiry
-		r.setSwitchState("OFF")
+// Defines the sessions of the contact and motion sensors. A door opening
+// during a motion session takes it over, as the door is more reliable at
+// telling that someone left.
+func (r *regelwerk) defineSessionKinds() {
+	r.sessionKinds = []*sessionKind{
+		{
+			name: "contact",
+			on:   false, off: true, // door opened, closed
+			expiry:    r.contactExpiry,
+			offDelay:  func(time.Time) time.Duration { return r.offDelay },
+			takesOver: []string{"motion"},
+			onExpiry:  r.sessionExpiry["contact"],
+		},
+		{
+			name: "motion",
+			on:   true, off: false,
+			expiry: r.motionExpiry,
+			offDelay: func(now time.Time) time.Duration {
+				return r.adaptive.Delay(r.motionOffDelay, now)
+			},
+			retriggered: r.adaptive.Retrigger,
+			onExpiry:    r.sessionExpiry["motion"],
 
-		// in case of a stuck sensor, reset occupancy to false to have it
-		// re-trigger immediately when next reporting
-		if name == "motion" && expired {
-			r.LookupDevice("motion").state = false
-		}
+			// motion doesn't turn on the lights during quiet hours, unless
+			// there's a night-light brightness
+			suppressed: func(now time.Time) bool {
+				return r.quietHours.Active(now) && r.quietHours.Brightness == 0
+			},
+		},
 	}
 }
+
+// Registers the callbacks that timers can refer to
+func (r *regelwerk) registerTimerCallbacks() {
+	r.timers.Register("session", r.lockedDevice(r.handleSessionTimer))
+	r.timers.Register("session_warning", r.lockedDevice(r.handleSessionWarningTimer))
+	r.timers.Register("presence", r.lockedDevice(r.handlePresenceTimer))
+	r.timers.Register("override", r.handleOverrideTimer)
+	r.timers.Register("sun_trigger", r.handleSunTriggerTimer)
+}
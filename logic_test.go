@@ -0,0 +1,719 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"regelwerk/rules"
+	"regelwerk/timers"
+)
+
+func testConfig() *config {
+	return &config{
+		OffDelay:       textDuration(time.Hour),
+		MotionOffDelay: textDuration(time.Hour),
+		MotionExpiry:   textDuration(time.Hour),
+		Sensor:         "door",
+		MotionSensor:   "pir",
+		Switch:         "light",
+		JournalSize:    10,
+		Overrides: map[string]overrideConfig{
+			"single_right": {Mode: OVERRIDE_DISCARD},
+		},
+		Scenes: map[string][]sceneStep{
+			"night": {{Device: "light", Payload: map[string]any{"state_right": "OFF"}}},
+		},
+	}
+}
+
+func TestSessionLifecycle(t *testing.T) {
+	r, mc := newTestRegelwerk(t, testConfig())
+	pir := r.deviceByTopic("pir")
+
+	tests := []struct {
+		name   string
+		action func()
+		want   sessionState
+	}{
+		{"start", func() {
+			r.startSession(r.ctx, "motion", pir, time.Hour)
+			pir.state = true
+		}, SESSION_ACTIVE},
+		{"no motion", func() { receive(r, "pir", map[string]any{"occupancy": false}) }, SESSION_PENDING_OFF},
+		{"motion again", func() { receive(r, "pir", map[string]any{"occupancy": true}) }, SESSION_ACTIVE},
+		{"door opened", func() { receive(r, "door", map[string]any{"contact": false}) }, SESSION_IDLE},
+	}
+	for _, tt := range tests {
+		tt.action()
+		if st := r.sessions.State("motion"); st != tt.want {
+			t.Errorf("%s: wanted motion session %s, got %s", tt.name, tt.want, st)
+		}
+	}
+
+	// motion was converted into a contact session
+	if st := r.sessions.State("contact"); st != SESSION_ACTIVE {
+		t.Errorf("wanted active contact session, got %s", st)
+	}
+	mc.WaitFor(t, "zigbee2mqtt/light/set", 2)
+
+	// manual override ends it
+	receive(r, "light", map[string]any{"action": "single_right", "state_right": "ON"})
+	if st := r.sessions.State("contact"); st != SESSION_IDLE {
+		t.Errorf("wanted contact session discarded, got %s", st)
+	}
+}
+
+func TestActivateScene(t *testing.T) {
+	r, mc := newTestRegelwerk(t, testConfig())
+
+	if err := r.ActivateScene(r.ctx, "night"); err != nil {
+		t.Fatal(err)
+	} else if err := r.ActivateScene(r.ctx, "day"); err == nil {
+		t.Errorf("expected error for unknown scene")
+	}
+
+	got := mc.WaitFor(t, "zigbee2mqtt/light/set", 1)
+	if got[0] != `{"state_right":"OFF"}` {
+		t.Errorf("wrong payload %s", got[0])
+	}
+}
+
+func TestContactSession(t *testing.T) {
+	tests := []struct {
+		hour  int
+		start bool
+	}{
+		{21, true},
+		{12, false}, // not dusk
+	}
+
+	for _, tt := range tests {
+		r, mc := newTestRegelwerk(t, testConfig())
+		fc := timers.NewFakeClock(time.Date(2022, 6, 1, tt.hour, 0, 0, 0, time.Local))
+		r.clock, r.timers.Clock = fc, fc
+
+		receive(r, "door", map[string]any{"contact": false})
+		if started := r.sessions.State("contact") == SESSION_ACTIVE; started != tt.start {
+			t.Errorf("%d:00: wanted session started %v", tt.hour, tt.start)
+			continue
+		} else if !started {
+			continue
+		}
+		mc.WaitFor(t, "zigbee2mqtt/light/set", 1)
+
+		receive(r, "door", map[string]any{"contact": true})
+		if st := r.sessions.State("contact"); st != SESSION_PENDING_OFF {
+			t.Errorf("wanted pending_off after door closed, got %s", st)
+		}
+
+		fc.Advance(time.Hour - time.Second)
+		if st := r.sessions.State("contact"); st != SESSION_PENDING_OFF {
+			t.Errorf("session ended before off delay, got %s", st)
+		}
+
+		fc.Advance(time.Second)
+		if st := r.sessions.State("contact"); st != SESSION_IDLE {
+			t.Errorf("wanted session ended after off delay, got %s", st)
+		}
+		if got := mc.WaitFor(t, "zigbee2mqtt/light/set", 2); got[1] != `{"state_right":"OFF"}` {
+			t.Errorf("wanted light turned off, got %s", got[1])
+		}
+	}
+}
+
+func TestSessionGroup(t *testing.T) {
+	cfg := testConfig()
+	cfg.Groups = map[string]groupConfig{"hall": {}}
+	cfg.Devices = map[string]deviceConfig{"door": {Group: "hall"}}
+	if _, err := newRegelwerk(context.Background(), cfg); err == nil {
+		t.Errorf("expected error for a session spanning groups")
+	}
+
+	cfg.Devices["pir"] = deviceConfig{Group: "hall"}
+	cfg.Devices["light"] = deviceConfig{Group: "hall"}
+	newTestRegelwerk(t, cfg)
+}
+
+func TestSunTrigger(t *testing.T) {
+	cfg := testConfig()
+	cfg.Actions = map[string]*actionSpec{
+		"night": {Type: "activate_scene", Scene: "night"},
+	}
+	cfg.SunTriggers = []sunTriggerConfig{
+		{Type: TRIGGER_AT_DUSK, Offset: offsetDuration(-15 * time.Minute), Action: "night"},
+		{Type: TRIGGER_AT_DAWN, Action: "night"}, // already past
+	}
+	r, mc := newTestRegelwerk(t, cfg)
+
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.Local)
+	fc := timers.NewFakeClock(now)
+	r.clock, r.timers.Clock = fc, fc
+
+	sunrise, sunset := r.sun.Today(now)
+	r.planSunTriggers(now, sunrise, sunset)
+
+	fc.Advance(6*time.Hour + 44*time.Minute) // 18:44, before 19:00 - 15m
+	if got := mc.Payloads("zigbee2mqtt/light/set"); len(got) != 0 {
+		t.Errorf("trigger ran early: %v", got)
+	}
+
+	fc.Advance(time.Minute)
+	mc.WaitFor(t, "zigbee2mqtt/light/set", 1)
+
+	fc.Advance(24 * time.Hour)
+	if got := mc.Payloads("zigbee2mqtt/light/set"); len(got) != 1 {
+		t.Errorf("expected a single run, got %v", got)
+	}
+}
+
+func TestOffWarning(t *testing.T) {
+	cfg := testConfig()
+	cfg.Actions = map[string]*actionSpec{
+		"dim": {Type: "publish", Topic: "test/warning", Payload: "dim"},
+	}
+	cfg.OffWarning = &offWarningConfig{Before: textDuration(10 * time.Minute), Action: "dim"}
+	r, mc := newTestRegelwerk(t, cfg)
+
+	fc := timers.NewFakeClock(time.Date(2022, 6, 1, 21, 0, 0, 0, time.Local))
+	r.clock, r.timers.Clock = fc, fc
+
+	receive(r, "door", map[string]any{"contact": false})
+	receive(r, "door", map[string]any{"contact": true})
+
+	fc.Advance(50*time.Minute - time.Second)
+	if got := mc.Payloads("test/warning"); len(got) != 0 {
+		t.Errorf("warned early: %v", got)
+	}
+	fc.Advance(time.Second)
+	mc.WaitFor(t, "test/warning", 1)
+
+	// re-triggering cancels the next warning
+	receive(r, "door", map[string]any{"contact": false})
+	receive(r, "door", map[string]any{"contact": true})
+	receive(r, "door", map[string]any{"contact": false})
+	fc.Advance(2 * time.Hour)
+	if got := mc.Payloads("test/warning"); len(got) != 1 {
+		t.Errorf("wanted no warning while door is open, got %v", got)
+	}
+}
+
+func TestManualOffGrace(t *testing.T) {
+	cfg := testConfig()
+	cfg.ManualOffGrace = textDuration(time.Minute)
+	r, _ := newTestRegelwerk(t, cfg)
+
+	fc := timers.NewFakeClock(time.Date(2022, 6, 1, 21, 0, 0, 0, time.Local))
+	r.clock, r.timers.Clock = fc, fc
+
+	receive(r, "light", map[string]any{"action": "single_right", "state_right": "OFF"})
+	receive(r, "door", map[string]any{"contact": false})
+	if st := r.sessions.State("contact"); st != SESSION_IDLE {
+		t.Errorf("session started within grace period: %s", st)
+	}
+
+	fc.Advance(time.Minute)
+	receive(r, "door", map[string]any{"contact": true})
+	receive(r, "door", map[string]any{"contact": false})
+	if st := r.sessions.State("contact"); st != SESSION_ACTIVE {
+		t.Errorf("wanted session after grace period, got %s", st)
+	}
+}
+
+func TestZ2MGroup(t *testing.T) {
+	cfg := testConfig()
+	cfg.Z2MGroups = map[string]z2mGroupConfig{
+		"lamps": {Members: []string{"lamp_1", "lamp_2"}},
+	}
+	r, mc := newTestRegelwerk(t, cfg)
+	zg := r.z2mGroups[0]
+
+	if st := zg.membersState(); st != nil {
+		t.Errorf("wanted unknown state before reports, got %v", st)
+	}
+
+	receive(r, "lamp_1", map[string]any{"state": "OFF"})
+	receive(r, "lamp_2", map[string]any{"state": "ON"})
+	if st := zg.membersState(); st != "ON" {
+		t.Errorf("wanted group on with a member on, got %v", st)
+	}
+
+	receive(r, "lamp_2", map[string]any{"state": "OFF"})
+	if st := zg.membersState(); st != "OFF" {
+		t.Errorf("wanted group off with all members off, got %v", st)
+	}
+	got := mc.WaitFor(t, "regelwerk/z2mgroup/lamps", 3)
+	if got[2] != `{"members":{"lamp_1":"OFF","lamp_2":"OFF"},"state":"OFF"}` {
+		t.Errorf("wrong group report %s", got[2])
+	}
+}
+
+func TestSyncWindow(t *testing.T) {
+	cfg := testConfig()
+	cfg.SyncWindow = textDuration(time.Minute)
+	r, _ := newTestRegelwerk(t, cfg)
+
+	fc := timers.NewFakeClock(time.Date(2022, 6, 1, 21, 0, 0, 0, time.Local))
+	r.clock, r.timers.Clock = fc, fc
+
+	r.startSyncWindow()
+	receive(r, "door", map[string]any{"contact": false})
+	if st := r.sessions.State("contact"); st != SESSION_IDLE {
+		t.Errorf("session started by synced state: %s", st)
+	} else if d := r.deviceByTopic("door"); d.state != false {
+		t.Errorf("state not synced, got %v", d.state)
+	}
+
+	r.syncUntil.Store(0)
+	receive(r, "door", map[string]any{"contact": true})
+	receive(r, "door", map[string]any{"contact": false})
+	if st := r.sessions.State("contact"); st != SESSION_ACTIVE {
+		t.Errorf("wanted session after sync window, got %s", st)
+	}
+}
+
+func TestStateQueryReply(t *testing.T) {
+	cfg := testConfig()
+	cfg.Actions = map[string]*actionSpec{
+		"notify": {Type: "publish", Topic: "test/notify", Payload: "pressed"},
+	}
+	cfg.Buttons = map[string]map[string]actionList{
+		"light": {"single": {"notify"}},
+	}
+	r, mc := newTestRegelwerk(t, cfg)
+
+	// a press arriving as the first message after the query is still a press
+	sw := r.deviceByTopic("light")
+	sw.RequestState(mc, r.clock.Now())
+	receive(r, "light", map[string]any{"state_right": "ON", "action": "single"})
+	mc.WaitFor(t, "test/notify", 1)
+	if sw.state != "ON" || sw.settledState != "ON" {
+		t.Errorf("initial state not taken on, got %v", sw.state)
+	} else if !sw.syncUntil.IsZero() {
+		t.Errorf("still waiting for the reply")
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	cfg := testConfig()
+	cfg.Sensors = []string{"0x0002"} // by IEEE address
+	r, mc := newTestRegelwerk(t, cfg)
+
+	r.updateRegistry([]byte(`[
+		{"ieee_address": "0x0001", "friendly_name": "door"},
+		{"ieee_address": "0x0002", "friendly_name": "back_door"},
+		{"ieee_address": "0x0003", "friendly_name": "light"}
+	]`))
+	if d := r.matchDevice("back_door"); d == nil || d.topic != "0x0002" {
+		t.Errorf("device configured by IEEE address not found by name, got %v", d)
+	}
+
+	// renamed in zigbee2mqtt
+	r.updateRegistry([]byte(`[
+		{"ieee_address": "0x0001", "friendly_name": "front_door"},
+		{"ieee_address": "0x0003", "friendly_name": "hall_light"}
+	]`))
+	if d := r.matchDevice("front_door"); d == nil || d.topic != "door" {
+		t.Errorf("renamed device not found, got %v", d)
+	}
+
+	r.Do(r.ctx, r.LookupDevice("switch").NewState("ON"))
+	mc.WaitFor(t, "zigbee2mqtt/hall_light/set", 1)
+}
+
+func TestCovers(t *testing.T) {
+	cfg := testConfig()
+	cfg.Location = location{Lat: 51.5074, Lng: -0.1278, set: true}
+	cfg.Covers = map[string]coverConfig{
+		"blind": {Azimuth: &[2]float64{135, 225}, MinElevation: 10, Position: 20},
+	}
+	r, mc := newTestRegelwerk(t, cfg)
+
+	bst := time.FixedZone("BST", 3600)
+	r.updateCovers(r.ctx, time.Date(2022, 6, 21, 9, 0, 0, 0, bst))
+	if got := mc.Payloads("zigbee2mqtt/blind/set"); len(got) != 0 {
+		t.Errorf("cover moved while sun is east: %v", got)
+	}
+
+	r.updateCovers(r.ctx, time.Date(2022, 6, 21, 13, 0, 0, 0, bst))
+	r.updateCovers(r.ctx, time.Date(2022, 6, 21, 13, 5, 0, 0, bst))
+	got := mc.WaitFor(t, "zigbee2mqtt/blind/set", 1)
+	if got[0] != `{"position":20}` {
+		t.Errorf("wanted cover closed, got %s", got[0])
+	}
+
+	r.updateCovers(r.ctx, time.Date(2022, 6, 21, 17, 0, 0, 0, bst))
+	if got := mc.WaitFor(t, "zigbee2mqtt/blind/set", 2); got[1] != `{"state":"OPEN"}` {
+		t.Errorf("wanted cover opened, got %s", got[1])
+	}
+
+	receive(r, "blind", map[string]any{"position": 100})
+	if st := r.CoverReport()["blind"]; st.Position != 100.0 || st.Shaded {
+		t.Errorf("wrong cover state %+v", st)
+	}
+
+	pos := 50.0
+	if err := r.Run(r.ctx, &actionSpec{Type: "cover", Device: "blind", Position: &pos}); err != nil {
+		t.Fatal(err)
+	}
+	if got := mc.WaitFor(t, "zigbee2mqtt/blind/set", 3); got[2] != `{"position":50}` {
+		t.Errorf("wrong cover action %s", got[2])
+	}
+}
+
+func TestThermostat(t *testing.T) {
+	cfg := testConfig()
+	cfg.Thermostats = map[string]thermostatConfig{
+		"trv": {
+			Setpoint: 17, Hysteresis: 0.5,
+			Schedule:    []setpointPeriod{{From: 6 * 60, To: 8 * 60, Setpoint: 20}},
+			Windows:     []string{"window"},
+			WindowDelay: textDuration(time.Minute),
+		},
+	}
+	r, mc := newTestRegelwerk(t, cfg)
+
+	fc := timers.NewFakeClock(time.Date(2022, 1, 10, 7, 0, 0, 0, time.Local))
+	r.clock, r.timers.Clock = fc, fc
+
+	receive(r, "trv", map[string]any{"local_temperature": 19.6})
+	if got := mc.WaitFor(t, "zigbee2mqtt/trv/set", 1); got[0] != `{"current_heating_setpoint":20}` {
+		t.Errorf("wanted scheduled setpoint, got %s", got[0])
+	}
+	if th := r.ThermostatReport()["trv"]; th.Heating {
+		t.Errorf("heating within hysteresis")
+	}
+
+	receive(r, "trv", map[string]any{"local_temperature": 19.5})
+	if th := r.ThermostatReport()["trv"]; !th.Heating {
+		t.Errorf("wanted heating below the setpoint")
+	}
+
+	// brief airing doesn't drop the setpoint
+	receive(r, "window", map[string]any{"contact": false})
+	receive(r, "window", map[string]any{"contact": true})
+	receive(r, "window", map[string]any{"contact": false})
+	if got := mc.Payloads("zigbee2mqtt/trv/set"); len(got) != 1 {
+		t.Errorf("setpoint changed before WindowDelay: %v", got)
+	}
+	fc.Advance(time.Minute)
+	receive(r, "trv", map[string]any{"local_temperature": 19.0})
+	if got := mc.WaitFor(t, "zigbee2mqtt/trv/set", 2); got[1] != `{"current_heating_setpoint":5}` {
+		t.Errorf("wanted setpoint dropped with window open, got %s", got[1])
+	}
+
+	fc.Advance(time.Hour)
+	receive(r, "window", map[string]any{"contact": true})
+	if got := mc.WaitFor(t, "zigbee2mqtt/trv/set", 3); got[2] != `{"current_heating_setpoint":17}` {
+		t.Errorf("wanted default setpoint after schedule, got %s", got[2])
+	}
+}
+
+func TestHumidityFan(t *testing.T) {
+	cfg := testConfig()
+	cfg.Fans = map[string]fanConfig{
+		"fan": {Sensor: "bathroom", Rise: 10, MinRun: textDuration(10 * time.Minute)},
+	}
+	r, mc := newTestRegelwerk(t, cfg)
+
+	fc := timers.NewFakeClock(time.Date(2022, 1, 10, 7, 0, 0, 0, time.Local))
+	r.clock, r.timers.Clock = fc, fc
+
+	for _, h := range []float64{50, 54, 52} {
+		receive(r, "bathroom", map[string]any{"humidity": h})
+		fc.Advance(5 * time.Minute)
+	}
+	if got := mc.Payloads("zigbee2mqtt/fan/set"); len(got) != 0 {
+		t.Errorf("fan turned on within baseline: %v", got)
+	}
+
+	receive(r, "bathroom", map[string]any{"humidity": 75.0})
+	mc.WaitFor(t, "zigbee2mqtt/fan/set", 1)
+
+	// recovered, but not run long enough
+	fc.Advance(5 * time.Minute)
+	receive(r, "bathroom", map[string]any{"humidity": 55.0})
+	if got := mc.Payloads("zigbee2mqtt/fan/set"); len(got) != 1 {
+		t.Errorf("fan turned off before MinRun: %v", got)
+	}
+
+	fc.Advance(5 * time.Minute)
+	if got := mc.WaitFor(t, "zigbee2mqtt/fan/set", 2); got[1] != `{"state":"OFF"}` {
+		t.Errorf("wanted fan off after MinRun, got %s", got[1])
+	}
+}
+
+func TestStatsCondition(t *testing.T) {
+	cfg := testConfig()
+	cfg.Stats = map[string]statConfig{
+		"humidity": {Device: "bathroom", Attr: "humidity"},
+	}
+	r, _ := newTestRegelwerk(t, cfg)
+
+	held := func(cond string) bool {
+		e, err := rules.Parse(cond)
+		if err != nil {
+			t.Fatalf("%s: %v", cond, err)
+		}
+		return r.checkCondition(r.ctx, e)
+	}
+	if held("stats.humidity.mean > 0") {
+		t.Errorf("condition on stat without samples held")
+	}
+
+	for _, h := range []float64{50, 60, 55} {
+		receive(r, "bathroom", map[string]any{"humidity": h})
+	}
+	if !held("stats.humidity.mean == 55 && stats.humidity.max == 60 && stats.humidity.count == 3") {
+		t.Errorf("wrong stats %+v", r.stats.Report(time.Now()))
+	}
+}
+
+func TestAlarm(t *testing.T) {
+	cfg := testConfig()
+	cfg.Actions = map[string]*actionSpec{
+		"siren": {Type: "publish", Topic: "test/siren", Payload: "ON"},
+	}
+	cfg.Alarm = &alarmConfig{
+		ExitDelay:  textDuration(time.Minute),
+		EntryDelay: textDuration(30 * time.Second),
+		Action:     "siren",
+	}
+	r, mc := newTestRegelwerk(t, cfg)
+
+	fc := timers.NewFakeClock(time.Date(2022, 6, 1, 12, 0, 0, 0, time.Local))
+	r.clock, r.timers.Clock = fc, fc
+
+	if err := r.Run(r.ctx, &actionSpec{Type: "alarm", Command: "arm"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// leaving within the exit delay
+	receive(r, "door", map[string]any{"contact": false})
+	fc.Advance(time.Minute)
+	if st := r.AlarmState(); st != ALARM_ARMED {
+		t.Errorf("wanted armed after exit delay, got %s", st)
+	}
+
+	// disarmed in time
+	receive(r, "pir", map[string]any{"occupancy": true})
+	fc.Advance(20 * time.Second)
+	r.SetAlarm(r.ctx, "disarm")
+	fc.Advance(time.Minute)
+	if got := mc.Payloads("test/siren"); len(got) != 0 {
+		t.Errorf("alarm went off after disarming: %v", got)
+	}
+
+	r.SetAlarm(r.ctx, "arm")
+	fc.Advance(time.Minute)
+	receive(r, "door", map[string]any{"contact": true})
+	receive(r, "door", map[string]any{"contact": false})
+	if st := r.AlarmState(); st != ALARM_PENDING {
+		t.Errorf("wanted entry delay, got %s", st)
+	}
+	fc.Advance(30 * time.Second)
+	mc.WaitFor(t, "test/siren", 1)
+	if st := r.AlarmState(); st != ALARM_TRIGGERED {
+		t.Errorf("wanted triggered, got %s", st)
+	}
+}
+
+func TestDoorbell(t *testing.T) {
+	cfg := testConfig()
+	cfg.Actions = map[string]*actionSpec{
+		"flash":  {Type: "publish", Topic: "test/flash", Payload: "ON", Debounce: textDuration(time.Minute)},
+		"notify": {Type: "publish", Topic: "test/notify", Payload: "ring"},
+	}
+	cfg.Buttons = map[string]map[string]actionList{
+		"doorbell": {"single": {"flash", "notify"}},
+	}
+	r, mc := newTestRegelwerk(t, cfg)
+
+	fc := timers.NewFakeClock(time.Date(2022, 6, 1, 12, 0, 0, 0, time.Local))
+	r.clock, r.timers.Clock = fc, fc
+
+	receive(r, "doorbell", map[string]any{"action": "single"})
+	receive(r, "doorbell", map[string]any{"action": "single"})
+	mc.WaitFor(t, "test/notify", 2)
+	if got := mc.Payloads("test/flash"); len(got) != 1 {
+		t.Errorf("wanted flash debounced, got %v", got)
+	}
+
+	fc.Advance(time.Minute)
+	receive(r, "doorbell", map[string]any{"action": "single"})
+	mc.WaitFor(t, "test/flash", 2)
+}
+
+func TestGestures(t *testing.T) {
+	cfg := testConfig()
+	cfg.Actions = map[string]*actionSpec{
+		"one":  {Type: "publish", Topic: "test/gesture", Payload: "single"},
+		"two":  {Type: "publish", Topic: "test/gesture", Payload: "double"},
+		"long": {Type: "publish", Topic: "test/gesture", Payload: "{{ .Payload.hold_time }}"},
+	}
+	cfg.Buttons = map[string]map[string]actionList{
+		"remote": {"single": {"one"}, "double": {"two"}, "long": {"long"}},
+	}
+	cfg.Devices = map[string]deviceConfig{"remote": {Gestures: &gestureConfig{}}}
+	r, mc := newTestRegelwerk(t, cfg)
+
+	fc := timers.NewFakeClock(time.Date(2022, 6, 1, 12, 0, 0, 0, time.Local))
+	r.clock, r.timers.Clock = fc, fc
+
+	receive(r, "remote", map[string]any{"action": "single"})
+	fc.Advance(300 * time.Millisecond)
+	receive(r, "remote", map[string]any{"action": "single"})
+	if got := mc.Payloads("test/gesture"); len(got) != 0 {
+		t.Errorf("gesture fired before the window closed: %v", got)
+	}
+	fc.Advance(400 * time.Millisecond)
+
+	receive(r, "remote", map[string]any{"action": "single"})
+	fc.Advance(time.Second)
+
+	receive(r, "remote", map[string]any{"action": "hold"})
+	fc.Advance(time.Second)
+	receive(r, "remote", map[string]any{"action": "hold"})
+	fc.Advance(time.Second)
+	receive(r, "remote", map[string]any{"action": "release"})
+
+	got := mc.WaitFor(t, "test/gesture", 3)
+	if got[0] != "double" || got[1] != "single" || got[2] != "2" {
+		t.Errorf("wrong gestures %v", got)
+	}
+}
+
+func TestMinToggle(t *testing.T) {
+	cfg := testConfig()
+	cfg.Devices = map[string]deviceConfig{"light": {MinToggle: textDuration(time.Minute)}}
+	r, mc := newTestRegelwerk(t, cfg)
+
+	fc := timers.NewFakeClock(time.Date(2022, 6, 1, 12, 0, 0, 0, time.Local))
+	r.clock, r.timers.Clock = fc, fc
+
+	sw := r.LookupDevice("switch")
+	r.Do(r.ctx, sw.NewState("ON"))
+	r.Do(r.ctx, sw.NewState("OFF")) // too soon
+	r.Do(r.ctx, sw.NewState("ON"))  // not switching
+	fc.Advance(time.Minute)
+	r.Do(r.ctx, sw.NewState("OFF"))
+
+	got := mc.WaitFor(t, "zigbee2mqtt/light/set", 3)
+	if got[1] != `{"state_right":"ON"}` || got[2] != `{"state_right":"OFF"}` {
+		t.Errorf("wrong commands sent %v", got)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	cfg := testConfig()
+	cfg.CircuitBreaker = &breakerConfig{MaxPerMinute: 4, CoolDown: textDuration(time.Hour)}
+	r, mc := newTestRegelwerk(t, cfg)
+
+	fc := timers.NewFakeClock(time.Date(2022, 6, 1, 21, 0, 0, 0, time.Local))
+	r.clock, r.timers.Clock = fc, fc
+
+	// a flapping door sensor
+	for i := 0; i < 3; i++ {
+		receive(r, "door", map[string]any{"contact": false})
+		receive(r, "door", map[string]any{"contact": true})
+	}
+	mc.WaitFor(t, "regelwerk/alert", 1)
+	if _, tripped := r.breaker.Tripped(fc.Now())[RULE_CONTACT]; !tripped {
+		t.Errorf("contact rule not tripped")
+	}
+
+	r.discardSession("contact", "test")
+	receive(r, "door", map[string]any{"contact": false})
+	if st := r.sessions.State("contact"); st != SESSION_IDLE {
+		t.Errorf("tripped rule started a session: %s", st)
+	}
+
+	fc.Advance(time.Hour)
+	receive(r, "door", map[string]any{"contact": true})
+	receive(r, "door", map[string]any{"contact": false})
+	if st := r.sessions.State("contact"); st != SESSION_ACTIVE {
+		t.Errorf("wanted session after cool-down, got %s", st)
+	}
+}
+
+func TestLoopDetection(t *testing.T) {
+	cfg := testConfig()
+	cfg.LoopLimit = 2
+	r, mc := newTestRegelwerk(t, cfg)
+	sw := r.LookupDevice("switch")
+
+	// reports not matching our command are someone else's
+	r.Do(r.ctx, sw.NewState("ON"))
+	sw.state = "OFF"
+	if ctx := r.tagEcho(r.ctx, sw); chainDepth(ctx) != 0 {
+		t.Errorf("unrelated change tagged with depth %d", chainDepth(ctx))
+	}
+
+	// a rule turning the light back off and on, as triggered by the light
+	ctx := r.ctx
+	for _, st := range []string{"ON", "OFF", "ON"} {
+		r.Do(ctx, sw.NewState(st))
+		sw.state = st
+		ctx = r.tagEcho(ctx, sw)
+	}
+
+	mc.WaitFor(t, "regelwerk/alert", 1)
+	if got := mc.Payloads("zigbee2mqtt/light/set"); len(got) != 3 {
+		t.Errorf("wanted the third command of the chain dropped, got %v", got)
+	}
+}
+
+func TestActionSource(t *testing.T) {
+	cfg := testConfig()
+	cfg.Actions = map[string]*actionSpec{
+		"on":  {Type: "publish", Device: "light", Payload: map[string]any{"state_right": "ON"}},
+		"off": {Type: "publish", Device: "light", Payload: map[string]any{"state_right": "OFF"}},
+	}
+	cfg.Buttons = map[string]map[string]actionList{
+		"remote": {"single": {"on"}},
+	}
+	cfg.Devices = map[string]deviceConfig{
+		"light": {Policy: POLICY_PRIORITY, Cooldown: textDuration(time.Hour)},
+	}
+	r, mc := newTestRegelwerk(t, cfg)
+
+	receive(r, "remote", map[string]any{"action": "single"})
+	mc.WaitFor(t, "zigbee2mqtt/light/set", 1)
+
+	// run by something else than the remote, so the button press wins
+	if err := r.Run(r.ctx, r.actions["off"]); err != nil {
+		t.Fatal(err)
+	}
+	if got := mc.Payloads("zigbee2mqtt/light/set"); len(got) != 1 {
+		t.Errorf("automation overrode the button: %v", got)
+	}
+}
+
+func TestRateLimitLatestContext(t *testing.T) {
+	cfg := testConfig()
+	cfg.Devices = map[string]deviceConfig{
+		"light": {MinInterval: textDuration(time.Minute)},
+	}
+	r, _ := newTestRegelwerk(t, cfg)
+	fc := timers.NewFakeClock(time.Date(2022, 6, 1, 10, 0, 0, 0, time.Local))
+	r.clock, r.timers.Clock = fc, fc
+
+	var sources []string
+	r.Use(func(next actionFunc) actionFunc {
+		return func(ctx context.Context, a *action) error {
+			sources = append(sources, sourceOf(ctx))
+			return next(ctx, a)
+		}
+	})
+
+	sw := r.LookupDevice("switch")
+	r.Do(r.ctx, sw.NewState("ON"))
+	r.Do(withSource(r.ctx, "first"), sw.NewState("OFF"))
+	r.Do(withSource(r.ctx, "latest"), sw.NewState("ON"))
+	fc.Advance(time.Minute)
+
+	if want := []string{SOURCE_AUTOMATION, "latest"}; !reflect.DeepEqual(sources, want) {
+		t.Errorf("wanted sources %v, got %v", want, sources)
+	}
+}
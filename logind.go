@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const LOGIND_INTERVAL = 30 * time.Second
+
+// Whether the local machine, e.g. a media PC, is in use, from logind's idle
+// hint. It shows up as a device with a true state while active, usable in
+// conditions as devices.<Device>.on, or as a presence input.
+type logindConfig struct {
+	Device   string // name of the device, default logind
+	Interval textDuration
+}
+
+type logind struct {
+	cfg logindConfig
+	dev *device
+
+	active, known bool // only used by Run
+}
+
+// Asks logind whether all sessions are idle, i.e. nobody is using the machine.
+// A variable to be replaced in tests.
+var logindIdleHint = func(ctx context.Context) (bool, error) {
+	out, err := exec.CommandContext(ctx, "loginctl", "show", "--property=IdleHint", "--value").Output()
+	if err != nil {
+		return false, fmt.Errorf("loginctl failed: %v", err)
+	}
+
+	switch hint := strings.TrimSpace(string(out)); hint {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected IdleHint %q", hint)
+	}
+}
+
+// Registers the machine's device
+func (r *regelwerk) addLogind(cfg *logindConfig) {
+	if cfg == nil {
+		return
+	}
+
+	lc := *cfg
+	if lc.Device == "" {
+		lc.Device = "logind"
+	}
+	if lc.Interval <= 0 {
+		lc.Interval = textDuration(LOGIND_INTERVAL)
+	}
+
+	r.logind = &logind{cfg: lc, dev: r.addVirtualDevice(lc.Device)}
+}
+
+// Polls the idle hint every Interval until the context is done
+func (r *regelwerk) runLogind(ctx context.Context) {
+	l := r.logind
+	for {
+		r.updateLogind(ctx)
+		if !r.idle.Sleep(ctx, time.Duration(l.cfg.Interval)) {
+			return
+		}
+	}
+}
+
+// Feeds the machine's activity to its device, on every poll while active so
+// presence doesn't time out while it's being used
+func (r *regelwerk) updateLogind(ctx context.Context) {
+	l := r.logind
+	idle, err := logindIdleHint(ctx)
+	if err != nil {
+		log.Printf("unable to get idle state: %v", err)
+		return
+	}
+
+	active := !idle
+	if l.known && active == l.active && !active {
+		return
+	} else if !l.known || active != l.active {
+		debugf(LOG_DEVICES, "logind %q is now active: %v", l.dev.id, active)
+	}
+	l.active, l.known = active, true
+	putVirtualState(l.dev, active)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLogindDevice(t *testing.T) {
+	idle := false
+	defer func(orig func(context.Context) (bool, error)) { logindIdleHint = orig }(logindIdleHint)
+	logindIdleHint = func(context.Context) (bool, error) { return idle, nil }
+
+	cfg := testConfig()
+	cfg.Logind = &logindConfig{Device: "htpc"}
+	r, _ := newTestRegelwerk(t, cfg)
+	d := r.LookupDevice("htpc")
+
+	// reported on every poll while active, to keep presence
+	inbox := d.group.inbox
+	for i := 0; i < 2; i++ {
+		r.updateLogind(r.ctx)
+		ev, _ := inbox.Get(r.ctx)
+		r.processMessage(r.ctx, ev.dev, ev.msg)
+	}
+	if d.state != true {
+		t.Errorf("active machine not on, got %#v", d.state)
+	}
+
+	idle = true
+	r.updateLogind(r.ctx)
+	ev, _ := inbox.Get(r.ctx)
+	r.processMessage(r.ctx, ev.dev, ev.msg)
+	if d.state != false {
+		t.Errorf("idle machine not off, got %#v", d.state)
+	}
+
+	r.updateLogind(r.ctx)
+	if len(inbox.normal) != 0 {
+		t.Errorf("idle machine reported again")
+	}
+}
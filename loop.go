@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"regelwerk/mqttio"
+)
+
+// how long after a command a matching state report is taken as its echo
+const LOOP_ECHO_WINDOW = 10 * time.Second
+
+// Detects rules triggering each other in a loop, e.g. a rule switching a
+// device that is itself the trigger of another rule switching it back.
+// Commands are correlated with the state reports they cause, and actions
+// caused by such a report are one step further down the chain. Chains longer
+// than the limit are cut, with an alert.
+type loopDetector struct {
+	limit int
+
+	mu   sync.Mutex
+	sent map[*device]sentCommand
+}
+
+type sentCommand struct {
+	want  any
+	depth int
+	at    time.Time
+}
+
+// key for the chain depth of the change being handled, if caused by us
+type loopDepthKey struct{}
+
+func newLoopDetector(limit int) *loopDetector {
+	if limit == 0 {
+		limit = 5
+	}
+	return &loopDetector{limit: limit, sent: make(map[*device]sentCommand)}
+}
+
+func chainDepth(ctx context.Context) int {
+	depth, _ := ctx.Value(loopDepthKey{}).(int)
+	return depth
+}
+
+// Returns a middleware that records commands, and drops those that are too
+// far down a chain of rules triggering each other
+func (r *regelwerk) detectLoops(ld *loopDetector) middleware {
+	return func(next actionFunc) actionFunc {
+		return func(ctx context.Context, a *action) error {
+			depth := chainDepth(ctx) + 1
+			if depth > ld.limit {
+				r.alert("rule loop: %s is %d changes down a chain of our own commands, dropped", a, depth)
+				return fmt.Errorf("rule loop detected")
+			}
+
+			if a.dev != nil && a.dev.stateAttr != "" {
+				m, _ := a.payload.(map[string]any)
+				if want, ok := mqttio.LookupPath(m, a.dev.stateAttr); ok {
+					ld.mu.Lock()
+					ld.sent[a.dev] = sentCommand{a.dev.normalizeState(want), depth, r.clock.Now()}
+					ld.mu.Unlock()
+				}
+			}
+			return next(ctx, a)
+		}
+	}
+}
+
+// Tags the context if the device's state change was caused by one of our
+// commands, for the actions it triggers in turn.
+// Called with the group lock held.
+func (r *regelwerk) tagEcho(ctx context.Context, d *device) context.Context {
+	ld := r.loops
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+
+	sc, found := ld.sent[d]
+	if !found || r.clock.Now().Sub(sc.at) > LOOP_ECHO_WINDOW {
+		delete(ld.sent, d)
+		return ctx
+	} else if d.state != sc.want {
+		return ctx
+	}
+
+	delete(ld.sent, d)
+	debugf(LOG_RULES, "dev %q change to %#v was caused by our command", d.id, d.state)
+	return context.WithValue(ctx, loopDepthKey{}, sc.depth)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Current config schema version. Version 1 (no Version given) has the session
+// settings at the top level, e.g. Sensor, OffDelay & MotionExpiry; version 2
+// groups them by session under Sessions. Older configs are migrated when
+// loaded, and can be converted with -migrate-config.
+const CONFIG_VERSION = 2
+
+// Settings of a session, in version 2 configs
+type sessionConfig struct {
+	Sensors  []string
+	OffDelay textDuration
+	Expiry   textDuration
+	OnExpiry []string // see SessionExpiry
+}
+
+// Top-level keys of version 1 that moved into Sessions
+var v1SessionKeys = []struct{ key, session, field string }{
+	{"Sensor", "contact", "Sensors"},
+	{"Sensors", "contact", "Sensors"},
+	{"OffDelay", "contact", "OffDelay"},
+	{"ContactExpiry", "contact", "Expiry"},
+	{"MotionSensor", "motion", "Sensors"},
+	{"MotionSensors", "motion", "Sensors"},
+	{"MotionOffDelay", "motion", "OffDelay"},
+	{"MotionExpiry", "motion", "Expiry"},
+}
+
+// Removes key from the raw config, matching it case-insensitively like
+// json.Unmarshal does
+func takeKey(raw map[string]any, key string) (any, bool) {
+	for k, v := range raw {
+		if strings.EqualFold(k, key) {
+			delete(raw, k)
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// Converts a raw config, with comments removed, to the current schema version
+func migrateConfig(cfgStr []byte) (map[string]any, error) {
+	var raw map[string]any
+	dec := json.NewDecoder(bytes.NewReader(cfgStr))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	version := 1
+	if v, found := takeKey(raw, "Version"); found {
+		n, ok := v.(json.Number)
+		if !ok {
+			return nil, fmt.Errorf("bad Version %v", v)
+		}
+		i, err := strconv.Atoi(n.String())
+		if err != nil || i < 1 {
+			return nil, fmt.Errorf("bad Version %v", v)
+		} else if i > CONFIG_VERSION {
+			return nil, fmt.Errorf("config Version %d is newer than supported (%d)", i, CONFIG_VERSION)
+		}
+		version = i
+	}
+
+	if version == 1 {
+		if err := migrateV1(raw); err != nil {
+			return nil, err
+		}
+	} else {
+		for _, k := range v1SessionKeys {
+			if _, found := takeKey(raw, k.key); found {
+				return nil, fmt.Errorf("%s is given in Sessions since Version 2", k.key)
+			}
+		}
+		if _, found := takeKey(raw, "SessionExpiry"); found {
+			return nil, fmt.Errorf("SessionExpiry is given in Sessions since Version 2")
+		}
+	}
+
+	raw["Version"] = CONFIG_VERSION
+	return raw, nil
+}
+
+// Moves the session settings into Sessions
+func migrateV1(raw map[string]any) error {
+	if _, found := takeKey(raw, "Sessions"); found {
+		return fmt.Errorf("Sessions needs Version %d", CONFIG_VERSION)
+	}
+
+	sessions := make(map[string]map[string]any)
+	session := func(name string) map[string]any {
+		if sessions[name] == nil {
+			sessions[name] = make(map[string]any)
+		}
+		return sessions[name]
+	}
+
+	for _, k := range v1SessionKeys {
+		v, found := takeKey(raw, k.key)
+		if !found {
+			continue
+		}
+
+		if k.field != "Sensors" {
+			session(k.session)[k.field] = v
+			continue
+		}
+
+		// Sensor & Sensors are merged, in that order
+		sensors, _ := sessions[k.session]["Sensors"].([]any)
+		switch v := v.(type) {
+		case nil:
+		case string:
+			if v != "" {
+				sensors = append([]any{v}, sensors...)
+			}
+		case []any:
+			sensors = append(sensors, v...)
+		default:
+			return fmt.Errorf("bad %s %v", k.key, v)
+		}
+		if len(sensors) > 0 {
+			session(k.session)["Sensors"] = sensors
+		}
+	}
+
+	if v, found := takeKey(raw, "SessionExpiry"); found {
+		expiry, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("bad SessionExpiry %v", v)
+		}
+		for name, modes := range expiry {
+			session(name)["OnExpiry"] = modes
+		}
+	}
+
+	if len(sessions) > 0 {
+		raw["Sessions"] = sessions
+	}
+	return nil
+}
+
+// Fills the session settings from Sessions, which override the defaults
+func (cfg *config) applySessions() error {
+	for name, s := range cfg.Sessions {
+		var sensor *string
+		var sensors *[]string
+		var offDelay, expiry *textDuration
+
+		switch name {
+		case "contact":
+			sensor, sensors = &cfg.Sensor, &cfg.Sensors
+			offDelay, expiry = &cfg.OffDelay, &cfg.ContactExpiry
+		case "motion":
+			sensor, sensors = &cfg.MotionSensor, &cfg.MotionSensors
+			offDelay, expiry = &cfg.MotionOffDelay, &cfg.MotionExpiry
+		default:
+			return fmt.Errorf("unknown session %q in Sessions", name)
+		}
+
+		if len(s.Sensors) > 0 {
+			*sensor, *sensors = s.Sensors[0], s.Sensors[1:]
+		}
+		if s.OffDelay != 0 {
+			*offDelay = s.OffDelay
+		}
+		if s.Expiry != 0 {
+			*expiry = s.Expiry
+		}
+		if s.OnExpiry != nil {
+			if cfg.SessionExpiry == nil {
+				cfg.SessionExpiry = make(map[string][]string)
+			}
+			cfg.SessionExpiry[name] = s.OnExpiry
+		}
+	}
+	return nil
+}
+
+// Writes the config file converted to the current schema version, with its
+// included files merged in. Comments are not preserved.
+func printMigratedConfig(w io.Writer, fname string) error {
+	cfgStr, err := readConfig(fname)
+	if err != nil {
+		return err
+	}
+
+	raw, err := migrateConfig(cfgStr)
+	if err != nil {
+		return err
+	}
+
+	// keep conditions like "a && b" readable
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "\t")
+	return enc.Encode(raw)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMigrateConfig(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "regelwerk.conf")
+	os.WriteFile(fname, []byte(`{
+		"Server": "tcp://localhost:1883",
+		"Sensor": "door",
+		"Sensors": ["back_door"],
+		"offDelay": "30s",
+		"MotionSensor": "pir",
+		"SessionExpiry": { "motion": ["notify"] }
+	}`), 0o644)
+
+	var v1 config
+	if err := parseConfig(fname, &v1); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := printMigratedConfig(&buf, fname); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(fname, buf.Bytes(), 0o644)
+
+	var v2 config
+	if err := parseConfig(fname, &v2); err != nil {
+		t.Fatal(err)
+	}
+	if v2.Version != CONFIG_VERSION || v2.Sessions["contact"].OffDelay != textDuration(30*time.Second) {
+		t.Errorf("not migrated: %s", buf.String())
+	}
+	if v2.Sensor != v1.Sensor || len(v2.Sensors) != 1 || v2.Sensors[0] != "back_door" ||
+		v2.MotionSensor != "pir" || v2.OffDelay != v1.OffDelay || v2.SessionExpiry["motion"][0] != "notify" {
+		t.Errorf("migrated config differs: %+v", v2)
+	}
+
+	os.WriteFile(fname, []byte(`{"Version": 2, "Sensor": "door"}`), 0o644)
+	if err := parseConfig(fname, &v2); err == nil {
+		t.Errorf("expected error for Version 1 key in Version 2")
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Records publishes instead of sending them to a broker
+type mockClient struct {
+	mu        sync.Mutex
+	published []mockPublish
+}
+
+type mockPublish struct {
+	Topic    string
+	Retained bool
+	Payload  string
+}
+
+func (m *mockClient) Publish(topic string, qos byte, retained bool, payload any) mqtt.Token {
+	var p string
+	switch v := payload.(type) {
+	case string:
+		p = v
+	case []byte:
+		p = string(v)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.published = append(m.published, mockPublish{topic, retained, p})
+	return &mockToken{}
+}
+
+func (m *mockClient) IsConnectionOpen() bool { return true }
+
+// Returns the payloads published to the topic so far
+func (m *mockClient) Payloads(topic string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var payloads []string
+	for _, p := range m.published {
+		if p.Topic == topic {
+			payloads = append(payloads, p.Payload)
+		}
+	}
+	return payloads
+}
+
+// Waits for n payloads on the topic, as some actions are sent asynchronously
+func (m *mockClient) WaitFor(t *testing.T, topic string, n int) []string {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		payloads := m.Payloads(topic)
+		if len(payloads) >= n {
+			return payloads
+		} else if time.Now().After(deadline) {
+			t.Fatalf("wanted %d publishes to %q, got %v", n, topic, payloads)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+type mockToken struct{}
+
+func (*mockToken) Wait() bool                     { return true }
+func (*mockToken) WaitTimeout(time.Duration) bool { return true }
+func (*mockToken) Done() <-chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}
+func (*mockToken) Error() error { return nil }
+
+// Sets up an instance from the config, connected to a mock client
+func newTestRegelwerk(t testing.TB, cfg *config) (*regelwerk, *mockClient) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	if cfg.QueueSize == 0 {
+		cfg.QueueSize = 16
+	}
+	if cfg.Overrides == nil {
+		cfg.Overrides = map[string]overrideConfig{}
+	}
+
+	r, err := newRegelwerk(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mc := &mockClient{}
+	r.client = mc
+	return r, mc
+}
+
+// Feeds a message from the device through the normal processing
+func receive(r *regelwerk, topic string, payload map[string]any) {
+	js, _ := json.Marshal(payload)
+	r.processMessage(r.ctx, r.deviceByTopic(topic), &virtualMessage{topic: MQTT_TOPIC_PREFIX + topic, payload: js})
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Modbus TCP defaults & function codes
+const (
+	MODBUS_PORT     = "502"
+	MODBUS_TIMEOUT  = 3 * time.Second
+	MODBUS_INTERVAL = 10 * time.Second
+
+	MODBUS_READ_COILS     = 0x01
+	MODBUS_READ_REGISTERS = 0x03
+	MODBUS_WRITE_COIL     = 0x05
+	MODBUS_WRITE_REGISTER = 0x06
+	MODBUS_EXCEPTION_FLAG = 0x80
+	MODBUS_COIL_ON        = 0xff00
+	MODBUS_MAX_RESPONSE   = 260
+)
+
+// A coil or holding register of a Modbus TCP device, polled to be used in
+// conditions as modbus.<name>
+type modbusInput struct {
+	Address  string  // host, or host:port
+	Unit     byte    // unit identifier, for devices behind a gateway
+	Coil     *uint16 // either a coil
+	Register *uint16 // or a holding register
+	Interval textDuration
+}
+
+func modbusAddress(address string) string {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return net.JoinHostPort(address, MODBUS_PORT)
+	}
+	return address
+}
+
+func validateModbus(inputs map[string]modbusInput) error {
+	for name, in := range inputs {
+		if err := validateModbusTarget(in.Address, in.Coil, in.Register); err != nil {
+			return fmt.Errorf("Modbus input %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Checks the device address, and that exactly one of coil & register is set
+func validateModbusTarget(address string, coil, register *uint16) error {
+	if address == "" {
+		return errors.New("needs an Address")
+	} else if (coil == nil) == (register == nil) {
+		return errors.New("needs either a Coil or a Register")
+	}
+
+	if _, port, err := net.SplitHostPort(address); err == nil {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("bad port in Address %q", address)
+		}
+	}
+	return nil
+}
+
+// Sends a request to a Modbus TCP device, returning the response's data.
+// A connection is opened for each, as devices often only allow one.
+func modbusRequest(ctx context.Context, address string, unit, fn byte, data []byte) ([]byte, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", modbusAddress(address))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(MODBUS_TIMEOUT)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	conn.SetDeadline(deadline)
+
+	// MBAP header with transaction 1 & protocol 0, then the PDU
+	req := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint16(req[0:], 1)
+	binary.BigEndian.PutUint16(req[4:], uint16(2+len(data)))
+	req[6], req[7] = unit, fn
+	if _, err := conn.Write(append(req, data...)); err != nil {
+		return nil, err
+	}
+
+	hdr := make([]byte, 8)
+	if _, err := io.ReadFull(conn, hdr); err != nil {
+		return nil, err
+	}
+	n := int(binary.BigEndian.Uint16(hdr[4:]))
+	if n < 2 || n > MODBUS_MAX_RESPONSE {
+		return nil, errors.New("bad response length")
+	}
+	resp := make([]byte, n-2)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, err
+	}
+
+	if hdr[7] == fn|MODBUS_EXCEPTION_FLAG && len(resp) > 0 {
+		return nil, fmt.Errorf("exception %d from %s", resp[0], address)
+	} else if hdr[7] != fn {
+		return nil, fmt.Errorf("unexpected function %d from %s", hdr[7], address)
+	}
+	return resp, nil
+}
+
+// Reads a coil as a bool, or a holding register as a number
+func modbusRead(ctx context.Context, in modbusInput) (any, error) {
+	fn, addr := byte(MODBUS_READ_REGISTERS), in.Register
+	if in.Coil != nil {
+		fn, addr = MODBUS_READ_COILS, in.Coil
+	}
+
+	data := []byte{0, 0, 0, 1} // one, at the address
+	binary.BigEndian.PutUint16(data, *addr)
+	resp, err := modbusRequest(ctx, in.Address, in.Unit, fn, data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case fn == MODBUS_READ_COILS && len(resp) >= 2:
+		return resp[1]&0x01 != 0, nil
+	case fn == MODBUS_READ_REGISTERS && len(resp) >= 3:
+		return float64(binary.BigEndian.Uint16(resp[1:])), nil
+	}
+	return nil, errors.New("short response")
+}
+
+// Writes a bool to a coil, or a number 0-65535 to a holding register
+func modbusWrite(ctx context.Context, address string, unit byte, coil, register *uint16, v any) error {
+	data := make([]byte, 4)
+	var fn byte
+
+	switch v := v.(type) {
+	case bool:
+		if coil == nil {
+			return errors.New("booleans can only be written to a Coil")
+		}
+		fn = MODBUS_WRITE_COIL
+		binary.BigEndian.PutUint16(data, *coil)
+		if v {
+			binary.BigEndian.PutUint16(data[2:], MODBUS_COIL_ON)
+		}
+	case float64:
+		if register == nil {
+			return errors.New("numbers can only be written to a Register")
+		} else if v != float64(uint16(v)) {
+			return fmt.Errorf("can't write %v to a register", v)
+		}
+		fn = MODBUS_WRITE_REGISTER
+		binary.BigEndian.PutUint16(data, *register)
+		binary.BigEndian.PutUint16(data[2:], uint16(v))
+	default:
+		return fmt.Errorf("can only write booleans and numbers, not %#v", v)
+	}
+
+	_, err := modbusRequest(ctx, address, unit, fn, data)
+	return err
+}
+
+// Last values read from the Modbus inputs
+type modbus struct {
+	inputs map[string]modbusInput
+
+	mu     sync.Mutex
+	values map[string]any
+}
+
+func newModbus(inputs map[string]modbusInput) *modbus {
+	return &modbus{inputs: inputs, values: make(map[string]any)}
+}
+
+// Polls each input every Interval until the context is done
+func (m *modbus) Run(ctx context.Context, idle *idleDetector) {
+	for name, in := range m.inputs {
+		go m.poll(ctx, idle, name, in)
+	}
+}
+
+func (m *modbus) poll(ctx context.Context, idle *idleDetector, name string, in modbusInput) {
+	interval := time.Duration(in.Interval)
+	if interval <= 0 {
+		interval = MODBUS_INTERVAL
+	}
+
+	for {
+		v, err := modbusRead(ctx, in)
+		if err != nil {
+			log.Printf("unable to read modbus input %q: %v", name, err)
+		}
+
+		m.mu.Lock()
+		m.values[name] = v // unknown after failures
+		m.mu.Unlock()
+
+		if !idle.Sleep(ctx, interval) {
+			return
+		}
+	}
+}
+
+func (m *modbus) Values() map[string]any {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	values := make(map[string]any, len(m.values))
+	for k, v := range m.values {
+		values[k] = v
+	}
+	return values
+}
+
+func init() {
+	actionChecks["modbus"] = func(spec *actionSpec) error {
+		return validateModbusTarget(spec.Address, spec.Coil, spec.Register)
+	}
+	actionTypes["modbus"] = func(ctx context.Context, r *regelwerk, spec *actionSpec) error {
+		if spec.Address == "" {
+			return errors.New("modbus needs an Address")
+		}
+
+		r.Do(ctx, &action{
+			topic:   "modbus/" + spec.Address,
+			payload: spec.Payload,
+			source:  sourceOf(ctx),
+			send: func(ctx context.Context) error {
+				// run asynchronously, so the device doesn't hold up the rules
+				go func() {
+					err := modbusWrite(ctx, spec.Address, spec.Unit, spec.Coil, spec.Register, spec.Payload)
+					if err != nil {
+						log.Printf("modbus write to %s failed: %v", spec.Address, err)
+					} else {
+						debugf(LOG_ACTIONS, "modbus wrote %v to %s", spec.Payload, spec.Address)
+					}
+				}()
+				return nil
+			},
+		})
+		return nil
+	}
+}
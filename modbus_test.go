@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+// Serves one Modbus TCP request, replying with the PDU from reply
+func fakeModbusDevice(t *testing.T, reply func(fn byte, data []byte) []byte) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		hdr := make([]byte, 8)
+		if _, err := io.ReadFull(conn, hdr); err != nil {
+			return
+		}
+		data := make([]byte, binary.BigEndian.Uint16(hdr[4:])-2)
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return
+		}
+
+		pdu := reply(hdr[7], data)
+		binary.BigEndian.PutUint16(hdr[4:], uint16(1+len(pdu)))
+		conn.Write(append(hdr[:7], pdu...))
+	}()
+	return l.Addr().String()
+}
+
+func TestModbusRead(t *testing.T) {
+	addr := fakeModbusDevice(t, func(fn byte, data []byte) []byte {
+		if fn != MODBUS_READ_REGISTERS || binary.BigEndian.Uint16(data) != 40 {
+			return []byte{fn | MODBUS_EXCEPTION_FLAG, 2}
+		}
+		return []byte{fn, 2, 0x01, 0x2c}
+	})
+
+	reg := uint16(40)
+	v, err := modbusRead(context.Background(), modbusInput{Address: addr, Register: &reg})
+	if err != nil {
+		t.Fatal(err)
+	} else if v != 300.0 {
+		t.Errorf("wanted register value 300, got %v", v)
+	}
+}
+
+func TestModbusWrite(t *testing.T) {
+	written := make(chan []byte, 1)
+	addr := fakeModbusDevice(t, func(fn byte, data []byte) []byte {
+		pdu := append([]byte{fn}, data...)
+		written <- pdu
+		return pdu
+	})
+
+	coil := uint16(3)
+	if err := modbusWrite(context.Background(), addr, 1, &coil, nil, true); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := <-written, []byte{MODBUS_WRITE_COIL, 0, 3, 0xff, 0}; string(got) != string(want) {
+		t.Errorf("wanted write %x, got %x", want, got)
+	}
+
+	if err := modbusWrite(context.Background(), addr, 1, &coil, nil, 5.0); err == nil {
+		t.Errorf("number written to a coil")
+	}
+}
+
+func TestModbusException(t *testing.T) {
+	addr := fakeModbusDevice(t, func(fn byte, data []byte) []byte {
+		return []byte{fn | MODBUS_EXCEPTION_FLAG, 2}
+	})
+
+	coil := uint16(9)
+	if _, err := modbusRead(context.Background(), modbusInput{Address: addr, Coil: &coil}); err == nil {
+		t.Errorf("exception not reported")
+	}
+}
+
+func TestModbusLoadErrors(t *testing.T) {
+	reg := uint16(40)
+	for name, spec := range map[string]*actionSpec{
+		"no address":   {Type: "modbus", Register: &reg},
+		"no target":    {Type: "modbus", Address: "plc"},
+		"both targets": {Type: "modbus", Address: "plc", Coil: &reg, Register: &reg},
+		"bad port":     {Type: "modbus", Address: "plc:70000", Register: &reg},
+		"knx address":  {Type: "knx", GroupAddress: "1/8/0"},
+	} {
+		if err := validateButtons(nil, map[string]*actionSpec{name: spec}); err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+
+	inputs := map[string]modbusInput{"tank": {Address: "plc:502", Coil: &reg, Register: &reg}}
+	if err := validateModbus(inputs); err == nil {
+		t.Errorf("input with both coil and register accepted")
+	}
+	if err := validateButtons(nil, map[string]*actionSpec{
+		"ok": {Type: "modbus", Address: "plc:502", Register: &reg},
+	}); err != nil {
+		t.Errorf("valid action rejected: %v", err)
+	}
+}
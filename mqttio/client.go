@@ -0,0 +1,10 @@
+package mqttio
+
+import mqtt "github.com/eclipse/paho.mqtt.golang"
+
+// The parts of the MQTT client used once connected, so that tests can
+// substitute their own
+type Publisher interface {
+	Publish(topic string, qos byte, retained bool, payload any) mqtt.Token
+	IsConnectionOpen() bool
+}
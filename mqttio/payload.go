@@ -0,0 +1,83 @@
+package mqttio
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Decodes the payload as a JSON map
+func DecodePayload(msg mqtt.Message) (map[string]any, error) {
+	var m map[string]any
+	err := json.Unmarshal(msg.Payload(), &m)
+	return m, err
+}
+
+// Retrieves a string value from a map, key can be a dotted path
+// If key doesn't exist or an error, returns an empty string
+func GetMapValue(m map[string]any, key string) string {
+	v, exists := LookupPath(m, key)
+	if !exists {
+		return ""
+	}
+	vs, _ := v.(string)
+	return vs
+}
+
+// Looks up a value in a decoded JSON payload by a dotted path.
+// Each path element is either a map key or an array index, so
+// "update.state", "color.x" and "actions.0" (or "actions[0]") all work.
+// A key that itself contains a dot is matched as-is before splitting.
+func LookupPath(m map[string]any, path string) (any, bool) {
+	if v, ok := m[path]; ok {
+		return v, true
+	}
+	return LookupElems(m, SplitPath(path))
+}
+
+// Like LookupPath, with the path already split
+func LookupElems(m map[string]any, elems []string) (any, bool) {
+	var v any = m
+	for _, elem := range elems {
+		switch vv := v.(type) {
+		case map[string]any:
+			var ok bool
+			if v, ok = vv[elem]; !ok {
+				return nil, false
+			}
+
+		case []any:
+			i, err := strconv.Atoi(elem)
+			if err != nil || i < 0 || i >= len(vv) {
+				return nil, false
+			}
+			v = vv[i]
+
+		default:
+			return nil, false
+		}
+	}
+
+	return v, true
+}
+
+// Builds a payload with the value nested according to the dotted path,
+// e.g. "color.x" becomes {"color": {"x": value}}
+func NestPath(path string, value any) map[string]any {
+	elems := SplitPath(path)
+
+	m := map[string]any{elems[len(elems)-1]: value}
+	for i := len(elems) - 2; i >= 0; i-- {
+		m = map[string]any{elems[i]: m}
+	}
+	return m
+}
+
+// Splits a dotted path into its elements, treating "a[0]" as "a.0"
+func SplitPath(path string) []string {
+	path = strings.ReplaceAll(path, "[", ".")
+	path = strings.ReplaceAll(path, "]", "")
+	return strings.Split(path, ".")
+}
@@ -0,0 +1,46 @@
+package mqttio
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestLookupPath(t *testing.T) {
+	var m map[string]any
+	js := `{"state": "ON", "update": {"state": "idle"}, "color": {"x": 0.3},
+		"actions": ["a", {"b": 1}], "odd.key": true}`
+	if err := json.Unmarshal([]byte(js), &m); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path  string
+		value any
+		found bool
+	}{
+		{"state", "ON", true},
+		{"update.state", "idle", true},
+		{"color.x", 0.3, true},
+		{"actions.0", "a", true},
+		{"actions[1].b", 1.0, true},
+		{"odd.key", true, true},
+		{"actions.2", nil, false},
+		{"state.x", nil, false},
+		{"missing", nil, false},
+	}
+	for _, tt := range tests {
+		v, found := LookupPath(m, tt.path)
+		if found != tt.found || v != tt.value {
+			t.Errorf("%q: wanted %v (%v) got %v (%v)", tt.path, tt.value, tt.found, v, found)
+		}
+	}
+}
+
+func TestNestPath(t *testing.T) {
+	got := NestPath("color.x", 0.5)
+	want := map[string]any{"color": map[string]any{"x": 0.5}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wanted %v got %v", want, got)
+	}
+}
@@ -0,0 +1,49 @@
+// Package mqttio has the MQTT helpers of regelwerk: topic patterns, and
+// looking up and building values in JSON payloads.
+package mqttio
+
+import (
+	"path"
+	"strings"
+)
+
+// Checks if the topic contains MQTT wildcards or glob characters
+func IsTopicPattern(topic string) bool {
+	return strings.ContainsAny(topic, "+#*?[")
+}
+
+// Matches a topic against a pattern, level by level.
+// Both MQTT wildcards (+ for a single level, # at the end for any remaining
+// levels) and shell globs within a level like "bedroom_*" are supported.
+func MatchTopic(pattern, topic string) bool {
+	pl := strings.Split(pattern, "/")
+	tl := strings.Split(topic, "/")
+
+	for i, p := range pl {
+		if p == "#" {
+			return true
+		} else if i >= len(tl) {
+			return false
+		} else if p == "+" {
+			continue
+		}
+
+		if matched, err := path.Match(p, tl[i]); err != nil || !matched {
+			return false
+		}
+	}
+
+	return len(pl) == len(tl)
+}
+
+// Converts a device topic or pattern into an MQTT subscription filter,
+// widening globs to single-level wildcards
+func SubscriptionFilter(topic string) string {
+	levels := strings.Split(topic, "/")
+	for i, l := range levels {
+		if l != "+" && l != "#" && strings.ContainsAny(l, "+#*?[") {
+			levels[i] = "+"
+		}
+	}
+	return strings.Join(levels, "/")
+}
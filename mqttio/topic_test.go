@@ -0,0 +1,40 @@
+package mqttio
+
+import "testing"
+
+func TestMatchTopic(t *testing.T) {
+	tests := []struct {
+		pattern, topic string
+		match          bool
+	}{
+		{"sensors/+/motion", "sensors/hall/motion", true},
+		{"sensors/+/motion", "sensors/hall/door", false},
+		{"sensors/+/motion", "sensors/a/b/motion", false},
+		{"sensors/#", "sensors/hall/motion", true},
+		{"sensors/#", "lights/hall", false},
+		{"bedroom_*", "bedroom_motion", true},
+		{"bedroom_*", "bedroom/motion", false},
+		{"bedroom_*", "kitchen_motion", false},
+		{"+/motion_?", "hall/motion_1", true},
+	}
+	for _, tt := range tests {
+		if m := MatchTopic(tt.pattern, tt.topic); m != tt.match {
+			t.Errorf("%q vs %q: wanted %v got %v", tt.pattern, tt.topic, tt.match, m)
+		}
+	}
+}
+
+func TestSubscriptionFilter(t *testing.T) {
+	tests := []struct{ topic, filter string }{
+		{"0x00158d00037aa30d", "0x00158d00037aa30d"},
+		{"sensors/+/motion", "sensors/+/motion"},
+		{"sensors/#", "sensors/#"},
+		{"bedroom_*", "+"},
+		{"hall/motion_?", "hall/+"},
+	}
+	for _, tt := range tests {
+		if f := SubscriptionFilter(tt.topic); f != tt.filter {
+			t.Errorf("%q: wanted %q got %q", tt.topic, tt.filter, f)
+		}
+	}
+}
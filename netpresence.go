@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	NETPRESENCE_INTERVAL   = time.Minute
+	NETPRESENCE_AWAY_AFTER = 10 * time.Minute
+	ARP_TABLE              = "/proc/net/arp"
+	ARP_COMPLETE           = 0x2 // flag of resolved entries
+)
+
+// Presence of phones and the like on the network, as a device that's on
+// while any of the Hosts is reachable. Phones sleep their wifi, so a host is
+// only considered gone once it hasn't been seen for AwayAfter.
+type netPresenceConfig struct {
+	Device    string // name of the device, default network_presence
+	Hosts     []netPresenceHost
+	Interval  textDuration
+	AwayAfter textDuration
+}
+
+// A host is pinged by IP, and looked up in the ARP table by IP or MAC, as
+// phones often don't answer pings but still resolve
+type netPresenceHost struct {
+	IP  string `json:",omitempty"`
+	MAC string `json:",omitempty"`
+}
+
+func (h netPresenceHost) String() string {
+	if h.IP != "" {
+		return h.IP
+	}
+	return h.MAC
+}
+
+type netPresence struct {
+	cfg netPresenceConfig
+	dev *device
+
+	mu       sync.Mutex
+	lastSeen map[string]time.Time // by host
+	present  bool
+	known    bool
+}
+
+func validateNetPresence(cfg *netPresenceConfig) error {
+	if cfg == nil {
+		return nil
+	} else if len(cfg.Hosts) == 0 {
+		return fmt.Errorf("NetworkPresence needs Hosts")
+	}
+
+	for _, h := range cfg.Hosts {
+		if h.IP == "" && h.MAC == "" {
+			return fmt.Errorf("NetworkPresence hosts need an IP or MAC")
+		} else if h.IP != "" && net.ParseIP(h.IP) == nil {
+			return fmt.Errorf("bad NetworkPresence IP %q", h.IP)
+		} else if _, err := net.ParseMAC(h.MAC); h.MAC != "" && err != nil {
+			return fmt.Errorf("bad NetworkPresence MAC %q", h.MAC)
+		}
+	}
+	return nil
+}
+
+// Pings the IP once, using the system's ping as raw sockets need privileges.
+// A variable to be replaced in tests.
+var pingHost = func(ctx context.Context, ip string) bool {
+	return exec.CommandContext(ctx, "ping", "-n", "-q", "-c", "1", "-W", "1", ip).Run() == nil
+}
+
+// Reads the resolved entries of the ARP table, as a set of IPs and MACs.
+// A variable to be replaced in tests.
+var readARPTable = func() (map[string]bool, error) {
+	f, err := os.Open(ARP_TABLE)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseARPTable(f)
+}
+
+func parseARPTable(rd io.Reader) (map[string]bool, error) {
+	resolved := make(map[string]bool)
+	sc := bufio.NewScanner(rd)
+	sc.Scan() // header
+
+	for sc.Scan() {
+		var ip, hwType, mac string
+		var flags int
+		if _, err := fmt.Sscanf(sc.Text(), "%s %s 0x%x %s", &ip, &hwType, &flags, &mac); err != nil {
+			continue
+		} else if flags&ARP_COMPLETE == 0 {
+			continue
+		}
+		resolved[ip] = true
+		resolved[strings.ToLower(mac)] = true
+	}
+	return resolved, sc.Err()
+}
+
+// Registers the presence device
+func (r *regelwerk) addNetPresence(cfg *netPresenceConfig) {
+	if cfg == nil {
+		return
+	}
+
+	nc := *cfg
+	if nc.Device == "" {
+		nc.Device = "network_presence"
+	}
+	if nc.Interval <= 0 {
+		nc.Interval = textDuration(NETPRESENCE_INTERVAL)
+	}
+	if nc.AwayAfter <= 0 {
+		nc.AwayAfter = textDuration(NETPRESENCE_AWAY_AFTER)
+	}
+
+	for i, h := range nc.Hosts {
+		if h.MAC != "" {
+			mac, _ := net.ParseMAC(h.MAC)
+			nc.Hosts[i].MAC = mac.String()
+		}
+	}
+
+	r.netPresence = &netPresence{
+		cfg:      nc,
+		dev:      r.addVirtualDevice(nc.Device),
+		lastSeen: make(map[string]time.Time),
+	}
+}
+
+// Probes the hosts every Interval until the context is done
+func (r *regelwerk) runNetPresence(ctx context.Context) {
+	np := r.netPresence
+	for {
+		r.probeHosts(ctx)
+		if !r.idle.Sleep(ctx, time.Duration(np.cfg.Interval)) {
+			return
+		}
+	}
+}
+
+// Pings the hosts in parallel, then checks the ARP table, which the pings
+// also refresh
+func (r *regelwerk) probeHosts(ctx context.Context) {
+	np := r.netPresence
+	seen := make([]bool, len(np.cfg.Hosts))
+
+	var wg sync.WaitGroup
+	for i, h := range np.cfg.Hosts {
+		if h.IP == "" {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, ip string) {
+			defer wg.Done()
+			seen[i] = pingHost(ctx, ip)
+		}(i, h.IP)
+	}
+	wg.Wait()
+
+	arp, err := readARPTable()
+	if err != nil {
+		debugf(LOG_DEVICES, "unable to read ARP table: %v", err)
+	}
+	for i, h := range np.cfg.Hosts {
+		seen[i] = seen[i] || arp[h.IP] || arp[h.MAC]
+	}
+
+	r.updateNetPresence(seen, r.clock.Now())
+}
+
+// Updates the device with which hosts were seen: it's on as soon as one is,
+// but only off once none has been for AwayAfter
+func (r *regelwerk) updateNetPresence(seen []bool, now time.Time) {
+	np := r.netPresence
+	np.mu.Lock()
+	defer np.mu.Unlock()
+
+	present := false
+	for i, h := range np.cfg.Hosts {
+		key := h.String()
+		if seen[i] {
+			if np.lastSeen[key].IsZero() || now.Sub(np.lastSeen[key]) >= time.Duration(np.cfg.AwayAfter) {
+				debugf(LOG_DEVICES, "host %s appeared", key)
+			}
+			np.lastSeen[key] = now
+		}
+		if last := np.lastSeen[key]; !last.IsZero() && now.Sub(last) < time.Duration(np.cfg.AwayAfter) {
+			present = true
+		}
+	}
+
+	if np.known && present == np.present {
+		return
+	}
+	log.Printf("network presence %q is now %v", np.dev.id, present)
+	np.present, np.known = present, true
+	putVirtualState(np.dev, present)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseARPTable(t *testing.T) {
+	table := `IP address       HW type     Flags       HW address            Mask     Device
+192.168.1.23     0x1         0x2         AA:BB:CC:DD:EE:FF     *        wlan0
+192.168.1.24     0x1         0x0         00:00:00:00:00:00     *        wlan0
+`
+	arp, err := parseARPTable(strings.NewReader(table))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !arp["192.168.1.23"] || !arp["aa:bb:cc:dd:ee:ff"] {
+		t.Errorf("resolved entry missing: %v", arp)
+	} else if arp["192.168.1.24"] {
+		t.Errorf("incomplete entry taken as resolved")
+	}
+}
+
+func TestNetPresenceDamping(t *testing.T) {
+	cfg := testConfig()
+	cfg.NetworkPresence = &netPresenceConfig{
+		Device:    "phones",
+		Hosts:     []netPresenceHost{{IP: "192.168.1.23"}, {MAC: "AA:BB:CC:DD:EE:FF"}},
+		AwayAfter: textDuration(10 * time.Minute),
+	}
+	r, _ := newTestRegelwerk(t, cfg)
+	d := r.LookupDevice("phones")
+	inbox := d.group.inbox
+
+	expect := func(present bool) {
+		t.Helper()
+		if len(inbox.normal) != 1 {
+			t.Fatalf("wanted an update, got %d", len(inbox.normal))
+		}
+		ev, _ := inbox.Get(r.ctx)
+		r.processMessage(r.ctx, ev.dev, ev.msg)
+		if d.state != present {
+			t.Errorf("wanted presence %v, got %#v", present, d.state)
+		}
+	}
+
+	now := time.Date(2022, 6, 1, 21, 0, 0, 0, time.Local)
+	r.updateNetPresence([]bool{false, true}, now)
+	expect(true)
+
+	// missing a few probes doesn't make the phone gone
+	r.updateNetPresence([]bool{false, false}, now.Add(5*time.Minute))
+	if len(inbox.normal) != 0 {
+		t.Errorf("presence changed within AwayAfter")
+	}
+
+	r.updateNetPresence([]bool{false, false}, now.Add(10*time.Minute))
+	expect(false)
+}
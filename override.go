@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"regelwerk/timers"
+)
+
+// manual override modes
+const (
+	OVERRIDE_DISCARD    = "discard"    // end the current session
+	OVERRIDE_HOLD       = "hold"       // keep the current state for a while
+	OVERRIDE_UNTIL_DAWN = "until_dawn" // no automation until next sunrise
+	OVERRIDE_TOGGLE     = "toggle"     // turn automation off/on
+	OVERRIDE_GRACE      = "grace"      // after the lights were turned off by hand
+)
+
+// What a switch button action does
+type overrideConfig struct {
+	Mode     string
+	Duration textDuration // for hold
+}
+
+// An active override, during which sessions are not started
+type override struct {
+	Mode  string
+	Until time.Time `json:",omitempty"` // zero if indefinite
+}
+
+func validateOverrides(overrides map[string]overrideConfig) error {
+	for action, o := range overrides {
+		switch o.Mode {
+		case OVERRIDE_DISCARD, OVERRIDE_UNTIL_DAWN, OVERRIDE_TOGGLE:
+		case OVERRIDE_HOLD:
+			if o.Duration <= 0 {
+				return fmt.Errorf("override for %q needs a Duration", action)
+			}
+		default:
+			return fmt.Errorf("unknown override mode %q for %q", o.Mode, action)
+		}
+	}
+	return nil
+}
+
+// Performs the override configured for the button action, if any
+func (r *regelwerk) handleOverride(action string) {
+	o, found := r.overrides[action]
+	if !found || !r.ruleFires(RULE_OVERRIDES) {
+		return
+	}
+
+	r.overrideMu.Lock()
+	defer r.overrideMu.Unlock()
+
+	// all modes end the current session
+	if r.discardSessions("manual override") {
+		log.Printf("manual override - discarding current session")
+	}
+
+	switch o.Mode {
+	case OVERRIDE_HOLD:
+		r.setOverride(&override{Mode: o.Mode, Until: r.clock.Now().Add(time.Duration(o.Duration))})
+
+	case OVERRIDE_UNTIL_DAWN:
+		now := r.clock.Now()
+		dawn := r.sun.Sunrise(now)
+		if dawn.Before(now) {
+			dawn = r.sun.Sunrise(now.AddDate(0, 0, 1))
+		}
+		r.setOverride(&override{Mode: o.Mode, Until: dawn})
+
+	case OVERRIDE_TOGGLE:
+		if r.override != nil {
+			r.setOverride(nil)
+		} else {
+			r.setOverride(&override{Mode: o.Mode})
+		}
+	}
+}
+
+// Ignores sensors for a while after the lights were turned off by hand, so
+// they don't come back on while someone leaves the room. Doesn't replace
+// another active override.
+func (r *regelwerk) startManualOffGrace() {
+	if r.manualOffGrace <= 0 || !r.ruleFires(RULE_OVERRIDES) {
+		return
+	}
+
+	r.overrideMu.Lock()
+	defer r.overrideMu.Unlock()
+
+	if r.discardSessions("turned off by hand") {
+		log.Printf("lights turned off by hand - discarding current session")
+	}
+	if r.override == nil || r.override.Mode == OVERRIDE_GRACE {
+		r.setOverride(&override{Mode: OVERRIDE_GRACE, Until: r.clock.Now().Add(r.manualOffGrace)})
+	}
+}
+
+// Sets or clears (if nil) the active override, and publishes it.
+// Must be called with overrideMu held.
+func (r *regelwerk) setOverride(o *override) {
+	r.override = o
+	r.timers.Destroy("override")
+
+	if o == nil {
+		log.Printf("manual override ended, automation resumed")
+		r.client.Publish(CONTROL_TOPIC_PREFIX+"override", 0, true, "none")
+		return
+	}
+
+	if o.Until.IsZero() {
+		log.Printf("manual override %q, automation paused", o.Mode)
+	} else {
+		log.Printf("manual override %q, automation paused until %s",
+			o.Mode, o.Until.Format(time.RFC1123))
+
+		r.timers.Add("override", "override", nil)
+		r.timers.Start("override", o.Until.Sub(r.clock.Now()))
+	}
+
+	js, _ := json.Marshal(o)
+	r.client.Publish(CONTROL_TOPIC_PREFIX+"override", 0, true, js)
+}
+
+// Whether rules are allowed to start sessions
+func (r *regelwerk) AutomationActive() bool {
+	r.overrideMu.Lock()
+	defer r.overrideMu.Unlock()
+	return r.override == nil && r.BridgeOnline() && r.killSwitch.Enabled()
+}
+
+func (r *regelwerk) handleOverrideTimer(ctx context.Context, tm *timers.Timer, expired bool) {
+	r.overrideMu.Lock()
+	defer r.overrideMu.Unlock()
+	r.setOverride(nil)
+}
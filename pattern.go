@@ -0,0 +1,94 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	"regelwerk/mqttio"
+)
+
+// Whether this is a template registered with a topic pattern, rather than an
+// actual device
+func (d *device) isTemplate() bool {
+	return d.pattern != "" && d.topic == d.pattern
+}
+
+// Finds the device for a topic, creating it from a matching template if
+// needed. Returns nil if nothing matches.
+func (r *regelwerk) matchDevice(topic string) *device {
+	if d := r.deviceByTopic(topic); d != nil {
+		return d
+	} else if t := r.registry.ConfiguredTopic(topic); t != "" {
+		return r.deviceByTopic(t)
+	}
+
+	for _, tmpl := range r.patterns {
+		if mqttio.MatchTopic(tmpl.pattern, topic) {
+			d := *tmpl
+			d.topic = topic
+			// the copy is shallow, so each device needs its own press count
+			if tmpl.gesture != nil {
+				d.gesture = newGestureState(tmpl.gesture.cfg)
+			}
+
+			r.devicesMu.Lock()
+			r.devices[topic] = &d
+			r.devicesMu.Unlock()
+
+			log.Printf("dev %q matched %q", topic, tmpl.pattern)
+			return &d
+		}
+	}
+
+	return nil
+}
+
+// Returns the zigbee2mqtt topics to subscribe to: everything, or with
+// SubscribeDevices only those of the devices and the bridge. Topics of devices
+// outside of zigbee2mqtt and of republishing are always included.
+func (r *regelwerk) SubscriptionTopics() []string {
+	seen := make(map[string]bool)
+	var topics []string
+	add := func(topic string) {
+		if !seen[topic] {
+			seen[topic] = true
+			topics = append(topics, topic)
+		}
+	}
+
+	// devices outside of zigbee2mqtt
+	for topic := range r.stateTopics {
+		add(topic)
+	}
+
+	// republished topics, unless covered by subscribing to everything
+	for _, rp := range r.republishers {
+		if r.subscribeDevices || !strings.HasPrefix(rp.cfg.From, MQTT_TOPIC_PREFIX) {
+			add(mqttio.SubscriptionFilter(rp.cfg.From))
+		}
+	}
+
+	if !r.subscribeDevices {
+		add(MQTT_TOPIC_PREFIX + "#")
+		sort.Strings(topics)
+		return topics
+	}
+
+	r.devicesMu.RLock()
+	for _, d := range r.devices {
+		if d.virtual || (d.pattern != "" && !d.isTemplate()) {
+			continue
+		}
+		add(MQTT_TOPIC_PREFIX + mqttio.SubscriptionFilter(d.topic))
+	}
+	r.devicesMu.RUnlock()
+
+	if r.bridge != nil {
+		add(MQTT_TOPIC_PREFIX + "bridge/#")
+	} else {
+		add(MQTT_TOPIC_PREFIX + "bridge/devices")
+	}
+	sort.Strings(topics)
+	return topics
+}
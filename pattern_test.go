@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestMatchedDevicesHaveOwnGestures(t *testing.T) {
+	cfg := testConfig()
+	cfg.Buttons = map[string]map[string]actionList{"remote_*": {}}
+	cfg.Devices = map[string]deviceConfig{"remote_*": {Gestures: &gestureConfig{}}}
+	r, _ := newTestRegelwerk(t, cfg)
+
+	a, b := r.matchDevice("remote_a"), r.matchDevice("remote_b")
+	if a == nil || b == nil {
+		t.Fatalf("pattern didn't match")
+	} else if a.gesture == nil || a.gesture == b.gesture || a.gesture == r.devices["remote_*"].gesture {
+		t.Errorf("matched devices share their gesture state")
+	}
+}
+
+func TestMatchedTopicInRules(t *testing.T) {
+	cfg := testConfig()
+	cfg.Actions = map[string]*actionSpec{
+		"which": {Type: "publish", Topic: "test/pressed", Payload: "{{ .Topic }}", If: "topic != 'remote_b'"},
+	}
+	cfg.Buttons = map[string]map[string]actionList{"remote_*": {"single": {"which"}}}
+	r, mc := newTestRegelwerk(t, cfg)
+
+	for _, topic := range []string{"remote_a", "remote_b", "remote_c"} {
+		r.processMessage(r.ctx, r.matchDevice(topic), &virtualMessage{topic: MQTT_TOPIC_PREFIX + topic, payload: []byte(`{"action": "single"}`)})
+	}
+	if got := mc.WaitFor(t, "test/pressed", 2); got[0] != "remote_a" || got[1] != "remote_c" {
+		t.Errorf("wanted the matched topics, got %v", got)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsDuplicate(t *testing.T) {
+	d := &device{dedup: time.Second}
+	now := time.Date(2022, 6, 1, 21, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		payload string
+		after   time.Duration
+		dup     bool
+	}{
+		{`{"action":"single"}`, 0, false},
+		{`{"action":"single"}`, 100 * time.Millisecond, true},
+		{`{"action":"double"}`, 100 * time.Millisecond, false},
+		{`{"action":"double"}`, 2 * time.Second, false}, // outside window
+	}
+	for i, tt := range tests {
+		now = now.Add(tt.after)
+		if dup := d.isDuplicate([]byte(tt.payload), now); dup != tt.dup {
+			t.Errorf("%d: wanted duplicate %v", i, tt.dup)
+		}
+	}
+}
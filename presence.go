@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"regelwerk/mqttio"
+	"regelwerk/rules"
+	"regelwerk/timers"
+)
+
+const (
+	PRESENCE_UNKNOWN = "unknown"
+	PRESENCE_HOME    = "home"
+	PRESENCE_AWAY    = "away"
+)
+
+// Presence is derived from activity of its inputs: any input reporting its
+// active value means someone is home, and nobody is considered home once no
+// input has been active for AwayAfter.
+type presenceConfig struct {
+	Inputs    []presenceInput
+	AwayAfter textDuration
+}
+
+type presenceInput struct {
+	Device string // topic
+	Attr   string
+	Value  any // value of Attr that indicates someone's there
+}
+
+type presence struct {
+	cfg presenceConfig
+
+	mu    sync.Mutex
+	state string
+	since time.Time
+}
+
+// Records activity from the device, if it's a presence input
+func (r *regelwerk) observePresence(d *device, payload map[string]any) {
+	p := r.presence
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, in := range p.cfg.Inputs {
+		if in.Device != d.topic {
+			continue
+		}
+
+		if v, ok := mqttio.LookupPath(payload, in.Attr); ok && rules.Equal(v, in.Value) {
+			r.setPresence(PRESENCE_HOME)
+
+			// restart countdown to away
+			r.timers.Destroy("presence")
+			r.timers.Add("presence", "presence", map[string]string{"topic": d.topic})
+			r.timers.Start("presence", time.Duration(p.cfg.AwayAfter))
+			return
+		}
+	}
+}
+
+// Must be called with the presence lock held
+func (r *regelwerk) setPresence(state string) {
+	p := r.presence
+	if p.state == state {
+		return
+	}
+
+	log.Printf("presence changed from %s to %s", p.state, state)
+	p.state = state
+	p.since = r.clock.Now()
+
+	r.client.Publish(CONTROL_TOPIC_PREFIX+"presence", 0, true, state)
+}
+
+// Whether someone might be home.
+// Always true if presence detection isn't configured.
+func (r *regelwerk) SomeoneHome() bool {
+	p := r.presence
+	if p == nil {
+		return true
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state != PRESENCE_AWAY
+}
+
+func (r *regelwerk) handlePresenceTimer(ctx context.Context, tm *timers.Timer, expired bool) {
+	r.presence.mu.Lock()
+	defer r.presence.mu.Unlock()
+
+	r.setPresence(PRESENCE_AWAY)
+}
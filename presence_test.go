@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPresenceInput(t *testing.T) {
+	cfg := testConfig()
+	cfg.Presence = &presenceConfig{
+		Inputs:    []presenceInput{{Device: "pir", Attr: "occupancy", Value: true}},
+		AwayAfter: textDuration(time.Hour),
+	}
+	r, _ := newTestRegelwerk(t, cfg)
+
+	receive(r, "pir", map[string]any{"occupancy": []any{true}})
+	if r.presence.state != PRESENCE_UNKNOWN {
+		t.Errorf("list value taken as presence: %q", r.presence.state)
+	}
+	receive(r, "pir", map[string]any{"occupancy": true})
+	if r.presence.state != PRESENCE_HOME {
+		t.Errorf("wanted %q, got %q", PRESENCE_HOME, r.presence.state)
+	}
+
+	cfg.Presence.Inputs[0].Value = map[string]any{"occupancy": true}
+	if _, err := newRegelwerk(context.Background(), cfg); err == nil {
+		t.Error("object Value accepted")
+	}
+}
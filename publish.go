@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// how long to wait for a command's publish to complete
+const PUBLISH_TIMEOUT = 10 * time.Second
+
+// Counts of command publishes, for the status report
+type publishStats struct {
+	sent    atomic.Int64
+	failed  atomic.Int64 // publishes that errored or timed out
+	dropped atomic.Int64 // commands given up on
+}
+
+// marks the context of the OnPublishFailure action, so its own failures
+// don't trigger it again
+type publishFailureKey struct{}
+
+// Waits for the command's publish in the background, surfacing failures
+func (r *regelwerk) checkPublish(ctx context.Context, what string, tok mqtt.Token) {
+	r.pubStats.sent.Add(1)
+	go func() {
+		if err := waitToken(ctx, tok, PUBLISH_TIMEOUT); err != nil && ctx.Err() == nil {
+			r.publishFailed(what, err)
+			r.commandLost(ctx, what)
+		}
+	}()
+}
+
+func (r *regelwerk) publishFailed(what string, err error) {
+	r.pubStats.failed.Add(1)
+	log.Printf("publish of %s failed: %v", what, err)
+}
+
+// Runs the OnPublishFailure action, if any, for a command that wasn't sent
+func (r *regelwerk) commandLost(ctx context.Context, what string) {
+	r.pubStats.dropped.Add(1)
+
+	name := r.onPublishFailure
+	if name == "" || ctx.Value(publishFailureKey{}) != nil {
+		return
+	}
+
+	ctx = withTrigger(context.WithValue(ctx, publishFailureKey{}, true), map[string]any{"command": what})
+	if err := r.Run(ctx, r.actions[name]); err != nil {
+		log.Printf("action %q failed: %v", name, err)
+	}
+}
+
+func (r *regelwerk) PublishReport() map[string]any {
+	report := map[string]any{
+		"sent":    r.pubStats.sent.Load(),
+		"failed":  r.pubStats.failed.Load(),
+		"dropped": r.pubStats.dropped.Load(),
+	}
+	if r.queue != nil {
+		report["queued"] = r.queue.Len()
+	}
+	return report
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Hours during which motion doesn't turn on the lights, or only to a dim
+// night-light Brightness, regardless of dusk. Contact sessions are not
+// affected.
+type quietHoursConfig struct {
+	From, To   timeOfDay // can wrap around midnight, e.g. 23:00 to 06:00
+	Brightness int       // 0 keeps the lights off
+}
+
+// A time of the day, as "15:04" in the config
+type timeOfDay int // minutes since midnight
+
+func (t *timeOfDay) UnmarshalText(b []byte) error {
+	tm, err := time.Parse("15:04", string(b))
+	if err != nil {
+		return fmt.Errorf("expected a time like 23:30, got %q", b)
+	}
+	*t = timeOfDay(tm.Hour()*60 + tm.Minute())
+	return nil
+}
+
+func (q *quietHoursConfig) validate() error {
+	if q == nil {
+		return nil
+	} else if q.From == q.To {
+		return fmt.Errorf("QuietHours needs From and To to differ")
+	} else if q.Brightness < 0 || q.Brightness > 254 {
+		return fmt.Errorf("QuietHours Brightness needs to be within 0 to 254")
+	}
+	return nil
+}
+
+// Whether the time is within the quiet hours, if configured
+func (q *quietHoursConfig) Active(t time.Time) bool {
+	if q == nil {
+		return false
+	}
+	return inPeriod(t, q.From, q.To)
+}
+
+// Whether the time of day is from the start up to the end, which can wrap
+// around midnight
+func inPeriod(t time.Time, from, to timeOfDay) bool {
+	now := timeOfDay(t.Hour()*60 + t.Minute())
+	if from < to {
+		return now >= from && now < to
+	}
+	return now >= from || now < to
+}
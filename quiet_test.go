@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestQuietHours(t *testing.T) {
+	var q quietHoursConfig
+	if err := json.Unmarshal([]byte(`{"From": "23:00", "To": "06:30"}`), &q); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		hour, min int
+		quiet     bool
+	}{
+		{22, 59, false},
+		{23, 0, true},
+		{0, 0, true},
+		{6, 29, true},
+		{6, 30, false},
+		{12, 0, false},
+	}
+	for _, tt := range tests {
+		ts := time.Date(2022, 6, 1, tt.hour, tt.min, 0, 0, time.Local)
+		if q.Active(ts) != tt.quiet {
+			t.Errorf("%s: wanted quiet %v", ts.Format("15:04"), tt.quiet)
+		}
+	}
+
+	var none *quietHoursConfig
+	if none.Active(time.Now()) {
+		t.Errorf("quiet without quiet hours")
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"regelwerk/mqttio"
+)
+
+// payload formats of devices, besides JSON objects like zigbee2mqtt's
+const (
+	PAYLOAD_JSON   = "json"   // the default
+	PAYLOAD_RAW    = "raw"    // a bare string, e.g. ON
+	PAYLOAD_NUMBER = "number" // a bare number, e.g. 23.5
+
+	// a JSON document with the state at a path, e.g. "json:sensor.temp",
+	// or a bare JSON value with only "json:"
+	PAYLOAD_JSON_PATH = "json:"
+)
+
+// Decodes a device's payloads into a map with the value as the state
+// attribute, so they can be handled like those from zigbee2mqtt
+type payloadParser func(payload []byte, attr string) (map[string]any, error)
+
+// Returns the parser for a payload format, or nil for JSON objects
+func newPayloadParser(format string) (payloadParser, error) {
+	switch {
+	case format == "" || format == PAYLOAD_JSON:
+		return nil, nil
+
+	case format == PAYLOAD_RAW:
+		return func(payload []byte, attr string) (map[string]any, error) {
+			return map[string]any{attr: strings.TrimSpace(string(payload))}, nil
+		}, nil
+
+	case format == PAYLOAD_NUMBER:
+		return func(payload []byte, attr string) (map[string]any, error) {
+			f, err := strconv.ParseFloat(strings.TrimSpace(string(payload)), 64)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]any{attr: f}, nil
+		}, nil
+
+	case strings.HasPrefix(format, PAYLOAD_JSON_PATH):
+		path := strings.TrimPrefix(format, PAYLOAD_JSON_PATH)
+		return func(payload []byte, attr string) (map[string]any, error) {
+			var v any
+			if err := json.Unmarshal(payload, &v); err != nil {
+				return nil, err
+			}
+			if path != "" {
+				m, _ := v.(map[string]any)
+				var found bool
+				if v, found = mqttio.LookupPath(m, path); !found {
+					return nil, fmt.Errorf("%q not found", path)
+				}
+			}
+			return map[string]any{attr: v}, nil
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown payload format %q", format)
+}
+
+// Sets up a device publishing other than JSON objects, or outside of
+// zigbee2mqtt. Raw devices get commands as bare values.
+func (r *regelwerk) setupRawDevice(d *device, dc deviceConfig) error {
+	at, err := deviceTopics(d.topic, dc)
+	if err != nil {
+		return err
+	}
+
+	parser, err := newPayloadParser(at.payload)
+	if err != nil {
+		return fmt.Errorf("bad Payload for %q: %v", d.topic, err)
+	}
+
+	d.parser = parser
+	if parser != nil && d.stateAttr == "" {
+		d.stateAttr = "value"
+	}
+
+	if at.valueOn != nil {
+		d.valueOn, d.valueOff = at.valueOn, at.valueOff
+	}
+
+	if at.state != "" {
+		d.stateTopic, d.commandTopic = at.state, at.command
+		d.queryTopic, d.queryPayload = at.query, at.queryPayload
+		d.encodeCommand = at.encode
+		r.stateTopics[at.state] = d
+	} else if at.command != "" {
+		return fmt.Errorf("CommandTopic for %q needs a Topic", d.topic)
+	}
+	return nil
+}
+
+// Returns the bare value to publish to a raw device, or the command its
+// adapter builds, instead of the JSON object commands are built as
+func (d *device) rawCommand(payload any) any {
+	if m, ok := payload.(map[string]any); ok && d.parser != nil {
+		if v, found := mqttio.LookupPath(m, d.stateAttr); found {
+			if d.encodeCommand != nil {
+				return d.encodeCommand(v)
+			}
+			return v
+		}
+	}
+	return payload
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"regelwerk/timers"
+)
+
+func TestRawDevice(t *testing.T) {
+	cfg := testConfig()
+	cfg.Devices = map[string]deviceConfig{
+		"door":  {Payload: PAYLOAD_RAW, Topic: "home/garage/door"},
+		"light": {Payload: "json:relay.state", Topic: "home/garage/light", CommandTopic: "home/garage/light/set"},
+	}
+	r, mc := newTestRegelwerk(t, cfg)
+
+	fc := timers.NewFakeClock(time.Date(2022, 6, 1, 21, 0, 0, 0, time.Local))
+	r.clock, r.timers.Clock = fc, fc
+
+	got := r.SubscriptionTopics()
+	if len(got) != 3 || got[0] != "home/garage/door" {
+		t.Errorf("wrong subscriptions %v", got)
+	}
+
+	// bare value, taken as bool like the contact sensor's state
+	r.handleMqtt(nil, &virtualMessage{topic: "home/garage/door", payload: []byte("OFF")})
+	ev, _ := r.deviceByTopic("door").group.inbox.Get(r.ctx)
+	r.processMessage(r.ctx, ev.dev, ev.msg)
+	if st := r.sessions.State("contact"); st != SESSION_ACTIVE {
+		t.Errorf("raw report didn't start a session, got %s", st)
+	}
+
+	if got := mc.WaitFor(t, "home/garage/light/set", 1); got[0] != "ON" {
+		t.Errorf("wanted bare command, got %s", got[0])
+	}
+
+	light := r.deviceByTopic("light")
+	r.processMessage(r.ctx, light, &virtualMessage{payload: []byte(`{"relay": {"state": "OFF"}}`)})
+	if light.state != "OFF" {
+		t.Errorf("wrong state from JSON path %#v", light.state)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// A recorded MQTT message, one per line in the record file
+type recordedMsg struct {
+	Time    time.Time
+	Topic   string
+	Payload json.RawMessage
+}
+
+// Appends received messages to a file, for replaying or debugging later
+type recorder struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func newRecorder(fname string) (*recorder, error) {
+	f, err := os.OpenFile(fname, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &recorder{f: f}, nil
+}
+
+func (rec *recorder) Record(topic string, payload []byte) {
+	if rec == nil {
+		return
+	}
+
+	// keep JSON payloads as-is, wrap anything else as a string
+	raw := json.RawMessage(payload)
+	if !json.Valid(payload) {
+		raw, _ = json.Marshal(string(payload))
+	}
+
+	line, err := json.Marshal(recordedMsg{time.Now(), topic, raw})
+	if err != nil {
+		log.Printf("unable to record msg: %v", err)
+		return
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if _, err := rec.f.Write(append(line, '\n')); err != nil {
+		log.Printf("unable to record msg: %v", err)
+	}
+}
+
+func (rec *recorder) Close() error {
+	if rec == nil {
+		return nil
+	}
+	return rec.f.Close()
+}
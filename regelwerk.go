@@ -0,0 +1,742 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"regelwerk/mqttio"
+	"regelwerk/rules"
+	"regelwerk/timers"
+)
+
+const MQTT_TOPIC_PREFIX = "zigbee2mqtt/"
+
+type regelwerk struct {
+	client mqttio.Publisher
+	// for timers, debouncing and everything rules decide on, so that tests
+	// can control it. network deadlines, tracing and the send queue stay on
+	// the wall clock
+	clock timers.Clock
+	ctx   context.Context // cancelled on shutdown
+
+	sun      *sun
+	sunHooks []sunHook
+
+	sunTriggers []sunTriggerConfig
+	covers      *covers
+	thermostats *thermostats
+	fans        *fans
+	stats       *stats
+	counters    *counters // nil if not configured
+	energy      *energy   // nil if not configured
+	appliances  *appliances
+	alarm       *alarm
+	emergency   *emergency
+	alerts      *alerts
+	quietHours  *quietHoursConfig
+	adaptive    *adaptiveDelay
+	offWarning  *offWarningConfig
+
+	manualOffGrace time.Duration
+
+	motionOffDelay time.Duration
+	motionExpiry   time.Duration
+	contactExpiry  time.Duration
+	offDelay       time.Duration
+
+	sessionKinds  []*sessionKind
+	sessionExpiry map[string][]string
+
+	timers *timers.Set
+
+	// devices
+	devicesMu   sync.RWMutex
+	devices     map[string]*device
+	stateTopics map[string]*device // devices outside of zigbee2mqtt, fixed after setup
+	devicesById map[string]*device
+	patterns    []*device // templates for wildcard topics
+
+	groups    map[string]*group
+	queueSize int
+
+	middleware []middleware
+	idle       *idleDetector
+
+	scenes   map[string][]sceneStep
+	commands map[string]commandFunc
+
+	presence *presence
+	vacation *vacation
+
+	overrides  map[string]overrideConfig
+	overrideMu sync.Mutex
+	override   *override // active override, if any
+
+	actions  map[string]*actionSpec
+	buttons  map[string]map[string]actionList
+	debounce *debouncer // of actions run by buttons
+
+	confirm *confirmer
+
+	journal *journal
+	status  map[string]statusFunc
+
+	recorder *recorder // nil if not recording
+
+	brightness *brightnessConfig
+	states     stateCache // for payload templates & conditions
+	sessionIf  rules.Expr
+	virtuals   virtuals
+	z2mGroups  []*z2mGroup
+	queue      *sendQueue // nil if commands are published directly
+	pubStats   publishStats
+
+	onPublishFailure string // action
+	subscribeDevices bool
+
+	registry *registry // devices as known to zigbee2mqtt
+
+	bridge       *bridge // nil if not monitored
+	batteries    *batteries
+	linkQuality  *linkQuality
+	exporter     *exporter // nil if not exporting
+	tracer       *tracer   // nil if not tracing
+	socketPath   string
+	arbiter      *arbiter
+	rules        *ruleSet
+	killSwitch   *killSwitch
+	breaker      *breaker // of rules, if configured
+	loops        *loopDetector
+	sessions     *sessions
+	calendar     *calendar // nil if not configured
+	weather      *weather  // nil if not configured
+	knx          *knxConfig
+	republishers []*republisher
+	logind       *logind      // nil if not configured
+	netPresence  *netPresence // nil if not configured
+	modbus       *modbus      // nil if not configured
+
+	lastMessage atomic.Int64 // time of last received message
+	started     time.Time
+	staleAfter  time.Duration // without messages, for Health
+	httpToken   string
+
+	syncWindow time.Duration
+	syncUntil  atomic.Int64 // end of the current sync window
+}
+
+// Registers a device. If its topic is a pattern, it's used as a template for
+// all matching topics, which will share the device ID.
+func (r *regelwerk) AddDevice(d *device) {
+	if d.group == nil {
+		d.group = r.group(DEFAULT_GROUP)
+	}
+
+	if mqttio.IsTopicPattern(d.topic) {
+		d.pattern = d.topic
+		r.patterns = append(r.patterns, d)
+	}
+
+	r.devices[d.topic] = d
+	r.devicesById[d.id] = d
+}
+
+func (r *regelwerk) LookupDevice(id string) *device {
+	return r.devicesById[id]
+}
+
+// Checks if all devices with the ID are in the clear state, ignoring stale
+// ones.
+// Devices sharing an ID must be in the same group, and its lock held.
+func (r *regelwerk) allClear(id string, clear any) bool {
+	r.devicesMu.RLock()
+	defer r.devicesMu.RUnlock()
+
+	for _, d := range r.devices {
+		if d.id == id && !d.isTemplate() && !d.stale && d.state != clear {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the device with the topic, or nil if not found
+func (r *regelwerk) deviceByTopic(topic string) *device {
+	r.devicesMu.RLock()
+	defer r.devicesMu.RUnlock()
+	return r.devices[topic]
+}
+
+// Queries all registered devices for their current states
+func (r *regelwerk) RequestStates() {
+	r.devicesMu.RLock()
+	defer r.devicesMu.RUnlock()
+
+	for _, d := range r.devices {
+		if !d.isTemplate() {
+			d.group.mu.Lock()
+			d.RequestState(r.client, r.clock.Now())
+			d.group.mu.Unlock()
+		}
+	}
+}
+
+// Determines if it's dusk where the device is, or at the house if nil.
+// Without a configured location, 7pm to 7am is used as dusk.
+func (r *regelwerk) NowIsDusk(d *device) bool {
+	// heavy clouds make it darker earlier, and later in the morning
+	return r.sunFor(d).IsDusk(r.clock.Now(), r.weather.DuskShift())
+}
+
+// Checks if given Times are for the same day
+func isSameDay(t1, t2 time.Time) bool {
+	y1, m1, d1 := t1.Date()
+	y2, m2, d2 := t2.Date()
+	return y1 == y2 && m1 == m2 && d1 == d2 && t1.Location() == t2.Location()
+}
+
+func (r *regelwerk) handleMqtt(_ mqtt.Client, msg mqtt.Message) {
+	r.republishMessage(msg)
+
+	// check for and strip away z2m prefix
+	topic := strings.TrimPrefix(msg.Topic(), MQTT_TOPIC_PREFIX)
+	if topic == msg.Topic() {
+		// devices outside of zigbee2mqtt
+		if dev := r.stateTopics[msg.Topic()]; dev != nil {
+			r.lastMessage.Store(r.clock.Now().UnixNano())
+			r.idle.Activity()
+			dev.group.inbox.Put(dev, msg)
+		}
+		return
+	}
+
+	r.recorder.Record(msg.Topic(), msg.Payload())
+	r.lastMessage.Store(r.clock.Now().UnixNano())
+
+	if strings.HasPrefix(topic, "bridge/") {
+		if topic == "bridge/devices" {
+			r.updateRegistry(msg.Payload())
+		} else if r.bridge != nil {
+			r.handleBridge(topic, msg)
+		}
+		return
+	}
+
+	// ignore set/get requests
+	if strings.HasSuffix(topic, "/set") || strings.HasSuffix(topic, "/get") {
+		return
+	}
+
+	// checked first, as boxing the arguments allocates
+	if debugEnabled(LOG_MQTT) {
+		debugf(LOG_MQTT, "recv %q, payload %s", msg.Topic(), msg.Payload())
+	}
+
+	dev := r.matchDevice(topic)
+	if dev != nil {
+		r.idle.Activity()
+		dev.group.inbox.Put(dev, msg)
+	}
+}
+
+// Decodes a queued message and fires the device handlers
+func (r *regelwerk) processMessage(ctx context.Context, dev *device, msg mqtt.Message) {
+	_, lock := r.tracer.Start(ctx, "lock", time.Now())
+	dev.group.mu.Lock()
+	defer dev.group.mu.Unlock()
+	lock.End()
+
+	if !dev.virtual {
+		r.deviceSeen(dev)
+	}
+
+	if dev.isDuplicate(msg.Payload(), r.clock.Now()) {
+		debugf(LOG_DEVICES, "dev %q sent a duplicate, ignoring", dev.id)
+		return
+	}
+
+	ctx = withTopic(ctx, dev.topic)
+	payload, changed, err := dev.DecodePayload(msg)
+	if err != nil {
+		log.Printf("error parsing MQTT msg: %v", err)
+	} else if r.syncOnly(msg) {
+		dev.settledState = dev.state
+		debugf(LOG_DEVICES, "dev %q synced %q to %#v", dev.id, dev.stateAttr, dev.state)
+
+		// a leak or an intrusion reported while we weren't listening still
+		// needs handling
+		r.observeEmergency(ctx, dev, payload)
+		r.observeAlarm(ctx, dev, payload)
+	} else {
+		var sp *span
+		ctx, sp = r.tracer.Start(ctx, "rules", time.Now())
+		sp.SetAttr("device", dev.id)
+		defer sp.End()
+
+		// the state in the reply to our query isn't a change, but any events
+		// it carries are handled like in other messages
+		initial := dev.takeInitialState(r.clock.Now())
+		if changed && !initial {
+			ctx = r.tagEcho(ctx, dev)
+		}
+		r.journal.Add("event", dev.topic, payload)
+		r.confirm.Observe(dev)
+		r.observePresence(dev, payload)
+		r.observeBattery(dev, payload)
+		r.observeLinkQuality(dev, payload)
+		r.observeThermostats(ctx, dev, payload)
+		r.observeFans(ctx, dev, payload)
+		r.observeStats(dev, payload)
+		r.observeCounters(dev, payload)
+		r.observeEnergy(ctx, dev, payload)
+		r.observeAppliances(ctx, dev, payload)
+		r.observeEmergency(ctx, dev, payload)
+		r.observeAlerts(dev, payload)
+		r.observeAlarm(ctx, dev, payload)
+		r.exporter.Add(dev, payload)
+
+		// fire for arbitrary events
+		if !r.detectGesture(ctx, dev, payload) {
+			r.handleButton(ctx, dev, payload)
+		}
+		r.handleDeviceEvent(ctx, dev, payload)
+
+		// fire only on change events
+		if changed && !initial {
+			r.deviceChanged(ctx, dev, payload)
+		}
+	}
+
+	if err == nil && changed {
+		r.states.Set(dev)
+		r.updateZ2MGroups(dev)
+		r.updateVirtuals(ctx)
+	}
+}
+
+// Sets up the devices and modules from the config, ready to be connected
+func newRegelwerk(ctx context.Context, cfg *config) (*regelwerk, error) {
+	r := &regelwerk{
+		offDelay:       time.Duration(cfg.OffDelay),
+		motionOffDelay: time.Duration(cfg.MotionOffDelay),
+		motionExpiry:   time.Duration(cfg.MotionExpiry),
+		contactExpiry:  time.Duration(cfg.ContactExpiry),
+		sessionExpiry:  cfg.SessionExpiry,
+
+		started:    time.Now(),
+		staleAfter: time.Duration(cfg.HealthStaleAfter),
+		httpToken:  cfg.HTTPToken,
+
+		ctx:         ctx,
+		clock:       timers.RealClock{},
+		timers:      timers.NewSet(ctx),
+		devices:     make(map[string]*device),
+		stateTopics: make(map[string]*device),
+		devicesById: make(map[string]*device),
+
+		groups:    make(map[string]*group),
+		queueSize: cfg.QueueSize,
+		idle:      newIdleDetector(time.Duration(cfg.IdleAfter), cfg.IdleFactor),
+
+		scenes:    cfg.Scenes,
+		overrides: cfg.Overrides,
+		actions:   cfg.Actions,
+		buttons:   cfg.Buttons,
+		debounce:  newDebouncer(),
+
+		sunTriggers: cfg.SunTriggers,
+		quietHours:  cfg.QuietHours,
+		adaptive:    newAdaptiveDelay(cfg.AdaptiveOffDelay),
+		offWarning:  cfg.OffWarning,
+		queue:       newSendQueue(cfg.SendQueue),
+
+		onPublishFailure: cfg.OnPublishFailure,
+		subscribeDevices: cfg.SubscribeDevices,
+		registry:         newRegistry(),
+		syncWindow:       time.Duration(cfg.SyncWindow),
+
+		manualOffGrace: time.Duration(cfg.ManualOffGrace),
+
+		brightness: cfg.Brightness,
+
+		journal: newJournal(cfg.JournalSize),
+		status:  make(map[string]statusFunc),
+
+		arbiter:    newArbiter(cfg.Priorities),
+		rules:      newRuleSet(*stateFile),
+		killSwitch: newKillSwitch(*stateFile),
+		breaker:    newBreaker(cfg.CircuitBreaker),
+		loops:      newLoopDetector(cfg.LoopLimit),
+		sessions:   newSessions(),
+		confirm:    newConfirmer(time.Duration(cfg.ConfirmTimeout), cfg.ConfirmRetries),
+		commands:   make(map[string]commandFunc),
+	}
+
+	lat, lng, err := cfg.Location.Resolve(ctx, geocodeCacheFile(*stateFile))
+	if err != nil {
+		return nil, err
+	}
+	r.sun = newSun(lat, lng, float64(cfg.SunAngle))
+
+	// parse conditions once, instead of on every evaluation
+	if r.sessionIf, err = rules.ParseCondition(cfg.SessionIf); err != nil {
+		return nil, fmt.Errorf("bad SessionIf: %v", err)
+	}
+	for name, spec := range r.actions {
+		if spec.cond, err = rules.ParseCondition(spec.If); err != nil {
+			return nil, fmt.Errorf("action %q condition: %v", name, err)
+		}
+	}
+
+	// add devices
+	for _, topic := range append([]string{cfg.Sensor}, cfg.Sensors...) {
+		r.AddDevice(&device{
+			id:        "contact",
+			topic:     topic,
+			stateAttr: "contact",
+			state:     true,
+		})
+	}
+
+	motionSensors := cfg.MotionSensors
+	if cfg.MotionSensor != "" {
+		motionSensors = append([]string{cfg.MotionSensor}, motionSensors...)
+	}
+	for _, topic := range motionSensors {
+		r.AddDevice(&device{
+			id:        "motion",
+			topic:     topic,
+			stateAttr: "occupancy",
+			state:     false,
+		})
+	}
+
+	r.AddDevice(&device{
+		id:        "switch",
+		topic:     cfg.Switch,
+		stateAttr: "state_right",
+		state:     "OFF",
+	})
+
+	// remotes only need to be tracked for their actions
+	for topic := range cfg.Buttons {
+		if r.devices[topic] == nil {
+			r.AddDevice(&device{id: topic, topic: topic})
+		}
+	}
+
+	// mark safety-critical devices for the priority lane
+	for _, topic := range cfg.CriticalTopics {
+		if d := r.devices[topic]; d != nil {
+			d.critical = true
+		} else {
+			r.AddDevice(&device{id: topic, topic: topic, critical: true})
+		}
+	}
+
+	r.addCovers(cfg.Covers)
+	r.addThermostats(cfg.Thermostats)
+	r.addFans(cfg.Fans)
+	r.addStats(cfg.Stats)
+	r.addCounters(cfg.Counters, *stateFile)
+	r.addEnergy(cfg.Energy)
+	r.addAppliances(cfg.Appliances)
+	r.addAlarm(cfg.Alarm)
+	r.addEmergency(cfg.Emergency)
+	r.addAlerts(cfg.Alerts)
+	r.addLogind(cfg.Logind)
+	r.addNetPresence(cfg.NetworkPresence)
+
+	if err := r.addVirtuals(cfg.Virtual); err != nil {
+		return nil, err
+	} else if err := r.addZ2MGroups(cfg.Z2MGroups); err != nil {
+		return nil, err
+	} else if r.republishers, err = newRepublishers(cfg.Republish); err != nil {
+		return nil, err
+	}
+
+	for topic, dc := range cfg.Devices {
+		d := r.devices[topic]
+		if d == nil {
+			return nil, fmt.Errorf("settings for unknown device %q", topic)
+		} else if err := validatePolicy(topic, dc.Policy); err != nil {
+			return nil, err
+		} else if err := validateStateKind(topic, dc.StateKind); err != nil {
+			return nil, err
+		}
+
+		if dc.Group != "" {
+			d.group = r.group(dc.Group)
+		}
+		if dc.StateAttr != "" {
+			d.stateAttr = dc.StateAttr
+			d.stateAttrFixed = true
+		}
+		if dc.StateKind != "" {
+			d.stateKind, d.stateKindFixed = dc.StateKind, true
+		}
+		if err := r.setupRawDevice(d, dc); err != nil {
+			return nil, err
+		}
+		d.debounce = time.Duration(dc.Debounce)
+		d.minInterval = time.Duration(dc.MinInterval)
+		d.minToggle = time.Duration(dc.MinToggle)
+		d.changeThreshold = dc.ChangeThreshold
+		d.dedup = time.Duration(dc.Dedup)
+		d.staleAfter = time.Duration(dc.StaleAfter)
+		d.policy = dc.Policy
+		d.cooldown = time.Duration(dc.Cooldown)
+		if dc.Gestures != nil {
+			d.gesture = newGestureState(*dc.Gestures)
+		}
+	}
+
+	if err := r.checkZ2MGroups(); err != nil {
+		return nil, err
+	} else if err := r.checkSessionGroup(); err != nil {
+		return nil, err
+	}
+
+	for name, gc := range cfg.Groups {
+		lat, lng := r.sun.lat, r.sun.lng
+		if gc.Location.set {
+			if lat, lng, err = gc.Location.Resolve(ctx, geocodeCacheFile(*stateFile)); err != nil {
+				return nil, err
+			}
+		}
+		angle := r.sun.angle
+		if gc.SunAngle != 0 {
+			angle = float64(gc.SunAngle)
+		}
+		r.group(name).sun = newSun(lat, lng, angle)
+	}
+
+	for _, d := range r.devices {
+		if d.stateKind == "" {
+			d.stateKind = kindOf(d.state)
+		}
+		d.settledState = d.state
+		d.lastUpdated = r.clock.Now()
+		if d.stateAttr != "" && d.staleAfter == 0 {
+			d.staleAfter = time.Duration(cfg.StaleAfter)
+		}
+	}
+
+	if cfg.Presence != nil {
+		for _, in := range cfg.Presence.Inputs {
+			if r.devices[in.Device] == nil {
+				return nil, fmt.Errorf("unknown presence input device %q", in.Device)
+			}
+			switch in.Value.(type) {
+			case []any, map[string]any:
+				return nil, fmt.Errorf("presence input %q needs a plain Value", in.Device)
+			}
+		}
+		r.presence = &presence{cfg: *cfg.Presence, state: PRESENCE_UNKNOWN}
+	}
+
+	if cfg.Battery != nil {
+		r.batteries = &batteries{
+			cfg:    *cfg.Battery,
+			levels: make(map[string]float64),
+			low:    make(map[string]bool),
+		}
+	}
+
+	if cfg.LinkQuality != nil {
+		r.linkQuality = &linkQuality{cfg: *cfg.LinkQuality, links: make(map[string]*link)}
+	}
+
+	if cfg.Export != nil {
+		r.exporter = newExporter(*cfg.Export)
+	}
+
+	if cfg.Tracing != nil {
+		r.tracer = newTracer(*cfg.Tracing)
+		r.Use(r.tracer.traceActions)
+	}
+
+	if cfg.Calendar != nil {
+		r.calendar = &calendar{cfg: *cfg.Calendar}
+		r.timers.Register("calendar", r.handleCalendarTimer)
+	}
+
+	if cfg.Weather != nil {
+		wc := *cfg.Weather
+		wc.lat, wc.lng = r.sun.lat, r.sun.lng
+		r.weather = &weather{cfg: wc}
+	}
+
+	r.knx = cfg.KNX
+	if len(cfg.Modbus) > 0 {
+		r.modbus = newModbus(cfg.Modbus)
+	}
+
+	if cfg.WatchBridge {
+		r.bridge = &bridge{}
+	}
+
+	if cfg.Vacation != nil {
+		r.vacation = newVacation(*cfg.Vacation, *stateFile)
+		r.registerVacation()
+	}
+
+	r.Use(logActions)
+	r.Use(r.killSwitchActions)
+	r.Use(r.snoozeActions)
+	r.Use(r.arbitrateActions)
+	r.Use(r.protectRelays())
+	r.Use(r.rateLimitActions())
+	r.Use(r.confirmActions(r.confirm))
+	r.Use(r.detectLoops(r.loops))
+	r.Use(r.journal.recordActions)
+
+	r.timers.StateFile = *stateFile
+	r.timers.Debugf = func(format string, args ...any) { debugf(LOG_TIMERS, format, args...) }
+	r.defineSessionKinds()
+	r.registerTimerCallbacks()
+	r.registerCommands()
+	r.registerDebugCommands()
+	r.registerStatus()
+	r.registerRules()
+	r.registerSnooze()
+	r.registerKillSwitch()
+	r.registerSessionHooks()
+
+	if err := r.rules.Restore(); err != nil {
+		log.Printf("unable to restore rules: %v", err)
+	}
+	if err := r.killSwitch.Restore(); err != nil {
+		log.Printf("unable to restore automation switch: %v", err)
+	}
+	if err := r.counters.Restore(); err != nil {
+		log.Printf("unable to restore counters: %v", err)
+	}
+	if r.vacation != nil {
+		if err := r.vacation.Restore(); err != nil {
+			log.Printf("unable to restore vacation mode: %v", err)
+		}
+	}
+
+	return r, nil
+}
+
+// Connects to the broker, subscribing to the device and control topics on
+// each (re)connect
+func (r *regelwerk) connect(cfg *config, sim *simulator) mqtt.Client {
+	//mqtt.DEBUG = log.New(os.Stdout, "[MQTT]", 0)
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.Server).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetClientID("regelwerk").
+		SetDialer(&net.Dialer{KeepAlive: -1}).
+		SetKeepAlive(60 * time.Second).
+		SetPingTimeout(2 * time.Second).
+		SetConnectRetry(true)
+
+	var synced bool
+	opts.SetOnConnectHandler(func(c mqtt.Client) {
+		r.startSyncWindow()
+
+		filters := make(map[string]byte)
+		for _, topic := range r.SubscriptionTopics() {
+			filters[topic] = 0
+		}
+		tok := c.SubscribeMultiple(filters, r.handleMqtt)
+		if tok.Wait() && tok.Error() != nil {
+			log.Fatal(tok.Error())
+		}
+
+		tok = c.Subscribe(CONTROL_TOPIC_PREFIX+"#", 0, r.handleControl)
+		if tok.Wait() && tok.Error() != nil {
+			log.Fatal(tok.Error())
+		}
+
+		if sim != nil {
+			if err := sim.Subscribe(c); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		log.Printf("subscribed to %d MQTT topics", len(filters))
+		r.publishAutomation()
+
+		// find out where the devices are at, instead of relying on defaults
+		if !synced {
+			synced = true
+			r.RequestStates()
+		}
+	})
+
+	client := mqtt.NewClient(opts)
+	r.client = client
+
+	log.Printf("connecting to MQTT broker %v...", cfg.Server)
+	if tok := client.Connect(); tok.Wait() && tok.Error() != nil {
+		log.Printf("cannot connect to MQTT broker: %v\n", tok.Error())
+	}
+	return client
+}
+
+// Starts the servers and the background loops of the configured features
+func (r *regelwerk) startServices(ctx context.Context, cfg *config) {
+	if cfg.HTTPAddr != "" {
+		go r.serveHTTP(ctx, cfg.HTTPAddr)
+	}
+
+	if cfg.ControlSocket != "" {
+		r.socketPath = cfg.ControlSocket
+		go r.serveSocket(ctx, cfg.ControlSocket)
+	}
+
+	sdNotify("READY=1")
+	go r.runWatchdog(ctx)
+	go r.runStaleCheck(ctx)
+	go r.runSunSchedule(ctx)
+	if r.covers != nil {
+		go r.runCovers(ctx)
+	}
+	if r.thermostats != nil {
+		go r.runThermostats(ctx)
+	}
+	if r.queue != nil {
+		go r.queue.Run(ctx, r)
+	}
+	go handleDebugSignal(ctx)
+	if r.exporter != nil {
+		go r.exporter.Run(ctx, r.idle)
+	}
+	if r.tracer != nil {
+		go r.tracer.Run(ctx, r.idle)
+	}
+	if r.calendar != nil {
+		go r.runCalendar(ctx)
+	}
+	if r.weather != nil {
+		go r.weather.Run(ctx, r.idle)
+	}
+	if r.modbus != nil {
+		r.modbus.Run(ctx, r.idle)
+	}
+	if r.counters != nil {
+		go r.runCounters(ctx)
+	}
+	if r.energy != nil {
+		go r.runEnergy(ctx)
+	}
+	if r.logind != nil {
+		go r.runLogind(ctx)
+	}
+	if r.netPresence != nil {
+		go r.runNetPresence(ctx)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+)
+
+// A device as known to zigbee2mqtt, from its retained bridge/devices
+type z2mDevice struct {
+	IEEEAddress  string `json:"ieee_address"`
+	FriendlyName string `json:"friendly_name"`
+	Definition   *struct {
+		Model   string
+		Vendor  string
+		Exposes []expose
+	}
+}
+
+// A capability of a device, as described by zigbee2mqtt
+type expose struct {
+	Type     string // binary, numeric, enum, or a composite like light
+	Name     string
+	Property string
+	ValueOn  any `json:"value_on"`
+	ValueOff any `json:"value_off"`
+	Features []expose
+}
+
+// Maps zigbee2mqtt's friendly names to IEEE addresses and back, so that
+// devices can be configured by either, and keep working when renamed
+type registry struct {
+	mu     sync.Mutex
+	byIEEE map[string]*z2mDevice
+	byName map[string]*z2mDevice
+
+	// configured topic of each device, which may be an IEEE address or a
+	// friendly name it since lost
+	configured map[string]string // by IEEE address
+}
+
+func newRegistry() *registry {
+	return &registry{
+		byIEEE:     make(map[string]*z2mDevice),
+		byName:     make(map[string]*z2mDevice),
+		configured: make(map[string]string),
+	}
+}
+
+// Takes on the device list from bridge/devices, remembering which configured
+// device each IEEE address is, and deriving their state attributes
+func (r *regelwerk) updateRegistry(payload []byte) {
+	var devices []*z2mDevice
+	if err := json.Unmarshal(payload, &devices); err != nil {
+		log.Printf("error parsing bridge devices: %v", err)
+		return
+	}
+
+	reg := r.registry
+	reg.mu.Lock()
+
+	reg.byIEEE = make(map[string]*z2mDevice, len(devices))
+	reg.byName = make(map[string]*z2mDevice, len(devices))
+	for _, zd := range devices {
+		reg.byIEEE[zd.IEEEAddress] = zd
+		reg.byName[zd.FriendlyName] = zd
+
+		if topic, known := reg.configured[zd.IEEEAddress]; known {
+			if topic != zd.FriendlyName {
+				debugf(LOG_DEVICES, "dev %q is now named %q", topic, zd.FriendlyName)
+			}
+		} else if r.deviceByTopic(zd.FriendlyName) != nil {
+			reg.configured[zd.IEEEAddress] = zd.FriendlyName
+		} else if r.deviceByTopic(zd.IEEEAddress) != nil {
+			reg.configured[zd.IEEEAddress] = zd.IEEEAddress
+		}
+	}
+	configured := make(map[string]*z2mDevice, len(reg.configured))
+	for ieee, topic := range reg.configured {
+		if zd := reg.byIEEE[ieee]; zd != nil {
+			configured[topic] = zd
+		}
+	}
+	reg.mu.Unlock()
+
+	debugf(LOG_DEVICES, "zigbee2mqtt has %d devices", len(devices))
+	for topic, zd := range configured {
+		if d := r.deviceByTopic(topic); d != nil {
+			r.applyExposes(d, zd)
+		}
+	}
+}
+
+// Returns the configured topic of the device now under the friendly name,
+// or "" if it's not a configured device
+func (reg *registry) ConfiguredTopic(name string) string {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if zd := reg.byName[name]; zd != nil {
+		return reg.configured[zd.IEEEAddress]
+	}
+	return ""
+}
+
+// Returns the current friendly name of the configured device, which is
+// where zigbee2mqtt takes its commands
+func (reg *registry) Name(topic string) string {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	for ieee, t := range reg.configured {
+		if t == topic {
+			if zd := reg.byIEEE[ieee]; zd != nil {
+				return zd.FriendlyName
+			}
+		}
+	}
+	return topic
+}
+
+// Returns zigbee2mqtt's description of the configured device, if known
+func (reg *registry) Lookup(topic string) *z2mDevice {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	for ieee, t := range reg.configured {
+		if t == topic {
+			return reg.byIEEE[ieee]
+		}
+	}
+	return nil
+}
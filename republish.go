@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"regelwerk/mqttio"
+)
+
+// Republishes messages under another topic, optionally transformed, to adapt
+// payloads for other consumers, e.g.
+// {"From": "zigbee2mqtt/+", "To": "home/{{ index .Levels 1 }}/temperature",
+// "Template": "{{ json .Payload.temperature }}"}
+type republishConfig struct {
+	From     string // topic or pattern, including any zigbee2mqtt/ prefix
+	To       string // topic, may be a template
+	Template string // for the payload, the same as received if not set
+	Retain   bool
+}
+
+// Data available to republish templates
+type republishData struct {
+	Topic   string   // received on
+	Levels  []string // of the topic, split at /
+	Payload any      // decoded if JSON, or else a string
+}
+
+type republisher struct {
+	cfg      republishConfig
+	to, tmpl *template.Template // nil if not templates
+}
+
+func newRepublishers(cfgs []republishConfig) ([]*republisher, error) {
+	var reps []*republisher
+	for _, rc := range cfgs {
+		if rc.From == "" || rc.To == "" {
+			return nil, fmt.Errorf("Republish needs From and To")
+		} else if !strings.Contains(rc.To, "{{") && mqttio.MatchTopic(rc.From, rc.To) {
+			return nil, fmt.Errorf("republishing %q to %q would loop", rc.From, rc.To)
+		}
+
+		rp := &republisher{cfg: rc}
+		var err error
+		if strings.Contains(rc.To, "{{") {
+			if rp.to, err = template.New("to").Funcs(templateFuncs).Parse(rc.To); err != nil {
+				return nil, fmt.Errorf("bad Republish To %q: %v", rc.To, err)
+			}
+		}
+		if rc.Template != "" {
+			if rp.tmpl, err = template.New("republish").Funcs(templateFuncs).Parse(rc.Template); err != nil {
+				return nil, fmt.Errorf("bad Republish Template for %q: %v", rc.From, err)
+			}
+		}
+		reps = append(reps, rp)
+	}
+	return reps, nil
+}
+
+// Republishes the message for each rule it matches.
+// Called from the MQTT client's callback, so must not block.
+func (r *regelwerk) republishMessage(msg mqtt.Message) {
+	if len(r.republishers) == 0 {
+		return
+	}
+
+	var data *republishData // only decoded once a rule matches
+	for _, rp := range r.republishers {
+		if !mqttio.MatchTopic(rp.cfg.From, msg.Topic()) {
+			continue
+		}
+
+		if data == nil {
+			data = &republishData{Topic: msg.Topic(), Levels: strings.Split(msg.Topic(), "/")}
+			if json.Unmarshal(msg.Payload(), &data.Payload) != nil {
+				data.Payload = string(msg.Payload())
+			}
+		}
+
+		topic, payload, err := rp.transform(msg.Payload(), data)
+		if err != nil {
+			log.Printf("unable to republish %q: %v", msg.Topic(), err)
+			continue
+		} else if mqttio.MatchTopic(rp.cfg.From, topic) {
+			log.Printf("not republishing %q to %q, as it would loop", msg.Topic(), topic)
+			continue
+		}
+
+		debugf(LOG_MQTT, "republish %q to %q, payload %s", msg.Topic(), topic, payload)
+		r.client.Publish(topic, 0, rp.cfg.Retain, payload)
+	}
+}
+
+// Returns the topic and payload to republish as
+func (rp *republisher) transform(raw []byte, data *republishData) (string, []byte, error) {
+	topic := rp.cfg.To
+	if rp.to != nil {
+		var buf strings.Builder
+		if err := rp.to.Execute(&buf, data); err != nil {
+			return "", nil, err
+		}
+		topic = buf.String()
+	}
+	if topic == "" || mqttio.IsTopicPattern(topic) {
+		return "", nil, fmt.Errorf("bad topic %q", topic)
+	}
+
+	if rp.tmpl == nil {
+		return topic, raw, nil
+	}
+	var buf bytes.Buffer
+	if err := rp.tmpl.Execute(&buf, data); err != nil {
+		return "", nil, err
+	}
+	return topic, buf.Bytes(), nil
+}
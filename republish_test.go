@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestRepublish(t *testing.T) {
+	cfg := testConfig()
+	cfg.Republish = []republishConfig{
+		{From: "zigbee2mqtt/+", To: "home/{{ index .Levels 1 }}/contact", Template: "{{ json .Payload.contact }}"},
+		{From: "legacy/door", To: "other/door"},
+	}
+	r, mc := newTestRegelwerk(t, cfg)
+
+	r.handleMqtt(nil, &virtualMessage{topic: "zigbee2mqtt/door", payload: []byte(`{"contact": false}`)})
+	if got := mc.Payloads("home/door/contact"); len(got) != 1 || got[0] != "false" {
+		t.Errorf("wanted transformed payload, got %v", got)
+	}
+
+	r.handleMqtt(nil, &virtualMessage{topic: "legacy/door", payload: []byte("OPEN")})
+	if got := mc.Payloads("other/door"); len(got) != 1 || got[0] != "OPEN" {
+		t.Errorf("wanted payload as-is, got %v", got)
+	}
+
+	topics := r.SubscriptionTopics()
+	if len(topics) != 2 || topics[0] != "legacy/door" {
+		t.Errorf("wrong subscriptions %v", topics)
+	}
+}
+
+func TestRepublishLoop(t *testing.T) {
+	if _, err := newRepublishers([]republishConfig{{From: "home/#", To: "home/copy"}}); err == nil {
+		t.Errorf("republishing into its own topics accepted")
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Numeric samples over a sliding time window.
+// Not safe for concurrent use, callers hold their own lock.
+type rollingWindow struct {
+	window  time.Duration
+	samples []sample
+}
+
+type sample struct {
+	at time.Time
+	v  float64
+}
+
+// Statistics of a window's samples
+type windowSummary struct {
+	Count                  int
+	Min, Max, Mean, Median float64
+}
+
+func newRollingWindow(window time.Duration) *rollingWindow {
+	return &rollingWindow{window: window}
+}
+
+// Adds a sample, dropping those that fell out of the window
+func (w *rollingWindow) Add(at time.Time, v float64) {
+	w.samples = append(w.samples, sample{at, v})
+	w.trim(at)
+}
+
+func (w *rollingWindow) trim(now time.Time) {
+	cutoff := now.Add(-w.window)
+	i := 0
+	for i < len(w.samples) && w.samples[i].at.Before(cutoff) {
+		i++
+	}
+	w.samples = w.samples[i:]
+}
+
+// Returns the average of the samples, ok is false if there are none
+func (w *rollingWindow) Mean() (mean float64, ok bool) {
+	if len(w.samples) == 0 {
+		return 0, false
+	}
+
+	sum := 0.0
+	for _, s := range w.samples {
+		sum += s.v
+	}
+	return sum / float64(len(w.samples)), true
+}
+
+// Summarizes the samples within the window at the time.
+// ok is false if there are none.
+func (w *rollingWindow) Summary(now time.Time) (sum windowSummary, ok bool) {
+	w.trim(now)
+	if len(w.samples) == 0 {
+		return sum, false
+	}
+
+	values := make([]float64, len(w.samples))
+	sum.Min, sum.Max = math.Inf(1), math.Inf(-1)
+	for i, s := range w.samples {
+		values[i] = s.v
+		sum.Min = math.Min(sum.Min, s.v)
+		sum.Max = math.Max(sum.Max, s.v)
+	}
+	sum.Count = len(values)
+	sum.Mean, _ = w.Mean()
+
+	sort.Float64s(values)
+	if n := len(values); n%2 == 1 {
+		sum.Median = values[n/2]
+	} else {
+		sum.Median = (values[n/2-1] + values[n/2]) / 2
+	}
+	return sum, true
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRollingWindow(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	w := newRollingWindow(time.Hour)
+
+	if _, ok := w.Summary(start); ok {
+		t.Errorf("summary without samples")
+	}
+
+	for i, v := range []float64{40, 10, 30, 20} {
+		w.Add(start.Add(time.Duration(i)*20*time.Minute), v)
+	}
+
+	// the first sample is older than an hour
+	sum, _ := w.Summary(start.Add(time.Hour + time.Minute))
+	want := windowSummary{Count: 3, Min: 10, Max: 30, Mean: 20, Median: 20}
+	if sum != want {
+		t.Errorf("wanted %+v, got %+v", want, sum)
+	}
+
+	w.Add(start.Add(110*time.Minute), 50)
+	if sum, _ := w.Summary(start.Add(110 * time.Minute)); sum.Median != 35 || sum.Count != 2 {
+		t.Errorf("wanted median 35 of 2 samples, got %+v", sum)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"regelwerk/timers"
+)
+
+// rules that can be disabled at runtime
+const (
+	RULE_CONTACT   = "contact"   // contact sensor sessions
+	RULE_MOTION    = "motion"    // motion sensor sessions
+	RULE_BUTTONS   = "buttons"   // actions of remotes
+	RULE_OVERRIDES = "overrides" // switch button overrides
+	RULE_COVERS    = "covers"    // closing covers against the sun
+)
+
+var ruleNames = []string{RULE_CONTACT, RULE_MOTION, RULE_BUTTONS, RULE_OVERRIDES, RULE_COVERS}
+
+// The set of disabled rules, persisted next to the -state file so that it
+// survives restarts
+type ruleSet struct {
+	mu       sync.Mutex
+	disabled map[string]bool
+	file     string // empty if not persisted
+}
+
+func newRuleSet(stateFile string) *ruleSet {
+	rs := &ruleSet{disabled: make(map[string]bool)}
+	if stateFile != "" {
+		rs.file = filepath.Join(filepath.Dir(stateFile), "rules.json")
+	}
+	return rs
+}
+
+func (rs *ruleSet) Enabled(name string) bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return !rs.disabled[name]
+}
+
+// Enables or disables the rules, e.g. {"motion": false}
+func (rs *ruleSet) Set(states map[string]bool) error {
+	for name := range states {
+		if !isRule(name) {
+			return fmt.Errorf("unknown rule %q", name)
+		}
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	for name, on := range states {
+		if on {
+			delete(rs.disabled, name)
+		} else {
+			rs.disabled[name] = true
+		}
+		if on {
+			log.Printf("rule %q enabled", name)
+		} else {
+			log.Printf("rule %q disabled", name)
+		}
+	}
+	return rs.save()
+}
+
+// Returns whether each rule is enabled
+func (rs *ruleSet) List() map[string]bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	states := make(map[string]bool, len(ruleNames))
+	for _, name := range ruleNames {
+		states[name] = !rs.disabled[name]
+	}
+	return states
+}
+
+func isRule(name string) bool {
+	for _, r := range ruleNames {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Must be called with mu held
+func (rs *ruleSet) save() error {
+	if rs.file == "" {
+		return nil
+	}
+
+	var disabled []string
+	for name := range rs.disabled {
+		disabled = append(disabled, name)
+	}
+	sort.Strings(disabled)
+
+	js, _ := json.Marshal(disabled)
+	return timers.WriteFileAtomic(rs.file, js)
+}
+
+// Loads the disabled rules, if they were saved
+func (rs *ruleSet) Restore() error {
+	if rs.file == "" {
+		return nil
+	}
+
+	js, err := os.ReadFile(rs.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var disabled []string
+	if err := json.Unmarshal(js, &disabled); err != nil {
+		return err
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for _, name := range disabled {
+		rs.disabled[name] = true
+		log.Printf("rule %q is disabled", name)
+	}
+	return nil
+}
+
+func (r *regelwerk) registerRules() {
+	r.AddStatus("rules", func() any { return r.rules.List() })
+
+	r.HandleCommand("rules/set", func(ctx context.Context, payload []byte) error {
+		var states map[string]bool
+		if err := json.Unmarshal(payload, &states); err != nil {
+			return err
+		}
+		if err := r.rules.Set(states); err != nil {
+			return err
+		}
+		r.publishJSON("rules", r.rules.List())
+		return nil
+	})
+}
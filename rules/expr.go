@@ -0,0 +1,452 @@
+// Package rules has the condition language of regelwerk's rules, and
+// JSONPath queries, to evaluate over decoded JSON payloads and state.
+package rules
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"regelwerk/mqttio"
+)
+
+// A parsed condition expression, e.g.
+// payload.occupancy && !devices.switch.on && (sun.isDark || payload.illuminance < 20)
+//
+// Supports &&, ||, !, comparisons (== != < <= > >=), parentheses, numbers,
+// "strings" or 'strings', true, false, null and dotted paths into the
+// environment, which can index arrays like payload.actions[0]. Path elements
+// may contain - and /, like devices.zigbee2mqtt/0x00158d00.on, and others
+// can be quoted, like devices['living room'].on. Paths that don't exist
+// evaluate to null.
+//
+// jsonpath(value, '$...') queries a value with a JSONPath, see JSONPath, and
+// len(value) is the length of a list, object or string.
+type Expr interface {
+	Eval(env map[string]any) any
+}
+
+type (
+	literal struct{ v any }
+	path    []string
+	not     struct{ x Expr }
+	length  struct{ x Expr }
+	query   struct {
+		x    Expr
+		path JSONPath
+	}
+	binary struct {
+		op   string
+		l, r Expr
+	}
+)
+
+func (e literal) Eval(env map[string]any) any { return e.v }
+
+func (e path) Eval(env map[string]any) any {
+	v, _ := mqttio.LookupElems(env, e)
+	return v
+}
+
+func (e not) Eval(env map[string]any) any { return !Truthy(e.x.Eval(env)) }
+
+func (e length) Eval(env map[string]any) any {
+	switch v := e.x.Eval(env).(type) {
+	case []any:
+		return float64(len(v))
+	case map[string]any:
+		return float64(len(v))
+	case string:
+		return float64(len(v))
+	}
+	return nil
+}
+
+func (e query) Eval(env map[string]any) any { return e.path.Query(e.x.Eval(env)) }
+
+func (e binary) Eval(env map[string]any) any {
+	switch e.op {
+	case "&&":
+		return Truthy(e.l.Eval(env)) && Truthy(e.r.Eval(env))
+	case "||":
+		return Truthy(e.l.Eval(env)) || Truthy(e.r.Eval(env))
+	}
+
+	l, r := e.l.Eval(env), e.r.Eval(env)
+	switch e.op {
+	case "==":
+		return Equal(l, r)
+	case "!=":
+		return !Equal(l, r)
+	}
+
+	// ordering only makes sense for two numbers or two strings
+	var c int
+	if lf, ok := l.(float64); ok {
+		rf, ok := r.(float64)
+		if !ok {
+			return false
+		}
+		c = compare(lf, rf)
+	} else if ls, ok := l.(string); ok {
+		rs, ok := r.(string)
+		if !ok {
+			return false
+		}
+		c = strings.Compare(ls, rs)
+	} else {
+		return false
+	}
+
+	switch e.op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default: // >=
+		return c >= 0
+	}
+}
+
+// Compares two values, where lists and objects are never equal, as they
+// can't be compared with ==
+func Equal(l, r any) bool {
+	switch l.(type) {
+	case []any, map[string]any:
+		return false
+	}
+	switch r.(type) {
+	case []any, map[string]any:
+		return false
+	}
+	return l == r
+}
+
+func compare(a, b float64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+// Whether the value counts as true: anything but null, false, 0 and ""
+func Truthy(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	}
+	return true
+}
+
+// Parses a condition expression
+func Parse(s string) (Expr, error) {
+	toks, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{toks: toks}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	} else if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected %q", p.toks[p.pos])
+	}
+	return e, nil
+}
+
+// Splits the expression into operators, parentheses, numbers, quoted
+// strings and identifiers/paths
+func tokenize(s string) ([]string, error) {
+	var toks []string
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case strings.HasPrefix(s[i:], "&&") || strings.HasPrefix(s[i:], "||") ||
+			strings.HasPrefix(s[i:], "==") || strings.HasPrefix(s[i:], "!=") ||
+			strings.HasPrefix(s[i:], "<=") || strings.HasPrefix(s[i:], ">="):
+			toks = append(toks, s[i:i+2])
+			i += 2
+		case strings.IndexByte("!<>(),", c) >= 0:
+			toks = append(toks, s[i:i+1])
+			i++
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(s) && s[j] != c {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			toks = append(toks, s[i:j+1])
+			i = j + 1
+		case c == '-' || c == '.' || c == '_' || c == '@' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)):
+			j := i + 1
+			for j < len(s) && (isPathChar(s[j]) || s[j] == '[') {
+				if s[j] != '[' {
+					j++
+					continue
+				}
+				n, err := keyLen(s[j:])
+				if err != nil {
+					return nil, fmt.Errorf("%v in %q", err, s[i:])
+				}
+				j += n
+			}
+			toks = append(toks, s[i:j])
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q", c)
+		}
+	}
+	return toks, nil
+}
+
+func isPathChar(c byte) bool {
+	return c == '.' || c == '_' || c == '-' || c == '/' ||
+		unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
+
+// Returns the length of the index or quoted key in brackets at the start of
+// s, like [0] or ['living room']
+func keyLen(s string) (int, error) {
+	if len(s) > 1 && (s[1] == '"' || s[1] == '\'') {
+		end := strings.IndexByte(s[2:], s[1])
+		if end < 0 || 2+end+1 >= len(s) || s[2+end+1] != ']' {
+			return 0, fmt.Errorf("unterminated key")
+		}
+		return 2 + end + 2, nil
+	}
+
+	n := 1
+	for n < len(s) && unicode.IsDigit(rune(s[n])) {
+		n++
+	}
+	if n == 1 || n >= len(s) || s[n] != ']' {
+		return 0, fmt.Errorf("expected an index or quoted key after [")
+	}
+	return n + 1, nil
+}
+
+// Splits a path like payload.actions[0] or devices['living room'].on into
+// its elements. Brackets must have been checked by keyLen.
+func splitPath(tok string) []string {
+	var elems []string
+	var cur strings.Builder
+	for i := 0; i < len(tok); i++ {
+		switch c := tok[i]; c {
+		case '.':
+			elems = append(elems, cur.String())
+			cur.Reset()
+		case '[':
+			elems = append(elems, cur.String())
+			cur.Reset()
+			n, err := keyLen(tok[i:])
+			if err != nil {
+				return nil
+			}
+			if q := tok[i+1]; q == '"' || q == '\'' {
+				cur.WriteString(tok[i+2 : i+n-2])
+			} else {
+				cur.WriteString(tok[i+1 : i+n-1])
+			}
+			i += n - 1 // at the ]
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	return append(elems, cur.String())
+}
+
+type parser struct {
+	toks []string
+	pos  int
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	return p.parseChain("||", p.parseAnd)
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	return p.parseChain("&&", p.parseNot)
+}
+
+func (p *parser) parseChain(op string, next func() (Expr, error)) (Expr, error) {
+	l, err := next()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek() == op {
+		p.pos++
+		r, err := next()
+		if err != nil {
+			return nil, err
+		}
+		l = binary{op, l, r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseNot() (Expr, error) {
+	if p.peek() == "!" {
+		p.pos++
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return not{x}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *parser) parseCompare() (Expr, error) {
+	l, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	switch op := p.peek(); op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		p.pos++
+		r, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		return binary{op, l, r}, nil
+	}
+	return l, nil
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	tok := p.peek()
+	p.pos++
+
+	switch {
+	case tok == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case tok == "(":
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		} else if p.peek() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return e, nil
+	case tok == "true":
+		return literal{true}, nil
+	case tok == "false":
+		return literal{false}, nil
+	case tok == "null":
+		return literal{nil}, nil
+	case tok[0] == '"' || tok[0] == '\'':
+		s, err := unquote(tok)
+		if err != nil {
+			return nil, fmt.Errorf("bad string %s", tok)
+		}
+		return literal{s}, nil
+	case tok[0] == '-' || tok[0] == '.' || unicode.IsDigit(rune(tok[0])):
+		f, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q", tok)
+		}
+		return literal{f}, nil
+	case unicode.IsLetter(rune(tok[0])) && p.peek() == "(":
+		return p.parseCall(tok)
+	case unicode.IsLetter(rune(tok[0])) || tok[0] == '_' || tok[0] == '@':
+		return path(splitPath(tok)), nil
+	}
+	return nil, fmt.Errorf("unexpected %q", tok)
+}
+
+// Parses the arguments of a function call, with the name already taken
+func (p *parser) parseCall(name string) (Expr, error) {
+	p.pos++ // (
+	var args []Expr
+	for p.peek() != ")" {
+		if len(args) > 0 {
+			if p.peek() != "," {
+				return nil, fmt.Errorf("missing , or ) in %s()", name)
+			}
+			p.pos++
+		}
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.pos++
+
+	switch name {
+	case "len":
+		if len(args) == 1 {
+			return length{args[0]}, nil
+		}
+	case "jsonpath":
+		// the path is parsed once here, so it has to be a literal
+		if len(args) != 2 {
+			break
+		} else if lit, ok := args[1].(literal); ok {
+			if s, ok := lit.v.(string); ok {
+				jp, err := ParseJSONPath(s)
+				if err != nil {
+					return nil, err
+				}
+				return query{args[0], jp}, nil
+			}
+		}
+		return nil, fmt.Errorf("jsonpath() needs a path string")
+	default:
+		return nil, fmt.Errorf("unknown function %s()", name)
+	}
+	return nil, fmt.Errorf("wrong number of arguments for %s()", name)
+}
+
+// Unquotes "double" or 'single' quoted strings, with backslash escapes
+func unquote(tok string) (string, error) {
+	if tok[0] == '"' {
+		return strconv.Unquote(tok)
+	}
+
+	var b strings.Builder
+	for i := 1; i < len(tok)-1; i++ {
+		if tok[i] == '\\' && i+1 < len(tok)-1 {
+			i++
+		}
+		b.WriteByte(tok[i])
+	}
+	return b.String(), nil
+}
+
+// Parses the condition, which is nil if not set
+func ParseCondition(cond string) (Expr, error) {
+	if cond == "" {
+		return nil, nil
+	}
+	return Parse(cond)
+}
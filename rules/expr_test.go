@@ -0,0 +1,72 @@
+package rules
+
+import "testing"
+
+func TestExpr(t *testing.T) {
+	env := map[string]any{
+		"payload": map[string]any{"occupancy": true, "illuminance": 12.0, "action": "single"},
+		"devices": map[string]any{
+			"switch": map[string]any{"state": "OFF", "on": false},
+		},
+		"sun": map[string]any{"isDark": false},
+	}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`payload.occupancy && !devices.switch.on && (sun.isDark || payload.illuminance < 20)`, true},
+		{`payload.illuminance >= 20`, false},
+		{`payload.action == "single"`, true},
+		{`devices.switch.state != "OFF"`, false},
+		{`payload.missing`, false},
+		{`payload.missing.deeper == null`, true},
+		{`!sun.isDark || false`, true},
+		{`payload.illuminance > -1.5`, true},
+	}
+	for _, tt := range tests {
+		e, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tt.expr, err)
+		} else if got := Truthy(e.Eval(env)); got != tt.want {
+			t.Errorf("%s: wanted %v got %v", tt.expr, tt.want, got)
+		}
+	}
+
+	for _, bad := range []string{`(a && b`, `a &&`, `a = b`, `"open`, `a b`,
+		`devices['open.on`, `1st_floor/lamp`, `a[`, `payload.x[`, `a['b'`, `a[b]`} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("%s: expected error", bad)
+		}
+	}
+}
+
+func TestExprDevicePaths(t *testing.T) {
+	on := map[string]any{"on": true}
+	env := map[string]any{
+		"devices": map[string]any{
+			"0x00158d00":                   on,
+			"zigbee2mqtt/0x00158d00":       on,
+			"1st_floor/lamp":               on,
+			"living-room":                  on,
+			"garden lights":                on,
+			"zigbee2mqtt/garden [outdoor]": on,
+		},
+	}
+
+	for _, cond := range []string{
+		`devices.0x00158d00.on`,
+		`devices.zigbee2mqtt/0x00158d00.on`,
+		`devices.1st_floor/lamp.on && devices.living-room.on`,
+		`devices['garden lights'].on`,
+		`devices["zigbee2mqtt/garden [outdoor]"].on == true`,
+		`devices["1st_floor/lamp"]['on']`,
+	} {
+		e, err := Parse(cond)
+		if err != nil {
+			t.Errorf("%s: %v", cond, err)
+		} else if !Truthy(e.Eval(env)) {
+			t.Errorf("%s: not true", cond)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package rules
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A parsed JSONPath, for values that dotted paths can't reach, e.g.
+// $.devices[-1].name, $..temperature or $.zones[?(@.open == true)].name
+//
+// Supports the root $, .key and ['key'], array indexes (negative from the
+// end), * for all children, .. for all descendants, and filters with a
+// condition over @, the child being filtered.
+type JSONPath struct {
+	steps    []pathStep
+	definite bool // at most one match, with no wildcards, .. or filters
+}
+
+type pathStep struct {
+	key       string // a map key, or "*" for all children
+	index     *int   // an array index instead
+	filter    Expr   // children for which it's true instead
+	recursive bool   // applies to all descendants
+}
+
+func ParseJSONPath(s string) (JSONPath, error) {
+	jp := JSONPath{definite: true}
+	if !strings.HasPrefix(s, "$") {
+		return jp, fmt.Errorf("JSONPath %q needs to start with $", s)
+	}
+
+	rest := s[1:]
+	for rest != "" {
+		var st pathStep
+		var err error
+
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			st.recursive = true
+			if st.key, rest = jsonPathName(rest[2:]); st.key == "" {
+				if !strings.HasPrefix(rest, "[") {
+					return jp, fmt.Errorf("JSONPath %q: missing name after ..", s)
+				}
+				// e.g. $..[0], the bracket is the step
+				if st, rest, err = parseJSONPathBracket(rest); err != nil {
+					return jp, fmt.Errorf("JSONPath %q: %v", s, err)
+				}
+				st.recursive = true
+			}
+		case rest[0] == '.':
+			if st.key, rest = jsonPathName(rest[1:]); st.key == "" {
+				return jp, fmt.Errorf("JSONPath %q: missing name after .", s)
+			}
+		case rest[0] == '[':
+			if st, rest, err = parseJSONPathBracket(rest); err != nil {
+				return jp, fmt.Errorf("JSONPath %q: %v", s, err)
+			}
+		default:
+			return jp, fmt.Errorf("JSONPath %q: unexpected %q", s, rest)
+		}
+
+		if st.recursive || st.key == "*" || st.filter != nil {
+			jp.definite = false
+		}
+		jp.steps = append(jp.steps, st)
+	}
+	return jp, nil
+}
+
+// Splits off a name, up to the next step
+func jsonPathName(s string) (string, string) {
+	i := strings.IndexAny(s, ".[")
+	if i < 0 {
+		i = len(s)
+	}
+	return s[:i], s[i:]
+}
+
+// Parses a [...] step: an index, a quoted key, * or a ?(filter)
+func parseJSONPathBracket(s string) (pathStep, string, error) {
+	var st pathStep
+
+	if strings.HasPrefix(s, "[?(") {
+		// find the matching parenthesis, skipping strings
+		depth, quote := 0, byte(0)
+		for i := 2; i < len(s); i++ {
+			switch c := s[i]; {
+			case quote != 0:
+				if c == '\\' {
+					i++
+				} else if c == quote {
+					quote = 0
+				}
+			case c == '"' || c == '\'':
+				quote = c
+			case c == '(':
+				depth++
+			case c == ')':
+				if depth--; depth == 0 {
+					if i+1 >= len(s) || s[i+1] != ']' {
+						return st, "", fmt.Errorf("missing ] after filter")
+					}
+					e, err := Parse(s[3:i])
+					if err != nil {
+						return st, "", fmt.Errorf("bad filter: %v", err)
+					}
+					st.filter = e
+					return st, s[i+2:], nil
+				}
+			}
+		}
+		return st, "", fmt.Errorf("unterminated filter")
+	}
+
+	end := strings.IndexByte(s, ']')
+	if end < 0 {
+		return st, "", fmt.Errorf("missing ]")
+	}
+	inner, rest := strings.TrimSpace(s[1:end]), s[end+1:]
+
+	switch {
+	case inner == "*":
+		st.key = "*"
+	case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+		st.key = inner[1 : len(inner)-1]
+	default:
+		i, err := strconv.Atoi(inner)
+		if err != nil {
+			return st, "", fmt.Errorf("bad index %q", inner)
+		}
+		st.index = &i
+	}
+	return st, rest, nil
+}
+
+// Returns all matches within the decoded JSON value
+func (jp JSONPath) Eval(v any) []any {
+	nodes := []any{v}
+	for _, st := range jp.steps {
+		var next []any
+		for _, n := range nodes {
+			if st.recursive {
+				for _, d := range descendants(n, nil) {
+					next = st.apply(d, next)
+				}
+			} else {
+				next = st.apply(n, next)
+			}
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+// Evaluates the path like in conditions: definite paths return their match
+// or null, others the list of matches
+func (jp JSONPath) Query(v any) any {
+	matches := jp.Eval(v)
+	if !jp.definite {
+		if matches == nil {
+			return []any{}
+		}
+		return matches
+	} else if len(matches) == 0 {
+		return nil
+	}
+	return matches[0]
+}
+
+// Appends the step's matches within the node
+func (st pathStep) apply(n any, out []any) []any {
+	switch {
+	case st.index != nil:
+		if list, ok := n.([]any); ok {
+			i := *st.index
+			if i < 0 {
+				i += len(list)
+			}
+			if i >= 0 && i < len(list) {
+				out = append(out, list[i])
+			}
+		}
+
+	case st.key == "*" || st.filter != nil:
+		for _, c := range children(n) {
+			if st.filter == nil || Truthy(st.filter.Eval(map[string]any{"@": c})) {
+				out = append(out, c)
+			}
+		}
+
+	default:
+		if m, ok := n.(map[string]any); ok {
+			if v, found := m[st.key]; found {
+				out = append(out, v)
+			}
+		}
+	}
+	return out
+}
+
+// The values of an object in key order, or the elements of an array
+func children(n any) []any {
+	switch n := n.(type) {
+	case map[string]any:
+		list := make([]any, 0, len(n))
+		keys := make([]string, 0, len(n))
+		for k := range n {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			list = append(list, n[k])
+		}
+		return list
+	case []any:
+		return n
+	}
+	return nil
+}
+
+// Appends the node and everything below it, depth first
+func descendants(n any, out []any) []any {
+	out = append(out, n)
+	for _, c := range children(n) {
+		out = descendants(c, out)
+	}
+	return out
+}
+
+// parsed paths of templates, as they're parsed on each expansion
+var jsonPathCache sync.Map
+
+// Queries the value with the path, like in conditions, parsing each path
+// only once. For templates, e.g. {{ jsonpath .Payload "$.zones[0].name" }}
+func QueryJSONPath(v any, path string) (any, error) {
+	if jp, ok := jsonPathCache.Load(path); ok {
+		return jp.(JSONPath).Query(v), nil
+	}
+
+	jp, err := ParseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+	jsonPathCache.Store(path, jp)
+	return jp.Query(v), nil
+}
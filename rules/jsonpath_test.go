@@ -0,0 +1,76 @@
+package rules
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestJSONPath(t *testing.T) {
+	var doc any
+	json.Unmarshal([]byte(`{
+		"zones": [
+			{"name": "kitchen", "open": true, "sensor": {"temperature": 21.5}},
+			{"name": "hall", "open": false, "sensor": {"temperature": 19}}
+		],
+		"odd.key": 1
+	}`), &doc)
+
+	tests := []struct {
+		path string
+		want any
+	}{
+		{"$.zones[0].name", "kitchen"},
+		{"$.zones[-1].name", "hall"},
+		{"$['odd.key']", 1.0},
+		{"$.zones[5].name", nil},
+		{"$.zones[*].name", []any{"kitchen", "hall"}},
+		{"$..temperature", []any{21.5, 19.0}},
+		{"$.zones[?(@.open == true)].name", []any{"kitchen"}},
+		{"$.zones[?(@.sensor.temperature < 20 && !@.open)].name", []any{"hall"}},
+		{"$.zones[?(@.name == 'attic')]", []any{}},
+	}
+	for _, tt := range tests {
+		jp, err := ParseJSONPath(tt.path)
+		if err != nil {
+			t.Errorf("%s: %v", tt.path, err)
+		} else if got := jp.Query(doc); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: wanted %#v, got %#v", tt.path, tt.want, got)
+		}
+	}
+
+	for _, bad := range []string{"zones", "$.", "$[x]", "$[0", "$[?(@.a]"} {
+		if _, err := ParseJSONPath(bad); err == nil {
+			t.Errorf("%s: expected error", bad)
+		}
+	}
+}
+
+func TestJSONPathConditions(t *testing.T) {
+	env := map[string]any{
+		"payload": map[string]any{
+			"actions": []any{"single", "double"},
+			"zones":   []any{map[string]any{"open": true}, map[string]any{"open": true}},
+		},
+	}
+
+	for _, cond := range []string{
+		`payload.actions[1] == "double"`,
+		`len(jsonpath(payload, '$.zones[?(@.open)]')) == 2`,
+		`jsonpath(payload, "$.actions[-1]") == 'double'`,
+		`payload.zones != null`,
+	} {
+		e, err := Parse(cond)
+		if err != nil {
+			t.Errorf("%s: %v", cond, err)
+		} else if !Truthy(e.Eval(env)) {
+			t.Errorf("%s: not true", cond)
+		}
+	}
+
+	for _, bad := range []string{`jsonpath(payload)`, `jsonpath(payload, payload.path)`, `nope(1)`, `len(1, 2)`} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("%s: expected error", bad)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// One publish of a scene, either to a z2m device or an arbitrary topic
+type sceneStep struct {
+	Device  string // z2m device, the payload is sent to its set topic
+	Topic   string // full MQTT topic, if not a z2m device
+	Payload any    // can be a template string, see templateData
+}
+
+func (st *sceneStep) action(ctx context.Context, r *regelwerk) (*action, error) {
+	payload, err := r.renderPayload(ctx, st.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("payload template: %v", err)
+	}
+
+	if st.Device != "" {
+		if d := r.deviceByTopic(st.Device); d != nil {
+			return &action{dev: d, payload: payload}, nil
+		}
+		return &action{topic: MQTT_TOPIC_PREFIX + st.Device + "/set", payload: payload}, nil
+	}
+	return &action{topic: st.Topic, payload: payload}, nil
+}
+
+// Sends out all publishes of the named scene
+func (r *regelwerk) ActivateScene(ctx context.Context, name string) error {
+	scene, found := r.scenes[name]
+	if !found {
+		return fmt.Errorf("unknown scene %q", name)
+	}
+
+	log.Printf("activating scene %q", name)
+	for i := range scene {
+		a, err := scene[i].action(ctx, r)
+		if err != nil {
+			log.Printf("scene %q step %d: %v", name, i+1, err)
+			continue
+		}
+		a.source = SOURCE_SCENE
+		r.Do(ctx, a)
+	}
+	return nil
+}
+
+// Checks that each step has a target
+func validateScenes(scenes map[string][]sceneStep) error {
+	for name, scene := range scenes {
+		for i, st := range scene {
+			if (st.Device == "") == (st.Topic == "") {
+				return fmt.Errorf("scene %q step %d needs either Device or Topic", name, i+1)
+			} else if st.Payload == nil {
+				return fmt.Errorf("scene %q step %d has no Payload", name, i+1)
+			} else if err := validatePayload(st.Payload); err != nil {
+				return fmt.Errorf("scene %q step %d: %v", name, i+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+func init() {
+	actionTypes["activate_scene"] = func(ctx context.Context, r *regelwerk, spec *actionSpec) error {
+		return r.ActivateScene(ctx, spec.Scene)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Sends a notification to systemd, if we are running as a notify service.
+// Errors are ignored, as there's nothing much we can do about them.
+func sdNotify(state string) {
+	sock := os.Getenv("NOTIFY_SOCKET")
+	if sock == "" {
+		return
+	}
+
+	// abstract namespace socket
+	if sock[0] == '@' {
+		sock = "\x00" + sock[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: sock, Net: "unixgram"})
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	conn.Write([]byte(state))
+}
+
+// Returns the watchdog interval requested by systemd, or 0 if none
+func sdWatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// Pings the systemd watchdog as long as we are connected to the broker.
+// If the connection is lost and doesn't come back, systemd will restart us.
+func (r *regelwerk) runWatchdog(ctx context.Context) {
+	interval := sdWatchdogInterval()
+	if interval == 0 {
+		return
+	}
+
+	t := time.NewTicker(interval / 2)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			if r.client.IsConnectionOpen() {
+				sdNotify("WATCHDOG=1")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
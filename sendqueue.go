@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Outgoing commands are queued and published with QoS 1, and retried with
+// exponential backoff if the broker doesn't acknowledge them within Timeout.
+// A newer command to the same topic replaces a queued one, so that stale
+// commands aren't sent after a reconnect, e.g. while a device is busy with
+// an OTA update.
+type sendQueueConfig struct {
+	Retries int
+	Backoff textDuration // before the first retry, doubling after that
+	Timeout textDuration // for the broker's acknowledgement
+}
+
+type sendQueue struct {
+	cfg sendQueueConfig
+
+	mu      sync.Mutex
+	pending map[string]*queuedPublish // by topic
+	wake    chan struct{}
+}
+
+type queuedPublish struct {
+	ctx      context.Context // of the action
+	topic    string
+	payload  []byte
+	attempts int
+	next     time.Time // when to (re)send
+}
+
+func newSendQueue(cfg *sendQueueConfig) *sendQueue {
+	if cfg == nil {
+		return nil
+	}
+
+	q := &sendQueue{
+		cfg:     *cfg,
+		pending: make(map[string]*queuedPublish),
+		wake:    make(chan struct{}, 1),
+	}
+	if q.cfg.Backoff <= 0 {
+		q.cfg.Backoff = textDuration(time.Second)
+	}
+	if q.cfg.Timeout <= 0 {
+		q.cfg.Timeout = textDuration(5 * time.Second)
+	}
+	return q
+}
+
+// Queues the payload, replacing any not yet sent to the same topic
+func (q *sendQueue) Enqueue(ctx context.Context, topic string, payload []byte) {
+	q.mu.Lock()
+	if old := q.pending[topic]; old != nil {
+		debugf(LOG_ACTIONS, "dropping superseded command to %s: %s", topic, old.payload)
+	}
+	q.pending[topic] = &queuedPublish{ctx: ctx, topic: topic, payload: payload, next: time.Now()}
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Returns the number of commands waiting to be sent
+func (q *sendQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// Sends queued commands until the context is done
+func (q *sendQueue) Run(ctx context.Context, r *regelwerk) {
+	for {
+		qp, wait := q.due(time.Now())
+		if qp != nil {
+			q.send(ctx, r, qp)
+			continue
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-q.wake:
+		case <-t.C:
+		}
+		t.Stop()
+	}
+}
+
+// Returns the next command that is due, or how long until one is
+func (q *sendQueue) due(now time.Time) (*queuedPublish, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	wait := time.Hour
+	for _, qp := range q.pending {
+		if !qp.next.After(now) {
+			return qp, 0
+		} else if d := qp.next.Sub(now); d < wait {
+			wait = d
+		}
+	}
+	return nil, wait
+}
+
+func (q *sendQueue) send(ctx context.Context, r *regelwerk, qp *queuedPublish) {
+	// wait for the connection without using up retries
+	if !r.client.IsConnectionOpen() {
+		q.mu.Lock()
+		qp.next = time.Now().Add(time.Duration(q.cfg.Backoff))
+		q.mu.Unlock()
+		return
+	}
+
+	r.pubStats.sent.Add(1)
+	tok := r.client.Publish(qp.topic, 1, false, qp.payload)
+	err := waitToken(ctx, tok, time.Duration(q.cfg.Timeout))
+	if err != nil && ctx.Err() == nil {
+		r.publishFailed(qp.topic, err)
+	}
+
+	q.mu.Lock()
+	if q.pending[qp.topic] != qp {
+		q.mu.Unlock()
+		return // superseded while sending
+	} else if err == nil {
+		delete(q.pending, qp.topic)
+		q.mu.Unlock()
+		return
+	}
+
+	qp.attempts++
+	if qp.attempts > q.cfg.Retries {
+		delete(q.pending, qp.topic)
+		q.mu.Unlock()
+
+		log.Printf("giving up on %s after %d attempts", qp.topic, qp.attempts)
+		r.commandLost(qp.ctx, qp.topic)
+		return
+	}
+
+	backoff := time.Duration(q.cfg.Backoff) << (qp.attempts - 1)
+	qp.next = time.Now().Add(backoff)
+	q.mu.Unlock()
+
+	debugf(LOG_ACTIONS, "retrying %s in %s", qp.topic, backoff)
+}
+
+// Waits for the token to complete, up to the timeout or until the context
+// is done, returning its error
+func waitToken(ctx context.Context, tok mqtt.Token, timeout time.Duration) error {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	select {
+	case <-tok.Done():
+		return tok.Error()
+	case <-t.C:
+		return errors.New("timed out")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Fails the first publishes, then passes them on
+type flakyClient struct {
+	mockClient
+	mu       sync.Mutex
+	failures int
+}
+
+type failedToken struct{ mockToken }
+
+func (*failedToken) Error() error { return errors.New("no ack") }
+
+func (c *flakyClient) Publish(topic string, qos byte, retained bool, payload any) mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.failures > 0 {
+		c.failures--
+		return &failedToken{}
+	}
+	return c.mockClient.Publish(topic, qos, retained, payload)
+}
+
+func TestSendQueue(t *testing.T) {
+	r, _ := newTestRegelwerk(t, testConfig())
+	fc := &flakyClient{failures: 2}
+	r.client = fc
+
+	q := newSendQueue(&sendQueueConfig{Retries: 2, Backoff: textDuration(time.Millisecond)})
+	q.Enqueue(r.ctx, "test/cmd", []byte("first"))
+	q.Enqueue(r.ctx, "test/cmd", []byte("second")) // replaces the first
+	q.Enqueue(r.ctx, "test/other", []byte("other"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx, r)
+
+	if got := fc.WaitFor(t, "test/cmd", 1); len(got) != 1 || got[0] != "second" {
+		t.Errorf("wanted only the latest command sent, got %v", got)
+	}
+	fc.WaitFor(t, "test/other", 1)
+
+	// acknowledged commands are removed right after publishing
+	deadline := time.Now().Add(time.Second)
+	for q.Len() != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := q.Len(); n != 0 {
+		t.Errorf("wanted empty queue, got %d", n)
+	}
+}
+
+func TestPublishFailure(t *testing.T) {
+	cfg := testConfig()
+	cfg.Actions = map[string]*actionSpec{
+		"notify": {Type: "publish", Topic: "test/failed", Payload: "{{ .Payload.command }}"},
+	}
+	cfg.OnPublishFailure = "notify"
+	r, _ := newTestRegelwerk(t, cfg)
+	fc := &flakyClient{failures: 1}
+	r.client = fc
+
+	r.Do(r.ctx, &action{topic: "test/cmd", payload: "ON"})
+
+	got := fc.WaitFor(t, "test/failed", 1)
+	if got[0] != "test/cmd ON" {
+		t.Errorf("wrong failure notification %q", got[0])
+	}
+	if n := r.pubStats.dropped.Load(); n != 1 {
+		t.Errorf("wanted 1 dropped command, got %d", n)
+	}
+}
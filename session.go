@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"regelwerk/timers"
+)
+
+// A session is the period the lights are on because of a contact or motion
+// sensor. It goes through these states:
+//
+//	idle -> active -> pending_off -> off -> idle
+//
+// pending_off goes back to active if the sensor triggers again during the
+// countdown, and active or pending_off sessions can be discarded straight
+// back to idle. The timing itself is done by a timer of the same name.
+type sessionState string
+
+const (
+	SESSION_IDLE        sessionState = "idle"
+	SESSION_ACTIVE      sessionState = "active"      // sensor triggered, lights on
+	SESSION_PENDING_OFF sessionState = "pending_off" // sensors clear, counting down
+	SESSION_OFF         sessionState = "off"         // lights being turned off
+)
+
+var sessionTransitions = map[sessionState][]sessionState{
+	SESSION_IDLE:        {SESSION_ACTIVE},
+	SESSION_ACTIVE:      {SESSION_PENDING_OFF, SESSION_OFF, SESSION_IDLE},
+	SESSION_PENDING_OFF: {SESSION_ACTIVE, SESSION_OFF, SESSION_IDLE},
+	SESSION_OFF:         {SESSION_IDLE},
+}
+
+// How a kind of session reacts to its sensors. The session is named after the
+// ID of the sensors triggering it.
+type sessionKind struct {
+	name    string
+	on, off any // sensor states that trigger the session, and clear it
+
+	expiry   time.Duration // of the session, 0 for none
+	offDelay func(now time.Time) time.Duration
+	onExpiry []string // EXPIRY_* behaviors, default reset
+
+	// sessions of other kinds this one replaces when triggered during them
+	takesOver []string
+
+	// optional: called when the session is triggered again during the
+	// countdown, and whether triggers shouldn't start a session
+	retriggered func(now time.Time)
+	suppressed  func(now time.Time) bool
+}
+
+// Returns the kind of session the sensor ID triggers, nil if none
+func (r *regelwerk) sessionKind(id string) *sessionKind {
+	for _, k := range r.sessionKinds {
+		if k.name == id {
+			return k
+		}
+	}
+	return nil
+}
+
+// Discards a session the kind takes over, returning its name if there was
+// one
+func (r *regelwerk) takeOverSession(k *sessionKind) string {
+	for _, name := range k.takesOver {
+		if r.discardSession(name, "converted to "+k.name) {
+			return name
+		}
+	}
+	return ""
+}
+
+// Discards the sessions of all kinds, returning whether there were any
+func (r *regelwerk) discardSessions(reason string) bool {
+	discarded := false
+	for _, k := range r.sessionKinds {
+		discarded = r.discardSession(k.name, reason) || discarded
+	}
+	return discarded
+}
+
+// What happens when a session expires, usually because its sensor is stuck
+const (
+	EXPIRY_RESET  = "reset"  // clear the sensor's state, so its next report re-triggers
+	EXPIRY_QUERY  = "query"  // ask the sensor for its actual state
+	EXPIRY_NOTIFY = "notify" // raise an alert
+	EXPIRY_EXTEND = "extend" // keep the lights on for another expiry period
+)
+
+func validateSessionExpiry(expiry map[string][]string) error {
+	for name, modes := range expiry {
+		if name != "contact" && name != "motion" {
+			return fmt.Errorf("SessionExpiry for unknown session %q", name)
+		}
+
+		seen := make(map[string]bool)
+		for _, mode := range modes {
+			switch mode {
+			case EXPIRY_RESET, EXPIRY_QUERY, EXPIRY_NOTIFY, EXPIRY_EXTEND:
+				seen[mode] = true
+			default:
+				return fmt.Errorf("unknown SessionExpiry %q for %q", mode, name)
+			}
+		}
+		if seen[EXPIRY_EXTEND] && (seen[EXPIRY_RESET] || seen[EXPIRY_QUERY]) {
+			return fmt.Errorf("SessionExpiry for %q can't both extend and end the session", name)
+		}
+	}
+	return nil
+}
+
+// Runs an action Before a session turns off the lights, e.g. dimming them,
+// so that occupants can re-trigger the sensor
+type offWarningConfig struct {
+	Before textDuration
+	Action string
+}
+
+// Called after a session changes state, without any locks held
+type sessionHook func(name string, from, to sessionState, reason string)
+
+type sessionInfo struct {
+	State sessionState
+	Since time.Time
+}
+
+// The state of each session, by name
+type sessions struct {
+	mu     sync.Mutex
+	states map[string]*sessionInfo
+	hooks  []sessionHook
+}
+
+func newSessions() *sessions {
+	return &sessions{states: make(map[string]*sessionInfo)}
+}
+
+// Registers a hook for all transitions.
+// Should be called before any events are processed.
+func (s *sessions) OnTransition(h sessionHook) {
+	s.hooks = append(s.hooks, h)
+}
+
+func (s *sessions) State(name string) sessionState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if si := s.states[name]; si != nil {
+		return si.State
+	}
+	return SESSION_IDLE
+}
+
+// Moves the session to the new state, if that's a valid transition.
+// Returns false otherwise.
+func (s *sessions) transition(name string, to sessionState, reason string, now time.Time) bool {
+	s.mu.Lock()
+	si := s.states[name]
+	if si == nil {
+		si = &sessionInfo{State: SESSION_IDLE}
+		s.states[name] = si
+	}
+
+	from := si.State
+	valid := false
+	for _, st := range sessionTransitions[from] {
+		valid = valid || st == to
+	}
+	if !valid {
+		s.mu.Unlock()
+		if from != to {
+			log.Printf("session %q: invalid transition %s -> %s (%s)", name, from, to, reason)
+		}
+		return false
+	}
+
+	si.State, si.Since = to, now
+	s.mu.Unlock()
+
+	debugf(LOG_RULES, "session %q: %s -> %s (%s)", name, from, to, reason)
+	for _, h := range s.hooks {
+		h(name, from, to, reason)
+	}
+	return true
+}
+
+func (s *sessions) List() map[string]sessionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make(map[string]sessionInfo, len(s.states))
+	for name, si := range s.states {
+		list[name] = *si
+	}
+	return list
+}
+
+// Takes on the states of restored session timers of the kinds
+func (s *sessions) Restore(ts *timers.Set, kinds []*sessionKind) {
+	isSession := make(map[string]bool)
+	for _, k := range kinds {
+		isSession[k.name] = true
+	}
+
+	for _, ti := range ts.List() {
+		if !isSession[ti.Name] {
+			continue
+		}
+
+		now := ts.Clock.Now()
+		s.transition(ti.Name, SESSION_ACTIVE, "restored", now)
+		if ti.Running {
+			s.transition(ti.Name, SESSION_PENDING_OFF, "restored", now)
+		}
+	}
+}
+
+// Records transitions in the journal, and publishes the new state to
+// regelwerk/session/<name>
+func (r *regelwerk) registerSessionHooks() {
+	r.sessions.OnTransition(func(name string, from, to sessionState, reason string) {
+		r.journal.Add("session", name, map[string]any{"from": from, "to": to, "reason": reason})
+		r.publishJSON("session/"+name, to)
+	})
+}
+
+// Starts a session for the device that triggered it, turning on the lights.
+// The expiry ends the session even if the sensor never clears, 0 for none.
+func (r *regelwerk) startSession(ctx context.Context, name string, d *device, expiry time.Duration) {
+	meta := map[string]string{"device": d.id, "topic": d.topic}
+	if r.timers.AddWithExpiry(name, "session", meta, expiry) == nil {
+		return
+	}
+
+	r.sessions.transition(name, SESSION_ACTIVE, "triggered by "+d.topic, r.clock.Now())
+	go r.setSwitchState(ctx, "ON")
+}
+
+// Stops the countdown of a session that's been triggered again.
+// Returns false if there's no such session.
+func (r *regelwerk) pauseSession(name string) bool {
+	if r.timers.Stop(name) == nil {
+		return false
+	}
+	r.timers.Destroy(name + "/warning")
+	r.sessions.transition(name, SESSION_ACTIVE, "triggered again", r.clock.Now())
+	return true
+}
+
+// Starts the countdown to turning off the lights, once the sensors are clear.
+// Returns false if there's no such session.
+func (r *regelwerk) countdownSession(name string, delay time.Duration) bool {
+	if !r.timers.Start(name, delay) {
+		return false
+	}
+	r.sessions.transition(name, SESSION_PENDING_OFF, "sensors clear", r.clock.Now())
+
+	// warn occupants ahead of the turn-off, giving them a chance to move
+	if w := r.offWarning; w != nil && delay > time.Duration(w.Before) {
+		warning := name + "/warning"
+		r.timers.Destroy(warning)
+		meta := map[string]string{"session": name, "action": w.Action, "topic": r.LookupDevice("switch").topic}
+		r.timers.Add(warning, "session_warning", meta)
+		r.timers.Start(warning, delay-time.Duration(w.Before))
+	}
+	return true
+}
+
+// Ends the session without touching the lights.
+// Returns false if there's no such session.
+func (r *regelwerk) discardSession(name, reason string) bool {
+	if !r.timers.Destroy(name) {
+		return false
+	}
+	r.timers.Destroy(name + "/warning")
+	r.sessions.transition(name, SESSION_IDLE, reason, r.clock.Now())
+	return true
+}
+
+// Ends the session when its off-delay or expiry timer fires
+func (r *regelwerk) handleSessionTimer(ctx context.Context, tm *timers.Timer, expired bool) {
+	k := r.sessionKind(tm.Name())
+	modes := []string{EXPIRY_RESET}
+	if k != nil && k.onExpiry != nil {
+		modes = k.onExpiry
+	}
+	if expired && r.sessionExpired(tm, k, modes) {
+		return
+	}
+
+	reason := "off-delay passed"
+	if expired {
+		reason = "expired"
+	}
+	r.timers.Destroy(tm.Name() + "/warning")
+	r.sessions.transition(tm.Name(), SESSION_OFF, reason, r.clock.Now())
+
+	// turn off lights after timeout/expiry
+	r.setSwitchState(ctx, "OFF")
+
+	if expired {
+		d := r.deviceByTopic(tm.Meta("topic"))
+		for _, mode := range modes {
+			switch {
+			case d == nil || k == nil:
+			case mode == EXPIRY_RESET:
+				// have the sensor re-trigger immediately when next reporting
+				d.state = k.off
+			case mode == EXPIRY_QUERY:
+				d.RequestState(r.client, r.clock.Now())
+			}
+		}
+	}
+
+	r.sessions.transition(tm.Name(), SESSION_IDLE, "lights off", r.clock.Now())
+}
+
+// Notifies about an expired session, and extends it if configured.
+// Returns whether it was extended, rather than to be ended.
+func (r *regelwerk) sessionExpired(tm *timers.Timer, k *sessionKind, modes []string) (extended bool) {
+	for _, mode := range modes {
+		switch mode {
+		case EXPIRY_NOTIFY:
+			r.alert("%s session expired, sensor %q may be stuck", tm.Name(), tm.Meta("topic"))
+		case EXPIRY_EXTEND:
+			extended = k != nil && r.sessions.State(tm.Name()) == SESSION_ACTIVE
+		}
+	}
+
+	if extended {
+		// replaces the expired timer, which is then left alone
+		log.Printf("%s session expired, extending by %s", tm.Name(), k.expiry)
+		r.timers.Destroy(tm.Name())
+		meta := map[string]string{"device": tm.Meta("device"), "topic": tm.Meta("topic")}
+		r.timers.AddWithExpiry(tm.Name(), "session", meta, k.expiry)
+	}
+	return extended
+}
+
+// Runs the warning action ahead of a session's turn-off
+func (r *regelwerk) handleSessionWarningTimer(ctx context.Context, tm *timers.Timer, expired bool) {
+	session, name := tm.Meta("session"), tm.Meta("action")
+	if expired || r.sessions.State(session) != SESSION_PENDING_OFF {
+		return
+	}
+
+	log.Printf("session %q turning off soon: running %q", session, name)
+	if spec := r.actions[name]; spec == nil {
+		log.Printf("unknown action %q", name)
+	} else if err := r.Run(withSource(ctx, SOURCE_SESSION), spec); err != nil {
+		log.Printf("action %q failed: %v", name, err)
+	}
+}
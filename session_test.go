@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"regelwerk/timers"
+)
+
+func TestSessionTransitions(t *testing.T) {
+	s := newSessions()
+
+	var seen []sessionState
+	s.OnTransition(func(name string, from, to sessionState, reason string) {
+		seen = append(seen, to)
+	})
+
+	steps := []struct {
+		to    sessionState
+		valid bool
+	}{
+		{SESSION_PENDING_OFF, false}, // not started yet
+		{SESSION_ACTIVE, true},
+		{SESSION_PENDING_OFF, true},
+		{SESSION_ACTIVE, true}, // triggered again
+		{SESSION_PENDING_OFF, true},
+		{SESSION_OFF, true},
+		{SESSION_ACTIVE, false}, // needs to go idle first
+		{SESSION_IDLE, true},
+	}
+	for i, st := range steps {
+		if ok := s.transition("contact", st.to, "test", time.Now()); ok != st.valid {
+			t.Errorf("step %d to %s: wanted %v got %v", i+1, st.to, st.valid, ok)
+		}
+	}
+
+	if len(seen) != 6 {
+		t.Errorf("expected 6 hook calls, got %v", seen)
+	} else if s.State("contact") != SESSION_IDLE {
+		t.Errorf("expected idle, got %s", s.State("contact"))
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	tests := []struct {
+		modes     []string
+		active    bool // after expiry
+		pirState  any
+		alertSent bool
+	}{
+		{nil, false, false, false},
+		{[]string{EXPIRY_NOTIFY}, false, true, true},
+		{[]string{EXPIRY_EXTEND}, true, true, false},
+	}
+
+	for _, tt := range tests {
+		cfg := testConfig()
+		cfg.SessionExpiry = map[string][]string{"motion": tt.modes}
+		r, mc := newTestRegelwerk(t, cfg)
+
+		fc := timers.NewFakeClock(time.Date(2022, 6, 1, 21, 0, 0, 0, time.Local))
+		r.clock, r.timers.Clock = fc, fc
+
+		receive(r, "pir", map[string]any{"occupancy": true})
+		fc.Advance(time.Hour)
+
+		if active := r.sessions.State("motion") == SESSION_ACTIVE; active != tt.active {
+			t.Errorf("%v: wanted session active %v", tt.modes, tt.active)
+		}
+		if st := r.deviceByTopic("pir").state; st != tt.pirState {
+			t.Errorf("%v: wanted sensor state %v, got %v", tt.modes, tt.pirState, st)
+		}
+		if sent := len(mc.Payloads("regelwerk/alert")) > 0; sent != tt.alertSent {
+			t.Errorf("%v: wanted alert %v", tt.modes, tt.alertSent)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"regelwerk/mqttio"
+)
+
+// Pretends to be the configured devices on the broker, so that rules can be
+// tried out without hardware. Like zigbee2mqtt, it publishes their state when
+// asked to with /get or told to change it with /set, and sensors are changed
+// with the simulate/set command, e.g.
+// {"Device": "0x00158d00037aa30d", "State": {"contact": false}}
+//
+// Don't use this on a broker with the real devices.
+type simulator struct {
+	client mqttio.Publisher
+
+	mu     sync.Mutex
+	states map[string]map[string]any // by topic
+}
+
+// Payload of simulate/set
+type simulateCommand struct {
+	Device string // topic
+	State  map[string]any
+}
+
+// Initial states of the simulated devices: doors closed, no motion, light off
+var simulatedDefaults = map[string]any{
+	"contact": true,
+	"motion":  false,
+	"switch":  "OFF",
+}
+
+func newSimulator(devices map[string]*device) *simulator {
+	s := &simulator{states: make(map[string]map[string]any)}
+	for topic, d := range devices {
+		if d.isTemplate() || d.virtual || d.stateAttr == "" {
+			continue
+		}
+		state := d.state
+		if state == nil {
+			state = simulatedDefaults[d.id]
+		}
+		s.states[topic] = mqttio.NestPath(d.stateAttr, state)
+	}
+	return s
+}
+
+// Subscribes to the /set and /get topics of the simulated devices
+func (s *simulator) Subscribe(c mqtt.Client) error {
+	s.client = c
+	for topic := range s.states {
+		for _, suffix := range []string{"/set", "/get"} {
+			tok := c.Subscribe(MQTT_TOPIC_PREFIX+topic+suffix, 0, s.handleRequest)
+			if tok.Wait() && tok.Error() != nil {
+				return tok.Error()
+			}
+		}
+	}
+	log.Printf("simulating %d devices", len(s.states))
+	return nil
+}
+
+func (s *simulator) handleRequest(_ mqtt.Client, msg mqtt.Message) {
+	topic := strings.TrimPrefix(msg.Topic(), MQTT_TOPIC_PREFIX)
+	if strings.HasSuffix(topic, "/get") {
+		s.publish(strings.TrimSuffix(topic, "/get"))
+		return
+	}
+
+	var changes map[string]any
+	if err := json.Unmarshal(msg.Payload(), &changes); err != nil {
+		log.Printf("simulator: bad payload for %q: %v", topic, err)
+		return
+	}
+	if err := s.Set(strings.TrimSuffix(topic, "/set"), changes); err != nil {
+		log.Printf("simulator: %v", err)
+	}
+}
+
+// Changes the state of the simulated device, and publishes it
+func (s *simulator) Set(topic string, changes map[string]any) error {
+	s.mu.Lock()
+	state := s.states[topic]
+	if state == nil {
+		s.mu.Unlock()
+		return fmt.Errorf("unknown device %q", topic)
+	}
+	for k, v := range changes {
+		state[k] = v
+	}
+	s.mu.Unlock()
+
+	s.publish(topic)
+	return nil
+}
+
+func (s *simulator) publish(topic string) {
+	s.mu.Lock()
+	js, _ := json.Marshal(s.states[topic])
+	s.mu.Unlock()
+
+	debugf(LOG_MQTT, "simulator: %s is now %s", topic, js)
+	s.client.Publish(MQTT_TOPIC_PREFIX+topic, 0, false, js)
+}
+
+func (r *regelwerk) registerSimulator(s *simulator) {
+	r.HandleCommand("simulate/set", func(ctx context.Context, payload []byte) error {
+		var cmd simulateCommand
+		if err := json.Unmarshal(payload, &cmd); err != nil {
+			return err
+		}
+		return s.Set(cmd.Device, cmd.State)
+	})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"regelwerk/timers"
+)
+
+// Payload of the snooze command, e.g. {"Device": "hall_light", "For": "2h"}.
+// A zero For ends the snooze early.
+type snoozeCommand struct {
+	Device string // ID or topic
+	For    textDuration
+}
+
+// Snoozes automations for the device: actions for it are dropped until the
+// time is up, except for manual ones and emergencies. The snooze is a timer,
+// so it survives restarts.
+func (r *regelwerk) Snooze(name string, dur time.Duration) error {
+	d := r.LookupDevice(name)
+	if d == nil {
+		d = r.deviceByTopic(name)
+	}
+	if d == nil {
+		return fmt.Errorf("unknown device %q", name)
+	} else if dur < 0 {
+		return fmt.Errorf("cannot snooze for %s", dur)
+	}
+
+	tname := "snooze/" + d.topic
+	r.timers.Destroy(tname)
+	if dur == 0 {
+		log.Printf("automations for %q resumed", d.topic)
+	} else {
+		r.timers.Add(tname, "snooze", map[string]string{"topic": d.topic})
+		r.timers.Start(tname, dur)
+		log.Printf("automations for %q snoozed for %s", d.topic, dur)
+	}
+	r.publishJSON("snoozed", r.SnoozeReport())
+	return nil
+}
+
+// Returns how long automations for the device are still snoozed
+func (r *regelwerk) snoozedFor(d *device) time.Duration {
+	left, ok := r.timers.Remaining("snooze/" + d.topic)
+	if !ok {
+		return 0
+	}
+	return left
+}
+
+// Returns the snoozed devices, with the time left
+func (r *regelwerk) SnoozeReport() map[string]string {
+	report := make(map[string]string)
+	for _, tm := range r.timers.List() {
+		if strings.HasPrefix(tm.Name, "snooze/") {
+			report[tm.Meta["topic"]] = tm.Remaining.Round(time.Second).String()
+		}
+	}
+	return report
+}
+
+func (r *regelwerk) handleSnoozeTimer(ctx context.Context, tm *timers.Timer, expired bool) {
+	log.Printf("automations for %q resumed", tm.Meta("topic"))
+
+	// the timer is only removed after this returns
+	report := r.SnoozeReport()
+	delete(report, tm.Meta("topic"))
+	r.publishJSON("snoozed", report)
+}
+
+// Blocks automated actions for snoozed devices
+func (r *regelwerk) snoozeActions(next actionFunc) actionFunc {
+	return func(ctx context.Context, a *action) error {
+		if a.dev == nil || a.source == SOURCE_MANUAL || isEmergency(ctx) {
+			return next(ctx, a)
+		}
+		if left := r.snoozedFor(a.dev); left > 0 {
+			return fmt.Errorf("snoozed for another %s", left.Round(time.Second))
+		}
+		return next(ctx, a)
+	}
+}
+
+func (r *regelwerk) registerSnooze() {
+	r.timers.Register("snooze", r.handleSnoozeTimer)
+	r.AddStatus("snoozed", func() any { return r.SnoozeReport() })
+
+	r.HandleCommand("snooze", func(ctx context.Context, payload []byte) error {
+		var cmd snoozeCommand
+		if err := json.Unmarshal(payload, &cmd); err != nil {
+			return err
+		}
+		return r.Snooze(cmd.Device, time.Duration(cmd.For))
+	})
+}
+
+func init() {
+	actionTypes["snooze"] = func(ctx context.Context, r *regelwerk, spec *actionSpec) error {
+		return r.Snooze(spec.Device, time.Duration(spec.For))
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"regelwerk/timers"
+)
+
+func TestSnooze(t *testing.T) {
+	r, mc := newTestRegelwerk(t, testConfig())
+	fc := timers.NewFakeClock(time.Date(2022, 6, 1, 21, 0, 0, 0, time.Local))
+	r.clock, r.timers.Clock = fc, fc
+
+	if err := r.Snooze("light", time.Hour); err != nil {
+		t.Fatal(err)
+	} else if err := r.Snooze("lamp", time.Hour); err == nil {
+		t.Errorf("expected error for unknown device")
+	}
+	if got := r.SnoozeReport(); got["light"] != "1h0m0s" {
+		t.Errorf("wanted light snoozed in status, got %v", got)
+	}
+
+	// automations are dropped, but manual commands still go through
+	if err := r.ActivateScene(r.ctx, "night"); err != nil {
+		t.Fatal(err)
+	}
+	a := r.devices["light"].NewState("ON")
+	a.source = SOURCE_MANUAL
+	r.Do(r.ctx, a)
+	if got := mc.WaitFor(t, "zigbee2mqtt/light/set", 1); len(got) != 1 || got[0] != `{"state_right":"ON"}` {
+		t.Errorf("wanted only the manual command, got %v", got)
+	}
+
+	fc.Advance(time.Hour)
+	if got := r.SnoozeReport(); len(got) != 0 {
+		t.Errorf("still snoozed: %v", got)
+	}
+	if err := r.ActivateScene(r.ctx, "night"); err != nil {
+		t.Fatal(err)
+	}
+	mc.WaitFor(t, "zigbee2mqtt/light/set", 2)
+}
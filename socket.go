@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// A request on the control socket, one JSON object per line, e.g.
+// {"Command": "timers/destroy", "Payload": "contact"}
+// Besides the queries below, all MQTT control commands are accepted.
+type socketRequest struct {
+	Command string
+	Payload string
+}
+
+type socketResponse struct {
+	Result any    `json:",omitempty"`
+	Error  string `json:",omitempty"`
+}
+
+// Answers a control socket request
+type queryFunc func(ctx context.Context, payload string) (any, error)
+
+func (r *regelwerk) socketQueries() map[string]queryFunc {
+	return map[string]queryFunc{
+		"status": func(ctx context.Context, _ string) (any, error) {
+			return r.Status(), nil
+		},
+		"timers/list": func(ctx context.Context, _ string) (any, error) {
+			return r.timers.List(), nil
+		},
+		"timers/destroy": func(ctx context.Context, name string) (any, error) {
+			if !r.timers.Destroy(name) {
+				return nil, fmt.Errorf("no timer %q", name)
+			}
+			return nil, nil
+		},
+		"config/reload": func(ctx context.Context, _ string) (any, error) {
+			return nil, r.reload()
+		},
+	}
+}
+
+// Serves the control socket until the context is done
+func (r *regelwerk) serveSocket(ctx context.Context, path string) {
+	// left behind if we weren't shut down cleanly
+	os.Remove(path)
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		log.Printf("unable to open control socket: %v", err)
+		return
+	}
+	os.Chmod(path, 0660)
+
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	log.Printf("listening for commands on %s", path)
+	queries := r.socketQueries()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("control socket failed: %v", err)
+			}
+			return
+		}
+		go r.handleSocket(ctx, conn, queries)
+	}
+}
+
+func (r *regelwerk) handleSocket(ctx context.Context, conn net.Conn, queries map[string]queryFunc) {
+	defer conn.Close()
+
+	enc := json.NewEncoder(conn)
+	sc := bufio.NewScanner(conn)
+	for sc.Scan() {
+		var req socketRequest
+		var resp socketResponse
+		var err error
+
+		if err = json.Unmarshal(sc.Bytes(), &req); err != nil {
+			err = fmt.Errorf("bad request: %v", err)
+		} else if q := queries[req.Command]; q != nil {
+			resp.Result, err = q(ctx, strings.TrimSpace(req.Payload))
+		} else if fn := r.commands[req.Command]; fn != nil {
+			log.Printf("received command %q: %s", req.Command, req.Payload)
+			err = fn(ctx, []byte(req.Payload))
+		} else {
+			err = fmt.Errorf("unknown command %q", req.Command)
+		}
+
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		if enc.Encode(resp) != nil {
+			return
+		}
+	}
+}
+
+// Checks the config file, then restarts in place to apply it.
+// Timers survive if they are persisted with -state.
+func (r *regelwerk) reload() error {
+	if _, err := loadConfig(*configFile); err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("config ok, restarting to reload")
+	if r.socketPath != "" {
+		os.Remove(r.socketPath)
+	}
+	return syscall.Exec(exe, os.Args, os.Environ())
+}
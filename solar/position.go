@@ -0,0 +1,34 @@
+package solar
+
+import (
+	"math"
+	"time"
+)
+
+// Position returns the Sun's azimuth (degrees clockwise from north) and
+// elevation (degrees above the horizon, without refraction) at the time.
+// Latitude is +ve north and longitude +ve east, in degrees.
+func Position(t time.Time, lat, lng float64) (azimuth, elevation float64) {
+	t = t.UTC()
+	minutes := float64(t.Hour()*60+t.Minute()) + float64(t.Second())/60
+	jc := julianCentury(julianDay(t) + minutes/1440)
+
+	eqTime := equationOfTime(jc)
+	decl := sunDeclination(jc) * DEG2RAD
+	latR := lat * DEG2RAD
+
+	// hour angle from the true solar time, 0 at solar noon
+	trueSolarTime := math.Mod(minutes+eqTime+4*lng, 1440)
+	ha := trueSolarTime/4 - 180
+	if ha < -180 {
+		ha += 360
+	}
+	haR := ha * DEG2RAD
+
+	cosZenith := math.Sin(latR)*math.Sin(decl) + math.Cos(latR)*math.Cos(decl)*math.Cos(haR)
+	elevation = 90 - math.Acos(math.Max(-1, math.Min(1, cosZenith)))/DEG2RAD
+
+	azimuth = math.Atan2(math.Sin(haR), math.Cos(haR)*math.Sin(latR)-math.Tan(decl)*math.Cos(latR))/DEG2RAD + 180
+	azimuth = math.Mod(azimuth+360, 360)
+	return azimuth, elevation
+}
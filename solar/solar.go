@@ -0,0 +1,56 @@
+package solar
+
+import "time"
+
+// Zenith angles of the Sun, in degrees, for sunrise/sunset and the
+// different kinds of twilight
+const (
+	ZenithOfficial     = 90.833 // sunrise/sunset, allowing for refraction
+	ZenithCivil        = 96
+	ZenithNautical     = 102
+	ZenithAstronomical = 108
+)
+
+// Dawn and dusk of a twilight
+type Twilight struct {
+	Dawn, Dusk time.Time
+}
+
+// The times of the different twilights for a day
+type Twilights struct {
+	Civil, Nautical, Astronomical Twilight
+}
+
+// Sunrise returns the time of sunrise on the date, in the date's location.
+// Latitude is +ve north and longitude +ve east, in degrees.
+// Returns the zero time if the sun doesn't rise that day.
+func Sunrise(date time.Time, lat, lng float64) time.Time {
+	return TimeAtSunAngle(date, true, ZenithOfficial, lat, lng)
+}
+
+// Sunset returns the time of sunset on the date, like Sunrise.
+func Sunset(date time.Time, lat, lng float64) time.Time {
+	return TimeAtSunAngle(date, false, ZenithOfficial, lat, lng)
+}
+
+// SolarNoon returns the time the Sun is highest on the date, at the longitude
+// (+ve east, in degrees).
+func SolarNoon(date time.Time, lng float64) time.Time {
+	return utcMinutesToTime(solarNoonUTC(julianDay(date), -lng), date)
+}
+
+// TwilightTimes returns the civil, nautical and astronomical twilights on the
+// date. Times are zero where the Sun doesn't reach the angle that day.
+func TwilightTimes(date time.Time, lat, lng float64) Twilights {
+	twilight := func(angle float64) Twilight {
+		return Twilight{
+			Dawn: TimeAtSunAngle(date, true, angle, lat, lng),
+			Dusk: TimeAtSunAngle(date, false, angle, lat, lng),
+		}
+	}
+	return Twilights{
+		Civil:        twilight(ZenithCivil),
+		Nautical:     twilight(ZenithNautical),
+		Astronomical: twilight(ZenithAstronomical),
+	}
+}
@@ -0,0 +1,87 @@
+package solar
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// Times from NOAA's solar calculator, to the minute
+func TestGolden(t *testing.T) {
+	est := time.FixedZone("EST", -5*3600)
+	bst := time.FixedZone("BST", 1*3600)
+	aedt := time.FixedZone("AEDT", 11*3600)
+
+	tests := []struct {
+		name      string
+		date      time.Time
+		lat, lng  float64
+		rise, set string
+		noon      string
+		civilDawn string
+		civilDusk string
+	}{
+		{"New York", time.Date(2022, 1, 1, 0, 0, 0, 0, est), 40.7128, -74.0060,
+			"07:20", "16:39", "11:59", "06:49", "17:10"},
+		{"London", time.Date(2020, 6, 21, 0, 0, 0, 0, bst), 51.5074, -0.1278,
+			"04:43", "21:21", "13:02", "03:55", "22:09"},
+		{"Sydney", time.Date(2022, 1, 1, 0, 0, 0, 0, aedt), -33.8688, 151.2093,
+			"05:47", "20:09", "12:58", "05:18", "20:38"},
+	}
+
+	check := func(name, what string, got time.Time, want string, date time.Time) {
+		t.Helper()
+		w, err := time.ParseInLocation("15:04", want, date.Location())
+		if err != nil {
+			t.Fatal(err)
+		}
+		w = time.Date(date.Year(), date.Month(), date.Day(), w.Hour(), w.Minute(), 0, 0, date.Location())
+
+		if diff := got.Sub(w); diff < -time.Minute || diff > time.Minute {
+			t.Errorf("%s: %s is %s, wanted %s", name, what, got.Format("15:04:05"), want)
+		}
+	}
+
+	for _, tt := range tests {
+		check(tt.name, "sunrise", Sunrise(tt.date, tt.lat, tt.lng), tt.rise, tt.date)
+		check(tt.name, "sunset", Sunset(tt.date, tt.lat, tt.lng), tt.set, tt.date)
+		check(tt.name, "solar noon", SolarNoon(tt.date, tt.lng), tt.noon, tt.date)
+
+		tw := TwilightTimes(tt.date, tt.lat, tt.lng)
+		check(tt.name, "civil dawn", tw.Civil.Dawn, tt.civilDawn, tt.date)
+		check(tt.name, "civil dusk", tw.Civil.Dusk, tt.civilDusk, tt.date)
+	}
+}
+
+func TestPolarDay(t *testing.T) {
+	date := time.Date(2022, 6, 21, 0, 0, 0, 0, time.UTC)
+
+	// Svalbard has midnight sun, and London no astronomical night in summer
+	if rise := Sunrise(date, 78.2, 15.6); !rise.IsZero() {
+		t.Errorf("sunrise in polar day at %v", rise)
+	}
+	if tw := TwilightTimes(date, 51.5074, -0.1278); !tw.Astronomical.Dusk.IsZero() {
+		t.Errorf("astronomical dusk in London's summer at %v", tw.Astronomical.Dusk)
+	}
+}
+
+func TestPosition(t *testing.T) {
+	bst := time.FixedZone("BST", 3600)
+	tests := []struct {
+		date          time.Time
+		azimuth, elev float64
+	}{
+		// London on the solstice, at solar noon and in the morning
+		{time.Date(2020, 6, 21, 13, 2, 19, 0, bst), 180, 61.9},
+		{time.Date(2020, 6, 21, 9, 2, 19, 0, bst), 98, 36.6},
+		{time.Date(2020, 6, 21, 21, 2, 19, 0, bst), 307.3, 1.5},
+	}
+
+	for _, tt := range tests {
+		az, el := Position(tt.date, 51.5074, -0.1278)
+		if math.Abs(az-tt.azimuth) > 0.5 || math.Abs(el-tt.elev) > 0.5 {
+			t.Errorf("%s: got azimuth %.1f, elevation %.1f, wanted %.1f, %.1f",
+				tt.date.Format("15:04"), az, el, tt.azimuth, tt.elev)
+		}
+	}
+}
@@ -1,4 +1,5 @@
-package main
+// Package solar calculates the times of sunrise, sunset and twilight.
+package solar
 
 // Code was ported over from NOAA's online calculator:
 // https://gml.noaa.gov/grad/solcalc/sunrise.html
@@ -139,14 +140,17 @@ func hourAngle(angle, decl, lat float64) float64 {
 			math.Tan(lat)*math.Tan(decl)) / DEG2RAD
 }
 
-// Calculate time at which Sun will be at the specified angle.
+// TimeAtSunAngle calculates the time at which the Sun will be at the specified angle.
 // Used to calculate sunset/sunrise timings. With an angle of 90.833°, the
 // sunset/sunrise time will be returned, depending on the rising parameter.
 // Other types of twilight are also possible, like 96° for civil twilight.
-// Latitude is +ve in north, -ve in south and longitude is +ve in the west and
-// -ve in the east (inverse of normal), all specified in degrees.
-func calcTimeAtSunAngle(date time.Time, rising bool, angle, lat, lng float64) time.Time {
+// Latitude is +ve in north, -ve in south and longitude is +ve in the east and
+// -ve in the west, all specified in degrees.
+// Returns the zero time if the Sun doesn't reach the angle that day, as
+// around the poles.
+func TimeAtSunAngle(date time.Time, rising bool, angle, lat, lng float64) time.Time {
 	jd := julianDay(date)
+	lng = -lng // NOAA's equations have longitude +ve in the west
 
 	f := func(t float64) float64 {
 		eqTime := equationOfTime(t)
@@ -168,11 +172,15 @@ func calcTimeAtSunAngle(date time.Time, rising bool, angle, lat, lng float64) ti
 
 	// second pass to include fractional Julian day in gamma
 	timeUTC = f(julianCentury(jd + timeUTC/1440))
+	if math.IsNaN(timeUTC) {
+		return time.Time{}
+	}
 
 	return utcMinutesToTime(timeUTC, date)
 }
 
-// Converts minutes from UTC into a Time object, relative to specified date.
+// Converts minutes from UTC into a Time object, relative to specified date,
+// in the date's location.
 // The minutes value will be rounded up to the nearest second.
 func utcMinutesToTime(minutes float64, date time.Time) time.Time {
 	offset := minutes * float64(time.Minute)
@@ -183,5 +191,5 @@ func utcMinutesToTime(minutes float64, date time.Time) time.Time {
 	// let it do the UTC conversion for us
 	d := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
 	d = d.Add(time.Duration(offset))
-	return d.Local()
+	return d.In(date.Location())
 }
@@ -1,4 +1,4 @@
-package main
+package solar
 
 import (
 	"testing"
@@ -39,8 +39,8 @@ func TestSunriseSunset(t *testing.T) {
 		makeDate(2022, 1, 1),
 	}
 	for _, d := range dates {
-		rise := calcTimeAtSunAngle(d, true, 90.833, 22, -122)
-		set := calcTimeAtSunAngle(d, false, 90.833, 22, -122)
+		rise := TimeAtSunAngle(d, true, 90.833, 22, 122)
+		set := TimeAtSunAngle(d, false, 90.833, 22, 122)
 		t.Logf("%v - rise %v\n", d, rise)
 		t.Logf("%v - set  %v\n", d, set)
 	}
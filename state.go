@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// kinds of device states
+const (
+	STATE_BOOL   = "bool"   // e.g. contact or occupancy
+	STATE_STRING = "string" // e.g. "ON" or "OFF"
+	STATE_NUMBER = "number" // e.g. position or brightness
+)
+
+func validateStateKind(topic, kind string) error {
+	switch kind {
+	case "", STATE_BOOL, STATE_STRING, STATE_NUMBER:
+		return nil
+	}
+	return fmt.Errorf("unknown StateKind %q for %q", kind, topic)
+}
+
+// Returns the kind of a decoded JSON value, or "" for others like objects
+func kindOf(v any) string {
+	switch v.(type) {
+	case bool:
+		return STATE_BOOL
+	case string:
+		return STATE_STRING
+	case float64:
+		return STATE_NUMBER
+	}
+	return ""
+}
+
+// Converts a value to the kind of state:
+//   - to bool: "ON"/"OFF" and "true"/"false" in any case, and numbers 1/0
+//   - to string: booleans as "ON"/"OFF", and numbers as formatted
+//   - to number: numeric strings, and booleans as 1/0
+//
+// Returns ok false if there's no such conversion.
+func coerceState(v any, kind string) (any, bool) {
+	switch kind {
+	case STATE_BOOL:
+		switch v := v.(type) {
+		case string:
+			switch strings.ToUpper(v) {
+			case "ON", "TRUE":
+				return true, true
+			case "OFF", "FALSE":
+				return false, true
+			}
+		case float64:
+			if v == 0 || v == 1 {
+				return v == 1, true
+			}
+		}
+
+	case STATE_STRING:
+		switch v := v.(type) {
+		case bool:
+			return onOffState(v), true
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), true
+		}
+
+	case STATE_NUMBER:
+		switch v := v.(type) {
+		case string:
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				return f, true
+			}
+		case bool:
+			if v {
+				return 1.0, true
+			}
+			return 0.0, true
+		}
+	}
+	return nil, false
+}
+
+func onOffState(on bool) string {
+	if on {
+		return "ON"
+	}
+	return "OFF"
+}
+
+// Brings a reported state to the kind of the device's state, taking on the
+// kind of the first one if it wasn't configured. States that can't be
+// converted are ignored, with a warning for each kind reported.
+// Called with the group lock held.
+func (d *device) typedState(v any) (any, bool) {
+	kind := kindOf(v)
+	if kind == d.stateKind {
+		return v, true
+	} else if d.stateKind == "" && kind != "" {
+		d.stateKind = kind
+		return v, true
+	}
+
+	if c, ok := coerceState(v, d.stateKind); ok {
+		debugf(LOG_DEVICES, "dev %q state %#v taken as %s %#v", d.id, v, d.stateKind, c)
+		return c, true
+	}
+
+	if d.kindWarned != kind {
+		want := d.stateKind
+		if want == "" {
+			want = "bool, string or number"
+		}
+		log.Printf("dev %q reported state %#v, which isn't a %s - ignoring", d.id, v, want)
+		d.kindWarned = kind
+	}
+	return nil, false
+}
@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestCoerceState(t *testing.T) {
+	tests := []struct {
+		v    any
+		kind string
+		want any
+		ok   bool
+	}{
+		{"on", STATE_BOOL, true, true},
+		{"FALSE", STATE_BOOL, false, true},
+		{1.0, STATE_BOOL, true, true},
+		{0.5, STATE_BOOL, nil, false},
+		{true, STATE_STRING, "ON", true},
+		{23.5, STATE_STRING, "23.5", true},
+		{" 23.5", STATE_NUMBER, 23.5, true},
+		{"open", STATE_NUMBER, nil, false},
+		{false, STATE_NUMBER, 0.0, true},
+	}
+	for _, tt := range tests {
+		if got, ok := coerceState(tt.v, tt.kind); got != tt.want || ok != tt.ok {
+			t.Errorf("%#v as %s: wanted %#v %v, got %#v %v", tt.v, tt.kind, tt.want, tt.ok, got, ok)
+		}
+	}
+}
+
+func TestTypedState(t *testing.T) {
+	r, _ := newTestRegelwerk(t, testConfig())
+	door := r.deviceByTopic("door")
+
+	receive(r, "door", map[string]any{"contact": "OFF"})
+	if door.state != false {
+		t.Errorf("wanted string state coerced to bool, got %#v", door.state)
+	}
+
+	receive(r, "door", map[string]any{"contact": "ajar"})
+	if door.state != false || door.kindWarned != STATE_STRING {
+		t.Errorf("wanted mismatched state ignored with a warning, got %#v", door.state)
+	}
+}
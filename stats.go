@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"regelwerk/mqttio"
+)
+
+// Rolling statistics of a numeric attribute of a device, e.g. for conditions
+// like "payload.humidity > stats.bath_humidity.mean + 10"
+type statConfig struct {
+	Device string
+	Attr   string       // can be a dotted path
+	Window textDuration // default 1h
+}
+
+type stats struct {
+	mu   sync.Mutex
+	cfg  map[string]statConfig
+	wins map[string]*rollingWindow
+}
+
+func validateStats(stats map[string]statConfig) error {
+	for name, sc := range stats {
+		if sc.Device == "" || sc.Attr == "" {
+			return fmt.Errorf("stat %q needs a Device and Attr", name)
+		} else if sc.Window < 0 {
+			return fmt.Errorf("stat %q: Window cannot be negative", name)
+		}
+	}
+	return nil
+}
+
+func (r *regelwerk) addStats(cfg map[string]statConfig) {
+	if len(cfg) == 0 {
+		return
+	}
+
+	r.stats = &stats{cfg: cfg, wins: make(map[string]*rollingWindow)}
+	for name, sc := range cfg {
+		if sc.Window == 0 {
+			sc.Window = textDuration(time.Hour)
+		}
+		r.stats.wins[name] = newRollingWindow(time.Duration(sc.Window))
+
+		if r.devices[sc.Device] == nil {
+			r.AddDevice(&device{id: sc.Device, topic: sc.Device})
+		}
+	}
+}
+
+// Adds the device's numeric attributes to their stats, and exports them
+func (r *regelwerk) observeStats(d *device, payload map[string]any) {
+	st := r.stats
+	if st == nil {
+		return
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := r.clock.Now()
+	for name, sc := range st.cfg {
+		if sc.Device != d.topic {
+			continue
+		}
+		v, _ := mqttio.LookupPath(payload, sc.Attr)
+		if f, ok := v.(float64); ok {
+			w := st.wins[name]
+			w.Add(now, f)
+			if sum, ok := w.Summary(now); ok {
+				r.exporter.AddStat(name, sum, now)
+			}
+		}
+	}
+}
+
+// Returns the current summary of each stat, those without samples are
+// missing
+func (st *stats) Report(now time.Time) map[string]windowSummary {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	report := make(map[string]windowSummary, len(st.wins))
+	for name, w := range st.wins {
+		if sum, ok := w.Summary(now); ok {
+			report[name] = sum
+		}
+	}
+	return report
+}
+
+// stats.<name>.min, .max, .mean, .median and .count, or null if there are no
+// samples in the window
+func (r *regelwerk) statsEnv(now time.Time) map[string]any {
+	if r.stats == nil {
+		return nil
+	}
+
+	env := make(map[string]any)
+	for name, sum := range r.stats.Report(now) {
+		env[name] = map[string]any{
+			"min":    sum.Min,
+			"max":    sum.Max,
+			"mean":   sum.Mean,
+			"median": sum.Median,
+			"count":  float64(sum.Count),
+		}
+	}
+	return env
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+)
+
+// Returns a section of the status report
+type statusFunc func() any
+
+// Registers a section for the status report
+func (r *regelwerk) AddStatus(section string, fn statusFunc) {
+	r.status[section] = fn
+}
+
+// Collects the status report from all sections
+func (r *regelwerk) Status() map[string]any {
+	st := make(map[string]any, len(r.status))
+	for section, fn := range r.status {
+		st[section] = fn()
+	}
+	return st
+}
+
+// Publishes the JSON encoded value under the control topic prefix
+func (r *regelwerk) publishJSON(topic string, v any) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("error encoding %s to JSON: %v", topic, err)
+		return
+	}
+	r.client.Publish(CONTROL_TOPIC_PREFIX+topic, 0, false, js)
+}
+
+func (r *regelwerk) registerStatus() {
+	r.AddStatus("timers", func() any { return r.timers.List() })
+	r.AddStatus("journal", func() any { return r.journal.Entries() })
+	r.AddStatus("sessions", func() any { return r.sessions.List() })
+	r.AddStatus("stale_devices", func() any { return r.StaleDevices() })
+	r.AddStatus("sun", func() any { return r.SunReport() })
+	r.AddStatus("publish", func() any { return r.PublishReport() })
+	if r.batteries != nil {
+		r.AddStatus("battery", func() any { return r.BatteryReport() })
+	}
+	if r.linkQuality != nil {
+		r.AddStatus("linkquality", func() any { return r.LinkQualityReport() })
+	}
+	if r.weather != nil {
+		r.AddStatus("weather", func() any { return r.weather.Report() })
+	}
+	if r.covers != nil {
+		r.AddStatus("covers", func() any { return r.CoverReport() })
+	}
+	if r.thermostats != nil {
+		r.AddStatus("thermostats", func() any { return r.ThermostatReport() })
+	}
+	if r.stats != nil {
+		r.AddStatus("stats", func() any { return r.stats.Report(r.clock.Now()) })
+	}
+	if r.energy != nil {
+		r.AddStatus("energy", func() any { return r.energy.Report(r.clock.Now()) })
+	}
+	if r.breaker != nil {
+		r.AddStatus("tripped_rules", func() any { return r.breaker.Tripped(r.clock.Now()) })
+	}
+	if r.alarm != nil {
+		r.AddStatus("alarm", func() any { return r.AlarmState() })
+	}
+	if r.alerts != nil {
+		r.AddStatus("alerts", func() any { return r.AlertsReport() })
+	}
+	if r.emergency != nil {
+		r.AddStatus("emergency", func() any { return r.EmergencyReport() })
+	}
+	if r.bridge != nil {
+		r.AddStatus("bridge_online", func() any { return r.BridgeOnline() })
+	}
+
+	r.HandleCommand("status/get", func(ctx context.Context, payload []byte) error {
+		r.publishJSON("status", r.Status())
+		return nil
+	})
+	r.HandleCommand("debug/dump", func(ctx context.Context, payload []byte) error {
+		r.publishJSON("debug/journal", r.journal.Entries())
+		return nil
+	})
+}
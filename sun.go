@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"regelwerk/solar"
+)
+
+// transitions of the sun, as published to regelwerk/sun
+const (
+	SUN_DAWN = "dawn"
+	SUN_DUSK = "dusk"
+)
+
+// Called at dawn and dusk
+type sunHook func(ctx context.Context, event string)
+
+// Sunrise and sunset at a location, for the house or a group of devices
+// with a Location or SunAngle of its own
+type sun struct {
+	lat, lng float64 // both zero if unknown, using 7am to 7pm instead
+	angle    float64
+
+	mu              sync.Mutex
+	day             time.Time // of the cached times
+	sunrise, sunset time.Time
+}
+
+func newSun(lat, lng, angle float64) *sun {
+	return &sun{lat: lat, lng: lng, angle: angle}
+}
+
+// Whether a location is configured, which can't be (0, 0) as per Validate
+func (s *sun) known() bool {
+	return s.lat != 0 || s.lng != 0
+}
+
+// Returns the time of sunrise for the day, or 7am if location is not set
+func (s *sun) Sunrise(day time.Time) time.Time {
+	if s.known() {
+		return solar.TimeAtSunAngle(day, true, s.angle, s.lat, s.lng)
+	}
+
+	y, m, d := day.Date()
+	return time.Date(y, m, d, 7, 0, 0, 0, day.Location())
+}
+
+// Returns the time of sunset for the day, or 7pm if location is not set
+func (s *sun) Sunset(day time.Time) time.Time {
+	if s.known() {
+		return solar.TimeAtSunAngle(day, false, s.angle, s.lat, s.lng)
+	}
+
+	y, m, d := day.Date()
+	return time.Date(y, m, d, 19, 0, 0, 0, day.Location())
+}
+
+// Returns the sunrise and sunset of the day, computed once a day
+func (s *sun) Today(ts time.Time) (sunrise, sunset time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !isSameDay(s.day, ts) {
+		s.sunrise = s.Sunrise(ts)
+		s.sunset = s.Sunset(ts)
+		s.day = ts
+
+		log.Printf("computed timings for %s:\nsunrise: %s\nsunset:  %s",
+			ts.Format("02 Jan 2006"),
+			s.sunrise.Format(time.RFC1123),
+			s.sunset.Format(time.RFC1123))
+	}
+	return s.sunrise, s.sunset
+}
+
+// Returns the sun's azimuth and elevation at the time, in degrees.
+// Only meaningful if the location is known.
+func (s *sun) Position(t time.Time) (azimuth, elevation float64) {
+	return solar.Position(t, s.lat, s.lng)
+}
+
+// Whether it's dark at the time, with dawn and dusk moved by shift, e.g.
+// for heavy clouds
+func (s *sun) IsDusk(ts time.Time, shift time.Duration) bool {
+	if !s.known() {
+		// default dusk/dawn logic, 7pm - 7am
+		return ts.Hour() >= 19 || ts.Hour() < 7
+	}
+
+	sunrise, sunset := s.Today(ts)
+	return ts.Before(sunrise.Add(shift)) || ts.After(sunset.Add(-shift))
+}
+
+// Returns the sun of the device's group, or the house's
+func (r *regelwerk) sunFor(d *device) *sun {
+	if d != nil && d.group != nil && d.group.sun != nil {
+		return d.group.sun
+	}
+	return r.sun
+}
+
+// Registers a hook to be called at dawn and dusk
+func (r *regelwerk) OnSunEvent(h sunHook) {
+	r.sunHooks = append(r.sunHooks, h)
+}
+
+// Computes the day's sun times just after midnight, so they're ready before
+// the first event, plans the day's sun triggers, and fires the dawn and dusk
+// events until the context is done. Dawn and dusk are as per NowIsDusk, including the weather's shift.
+func (r *regelwerk) runSunSchedule(ctx context.Context) {
+	var planned time.Time
+	for {
+		now := r.clock.Now()
+		sunrise, sunset := r.sun.Today(now)
+		for _, g := range r.groups {
+			if g.sun != nil {
+				g.sun.Today(now)
+			}
+		}
+		if !isSameDay(planned, now) {
+			r.planSunTriggers(now, sunrise, sunset)
+			planned = now
+		}
+
+		at, event := nextSunEvent(now, sunrise, sunset, r.weather.DuskShift())
+		if !r.sleepUntil(ctx, at) {
+			return
+		}
+		if event != "" {
+			r.fireSunEvent(ctx, event)
+		}
+	}
+}
+
+// Returns the time and name of the next sun event after now, or the next
+// midnight with no name if the day's events are over
+func nextSunEvent(now, sunrise, sunset time.Time, shift time.Duration) (time.Time, string) {
+	if dawn := sunrise.Add(shift); dawn.After(now) {
+		return dawn, SUN_DAWN
+	} else if dusk := sunset.Add(-shift); dusk.After(now) {
+		return dusk, SUN_DUSK
+	}
+
+	y, m, d := now.Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, now.Location()), ""
+}
+
+func (r *regelwerk) fireSunEvent(ctx context.Context, event string) {
+	log.Printf("sun: %s", event)
+	r.journal.Add("sun", event, nil)
+	r.client.Publish(CONTROL_TOPIC_PREFIX+"sun", 0, true, event)
+
+	for _, h := range r.sunHooks {
+		h(ctx, event)
+	}
+}
+
+// Waits on the clock until the time, returning false if the context was
+// done first
+func (r *regelwerk) sleepUntil(ctx context.Context, t time.Time) bool {
+	ch := make(chan struct{})
+	tm := r.clock.AfterFunc(t.Sub(r.clock.Now()), func() { close(ch) })
+	defer tm.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-ch:
+		return true
+	}
+}
+
+// Returns the sun's times for today and where it is now, for the status report
+func (r *regelwerk) SunReport() map[string]any {
+	now := r.clock.Now()
+	sunrise, sunset := r.sun.Today(now)
+	report := map[string]any{
+		"sunrise": sunrise,
+		"sunset":  sunset,
+		"dark":    r.NowIsDusk(nil),
+	}
+	if r.sun.known() {
+		report["azimuth"], report["elevation"] = r.sun.Position(now)
+	}
+	return report
+}
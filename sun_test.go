@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"regelwerk/timers"
+)
+
+func TestNextSunEvent(t *testing.T) {
+	day := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	at := func(h, m int) time.Time { return day.Add(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute) }
+	sunrise, sunset := at(6, 0), at(19, 0)
+
+	tests := []struct {
+		now   time.Time
+		shift time.Duration
+		want  time.Time
+		event string
+	}{
+		{at(1, 0), 0, sunrise, SUN_DAWN},
+		{at(6, 0), 0, sunset, SUN_DUSK},
+		{at(12, 0), 30 * time.Minute, at(18, 30), SUN_DUSK},
+		{at(6, 10), 30 * time.Minute, at(6, 30), SUN_DAWN},
+		{at(19, 0), 0, day.AddDate(0, 0, 1), ""},
+		{at(23, 59), 0, day.AddDate(0, 0, 1), ""},
+	}
+
+	for _, tt := range tests {
+		got, event := nextSunEvent(tt.now, sunrise, sunset, tt.shift)
+		if !got.Equal(tt.want) || event != tt.event {
+			t.Errorf("at %s: got %s %q, wanted %s %q", tt.now.Format("15:04"),
+				got.Format(time.Stamp), event, tt.want.Format(time.Stamp), tt.event)
+		}
+	}
+}
+
+func TestSunReport(t *testing.T) {
+	r, _ := newTestRegelwerk(t, testConfig())
+	if _, found := r.SunReport()["azimuth"]; found {
+		t.Errorf("sun position reported without a location")
+	}
+
+	cfg := testConfig()
+	cfg.Location = location{Lat: 51.5074, Lng: -0.1278, set: true}
+	r, _ = newTestRegelwerk(t, cfg)
+	fc := timers.NewFakeClock(time.Date(2022, 6, 21, 13, 2, 0, 0, time.FixedZone("BST", 3600)))
+	r.clock, r.timers.Clock = fc, fc
+
+	rep := r.SunReport()
+	if az := rep["azimuth"].(float64); az < 179 || az > 181 {
+		t.Errorf("wanted the sun south at noon, got azimuth %.1f", az)
+	}
+	if el := rep["elevation"].(float64); el < 61 || el > 63 {
+		t.Errorf("wrong elevation %.1f", el)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"regelwerk/timers"
+)
+
+// sun trigger types
+const (
+	TRIGGER_AT_DAWN = "at_dawn"
+	TRIGGER_AT_DUSK = "at_dusk"
+)
+
+// Runs a named action when the sun crosses the SunAngle, e.g. closing the
+// blinds at dusk, independent of any sensors
+type sunTriggerConfig struct {
+	Type   string
+	Offset offsetDuration // from dawn or dusk, e.g. "-15m" for before
+	Action string
+}
+
+// A duration that can be negative
+type offsetDuration time.Duration
+
+func (d *offsetDuration) UnmarshalText(b []byte) error {
+	t := strings.ReplaceAll(string(b), " ", "")
+	if t == "" {
+		return nil
+	}
+
+	dur, err := parseNamedDuration(t)
+	if err != nil {
+		return err
+	}
+	*d = offsetDuration(dur)
+	return nil
+}
+
+func validateSunTriggers(triggers []sunTriggerConfig, actions map[string]*actionSpec) error {
+	for i, st := range triggers {
+		if st.Type != TRIGGER_AT_DAWN && st.Type != TRIGGER_AT_DUSK {
+			return fmt.Errorf("sun trigger %d has unknown type %q", i, st.Type)
+		} else if actions[st.Action] == nil {
+			return fmt.Errorf("sun trigger %d refers to unknown action %q", i, st.Action)
+		}
+	}
+	return nil
+}
+
+// Schedules the triggers for the day with the given sunrise and sunset.
+// Timers are named by day, so those offset into the next day aren't
+// replaced by its planning.
+func (r *regelwerk) planSunTriggers(now, sunrise, sunset time.Time) {
+	for i, st := range r.sunTriggers {
+		at := sunrise
+		if st.Type == TRIGGER_AT_DUSK {
+			at = sunset
+		}
+		if at.IsZero() {
+			continue // sun doesn't cross the angle today
+		}
+		at = at.Add(time.Duration(st.Offset))
+		if !at.After(now) {
+			continue
+		}
+
+		name := fmt.Sprintf("sun/%d/%s", i, now.Format("2006-01-02"))
+		r.timers.Destroy(name)
+		r.timers.Add(name, "sun_trigger", map[string]string{"action": st.Action})
+		r.timers.Start(name, at.Sub(now))
+	}
+}
+
+func (r *regelwerk) handleSunTriggerTimer(ctx context.Context, tm *timers.Timer, expired bool) {
+	name := tm.Meta("action")
+	log.Printf("sun trigger %q: running %q", tm.Name(), name)
+	if spec := r.actions[name]; spec == nil {
+		log.Printf("unknown action %q", name)
+	} else if err := r.Run(withSource(ctx, SOURCE_SUN), spec); err != nil {
+		log.Printf("action %q failed: %v", name, err)
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import mqtt "github.com/eclipse/paho.mqtt.golang"
+
+// Starts the window after (re)subscribing during which messages only update
+// device states, as the broker replays retained states that may be stale
+func (r *regelwerk) startSyncWindow() {
+	if r.syncWindow > 0 {
+		r.syncUntil.Store(r.clock.Now().Add(r.syncWindow).UnixNano())
+	}
+}
+
+// Whether the message should only update the device's state, without firing
+// rules: retained messages, and anything within the sync window, if enabled
+func (r *regelwerk) syncOnly(msg mqtt.Message) bool {
+	if r.syncWindow <= 0 {
+		return false
+	}
+	return msg.Retained() || r.clock.Now().UnixNano() < r.syncUntil.Load()
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"regelwerk/rules"
+)
+
+// Data available to payload templates, e.g.
+// {"brightness": {{ if .IsLateNight }}30{{ else }}254{{ end }}}
+type templateData struct {
+	Payload map[string]any // of the event that triggered the action, if any
+	Topic   string         // of the device that sent it, as matched by a pattern
+	Devices map[string]any // latest states, by device ID and topic
+
+	Now             time.Time
+	Sunrise, Sunset time.Time
+	IsDark          bool
+	IsLateNight     bool    // after midnight, before sunrise
+	Night           float64 // 0 at dusk to 1 at midnight, see nightFraction
+
+	// in degrees, zero if Location is not set
+	SunAzimuth, SunElevation float64
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"jsonpath": rules.QueryJSONPath,
+}
+
+// Latest device states, readable from any group's worker
+type stateCache struct {
+	mu     sync.RWMutex
+	states map[string]any
+}
+
+func (sc *stateCache) Set(d *device) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.states == nil {
+		sc.states = make(map[string]any)
+	}
+	sc.states[d.id] = d.state
+	sc.states[d.topic] = d.state
+}
+
+func (sc *stateCache) Delete(d *device) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	delete(sc.states, d.id)
+	delete(sc.states, d.topic)
+}
+
+func (sc *stateCache) Snapshot() map[string]any {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	m := make(map[string]any, len(sc.states))
+	for k, v := range sc.states {
+		m[k] = v
+	}
+	return m
+}
+
+type triggerKey struct{}
+
+// Attaches the payload of the triggering event, for templates
+func withTrigger(ctx context.Context, payload map[string]any) context.Context {
+	return context.WithValue(ctx, triggerKey{}, payload)
+}
+
+type topicKey struct{}
+
+// Attaches the topic of the device that sent the triggering event, so rules
+// for a topic pattern can tell the matched devices apart
+func withTopic(ctx context.Context, topic string) context.Context {
+	return context.WithValue(ctx, topicKey{}, topic)
+}
+
+// Whether the payload is a template, rather than a literal
+func isTemplate(payload any) bool {
+	s, ok := payload.(string)
+	return ok && strings.Contains(s, "{{")
+}
+
+func parsePayloadTemplate(s string) (*template.Template, error) {
+	return template.New("payload").Funcs(templateFuncs).Parse(s)
+}
+
+// Checks that a payload template can be parsed, if it is one
+func validatePayload(payload any) error {
+	if !isTemplate(payload) {
+		return nil
+	}
+	_, err := parsePayloadTemplate(payload.(string))
+	return err
+}
+
+// Executes the template. The output is decoded if it's valid JSON, so that
+// it can be inspected by middleware, or else used as a plain string.
+func expandPayload(s string, data *templateData) (any, error) {
+	tmpl, err := parsePayloadTemplate(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+
+	var v any
+	if json.Unmarshal(buf.Bytes(), &v) == nil {
+		return v, nil
+	}
+	return buf.String(), nil
+}
+
+// Expands the payload if it's a template, or returns it as-is
+func (r *regelwerk) renderPayload(ctx context.Context, payload any) (any, error) {
+	if !isTemplate(payload) {
+		return payload, nil
+	}
+
+	now := r.clock.Now()
+	data := &templateData{
+		Devices: r.states.Snapshot(),
+		Now:     now,
+		Sunrise: r.sun.Sunrise(now),
+		Sunset:  r.sun.Sunset(now),
+		IsDark:  r.NowIsDusk(nil),
+	}
+	data.Payload, _ = ctx.Value(triggerKey{}).(map[string]any)
+	data.Topic, _ = ctx.Value(topicKey{}).(string)
+	data.IsLateNight = now.Before(data.Sunrise)
+	data.Night = nightFraction(now, data.Sunrise, data.Sunset)
+	if r.sun.known() {
+		data.SunAzimuth, data.SunElevation = r.sun.Position(now)
+	}
+
+	return expandPayload(payload.(string), data)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandPayload(t *testing.T) {
+	data := &templateData{
+		Payload:     map[string]any{"action": "single"},
+		Topic:       "remote_a",
+		Devices:     map[string]any{"switch": "ON"},
+		IsLateNight: true,
+	}
+
+	tests := []struct {
+		tmpl string
+		want any
+	}{
+		{`{"brightness": {{ if .IsLateNight }}30{{ else }}254{{ end }}}`,
+			map[string]any{"brightness": 30.0}},
+		{`{"state": {{ json .Devices.switch }}}`,
+			map[string]any{"state": "ON"}},
+		{`pressed {{ .Payload.action }}`, "pressed single"},
+		{`{{ .Topic }} pressed`, "remote_a pressed"},
+		{`{"first": {{ json (jsonpath .Payload "$.action") }}}`,
+			map[string]any{"first": "single"}},
+	}
+	for _, tt := range tests {
+		got, err := expandPayload(tt.tmpl, data)
+		if err != nil {
+			t.Errorf("%s: %v", tt.tmpl, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: wanted %#v got %#v", tt.tmpl, tt.want, got)
+		}
+	}
+
+	if _, err := expandPayload(`{{ .Missing.field }}`, data); err == nil {
+		t.Errorf("expected error for unknown field")
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// how often setpoints are re-evaluated, for schedules and open windows
+const THERMOSTAT_CHECK_INTERVAL = time.Minute
+
+// Setpoint for TRVs (thermostatic radiator valves). The target follows the
+// Schedule, drops to Away while nobody is home and to WindowOpen while a
+// window is open. Heating demand is derived from the local temperature with
+// Hysteresis, and published to regelwerk/thermostat/<topic>.
+type thermostatConfig struct {
+	Setpoint float64          // target temperature outside the schedule
+	Schedule []setpointPeriod // times of day with a different target
+	Away     float64          // target while nobody is home, 0 to keep heating
+
+	// heating is demanded below Setpoint - Hysteresis until above
+	// Setpoint + Hysteresis
+	Hysteresis float64
+
+	Windows     []string     // contact sensors of the room
+	WindowOpen  float64      // target while a window is open, default 5
+	WindowDelay textDuration // a window must be open this long first
+}
+
+type setpointPeriod struct {
+	From, To timeOfDay // can wrap around midnight
+	Setpoint float64
+}
+
+type thermostats struct {
+	mu   sync.Mutex
+	list []*thermostat
+}
+
+type thermostat struct {
+	topic string
+	cfg   thermostatConfig
+
+	temperature float64
+	tempKnown   bool
+	setpoint    float64 // last sent, 0 if none yet
+	heating     bool
+	openSince   map[string]time.Time // by window topic
+}
+
+// A thermostat's state, as published and in the status
+type thermostatInfo struct {
+	Temperature *float64 `json:"temperature"`
+	Setpoint    float64  `json:"setpoint"`
+	Heating     bool     `json:"heating"`
+	WindowOpen  bool     `json:"window_open"`
+}
+
+func validateThermostats(thermostats map[string]thermostatConfig) error {
+	for topic, tc := range thermostats {
+		if tc.Setpoint <= 0 {
+			return fmt.Errorf("thermostat %q needs a Setpoint", topic)
+		} else if tc.Hysteresis < 0 || tc.Away < 0 || tc.WindowOpen < 0 {
+			return fmt.Errorf("thermostat %q: temperatures cannot be negative", topic)
+		}
+		for _, p := range tc.Schedule {
+			if p.From == p.To || p.Setpoint <= 0 {
+				return fmt.Errorf("thermostat %q: schedule needs From and To to differ, and a Setpoint", topic)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *regelwerk) addThermostats(cfg map[string]thermostatConfig) {
+	if len(cfg) == 0 {
+		return
+	}
+
+	r.thermostats = &thermostats{}
+	for topic, tc := range cfg {
+		if tc.WindowOpen == 0 {
+			tc.WindowOpen = 5
+		}
+		r.thermostats.list = append(r.thermostats.list, &thermostat{
+			topic:     topic,
+			cfg:       tc,
+			openSince: make(map[string]time.Time),
+		})
+
+		r.AddDevice(&device{
+			id:             topic,
+			topic:          topic,
+			stateAttr:      "local_temperature",
+			stateAttrFixed: true,
+		})
+		for _, w := range tc.Windows {
+			if r.devices[w] == nil {
+				r.AddDevice(&device{id: w, topic: w, stateAttr: "contact", state: true})
+			}
+		}
+	}
+}
+
+// Returns the setpoint at the time, before window and presence adjustments
+func (th *thermostat) scheduled(now time.Time) float64 {
+	for _, p := range th.cfg.Schedule {
+		if inPeriod(now, p.From, p.To) {
+			return p.Setpoint
+		}
+	}
+	return th.cfg.Setpoint
+}
+
+// Whether a window has been open for longer than the WindowDelay
+func (th *thermostat) windowOpen(now time.Time) bool {
+	for _, since := range th.openSince {
+		if now.Sub(since) >= time.Duration(th.cfg.WindowDelay) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the target temperature at the time
+func (th *thermostat) target(now time.Time, home bool) float64 {
+	if th.windowOpen(now) {
+		return th.cfg.WindowOpen
+	} else if !home && th.cfg.Away > 0 {
+		return th.cfg.Away
+	}
+	return th.scheduled(now)
+}
+
+func (th *thermostat) info(now time.Time) thermostatInfo {
+	info := thermostatInfo{Setpoint: th.setpoint, Heating: th.heating, WindowOpen: th.windowOpen(now)}
+	if th.tempKnown {
+		t := th.temperature
+		info.Temperature = &t
+	}
+	return info
+}
+
+// Tracks the temperature of thermostats and the windows of their rooms
+func (r *regelwerk) observeThermostats(ctx context.Context, d *device, payload map[string]any) {
+	ts := r.thermostats
+	if ts == nil {
+		return
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	now := r.clock.Now()
+	for _, th := range ts.list {
+		changed := false
+		if d.topic == th.topic {
+			if temp, ok := payload["local_temperature"].(float64); ok {
+				th.temperature, th.tempKnown = temp, true
+				changed = true
+			}
+		}
+		for _, w := range th.cfg.Windows {
+			if closed, ok := payload["contact"].(bool); ok && d.topic == w {
+				if _, open := th.openSince[w]; closed && open {
+					delete(th.openSince, w)
+				} else if !closed && !open {
+					th.openSince[w] = now
+				}
+				changed = true
+			}
+		}
+
+		if changed {
+			r.updateThermostat(ctx, th, now)
+		}
+	}
+}
+
+// Periodically re-evaluates the setpoints as schedules and windows change
+func (r *regelwerk) runThermostats(ctx context.Context) {
+	for r.idle.Sleep(ctx, THERMOSTAT_CHECK_INTERVAL) {
+		ts := r.thermostats
+		ts.mu.Lock()
+		for _, th := range ts.list {
+			r.updateThermostat(ctx, th, r.clock.Now())
+		}
+		ts.mu.Unlock()
+	}
+}
+
+// Sends a new setpoint if the target changed, and updates the heating demand.
+// Must be called with the thermostats lock held.
+func (r *regelwerk) updateThermostat(ctx context.Context, th *thermostat, now time.Time) {
+	target := th.target(now, r.SomeoneHome())
+	changed := false
+
+	if target != th.setpoint {
+		log.Printf("thermostat %q setpoint %.1f -> %.1f", th.topic, th.setpoint, target)
+		th.setpoint = target
+		changed = true
+
+		r.Do(ctx, &action{
+			dev:     r.devices[th.topic],
+			payload: map[string]any{"current_heating_setpoint": target},
+			source:  SOURCE_THERMOSTAT,
+		})
+	}
+
+	if th.tempKnown {
+		heating := th.heating
+		if th.heating && th.temperature >= target+th.cfg.Hysteresis {
+			heating = false
+		} else if !th.heating && th.temperature <= target-th.cfg.Hysteresis {
+			heating = true
+		}
+		if heating != th.heating {
+			th.heating = heating
+			changed = true
+		}
+	}
+
+	if changed {
+		r.publishJSON("thermostat/"+th.topic, th.info(now))
+	}
+}
+
+// Reports the state of each thermostat
+func (r *regelwerk) ThermostatReport() map[string]thermostatInfo {
+	ts := r.thermostats
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	now := r.clock.Now()
+	report := make(map[string]thermostatInfo, len(ts.list))
+	for _, th := range ts.list {
+		report[th.topic] = th.info(now)
+	}
+	return report
+}
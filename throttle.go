@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"regelwerk/mqttio"
+)
+
+// Fires the change handlers for the device, unless it's being debounced.
+// With debouncing, the handlers only fire once the state has settled for the
+// debounce period, and only if it differs from the last settled state.
+// Must be called with the group lock held.
+func (r *regelwerk) deviceChanged(ctx context.Context, d *device, payload map[string]any) {
+	if d.debounce == 0 {
+		r.fireDeviceChanged(ctx, d, payload)
+		return
+	}
+
+	d.pendingPayload, d.pendingCtx = payload, ctx
+	if d.debounceT != nil {
+		d.debounceT.Reset(d.debounce)
+		return
+	}
+
+	d.debounceT = r.clock.AfterFunc(d.debounce, func() {
+		d.group.mu.Lock()
+		defer d.group.mu.Unlock()
+
+		d.debounceT = nil
+		ctx := d.pendingCtx
+		d.pendingCtx = nil
+		if ctx.Err() != nil {
+			return
+		} else if d.state != d.settledState {
+			r.fireDeviceChanged(ctx, d, d.pendingPayload)
+		} else {
+			debugf(LOG_DEVICES, "dev %q state %q settled back to %#v", d.id, d.stateAttr, d.state)
+		}
+	})
+}
+
+func (r *regelwerk) fireDeviceChanged(ctx context.Context, d *device, payload map[string]any) {
+	d.settledState = d.state
+
+	debugf(LOG_DEVICES, "dev %q (%q) state %q changed to %#v",
+		d.id, d.topic, d.stateAttr, d.state)
+	r.handleDeviceChangedEvent(ctx, d, payload)
+}
+
+// Returns a middleware that spaces out publishes to the same device by at
+// least its minInterval. Actions that come too soon are held back until the
+// interval has passed, with newer actions replacing older held ones.
+func (r *regelwerk) rateLimitActions() middleware {
+	type limit struct {
+		lastSent   time.Time
+		pending    *action
+		pendingCtx context.Context
+	}
+
+	var mu sync.Mutex
+	limits := make(map[*device]*limit)
+
+	return func(next actionFunc) actionFunc {
+		return func(ctx context.Context, a *action) error {
+			if a.dev == nil || a.dev.minInterval == 0 {
+				return next(ctx, a)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			l := limits[a.dev]
+			if l == nil {
+				l = &limit{}
+				limits[a.dev] = l
+			}
+
+			wait := a.dev.minInterval - r.clock.Now().Sub(l.lastSent)
+			if wait <= 0 && l.pending == nil {
+				l.lastSent = r.clock.Now()
+				return next(ctx, a)
+			}
+
+			if l.pending != nil {
+				log.Printf("rate limited: action %s superseded", l.pending)
+			} else {
+				r.clock.AfterFunc(wait, func() {
+					mu.Lock()
+					pa, pctx := l.pending, l.pendingCtx
+					l.pending, l.pendingCtx = nil, nil
+					l.lastSent = r.clock.Now()
+					mu.Unlock()
+
+					if err := next(pctx, pa); err != nil {
+						log.Printf("action %s not sent: %v", pa, err)
+					}
+				})
+			}
+			l.pending, l.pendingCtx = a, ctx
+			return nil
+		}
+	}
+}
+
+// Returns a middleware that drops commands switching a device's state within
+// its minToggle of the last switch, protecting mechanical relays from rapid
+// toggling by flapping sensors or rules fighting each other. Commands that
+// don't change the state are let through.
+func (r *regelwerk) protectRelays() middleware {
+	type toggle struct {
+		state any
+		at    time.Time
+	}
+
+	var mu sync.Mutex
+	last := make(map[*device]toggle)
+
+	return func(next actionFunc) actionFunc {
+		return func(ctx context.Context, a *action) error {
+			if a.dev == nil || a.dev.minToggle == 0 || isEmergency(ctx) {
+				return next(ctx, a)
+			}
+			m, _ := a.payload.(map[string]any)
+			state, ok := mqttio.LookupPath(m, a.dev.stateAttr)
+			if !ok {
+				return next(ctx, a)
+			}
+
+			mu.Lock()
+			now := r.clock.Now()
+			t, found := last[a.dev]
+			if found && t.state != state {
+				if since := now.Sub(t.at); since < a.dev.minToggle {
+					mu.Unlock()
+					return fmt.Errorf("suppressed, last switched %s ago", since.Round(time.Second))
+				}
+			}
+			if !found || t.state != state {
+				last[a.dev] = toggle{state, now}
+			}
+			mu.Unlock()
+
+			return next(ctx, a)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package timers
+
+import "time"
+
+// Source of time for timers, so that tests can control it
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) ClockTimer
+}
+
+// The subset of *time.Timer in use
+type ClockTimer interface {
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// The system clock
+type RealClock struct{}
+
+func (RealClock) Now() time.Time { return time.Now() }
+
+func (RealClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	return time.AfterFunc(d, f)
+}
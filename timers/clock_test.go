@@ -0,0 +1,26 @@
+package timers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	c := NewFakeClock(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	var fired []int
+	c.AfterFunc(2*time.Second, func() { fired = append(fired, 2) })
+	c.AfterFunc(time.Second, func() { fired = append(fired, 1) })
+	t3 := c.AfterFunc(3*time.Second, func() { fired = append(fired, 3) })
+	t3.Stop()
+
+	c.Advance(1500 * time.Millisecond)
+	if len(fired) != 1 {
+		t.Errorf("expected 1 timer fired, got %v", fired)
+	}
+
+	c.Advance(5 * time.Second)
+	if len(fired) != 2 || fired[0] != 1 || fired[1] != 2 {
+		t.Errorf("expected timers 1, 2 in order, got %v", fired)
+	}
+}
@@ -0,0 +1,104 @@
+package timers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A timer as written to the state file
+type savedTimer struct {
+	Name      string
+	Callback  string
+	Meta      map[string]string `json:",omitempty"`
+	Deadline  time.Time         `json:",omitempty"` // zero if stopped
+	Remaining time.Duration     `json:",omitempty"` // if paused
+	Expiry    time.Time         `json:",omitempty"`
+}
+
+// Writes out all timers to the state file.
+// Must be called with mu held.
+func (ts *Set) save() {
+	if ts.StateFile == "" {
+		return
+	}
+
+	saved := make([]savedTimer, 0, len(ts.timers))
+	for name, t := range ts.timers {
+		saved = append(saved, savedTimer{name, t.callback, t.meta, t.deadline, t.remaining, t.expiry})
+	}
+
+	js, err := json.Marshal(saved)
+	if err == nil {
+		err = WriteFileAtomic(ts.StateFile, js)
+	}
+	if err != nil {
+		log.Printf("unable to save timers: %v", err)
+	}
+}
+
+// Re-arms timers from the state file.
+// Timers that should have fired while we were down will fire immediately.
+func (ts *Set) Restore() error {
+	if ts.StateFile == "" {
+		return nil
+	}
+
+	js, err := os.ReadFile(ts.StateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var saved []savedTimer
+	if err := json.Unmarshal(js, &saved); err != nil {
+		return err
+	}
+
+	for _, st := range saved {
+		var tm *Timer
+		if st.Expiry.IsZero() {
+			tm = ts.Add(st.Name, st.Callback, st.Meta)
+		} else {
+			tm = ts.AddWithExpiry(st.Name, st.Callback, st.Meta, st.Expiry.Sub(ts.Clock.Now()))
+		}
+		if tm == nil {
+			continue
+		}
+
+		if !st.Deadline.IsZero() {
+			ts.Start(st.Name, st.Deadline.Sub(ts.Clock.Now()))
+		} else if st.Remaining > 0 {
+			ts.mu.Lock()
+			tm.remaining = st.Remaining
+			ts.mu.Unlock()
+		}
+
+		log.Printf("restored timer %q", st.Name)
+	}
+
+	return nil
+}
+
+// Replaces the file contents, without leaving a partially written file behind
+func WriteFileAtomic(fname string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(fname), filepath.Base(fname)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), fname)
+}
@@ -0,0 +1,296 @@
+// Package timers provides named countdown timers that call registered
+// callbacks, and can be persisted to a file to survive restarts.
+package timers
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Called when a timer fires, or when its expiry timer fires instead
+type Func func(ctx context.Context, tm *Timer, expired bool)
+
+// A named countdown timer, with an optional expiry.
+// The countdown is created stopped and can be (re)started, stopped and paused
+// any number of times. The expiry, if set, runs independently and fires the
+// callback even if the countdown never completes.
+type Timer struct {
+	name     string
+	callback string            // name of registered callback
+	meta     map[string]string // arbitrary info, e.g. device or session
+
+	t, expT ClockTimer
+	fired   atomic.Uint32
+
+	// when the timers will fire, zero if not running
+	deadline, expiry time.Time
+
+	// time left on the countdown, if paused
+	remaining time.Duration
+}
+
+func (tm *Timer) Name() string {
+	return tm.name
+}
+
+// Returns the metadata value for key, or empty string if not set
+func (tm *Timer) Meta(key string) string {
+	return tm.meta[key]
+}
+
+// A snapshot of a timer's state, for listing
+type Info struct {
+	Name      string
+	Meta      map[string]string `json:",omitempty"`
+	Running   bool
+	Remaining time.Duration
+	Expiry    time.Time `json:",omitempty"`
+}
+
+// Collection of named timers, at most one per name
+type Set struct {
+	mu        sync.Mutex
+	timers    map[string]*Timer
+	callbacks map[string]Func
+
+	StateFile string // where timers are persisted, if set
+	Clock     Clock
+
+	// logs timers firing, if set
+	Debugf func(format string, args ...any)
+
+	// passed to callbacks, which are not called once it's done
+	ctx context.Context
+}
+
+func NewSet(ctx context.Context) *Set {
+	return &Set{
+		ctx:       ctx,
+		Clock:     RealClock{},
+		timers:    make(map[string]*Timer),
+		callbacks: make(map[string]Func),
+	}
+}
+
+// Registers a callback that timers can refer to by name.
+// Callbacks are referenced by name so that persisted timers can be restored.
+func (ts *Set) Register(callback string, fn Func) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.callbacks[callback] = fn
+}
+
+func (ts *Set) mkTimerFunc(expired bool, tm *Timer) func() {
+	return func() {
+		// guard against timeout & expiry firing twice
+		if ts.ctx.Err() == nil && tm.fired.CompareAndSwap(0, 1) {
+			if ts.Debugf != nil {
+				ev := "fired"
+				if expired {
+					ev = "expired"
+				}
+				ts.Debugf("timer %q %s", tm.name, ev)
+			}
+
+			ts.mu.Lock()
+			if ts.timers[tm.name] != tm {
+				// destroyed just as it fired
+				ts.mu.Unlock()
+				return
+			}
+			fn := ts.callbacks[tm.callback]
+			tm.stop()
+			ts.mu.Unlock()
+
+			if fn != nil {
+				fn(ts.ctx, tm, expired)
+			} else {
+				log.Printf("timer %q has no callback %q", tm.name, tm.callback)
+			}
+
+			ts.mu.Lock()
+			defer ts.mu.Unlock()
+
+			if ts.timers[tm.name] == tm {
+				delete(ts.timers, tm.name)
+				ts.save()
+			}
+		}
+	}
+}
+
+// Creates a stopped timer that calls the named callback when it fires.
+// Returns nil if a timer by that name already exists.
+func (ts *Set) Add(name, callback string, meta map[string]string) *Timer {
+	return ts.add(name, callback, meta, 0)
+}
+
+// Like Add, but the callback will also be fired after the expiry duration,
+// whether or not the timer was started
+func (ts *Set) AddWithExpiry(name, callback string, meta map[string]string, expiry time.Duration) *Timer {
+	return ts.add(name, callback, meta, expiry)
+}
+
+func (ts *Set) add(name, callback string, meta map[string]string, expiry time.Duration) *Timer {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	// do not overwrite existing timers
+	if _, exists := ts.timers[name]; exists {
+		return nil
+	}
+
+	tm := &Timer{name: name, callback: callback, meta: meta}
+	tm.t = ts.Clock.AfterFunc(time.Hour, ts.mkTimerFunc(false, tm))
+	tm.t.Stop()
+
+	// both timers are only ever created under the lock, so they can always
+	// be stopped together
+	if expiry != 0 {
+		tm.expiry = ts.Clock.Now().Add(expiry)
+		tm.expT = ts.Clock.AfterFunc(expiry, ts.mkTimerFunc(true, tm))
+	}
+
+	ts.timers[name] = tm
+	ts.save()
+	return tm
+}
+
+// Stops both countdown and expiry timers
+func (t *Timer) stop() {
+	t.t.Stop()
+	if t.expT != nil {
+		t.expT.Stop()
+	}
+}
+
+// Stops and removes the timer, without firing it
+func (ts *Set) Destroy(name string) bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if t := ts.timers[name]; t != nil {
+		t.stop()
+		delete(ts.timers, name)
+		ts.save()
+		return true
+	}
+
+	return false
+}
+
+// Tries to (re)start timer if it exists
+// Returns whether the timer was found, false if it wasn't
+func (ts *Set) Start(name string, dur time.Duration) bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	t, found := ts.timers[name]
+	if !found {
+		return false
+	}
+
+	t.t.Reset(dur)
+	t.deadline = ts.Clock.Now().Add(dur)
+	t.remaining = 0
+	ts.save()
+	return true
+}
+
+// Stop a timer, if found
+// Does not affect the expiry timer; that continues running
+func (ts *Set) Stop(name string) *Timer {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	t, found := ts.timers[name]
+	if !found {
+		return nil
+	}
+
+	t.t.Stop()
+	t.deadline = time.Time{}
+	t.remaining = 0
+	ts.save()
+	return t
+}
+
+// Stops the countdown but remembers the time left, for Resume
+func (ts *Set) Pause(name string) bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	t, found := ts.timers[name]
+	if !found || t.deadline.IsZero() {
+		return false
+	}
+
+	t.t.Stop()
+	t.remaining = t.deadline.Sub(ts.Clock.Now())
+	t.deadline = time.Time{}
+	ts.save()
+	return true
+}
+
+// Continues a paused countdown
+func (ts *Set) Resume(name string) bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	t, found := ts.timers[name]
+	if !found || t.remaining == 0 {
+		return false
+	}
+
+	t.t.Reset(t.remaining)
+	t.deadline = ts.Clock.Now().Add(t.remaining)
+	t.remaining = 0
+	ts.save()
+	return true
+}
+
+// Returns the time left on the countdown.
+// ok is false if the timer doesn't exist.
+func (ts *Set) Remaining(name string) (left time.Duration, ok bool) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	t, found := ts.timers[name]
+	if !found {
+		return 0, false
+	}
+	return t.timeLeft(ts.Clock.Now()), true
+}
+
+func (t *Timer) timeLeft(now time.Time) time.Duration {
+	if !t.deadline.IsZero() {
+		return t.deadline.Sub(now)
+	}
+	return t.remaining
+}
+
+// Lists all timers, sorted by name
+func (ts *Set) List() []Info {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	now := ts.Clock.Now()
+	list := make([]Info, 0, len(ts.timers))
+	for _, t := range ts.timers {
+		list = append(list, Info{
+			Name:      t.name,
+			Meta:      t.meta,
+			Running:   !t.deadline.IsZero(),
+			Remaining: t.timeLeft(now),
+			Expiry:    t.expiry,
+		})
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
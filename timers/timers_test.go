@@ -0,0 +1,110 @@
+package timers
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Creates a Set on a fake clock, with a "count" callback that tallies how
+// often it fired
+func newCountingSet() (*Set, *FakeClock, *atomic.Int32, *atomic.Int32) {
+	var fired, expired atomic.Int32
+	fc := NewFakeClock(time.Date(2022, 6, 1, 10, 0, 0, 0, time.UTC))
+	ts := NewSet(context.Background())
+	ts.Clock = fc
+	ts.Register("count", func(ctx context.Context, tm *Timer, exp bool) {
+		if exp {
+			expired.Add(1)
+		} else {
+			fired.Add(1)
+		}
+	})
+	return ts, fc, &fired, &expired
+}
+
+func TestTimerDestroyBeforeExpiry(t *testing.T) {
+	ts, fc, fired, expired := newCountingSet()
+
+	if ts.AddWithExpiry("a", "count", nil, 20*time.Second) == nil {
+		t.Fatal("timer not added")
+	}
+	if !ts.Destroy("a") {
+		t.Fatal("timer not destroyed")
+	}
+
+	fc.Advance(time.Minute)
+	if n := fired.Load() + expired.Load(); n != 0 {
+		t.Errorf("destroyed timer fired %d times", n)
+	}
+}
+
+func TestTimerStopKeepsExpiry(t *testing.T) {
+	ts, fc, fired, expired := newCountingSet()
+
+	ts.AddWithExpiry("a", "count", nil, 20*time.Second)
+	ts.Start("a", time.Hour)
+	if ts.Stop("a") == nil {
+		t.Fatal("timer not found")
+	}
+
+	fc.Advance(time.Minute)
+	if fired.Load() != 0 || expired.Load() != 1 {
+		t.Errorf("wanted expiry only, got fired %d expired %d", fired.Load(), expired.Load())
+	}
+	if _, ok := ts.Remaining("a"); ok {
+		t.Error("expired timer not removed")
+	}
+}
+
+func TestTimerFireCancelsExpiry(t *testing.T) {
+	ts, fc, fired, expired := newCountingSet()
+
+	tm := ts.AddWithExpiry("a", "count", nil, 40*time.Second)
+	ts.Start("a", 10*time.Second)
+
+	fc.Advance(time.Minute)
+	if fired.Load() != 1 || expired.Load() != 0 {
+		t.Errorf("wanted single fire, got fired %d expired %d", fired.Load(), expired.Load())
+	}
+	if tm.expT.Stop() {
+		t.Error("expiry timer still pending after firing")
+	}
+}
+
+func TestTimerNoOverwrite(t *testing.T) {
+	ts, _, _, _ := newCountingSet()
+
+	if ts.AddWithExpiry("a", "count", nil, time.Hour) == nil {
+		t.Fatal("timer not added")
+	}
+	if ts.Add("a", "count", nil) != nil {
+		t.Error("existing timer was overwritten")
+	}
+	ts.Destroy("a")
+}
+
+func TestTimerPauseResume(t *testing.T) {
+	ts, fc, _, _ := newCountingSet()
+
+	ts.Add("a", "count", nil)
+	ts.Start("a", time.Hour)
+	if !ts.Pause("a") {
+		t.Fatal("timer not paused")
+	}
+
+	left, _ := ts.Remaining("a")
+	fc.Advance(10 * time.Minute)
+	if left2, _ := ts.Remaining("a"); left2 != left {
+		t.Errorf("paused timer still counting down: %v -> %v", left, left2)
+	}
+
+	if !ts.Resume("a") {
+		t.Fatal("timer not resumed")
+	}
+	if list := ts.List(); len(list) != 1 || !list[0].Running {
+		t.Errorf("resumed timer not running: %+v", list)
+	}
+	ts.Destroy("a")
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// finished spans held while the collector is unreachable, older ones are
+// dropped
+const TRACE_BUFFER_SIZE = 5000
+
+// Sends spans of message handling to an OpenTelemetry collector, or anything
+// else accepting OTLP over HTTP with JSON like Jaeger or Tempo, to analyze
+// latency and which events caused which actions
+type tracingConfig struct {
+	URL         string // traces endpoint, e.g. http://localhost:4318/v1/traces
+	ServiceName string // default regelwerk
+	Interval    textDuration
+}
+
+type tracer struct {
+	cfg    tracingConfig
+	client http.Client
+
+	mu    sync.Mutex
+	spans []*span
+}
+
+// A timed operation, within a trace of a message and what it caused
+type span struct {
+	t                *tracer
+	traceID          [16]byte
+	spanID, parentID [8]byte
+
+	name       string
+	start, end time.Time
+	attrs      map[string]string
+	err        error
+}
+
+type spanKey struct{}
+
+func newTracer(cfg tracingConfig) *tracer {
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = "regelwerk"
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = textDuration(5 * time.Second)
+	}
+	return &tracer{cfg: cfg, client: http.Client{Timeout: 10 * time.Second}}
+}
+
+// Starts a span, as child of the context's span if any.
+// Returns a nil span if not tracing, which can still be used.
+func (t *tracer) Start(ctx context.Context, name string, start time.Time) (context.Context, *span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	sp := &span{t: t, name: name, start: start, attrs: make(map[string]string)}
+	if parent, _ := ctx.Value(spanKey{}).(*span); parent != nil {
+		sp.traceID, sp.parentID = parent.traceID, parent.spanID
+	} else {
+		rand.Read(sp.traceID[:])
+	}
+	rand.Read(sp.spanID[:])
+
+	return context.WithValue(ctx, spanKey{}, sp), sp
+}
+
+func (sp *span) SetAttr(key, value string) {
+	if sp != nil {
+		sp.attrs[key] = value
+	}
+}
+
+// Marks the span as failed, if err isn't nil
+func (sp *span) SetError(err error) {
+	if sp != nil {
+		sp.err = err
+	}
+}
+
+// Finishes the span, queueing it for the next export
+func (sp *span) End() {
+	if sp == nil {
+		return
+	}
+	sp.end = time.Now()
+
+	t := sp.t
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.spans) >= TRACE_BUFFER_SIZE {
+		t.spans = t.spans[1:]
+	}
+	t.spans = append(t.spans, sp)
+}
+
+// Traces actions through the rest of the middleware chain, up to publishing
+func (t *tracer) traceActions(next actionFunc) actionFunc {
+	return func(ctx context.Context, a *action) error {
+		ctx, sp := t.Start(ctx, "action", time.Now())
+		sp.SetAttr("target", a.Target())
+		sp.SetAttr("source", a.source)
+		defer sp.End()
+
+		err := next(ctx, a)
+		sp.SetError(err)
+		return err
+	}
+}
+
+// Exports finished spans every Interval until the context is done
+func (t *tracer) Run(ctx context.Context, idle *idleDetector) {
+	for idle.Sleep(ctx, time.Duration(t.cfg.Interval)) {
+		t.mu.Lock()
+		spans := t.spans
+		t.spans = nil
+		t.mu.Unlock()
+
+		if len(spans) == 0 {
+			continue
+		}
+
+		if err := t.export(ctx, spans); err != nil {
+			log.Printf("trace export failed, will retry: %v", err)
+
+			t.mu.Lock()
+			t.spans = append(spans, t.spans...)
+			if n := len(t.spans) - TRACE_BUFFER_SIZE; n > 0 {
+				t.spans = t.spans[n:]
+			}
+			t.mu.Unlock()
+		}
+	}
+}
+
+func (t *tracer) export(ctx context.Context, spans []*span) error {
+	body, err := json.Marshal(otlpRequest(t.cfg.ServiceName, spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// OTLP's JSON encoding of an export request
+type otlpAttr struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID      string     `json:"traceId"`
+	SpanID       string     `json:"spanId"`
+	ParentSpanID string     `json:"parentSpanId,omitempty"`
+	Name         string     `json:"name"`
+	Kind         int        `json:"kind"`
+	Start        string     `json:"startTimeUnixNano"`
+	End          string     `json:"endTimeUnixNano"`
+	Attributes   []otlpAttr `json:"attributes,omitempty"`
+	Status       struct {
+		Code    int    `json:"code,omitempty"` // 2 is error
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+func otlpAttrs(m map[string]string) []otlpAttr {
+	var attrs []otlpAttr
+	for _, k := range sortedKeys(m) {
+		a := otlpAttr{Key: k}
+		a.Value.StringValue = m[k]
+		attrs = append(attrs, a)
+	}
+	return attrs
+}
+
+func otlpRequest(service string, spans []*span) map[string]any {
+	list := make([]otlpSpan, 0, len(spans))
+	for _, sp := range spans {
+		o := otlpSpan{
+			TraceID:    hex.EncodeToString(sp.traceID[:]),
+			SpanID:     hex.EncodeToString(sp.spanID[:]),
+			Name:       sp.name,
+			Kind:       1, // internal
+			Start:      strconv.FormatInt(sp.start.UnixNano(), 10),
+			End:        strconv.FormatInt(sp.end.UnixNano(), 10),
+			Attributes: otlpAttrs(sp.attrs),
+		}
+		if sp.parentID != [8]byte{} {
+			o.ParentSpanID = hex.EncodeToString(sp.parentID[:])
+		}
+		if sp.err != nil {
+			o.Status.Code, o.Status.Message = 2, sp.err.Error()
+		}
+		list = append(list, o)
+	}
+
+	return map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": otlpAttrs(map[string]string{"service.name": service}),
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "regelwerk"},
+				"spans": list,
+			}},
+		}},
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTraceActions(t *testing.T) {
+	cfg := testConfig()
+	cfg.Tracing = &tracingConfig{URL: "http://localhost:4318/v1/traces"}
+	r, mc := newTestRegelwerk(t, cfg)
+
+	ctx, msg := r.tracer.Start(context.Background(), "message", time.Now())
+	r.Do(ctx, r.LookupDevice("switch").NewState("ON"))
+	msg.End()
+	mc.WaitFor(t, "zigbee2mqtt/light/set", 1)
+
+	req := otlpRequest("regelwerk", r.tracer.spans)
+	spans := req["resourceSpans"].([]any)[0].(map[string]any)["scopeSpans"].([]any)[0].(map[string]any)["spans"].([]otlpSpan)
+	if len(spans) != 2 {
+		t.Fatalf("wanted 2 spans, got %+v", spans)
+	}
+	action, message := spans[0], spans[1]
+	if action.Name != "action" || action.TraceID != message.TraceID || action.ParentSpanID != message.SpanID {
+		t.Errorf("action not traced as part of the message: %+v", spans)
+	} else if message.ParentSpanID != "" {
+		t.Errorf("message span has a parent")
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"regelwerk/timers"
+)
+
+// Simulates presence while on vacation, by turning lights on around dusk
+// and off some time later, with random variations every day
+type vacationConfig struct {
+	Lights []string     // z2m devices, switched by their "state" attribute
+	Jitter textDuration // max random offset from dusk for turning on
+
+	// how long lights stay on, picked randomly between the two
+	MinOn, MaxOn textDuration
+}
+
+type vacation struct {
+	cfg vacationConfig
+
+	mu      sync.Mutex
+	enabled bool
+	lit     map[string]bool // lights we turned on
+	file    string          // next to the -state file, empty if not persisted
+}
+
+// The vacation mode as written to its file
+type savedVacation struct {
+	Enabled bool
+	Lit     []string `json:",omitempty"`
+}
+
+func newVacation(cfg vacationConfig, stateFile string) *vacation {
+	v := &vacation{cfg: cfg, lit: make(map[string]bool)}
+	if stateFile != "" {
+		v.file = filepath.Join(filepath.Dir(stateFile), "vacation.json")
+	}
+	return v
+}
+
+// A planned on/off time of a light, as published
+type vacationSlot struct {
+	On, Off time.Time
+}
+
+// Turns vacation mode on or off
+func (r *regelwerk) SetVacation(ctx context.Context, enabled bool) error {
+	v := r.vacation
+	if v == nil {
+		return fmt.Errorf("vacation mode is not configured")
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.enabled == enabled {
+		return nil
+	}
+
+	v.enabled = enabled
+	v.save()
+	log.Printf("vacation mode %s", onOff(enabled))
+	r.client.Publish(CONTROL_TOPIC_PREFIX+"vacation", 0, true, onOff(enabled))
+
+	if enabled {
+		r.planVacation(r.clock.Now())
+	} else {
+		for _, t := range r.timers.List() {
+			if strings.HasPrefix(t.Name, "vacation/") {
+				r.timers.Destroy(t.Name)
+			}
+		}
+		for light := range v.lit {
+			r.switchVacationLight(ctx, light, false)
+		}
+	}
+	return nil
+}
+
+// Schedules the lights for the day, and the planning of the next day.
+// Must be called with the vacation lock held.
+func (r *regelwerk) planVacation(day time.Time) {
+	v := r.vacation
+	cfg := v.cfg
+
+	dusk := r.sun.Sunset(day)
+	schedule := make(map[string]vacationSlot)
+
+	for _, light := range cfg.Lights {
+		on := dusk.Add(randDuration(-time.Duration(cfg.Jitter), time.Duration(cfg.Jitter)))
+		off := on.Add(randDuration(time.Duration(cfg.MinOn), time.Duration(cfg.MaxOn)))
+		if off.Before(r.clock.Now()) {
+			continue
+		}
+		schedule[light] = vacationSlot{on, off}
+
+		meta := map[string]string{"device": light}
+		r.timers.Destroy("vacation/" + light + "/on")
+		r.timers.Destroy("vacation/" + light + "/off")
+		r.timers.Add("vacation/"+light+"/on", "vacation_on", meta)
+		r.timers.Add("vacation/"+light+"/off", "vacation_off", meta)
+		r.timers.Start("vacation/"+light+"/on", on.Sub(r.clock.Now()))
+		r.timers.Start("vacation/"+light+"/off", off.Sub(r.clock.Now()))
+
+		log.Printf("vacation: %q on at %s, off at %s", light,
+			on.Format(time.Kitchen), off.Format(time.Kitchen))
+	}
+
+	js, _ := json.Marshal(schedule)
+	r.client.Publish(CONTROL_TOPIC_PREFIX+"vacation/schedule", 0, true, js)
+
+	// plan the next day around noon, well clear of the evening
+	y, m, d := day.Date()
+	next := time.Date(y, m, d+1, 12, 0, 0, 0, day.Location())
+	r.timers.Destroy("vacation/plan")
+	r.timers.Add("vacation/plan", "vacation_plan", nil)
+	r.timers.Start("vacation/plan", next.Sub(r.clock.Now()))
+}
+
+func (r *regelwerk) switchVacationLight(ctx context.Context, light string, on bool) {
+	if on {
+		r.vacation.lit[light] = true
+	} else {
+		delete(r.vacation.lit, light)
+	}
+	r.vacation.save()
+
+	r.Do(ctx, &action{
+		topic:   MQTT_TOPIC_PREFIX + light + "/set",
+		payload: map[string]any{"state": onOff(on)},
+		source:  SOURCE_VACATION,
+	})
+}
+
+// Writes out the mode and the lights we turned on, for Restore.
+// Must be called with the vacation lock held.
+func (v *vacation) save() {
+	if v.file == "" {
+		return
+	}
+
+	saved := savedVacation{Enabled: v.enabled}
+	for light := range v.lit {
+		saved.Lit = append(saved.Lit, light)
+	}
+
+	js, _ := json.Marshal(saved)
+	if err := timers.WriteFileAtomic(v.file, js); err != nil {
+		log.Printf("unable to save vacation mode: %v", err)
+	}
+}
+
+// Loads the mode and the lights we turned on, if they were saved. Its timers
+// are restored along with the others.
+func (v *vacation) Restore() error {
+	if v.file == "" {
+		return nil
+	}
+
+	js, err := os.ReadFile(v.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var saved savedVacation
+	if err := json.Unmarshal(js, &saved); err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.enabled = saved.Enabled
+	for _, light := range saved.Lit {
+		v.lit[light] = true
+	}
+	if v.enabled {
+		log.Printf("vacation mode is on")
+	}
+	return nil
+}
+
+// Wraps a timer callback to run with the vacation lock held, and only if
+// vacation mode is on
+func (v *vacation) locked(fn timers.Func) timers.Func {
+	return func(ctx context.Context, tm *timers.Timer, expired bool) {
+		v.mu.Lock()
+		defer v.mu.Unlock()
+
+		if v.enabled {
+			fn(ctx, tm, expired)
+		}
+	}
+}
+
+func (r *regelwerk) registerVacation() {
+	v := r.vacation
+	r.timers.Register("vacation_on", v.locked(func(ctx context.Context, tm *timers.Timer, _ bool) {
+		r.switchVacationLight(ctx, tm.Meta("device"), true)
+	}))
+	r.timers.Register("vacation_off", v.locked(func(ctx context.Context, tm *timers.Timer, _ bool) {
+		r.switchVacationLight(ctx, tm.Meta("device"), false)
+	}))
+	r.timers.Register("vacation_plan", v.locked(func(ctx context.Context, tm *timers.Timer, _ bool) {
+		r.planVacation(r.clock.Now())
+	}))
+
+	r.HandleCommand("vacation/set", func(ctx context.Context, payload []byte) error {
+		on, err := parseOnOff(payload)
+		if err != nil {
+			return err
+		}
+		return r.SetVacation(ctx, on)
+	})
+}
+
+// Returns a random duration in [min, max)
+func randDuration(min, max time.Duration) time.Duration {
+	if max <= min {
+		return min
+	}
+	return min + time.Duration(rand.Int63n(int64(max-min)))
+}
+
+func onOff(b bool) string {
+	if b {
+		return "ON"
+	}
+	return "OFF"
+}
+
+// Parses an ON/OFF payload, also accepting true/false and 1/0
+func parseOnOff(payload []byte) (bool, error) {
+	switch strings.ToUpper(strings.TrimSpace(string(payload))) {
+	case "ON", "TRUE", "1":
+		return true, nil
+	case "OFF", "FALSE", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("expected ON or OFF, got %q", payload)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"regelwerk/timers"
+)
+
+func TestVacationRestore(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "timers.json")
+	cfg := testConfig()
+	cfg.Vacation = &vacationConfig{
+		Lights: []string{"lamp"},
+		MinOn:  textDuration(time.Hour),
+		MaxOn:  textDuration(time.Hour),
+	}
+	r, _ := newTestRegelwerk(t, cfg)
+	fc := timers.NewFakeClock(time.Date(2022, 6, 1, 10, 0, 0, 0, time.Local))
+	r.clock, r.timers.Clock = fc, fc
+
+	r.vacation = newVacation(*cfg.Vacation, stateFile)
+	if err := r.SetVacation(r.ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	r.vacation.mu.Lock()
+	r.switchVacationLight(r.ctx, "lamp", true)
+	r.vacation.mu.Unlock()
+
+	// after a restart, turning it off still turns off the lamp
+	r, mc := newTestRegelwerk(t, cfg)
+	r.vacation = newVacation(*cfg.Vacation, stateFile)
+	if err := r.vacation.Restore(); err != nil {
+		t.Fatal(err)
+	} else if !r.vacation.enabled {
+		t.Fatal("vacation mode not restored")
+	}
+
+	if err := r.SetVacation(r.ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	mc.WaitFor(t, "zigbee2mqtt/lamp/set", 1)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"regelwerk/mqttio"
+	"regelwerk/rules"
+)
+
+// A device whose state is computed from a condition expression over other
+// devices, e.g. "any_window_open". Its changes are fed through the same
+// processing as real devices, so it can be used as a sensor or in
+// conditions, and are published to regelwerk/virtual/<name>.
+type virtualDevice struct {
+	dev   *device
+	cond  rules.Expr
+	value any
+	known bool // whether value has been computed yet
+}
+
+type virtuals struct {
+	mu   sync.Mutex
+	devs []*virtualDevice
+}
+
+// Registers the virtual devices, reusing already configured devices of the
+// same name, e.g. if a virtual device is used as the Sensor
+func (r *regelwerk) addVirtuals(defs map[string]string) error {
+	for name, cond := range defs {
+		e, err := rules.Parse(cond)
+		if err != nil {
+			return fmt.Errorf("virtual device %q: %v", name, err)
+		}
+
+		d := r.addVirtualDevice(name)
+		r.virtuals.devs = append(r.virtuals.devs, &virtualDevice{dev: d, cond: e})
+	}
+	return nil
+}
+
+// Registers a device whose state regelwerk computes, or marks an already
+// configured device of the same name as such
+func (r *regelwerk) addVirtualDevice(name string) *device {
+	d := r.devices[name]
+	if d == nil {
+		d = &device{id: name, topic: name, stateAttr: "state"}
+		r.AddDevice(d)
+	}
+	d.virtual = true
+	return d
+}
+
+// Recomputes the virtual devices, queueing an update for those that changed.
+// Called after any device state changes.
+func (r *regelwerk) updateVirtuals(ctx context.Context) {
+	if len(r.virtuals.devs) == 0 {
+		return
+	}
+
+	env := r.exprEnv(ctx)
+
+	r.virtuals.mu.Lock()
+	defer r.virtuals.mu.Unlock()
+
+	for _, v := range r.virtuals.devs {
+		value := v.cond.Eval(env)
+		if v.known && value == v.value {
+			continue
+		}
+		v.value, v.known = value, true
+
+		debugf(LOG_DEVICES, "virtual device %q is now %#v", v.dev.id, value)
+		r.publishJSON("virtual/"+v.dev.topic, value)
+		putVirtualState(v.dev, value)
+	}
+}
+
+// Queues a state update for a device whose state regelwerk computes
+func putVirtualState(d *device, value any) {
+	js, _ := json.Marshal(mqttio.NestPath(d.stateAttr, value))
+	d.group.inbox.Put(d, &virtualMessage{topic: MQTT_TOPIC_PREFIX + d.topic, payload: js})
+}
+
+// A state update for a virtual device, queued like a received message
+type virtualMessage struct {
+	topic   string
+	payload []byte
+}
+
+func (m *virtualMessage) Duplicate() bool   { return false }
+func (m *virtualMessage) Qos() byte         { return 0 }
+func (m *virtualMessage) Retained() bool    { return false }
+func (m *virtualMessage) Topic() string     { return m.topic }
+func (m *virtualMessage) MessageID() uint16 { return 0 }
+func (m *virtualMessage) Payload() []byte   { return m.payload }
+func (m *virtualMessage) Ack()              {}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const METNO_URL = "https://api.met.no/weatherdata/locationforecast/2.0/compact?lat=%.4f&lon=%.4f"
+
+// Current conditions from the met.no forecast for the configured Location.
+// Heavy cloud cover can make dusk start earlier, and end later in the morning.
+type weatherConfig struct {
+	Refresh textDuration // how often to poll
+
+	Overcast    float64      // cloud cover in percent, above which it's overcast
+	DuskEarlier textDuration // how much earlier dusk starts when overcast
+	lat, lng    float64
+}
+
+type weatherReport struct {
+	CloudCover float64   // percent
+	Rain       float64   // mm expected in the next hour
+	Updated    time.Time // zero if not known yet
+}
+
+type weather struct {
+	cfg weatherConfig
+
+	mu     sync.Mutex
+	report weatherReport
+}
+
+func (w *weather) Report() weatherReport {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.report
+}
+
+// How much earlier dusk starts because of the weather
+func (w *weather) DuskShift() time.Duration {
+	if w == nil {
+		return 0
+	}
+
+	rep := w.Report()
+	if rep.Updated.IsZero() || rep.CloudCover < w.cfg.Overcast {
+		return 0
+	}
+	return time.Duration(w.cfg.DuskEarlier)
+}
+
+// Polls the forecast every Refresh until the context is done
+func (w *weather) Run(ctx context.Context, idle *idleDetector) {
+	for {
+		if err := w.update(ctx); err != nil {
+			log.Printf("unable to get weather: %v", err)
+		}
+
+		if !idle.Sleep(ctx, time.Duration(w.cfg.Refresh)) {
+			return
+		}
+	}
+}
+
+func (w *weather) update(ctx context.Context) error {
+	url := fmt.Sprintf(METNO_URL, w.cfg.lat, w.cfg.lng)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	// required by their terms of service
+	req.Header.Set("User-Agent", "regelwerk github.com/geekman/regelwerk")
+
+	c := http.Client{Timeout: 30 * time.Second}
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned %s", resp.Status)
+	}
+
+	var forecast struct {
+		Properties struct {
+			Timeseries []struct {
+				Data struct {
+					Instant struct {
+						Details struct {
+							CloudAreaFraction float64 `json:"cloud_area_fraction"`
+						}
+					}
+					Next1Hours struct {
+						Details struct {
+							PrecipitationAmount float64 `json:"precipitation_amount"`
+						}
+					} `json:"next_1_hours"`
+				}
+			}
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&forecast); err != nil {
+		return err
+	} else if len(forecast.Properties.Timeseries) == 0 {
+		return fmt.Errorf("empty forecast")
+	}
+
+	now := forecast.Properties.Timeseries[0].Data
+	w.mu.Lock()
+	w.report = weatherReport{
+		CloudCover: now.Instant.Details.CloudAreaFraction,
+		Rain:       now.Next1Hours.Details.PrecipitationAmount,
+		Updated:    time.Now(),
+	}
+	w.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// magic packets are sent as UDP broadcasts to the discard port
+const (
+	WOL_PORT         = 9
+	WOL_REPEAT_DELAY = 100 * time.Millisecond
+)
+
+// Builds the magic packet waking the MAC: 6 bytes of ff, then the MAC 16 times
+func wolPacket(mac net.HardwareAddr) []byte {
+	pkt := make([]byte, 0, 6+16*len(mac))
+	pkt = append(pkt, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+	for i := 0; i < 16; i++ {
+		pkt = append(pkt, mac...)
+	}
+	return pkt
+}
+
+// Returns the local address & broadcast address of the interface's first
+// IPv4 network, or nil & the limited broadcast address without one
+func wolAddrs(iface string) (*net.UDPAddr, *net.UDPAddr, error) {
+	bcast := &net.UDPAddr{IP: net.IPv4bcast, Port: WOL_PORT}
+	if iface == "" {
+		return nil, bcast, nil
+	}
+
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, nil, err
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || ipnet.IP.To4() == nil {
+			continue
+		}
+
+		ip, mask := ipnet.IP.To4(), ipnet.Mask
+		if len(mask) == net.IPv6len {
+			mask = mask[12:]
+		}
+		bc := make(net.IP, net.IPv4len)
+		for i := range bc {
+			bc[i] = ip[i] | ^mask[i]
+		}
+		return &net.UDPAddr{IP: ip}, &net.UDPAddr{IP: bc, Port: WOL_PORT}, nil
+	}
+	return nil, nil, fmt.Errorf("interface %s has no IPv4 address", iface)
+}
+
+// Sends the magic packet once
+func sendWakeOnLAN(mac net.HardwareAddr, iface string) error {
+	local, bcast, err := wolAddrs(iface)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialUDP("udp4", local, bcast)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write(wolPacket(mac))
+	return err
+}
+
+func init() {
+	actionChecks["wol"] = func(spec *actionSpec) error {
+		if spec.MAC == "" {
+			return errors.New("wol needs a MAC")
+		}
+		_, err := net.ParseMAC(spec.MAC)
+		return err
+	}
+	actionTypes["wol"] = func(ctx context.Context, r *regelwerk, spec *actionSpec) error {
+		mac, err := net.ParseMAC(spec.MAC)
+		if err != nil {
+			return err
+		}
+
+		repeat := spec.Repeat
+		if repeat <= 0 {
+			repeat = 1
+		}
+		r.Do(ctx, &action{
+			topic:   "wol/" + spec.MAC,
+			payload: "wake",
+			source:  sourceOf(ctx),
+			send: func(ctx context.Context) error {
+				if err := sendWakeOnLAN(mac, spec.Interface); err != nil {
+					return fmt.Errorf("unable to wake %s: %v", spec.MAC, err)
+				}
+				debugf(LOG_ACTIONS, "sent wake-on-LAN to %s", spec.MAC)
+
+				// repeat it as it's easily lost, without holding up the rules
+				for i := 1; i < repeat; i++ {
+					r.clock.AfterFunc(time.Duration(i)*WOL_REPEAT_DELAY, func() {
+						if ctx.Err() != nil {
+							return
+						} else if err := sendWakeOnLAN(mac, spec.Interface); err != nil {
+							log.Printf("unable to wake %s: %v", spec.MAC, err)
+						}
+					})
+				}
+				return nil
+			},
+		})
+		return nil
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestWolPacket(t *testing.T) {
+	mac, _ := net.ParseMAC("00:11:22:33:44:55")
+	pkt := wolPacket(mac)
+
+	if len(pkt) != 102 || !bytes.Equal(pkt[:6], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}) {
+		t.Fatalf("bad magic packet %x", pkt)
+	}
+	for i := 6; i < len(pkt); i += 6 {
+		if !bytes.Equal(pkt[i:i+6], mac) {
+			t.Errorf("MAC %d is %x", i/6, pkt[i:i+6])
+		}
+	}
+}
+
+func TestWolAddrs(t *testing.T) {
+	_, bcast, err := wolAddrs("")
+	if err != nil || !bcast.IP.Equal(net.IPv4bcast) || bcast.Port != WOL_PORT {
+		t.Errorf("wanted limited broadcast, got %v %v", bcast, err)
+	}
+
+	local, bcast, err := wolAddrs("lo")
+	if err != nil {
+		t.Skipf("no loopback interface: %v", err)
+	} else if !local.IP.Equal(net.IPv4(127, 0, 0, 1)) || !bcast.IP.Equal(net.IPv4(127, 255, 255, 255)) {
+		t.Errorf("wrong addresses for lo: %v %v", local, bcast)
+	}
+
+	if _, _, err := wolAddrs("nonexistent0"); err == nil {
+		t.Errorf("unknown interface accepted")
+	}
+}
+
+func TestWolBadMAC(t *testing.T) {
+	for _, mac := range []string{"", "00:11:22"} {
+		actions := map[string]*actionSpec{"wake": {Type: "wol", MAC: mac}}
+		if err := validateButtons(nil, actions); err == nil {
+			t.Errorf("MAC %q accepted", mac)
+		}
+	}
+}
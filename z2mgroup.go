@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"regelwerk/mqttio"
+)
+
+// A zigbee2mqtt group, set through its own topic like a device. z2m reports
+// the group's state optimistically when it's set, which can diverge from the
+// members' reports, e.g. when one of them missed the command. Members are
+// taken as the truth: the group is on if any member is.
+type z2mGroupConfig struct {
+	Members   []string // topics of the member devices
+	StateAttr string   // of the group and members, "state" by default
+}
+
+type z2mGroup struct {
+	dev     *device
+	members []*device
+}
+
+// Registers the groups and their members as devices, reusing already
+// configured ones
+func (r *regelwerk) addZ2MGroups(defs map[string]z2mGroupConfig) error {
+	for name, gc := range defs {
+		if len(gc.Members) == 0 {
+			return fmt.Errorf("zigbee2mqtt group %q has no members", name)
+		}
+		attr := gc.StateAttr
+		if attr == "" {
+			attr = "state"
+		}
+
+		zg := &z2mGroup{dev: r.z2mGroupDevice(name, attr)}
+		for _, topic := range gc.Members {
+			zg.members = append(zg.members, r.z2mGroupDevice(topic, attr))
+		}
+		r.z2mGroups = append(r.z2mGroups, zg)
+	}
+	return nil
+}
+
+func (r *regelwerk) z2mGroupDevice(topic, attr string) *device {
+	d := r.devices[topic]
+	if d == nil {
+		d = &device{id: topic, topic: topic, stateAttr: attr}
+		r.AddDevice(d)
+	}
+	return d
+}
+
+// Checks that the groups and their members are processed together, as their
+// states are compared under the group lock
+func (r *regelwerk) checkZ2MGroups() error {
+	for _, zg := range r.z2mGroups {
+		for _, m := range zg.members {
+			if m.group != zg.dev.group {
+				return fmt.Errorf("zigbee2mqtt group %q and member %q need to be in the same group",
+					zg.dev.topic, m.topic)
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the state of the group as per its members: ON if any is on, OFF if
+// all known ones are off, or nil if none have reported
+func (zg *z2mGroup) membersState() any {
+	var state any
+	for _, m := range zg.members {
+		if m.state == "ON" {
+			return "ON"
+		} else if m.state != nil {
+			state = m.state
+		}
+	}
+	return state
+}
+
+// Reconciles the groups the device belongs to after its state changed,
+// correcting the group's state if it diverged from the members'.
+// Called with the group lock held.
+func (r *regelwerk) updateZ2MGroups(dev *device) {
+	for _, zg := range r.z2mGroups {
+		if dev == zg.dev {
+			if state := zg.membersState(); state != nil && state != dev.state {
+				debugf(LOG_DEVICES, "zigbee2mqtt group %q reported %v, members are %v",
+					dev.topic, dev.state, state)
+			}
+			continue
+		}
+
+		for _, m := range zg.members {
+			if m == dev {
+				r.reconcileZ2MGroup(zg)
+				break
+			}
+		}
+	}
+}
+
+func (r *regelwerk) reconcileZ2MGroup(zg *z2mGroup) {
+	members := make(map[string]any, len(zg.members))
+	for _, m := range zg.members {
+		members[m.topic] = m.state
+	}
+	state := zg.membersState()
+	r.publishJSON("z2mgroup/"+zg.dev.topic, map[string]any{"state": state, "members": members})
+
+	if state == nil || state == zg.dev.state {
+		return
+	}
+
+	// feed the corrected state through the normal processing, so rules see it
+	debugf(LOG_DEVICES, "zigbee2mqtt group %q is now %v as per its members", zg.dev.topic, state)
+	js, _ := json.Marshal(mqttio.NestPath(zg.dev.stateAttr, state))
+	zg.dev.group.inbox.Put(zg.dev, &virtualMessage{topic: MQTT_TOPIC_PREFIX + zg.dev.topic, payload: js})
+}